@@ -0,0 +1,89 @@
+// Package callback encodes inline keyboard callback data.
+//
+// Telegram limits callback data to 64 bytes and clients can send any
+// data back, so the data is a compact "action:issue:arg" payload
+// prefixed with a truncated HMAC-SHA256 signature.
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// MaxLength - the Bot API limit for callback data, in bytes.
+const MaxLength = 64
+
+// sigLength - the signature length, in base64 characters (72 bits).
+const sigLength = 12
+
+// Errors.
+var (
+	ErrTooLong      = errors.New("callback: data too long")
+	ErrMalformed    = errors.New("callback: malformed data")
+	ErrBadSignature = errors.New("callback: bad signature")
+)
+
+// Data - a button action on an issue, with an optional argument
+// (e.g. a transition id).
+type Data struct {
+	Action string
+	Issue  string
+	Arg    string
+}
+
+// Signer - signs and verifies callback data.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner - creates a signer with a secret key.
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+func (s *Signer) sign(payload string) string {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))[:sigLength]
+}
+
+// Encode - returns the signed callback data.
+func (s *Signer) Encode(d Data) (string, error) {
+	for _, f := range []string{d.Action, d.Issue, d.Arg} {
+		if strings.Contains(f, ":") {
+			return "", ErrMalformed
+		}
+	}
+
+	payload := d.Action + ":" + d.Issue + ":" + d.Arg
+
+	data := s.sign(payload) + payload
+	if len(data) > MaxLength {
+		return "", ErrTooLong
+	}
+
+	return data, nil
+}
+
+// Decode - verifies and decodes callback data.
+func (s *Signer) Decode(data string) (Data, error) {
+	if len(data) <= sigLength || len(data) > MaxLength {
+		return Data{}, ErrMalformed
+	}
+
+	sig, payload := data[:sigLength], data[sigLength:]
+	if !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return Data{}, ErrBadSignature
+	}
+
+	parts := strings.Split(payload, ":")
+	if len(parts) != 3 {
+		return Data{}, ErrMalformed
+	}
+
+	return Data{Action: parts[0], Issue: parts[1], Arg: parts[2]}, nil
+}
@@ -0,0 +1,81 @@
+package callback
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSigner(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+
+	tests := []struct {
+		name string
+		data Data
+		err  error
+	}{
+		{name: "transition", data: Data{Action: "t", Issue: "SD-42", Arg: "31"}},
+		{name: "no argument", data: Data{Action: "a", Issue: "SERVICEDESK-123456"}},
+		{name: "too long", data: Data{Action: "t", Issue: "SD-1", Arg: strings.Repeat("9", 50)}, err: ErrTooLong},
+		{name: "separator", data: Data{Action: "t", Issue: "SD:1"}, err: ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		enc, err := s.Encode(tt.data)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Encode() error = %v, want %v", tt.name, err, tt.err)
+
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if len(enc) > MaxLength {
+			t.Errorf("%s: %d bytes, over the limit", tt.name, len(enc))
+		}
+
+		got, err := s.Decode(enc)
+		if err != nil || got != tt.data {
+			t.Errorf("%s: Decode() = %+v, %v; want %+v", tt.name, got, err, tt.data)
+		}
+	}
+}
+
+func TestSignerRejects(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+
+	enc, err := s.Encode(Data{Action: "t", Issue: "SD-42", Arg: "31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"tampered issue", strings.Replace(enc, "SD-42", "SD-43", 1), ErrBadSignature},
+		{"other key", mustEncode(t, NewSigner([]byte("other")), Data{Action: "t", Issue: "SD-42", Arg: "31"}), ErrBadSignature},
+		{"short", "abc", ErrMalformed},
+		{"empty", "", ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		if _, err := s.Decode(tt.data); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Decode() error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func mustEncode(t *testing.T, s *Signer, d Data) string {
+	t.Helper()
+
+	enc, err := s.Encode(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return enc
+}
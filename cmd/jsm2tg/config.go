@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/oncall"
+)
+
+const configUsage = `usage: jsm2tg config [flags] check
+
+  check  validate the configuration file and the on-call schedule it
+         names, reporting every error with its line
+
+A running serve reloads the configuration on SIGHUP.
+
+flags:`
+
+func runConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	configPath := fs.String("config", "jsm2tg.yaml", "configuration file")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), configUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	switch fs.Arg(0) {
+	case "check":
+		return checkConfig(*configPath)
+	default:
+		return fmt.Errorf("unknown subcommand %q", fs.Arg(0))
+	}
+}
+
+func checkConfig(path string) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		errs := config.Errors(err)
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+
+		if len(errs) == 1 {
+			return errors.New("1 error")
+		}
+
+		return fmt.Errorf("%d errors", len(errs))
+	}
+
+	if cfg.OnCall != "" {
+		if _, err := oncall.Load(cfg.OnCall); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s: ok, %d chats, %d routing rules\n", path, len(cfg.Chats), len(cfg.Routing.Rules))
+
+	return nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/parser"
+	"github.com/schors/jsm2tg/tg"
+)
+
+const convertUsage = `usage: jsm2tg convert [flags] [file|directory|-]
+
+Converts Jira wiki markup read from the file, or stdin, to a Telegram
+message written to stdout. A directory converts every .jira file in it
+to a file next to it, or in -out, named after the format:
+.md, .html, .json or .txt.
+
+formats:
+  markdownv2     text for parse_mode MarkdownV2
+  html           text for parse_mode HTML
+  entities-json  {"text": ..., "entities": [...]} as sent with entities
+  plain          the text without formatting
+
+flags:`
+
+// Output formats of convert.
+const (
+	formatMarkdownV2 = "markdownv2"
+	formatHTML       = "html"
+	formatEntities   = "entities-json"
+	formatPlain      = "plain"
+)
+
+// formatExt - the file extensions of the output formats in batch mode.
+var formatExt = map[string]string{
+	formatMarkdownV2: ".md",
+	formatHTML:       ".html",
+	formatEntities:   ".json",
+	formatPlain:      ".txt",
+}
+
+// entitiesMessage - a message in the entities-json format.
+type entitiesMessage struct {
+	Text     string             `json:"text"`
+	Entities []tg.MessageEntity `json:"entities"`
+}
+
+// converter - the convert flags.
+type converter struct {
+	to       string
+	split    bool
+	validate bool
+}
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := fs.String("to", formatMarkdownV2, "output format: markdownv2, html, entities-json or plain")
+	split := fs.Bool("split", false, "split the output into messages Telegram accepts")
+	validate := fs.Bool("validate", false, "only check the output as Telegram would and report errors")
+	outDir := fs.String("out", "", "output directory of a directory conversion")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), convertUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if _, ok := formatExt[*to]; !ok {
+		return fmt.Errorf("unknown format %q", *to)
+	}
+
+	if *split && *to == formatHTML {
+		return errors.New("-split is not supported for html")
+	}
+
+	c := converter{to: *to, split: *split, validate: *validate}
+
+	input := fs.Arg(0)
+	if input == "" || input == "-" {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		return c.run(os.Stdout, string(src))
+	}
+
+	if fi, err := os.Stat(input); err == nil && fi.IsDir() {
+		return c.batch(input, *outDir)
+	}
+
+	src, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+
+	return c.run(os.Stdout, string(src))
+}
+
+// run - converts src and writes the result, nothing if validating.
+func (c *converter) run(w io.Writer, src string) error {
+	chunks, err := c.convert(src)
+	if err != nil {
+		return err
+	}
+
+	if c.validate {
+		return nil
+	}
+
+	return c.write(w, chunks)
+}
+
+// convert - converts src to the chunks of the output format; one chunk
+// unless splitting. Markup Telegram would reject is an error when
+// validating or when it's needed for the format.
+func (c *converter) convert(src string) ([]string, error) {
+	if c.to == formatHTML {
+		out := parser.ConvertJiraToTgHTML(src)
+
+		if c.validate {
+			text, _, err := tg.ParseHTML(out)
+			if err != nil {
+				return nil, withContext(out, err)
+			}
+
+			if err := checkLength(text); err != nil {
+				return nil, err
+			}
+		}
+
+		return []string{out}, nil
+	}
+
+	md := parser.ConvertJiraToTgMarkup(src)
+
+	chunks := []string{md}
+	if c.split {
+		chunks = tg.SplitMarkdownV2(md, tg.MaxMessageLength)
+	}
+
+	out := make([]string, 0, len(chunks))
+
+	for i, chunk := range chunks {
+		if c.to == formatMarkdownV2 && !c.validate {
+			out = append(out, chunk)
+
+			continue
+		}
+
+		text, entities, err := tg.ParseMarkdownV2(chunk)
+		if err == nil && c.validate {
+			err = checkLength(text)
+		}
+
+		if err != nil {
+			if len(chunks) > 1 {
+				return nil, fmt.Errorf("message %d: %w", i+1, withContext(chunk, err))
+			}
+
+			return nil, withContext(chunk, err)
+		}
+
+		switch c.to {
+		case formatMarkdownV2:
+			out = append(out, chunk)
+		case formatPlain:
+			out = append(out, text)
+		case formatEntities:
+			if entities == nil {
+				entities = []tg.MessageEntity{}
+			}
+
+			b, err := json.Marshal(entitiesMessage{Text: text, Entities: entities})
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, string(b))
+		}
+	}
+
+	return out, nil
+}
+
+// write - writes the chunks; when splitting, a JSON array of messages or
+// the text of every message after a header line.
+func (c *converter) write(w io.Writer, chunks []string) error {
+	var b strings.Builder
+
+	switch {
+	case c.to == formatEntities:
+		js := chunks[0]
+		if c.split {
+			js = "[" + strings.Join(chunks, ",") + "]"
+		}
+
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(js), "", "  "); err != nil {
+			return err
+		}
+
+		b.Write(buf.Bytes())
+	case !c.split:
+		b.WriteString(chunks[0])
+	default:
+		for i, chunk := range chunks {
+			fmt.Fprintf(&b, "--- message %d/%d ---\n%s\n", i+1, len(chunks), chunk)
+		}
+	}
+
+	if s := b.String(); !strings.HasSuffix(s, "\n") {
+		b.WriteByte('\n')
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// batch - converts every .jira file of dir to a file in out, dir if
+// empty, and reports every file. It fails if any file failed.
+func (c *converter) batch(dir, out string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.jira"))
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no .jira files in %s", dir)
+	}
+
+	if out == "" {
+		out = dir
+	}
+
+	if !c.validate {
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+
+	for _, file := range files {
+		dst := filepath.Join(out, strings.TrimSuffix(filepath.Base(file), ".jira")+formatExt[c.to])
+
+		if err := c.file(file, dst); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+
+			failed++
+
+			continue
+		}
+
+		if c.validate {
+			fmt.Printf("%s: ok\n", file)
+		} else {
+			fmt.Printf("%s -> %s\n", file, dst)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+
+	return nil
+}
+
+// file - converts one file of a batch.
+func (c *converter) file(src, dst string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if c.validate {
+		return c.run(io.Discard, string(b))
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if err := c.run(f, string(b)); err != nil {
+		f.Close()
+		os.Remove(dst)
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// plainText - returns the Jira markup converted to text without
+// formatting, as convert -to plain writes it; from the HTML conversion
+// if the MarkdownV2 one doesn't parse.
+func plainText(src string) (string, error) {
+	text, _, err := tg.ParseMarkdownV2(parser.ConvertJiraToTgMarkup(src))
+	if err == nil {
+		return text, nil
+	}
+
+	text, _, htmlErr := tg.ParseHTML(parser.ConvertJiraToTgHTML(src))
+	if htmlErr != nil {
+		return "", err
+	}
+
+	return text, nil
+}
+
+// checkLength - fails if the text is too long for one message.
+func checkLength(text string) error {
+	if n := tg.UTF16Len(text); n > tg.MaxMessageLength {
+		return fmt.Errorf("the message is %d characters long, Telegram takes up to %d; use -split", n, tg.MaxMessageLength)
+	}
+
+	return nil
+}
+
+// withContext - adds the text around the offset of an entity error.
+func withContext(text string, err error) error {
+	var e *tg.EntityError
+	if errors.As(err, &e) {
+		return fmt.Errorf("%w: %q", err, delivery.Surrounding(text, e.Offset, 20))
+	}
+
+	return err
+}
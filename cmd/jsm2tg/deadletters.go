@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/store"
+)
+
+const deadLettersUsage = `usage: jsm2tg deadletters [flags] <list|replay|purge> [id...]
+
+  list            show the messages the outbox gave up on
+  replay [id...]  queue dead letters again, all without ids;
+                  they are sent on the next start of serve
+  purge [id...]   delete dead letters, all without ids
+
+The store is locked while serve runs; stop it first, or the command
+fails after waiting 5 seconds for the lock.
+
+flags:`
+
+func runDeadLetters(args []string) error {
+	fs := flag.NewFlagSet("deadletters", flag.ExitOnError)
+	configPath := fs.String("config", "jsm2tg.yaml", "configuration file")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), deadLettersUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Store == "" {
+		return errors.New("no store configured, dead letters are not kept")
+	}
+
+	st, err := store.Open(cfg.Store)
+	if errors.Is(err, store.ErrLocked) {
+		return fmt.Errorf("%w; stop serve first, dead letters are managed with it stopped", err)
+	}
+
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	ids := fs.Args()[1:]
+
+	switch fs.Arg(0) {
+	case "list":
+		return listDeadLetters(st)
+	case "replay":
+		n, err := delivery.ReplayDeadLetters(st, ids...)
+		fmt.Printf("%d dead letters queued\n", n)
+
+		return err
+	case "purge":
+		n, err := delivery.PurgeDeadLetters(st, ids...)
+		fmt.Printf("%d dead letters purged\n", n)
+
+		return err
+	default:
+		return fmt.Errorf("unknown subcommand %q", fs.Arg(0))
+	}
+}
+
+func listDeadLetters(st store.Store) error {
+	jobs, err := delivery.DeadLetters(st)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tCHAT\tATTEMPTS\tERROR\tTEXT")
+
+	for _, j := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n",
+			j.ID, j.Created.Local().Format(time.DateTime), j.Chat, j.Attempts, j.LastError, excerpt(j.Text(), 40))
+	}
+
+	return w.Flush()
+}
+
+// excerpt - the first line of s, cut to n runes.
+func excerpt(s string, n int) string {
+	s, _, _ = strings.Cut(s, "\n")
+
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+
+	return s
+}
@@ -0,0 +1,68 @@
+// Command jsm2tg forwards Jira Service Management notifications to Telegram.
+//
+// Usage:
+//
+//	jsm2tg <command> [flags]
+//
+// Commands:
+//
+//	serve        run the webhook receiver daemon
+//	convert      convert Jira wiki markup to Telegram messages
+//	send         convert Jira wiki markup and send it to a chat
+//	config       validate the configuration
+//	deadletters  list, replay or purge undeliverable messages
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	_ "time/tzdata" // digest time zones on hosts without zoneinfo
+)
+
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"serve":       {runServe, "run the webhook receiver daemon"},
+	"convert":     {runConvert, "convert Jira wiki markup to Telegram messages"},
+	"send":        {runSend, "convert Jira wiki markup and send it to a chat"},
+	"config":      {runConfig, "validate the configuration"},
+	"deadletters": {runDeadLetters, "list, replay or purge undeliverable messages"},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: jsm2tg <command> [flags]\n\ncommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "jsm2tg: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "jsm2tg %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/tg"
+)
+
+const sendUsage = `usage: jsm2tg send -chat ID -token-file FILE [flags] [file|-]
+
+Converts Jira wiki markup read from the file, or stdin, and sends it to
+a Telegram chat, split into as many messages as needed. Rate limits and
+server errors are retried; markup Telegram rejects falls back to HTML
+and plain text. The ids of the sent messages are printed.
+
+flags:`
+
+// sendOptions - the send flags.
+type sendOptions struct {
+	chat      int64
+	thread    int
+	silent    bool
+	replyTo   int
+	parseMode string
+}
+
+func runSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	chat := fs.Int64("chat", 0, "chat id")
+	tokenFile := fs.String("token-file", "", "file with the bot token")
+	thread := fs.Int("thread", 0, "forum topic id")
+	silent := fs.Bool("silent", false, "send without notification")
+	replyTo := fs.Int("reply-to", 0, "id of the message to reply to")
+	parseMode := fs.String("parse-mode", formatMarkdownV2, "markdownv2, html or plain")
+	apiURL := fs.String("api-url", tg.DefaultBaseURL, "Bot API endpoint")
+	retries := fs.Int("retries", 5, "retries of rate limited and failed requests after the first attempt; 0 for none")
+	dryRun := fs.Bool("dry-run", false, "print the Bot API requests instead of sending them")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), sendUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *chat == 0 || *retries < 0 || fs.NArg() > 1 || (*tokenFile == "" && !*dryRun) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		src []byte
+		err error
+	)
+
+	if input := fs.Arg(0); input == "" || input == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(input)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	token := "<token>"
+
+	if *tokenFile != "" {
+		b, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return err
+		}
+
+		if token = strings.TrimSpace(string(b)); token == "" {
+			return fmt.Errorf("%s: empty token", *tokenFile)
+		}
+	}
+
+	var bot delivery.Bot = tg.NewClient(token, tg.WithBaseURL(*apiURL))
+	if *dryRun {
+		bot = &dryRunBot{w: os.Stdout, url: strings.TrimRight(*apiURL, "/") + "/bot<token>/"}
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	// a zero MaxRetries selects the default
+	maxRetries := *retries
+	if maxRetries == 0 {
+		maxRetries = -1
+	}
+
+	sender := delivery.NewSender(bot, delivery.Config{MaxRetries: maxRetries, Logger: logger}, nil)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ids, err := send(ctx, sender, sendOptions{
+		chat:      *chat,
+		thread:    *thread,
+		silent:    *silent,
+		replyTo:   *replyTo,
+		parseMode: *parseMode,
+	}, string(src))
+
+	if !*dryRun {
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+	}
+
+	return err
+}
+
+// send - converts the Jira markup and sends it in as many messages as
+// needed; it returns the ids of the messages sent, also on an error.
+func send(ctx context.Context, s *delivery.Sender, o sendOptions, src string) ([]int, error) {
+	messages, err := sendRenderings(o.parseMode, src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := tg.SendMessageParams{
+		ChatID:              o.chat,
+		MessageThreadID:     o.thread,
+		DisableNotification: o.silent,
+	}
+
+	if o.replyTo != 0 {
+		p.ReplyParameters = &tg.ReplyParameters{MessageID: o.replyTo}
+	}
+
+	ids := make([]int, 0, len(messages))
+
+	for i, rr := range messages {
+		m, err := s.SendRendered(ctx, p, rr)
+		if err != nil {
+			if len(messages) > 1 {
+				return ids, fmt.Errorf("message %d of %d: %w", i+1, len(messages), err)
+			}
+
+			return ids, err
+		}
+
+		ids = append(ids, m.MessageID)
+
+		// only the first message is a reply
+		p.ReplyParameters = nil
+	}
+
+	return ids, nil
+}
+
+// sendRenderings - returns the renderings of every message of src in the
+// parse mode. A text sent in one message falls back to HTML and plain
+// text; the parts of a split one fall back to their markup shown as is.
+func sendRenderings(parseMode, src string) ([][]delivery.Rendering, error) {
+	switch parseMode {
+	case formatMarkdownV2:
+		rr := delivery.JiraRenderings(src)
+
+		chunks := tg.SplitMarkdownV2(rr[0].Text, tg.MaxMessageLength)
+		if len(chunks) == 1 {
+			return [][]delivery.Rendering{rr}, nil
+		}
+
+		return splitRenderings(chunks, true), nil
+	case formatPlain:
+		text, err := plainText(src)
+		if err != nil {
+			return nil, err
+		}
+
+		return splitRenderings(tg.SplitMarkdownV2(delivery.PlainRendering(text).Text, tg.MaxMessageLength), false), nil
+	case formatHTML:
+		rr := delivery.JiraRenderings(src)[1:]
+
+		text, _, err := tg.ParseHTML(rr[0].Text)
+		if err == nil && tg.UTF16Len(text) > tg.MaxMessageLength {
+			return nil, errors.New("the text is too long for one message; html can't be split, use markdownv2")
+		}
+
+		return [][]delivery.Rendering{rr}, nil
+	default:
+		return nil, fmt.Errorf("unknown parse mode %q", parseMode)
+	}
+}
+
+// splitRenderings - returns the renderings of MarkdownV2 chunks, falling
+// back to the markup shown as is if fallback is set.
+func splitRenderings(chunks []string, fallback bool) [][]delivery.Rendering {
+	out := make([][]delivery.Rendering, 0, len(chunks))
+
+	for _, chunk := range chunks {
+		rr := []delivery.Rendering{{Name: "markdownv2", Text: chunk, ParseMode: tg.ParseModeMarkdownV2}}
+		if fallback {
+			rr = append(rr, delivery.PlainRendering(chunk))
+		}
+
+		out = append(out, rr)
+	}
+
+	return out
+}
+
+// dryRunBot - prints the Bot API requests instead of making them.
+type dryRunBot struct {
+	w   io.Writer
+	url string
+	n   int
+}
+
+func (b *dryRunBot) print(method string, params any) (*tg.Message, error) {
+	body, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	b.n++
+
+	if _, err := fmt.Fprintf(b.w, "POST %s%s\n%s\n\n", b.url, method, body); err != nil {
+		return nil, err
+	}
+
+	return &tg.Message{MessageID: b.n}, nil
+}
+
+func (b *dryRunBot) SendMessage(_ context.Context, p tg.SendMessageParams) (*tg.Message, error) {
+	return b.print("sendMessage", p)
+}
+
+func (b *dryRunBot) EditMessageText(_ context.Context, p tg.EditMessageTextParams) (*tg.Message, error) {
+	return b.print("editMessageText", p)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// stubBot - a Bot API stand-in answering sendMessage; it fails the
+// first request with a server error and rejects MarkdownV2 containing
+// "reject".
+type stubBot struct {
+	mu   sync.Mutex
+	sent []tg.SendMessageParams
+	hits int
+}
+
+func (b *stubBot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var p tg.SendMessageParams
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.hits++
+
+	switch {
+	case b.hits == 1:
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, `{"ok":false,"error_code":502,"description":"Bad Gateway"}`)
+	case p.ParseMode == tg.ParseModeMarkdownV2 && strings.Contains(p.Text, "reject"):
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities: Can't find end of the entity starting at byte offset 0"}`)
+	default:
+		b.sent = append(b.sent, p)
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"chat":{"id":%d,"type":"supergroup"},"date":1}}`, 100+len(b.sent), p.ChatID)
+	}
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		parseMode string
+		modes     []string
+	}{
+		{"markdownv2", "Hello *world*", formatMarkdownV2, []string{tg.ParseModeMarkdownV2}},
+		{"fallback to html", "reject *this*", formatMarkdownV2, []string{tg.ParseModeHTML}},
+		{"split", strings.Repeat("word ", 2000), formatMarkdownV2, []string{tg.ParseModeMarkdownV2, tg.ParseModeMarkdownV2, tg.ParseModeMarkdownV2}},
+		{"html", "*bold*", formatHTML, []string{tg.ParseModeHTML}},
+		{"plain", "*not bold* 1.2", formatPlain, []string{tg.ParseModeMarkdownV2}},
+	}
+
+	for _, tt := range tests {
+		bot := &stubBot{}
+		srv := httptest.NewServer(bot)
+
+		s := delivery.NewSender(tg.NewClient("TOKEN", tg.WithBaseURL(srv.URL)), delivery.Config{PerChatRate: 1000, PerChatBurst: 10, BaseBackoff: time.Millisecond}, nil)
+
+		ids, err := send(context.Background(), s, sendOptions{chat: -100, thread: 7, silent: true, replyTo: 5, parseMode: tt.parseMode}, tt.input)
+
+		srv.Close()
+
+		if err != nil {
+			t.Errorf("%s: send() error = %v", tt.name, err)
+
+			continue
+		}
+
+		if len(bot.sent) != len(tt.modes) || len(ids) != len(tt.modes) {
+			t.Errorf("%s: sent %d messages (ids %v), want %d", tt.name, len(bot.sent), ids, len(tt.modes))
+
+			continue
+		}
+
+		for i, p := range bot.sent {
+			if p.ParseMode != tt.modes[i] || p.ChatID != -100 || p.MessageThreadID != 7 || !p.DisableNotification {
+				t.Errorf("%s: message %d = %+v", tt.name, i, p)
+			}
+
+			if reply := p.ReplyParameters != nil && p.ReplyParameters.MessageID == 5; reply != (i == 0) {
+				t.Errorf("%s: message %d reply parameters = %+v", tt.name, i, p.ReplyParameters)
+			}
+		}
+	}
+}
+
+func TestSendPlain(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"monospace", "{{x.y}} 1.2\n", `x\.y 1\.2`},
+		{"link and code", "See [the docs|https://ex.com/a] now\n{code:go}x := 1{code}\n", "See the docs now\nx :\\= 1"},
+	}
+
+	for _, tt := range tests {
+		rr, err := sendRenderings(formatPlain, tt.input)
+		if err != nil {
+			t.Errorf("%s: sendRenderings() error = %v", tt.name, err)
+
+			continue
+		}
+
+		if len(rr) != 1 || len(rr[0]) != 1 || strings.TrimSpace(rr[0][0].Text) != tt.want {
+			t.Errorf("%s: sendRenderings() = %+v, want %q", tt.name, rr, tt.want)
+
+			continue
+		}
+
+		// the text convert -to plain writes, escaped
+		text, err := plainText(tt.input)
+		if err != nil || rr[0][0].Text != tg.EscapeTelegram(text) {
+			t.Errorf("%s: sent %q, convert -to plain gives %q (%v)", tt.name, rr[0][0].Text, text, err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/daemon"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/metrics"
+	"github.com/schors/jsm2tg/oncall"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "jsm2tg.yaml", "configuration file")
+	workers := fs.Int("workers", 4, "number of delivery workers")
+	debug := fs.Bool("debug", false, "enable debug logging")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+
+	opts := []tg.Option{}
+	if cfg.Telegram.APIURL != "" {
+		opts = append(opts, tg.WithBaseURL(cfg.Telegram.APIURL))
+	}
+
+	bot := tg.NewClient(cfg.Telegram.Token, opts...)
+
+	st, err := store.Open(cfg.Store)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	reg := metrics.NewRegistry()
+
+	// the daemon records the messages the outbox delivers late
+	var d *daemon.Daemon
+
+	sender := delivery.NewSender(bot, delivery.Config{Logger: logger, Metrics: reg}, nil)
+
+	outbox, err := delivery.NewOutbox(sender, st, delivery.OutboxConfig{
+		MaxAttempts: cfg.Outbox.MaxAttempts,
+		BaseBackoff: cfg.Outbox.Backoff,
+		MaxBackoff:  cfg.Outbox.MaxBackoff,
+		Delivered:   func(tag string, m *tg.Message) { d.Delivered(tag, m) },
+		Logger:      logger,
+		Metrics:     reg,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	dopts := []daemon.Option{
+		daemon.WithLogger(logger),
+		daemon.WithStore(st),
+		daemon.WithBot(bot),
+		daemon.WithLimiter(sender),
+		daemon.WithWorkers(*workers),
+		daemon.WithMetrics(reg),
+		daemon.WithReadyCheck("outbox", outbox.Ready),
+	}
+
+	if cfg.Jira.HasCredentials() {
+		dopts = append(dopts, daemon.WithJira(newJiraClient(cfg.Jira)), daemon.WithJiraAs(func(u config.User) daemon.Jira {
+			return newUserJiraClient(cfg.Jira.URL, u)
+		}))
+	}
+
+	if cfg.OnCall != "" {
+		s, err := oncall.Load(cfg.OnCall)
+		if err != nil {
+			return err
+		}
+
+		dopts = append(dopts, daemon.WithOnCall(s))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	allowed := []string{"message", "callback_query"}
+
+	switch cfg.Telegram.Updates {
+	case config.UpdatesPolling:
+		// getUpdates fails while a webhook is set
+		if err := bot.DeleteWebhook(ctx); err != nil {
+			return err
+		}
+	case config.UpdatesWebhook:
+		err := bot.SetWebhook(ctx, tg.SetWebhookParams{
+			URL:            cfg.Telegram.WebhookURL,
+			SecretToken:    cfg.Telegram.WebhookSecret,
+			AllowedUpdates: allowed,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Telegram.Updates != config.UpdatesNone {
+		me, err := bot.GetMe(ctx)
+		if err != nil {
+			return err
+		}
+
+		dopts = append(dopts, daemon.WithUsername(me.Username))
+	}
+
+	d, err = daemon.New(cfg, outbox, dopts...)
+	if err != nil {
+		return err
+	}
+
+	go reloadOnHUP(ctx, *configPath, d, logger)
+
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           d.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// on shutdown the server stops taking webhooks first, then the daemon
+	// finishes the jobs it accepted, then the outbox stops
+	runCtx, stopRun := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopRun()
+
+	outboxCtx, stopOutbox := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopOutbox()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := outbox.Run(outboxCtx); err != nil {
+				logger.Error("outbox stopped", "error", err)
+			}
+		}()
+
+		d.Run(runCtx)
+		stopOutbox()
+		wg.Wait()
+	}()
+
+	if cfg.Telegram.Updates == config.UpdatesPolling {
+		go func() {
+			if err := bot.Poll(ctx, d, allowed, logger); err != nil && ctx.Err() == nil {
+				logger.Error("telegram polling stopped", "error", err)
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		srv.Shutdown(shutdownCtx)
+		stopRun()
+	}()
+
+	logger.Info("listening", "addr", cfg.Listen, "path", cfg.WebhookPath)
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		stop()
+
+		return err
+	}
+
+	<-done
+
+	return nil
+}
+
+// reloadOnHUP - reloads the configuration and the on-call schedule on
+// SIGHUP until ctx is done. An invalid configuration is logged and the
+// running one is kept.
+func reloadOnHUP(ctx context.Context, path string, d *daemon.Daemon, logger *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := reload(path, d, logger); err != nil {
+			logger.Error("configuration reload failed, the running one is kept", "error", err)
+		}
+	}
+}
+
+func reload(path string, d *daemon.Daemon, logger *slog.Logger) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	var schedule *oncall.Schedule
+
+	if cfg.OnCall != "" {
+		if schedule, err = oncall.Load(cfg.OnCall); err != nil {
+			return err
+		}
+	}
+
+	changed, err := d.Reload(cfg, schedule)
+	if err != nil {
+		return err
+	}
+
+	if len(changed) > 0 {
+		logger.Warn("configuration changes take effect after a restart", "settings", strings.Join(changed, ", "))
+	}
+
+	logger.Info("configuration reloaded", "chats", len(cfg.Chats), "rules", len(cfg.Routing.Rules))
+
+	return nil
+}
+
+func newJiraClient(c config.Jira) *jira.Client {
+	cloud := jira.WithCloud(c.Cloud || jira.IsCloudURL(c.URL))
+
+	if c.PAT != "" {
+		return jira.NewClient(c.URL, jira.WithToken(c.PAT), cloud)
+	}
+
+	return jira.NewClient(c.URL, jira.WithBasicAuth(c.User, c.Token), cloud)
+}
+
+// newUserJiraClient - returns a client acting as a linked user with
+// their own token.
+func newUserJiraClient(url string, u config.User) *jira.Client {
+	if u.Email != "" {
+		return jira.NewClient(url, jira.WithBasicAuth(u.Email, u.JiraToken))
+	}
+
+	return jira.NewClient(url, jira.WithToken(u.JiraToken))
+}
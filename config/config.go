@@ -0,0 +1,565 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Defaults.
+const (
+	DefaultListen              = ":8080"
+	DefaultWebhookPath         = "/webhook"
+	DefaultTelegramWebhookPath = "/telegram"
+	DefaultPageSize            = 5
+	DefaultSLAJQL              = "resolution = Unresolved"
+)
+
+// Config - the jsm2tg daemon configuration.
+type Config struct {
+	Listen      string   `yaml:"listen"`
+	WebhookPath string   `yaml:"webhook_path"`
+	Auth        Auth     `yaml:"auth"`
+	Telegram    Telegram `yaml:"telegram"`
+	Jira        Jira     `yaml:"jira"`
+	Chats       []Chat   `yaml:"chats"`
+	Users       []User   `yaml:"users"`
+
+	// Store - the bbolt database keeping issue to message mappings;
+	// empty keeps them in memory only.
+	Store string `yaml:"store"`
+
+	Routing   route.Config `yaml:"routing"`
+	Topics    Topics       `yaml:"topics"`
+	Commands  Commands     `yaml:"commands"`
+	Requests  Requests     `yaml:"requests"`
+	SLA       SLA          `yaml:"sla"`
+	Outbox    Outbox       `yaml:"outbox"`
+	Changelog Changelog    `yaml:"changelog"`
+
+	// QuietHours - daily windows when messages to chats don't notify.
+	QuietHours []QuietHours `yaml:"quiet_hours"`
+	// OnCall - the on-call schedule file (see package oncall); who is on
+	// call is mentioned in new issue messages and SLA alerts.
+	OnCall string `yaml:"oncall"`
+
+	// Templates - message templates by event kind (or "default"),
+	// overriding the built-in ones.
+	Templates map[string]string `yaml:"templates"`
+}
+
+// Auth - webhook authentication. Every configured check must pass;
+// with nothing configured all requests are accepted.
+type Auth struct {
+	// Secret - the Jira Cloud webhook secret used to verify
+	// the X-Hub-Signature HMAC-SHA256 header.
+	Secret string `yaml:"secret"`
+	// Token - a static token expected in the "token" query parameter,
+	// the X-Webhook-Token header or an "Authorization: Bearer" header
+	// (Jira Server/DC can't sign requests).
+	Token string `yaml:"token"`
+	// Allow - CIDR ranges requests may come from.
+	Allow []string `yaml:"allow"`
+	// TrustForwarded - take the client address from X-Forwarded-For
+	// when running behind a reverse proxy.
+	TrustForwarded bool `yaml:"trust_forwarded"`
+}
+
+// Update modes.
+const (
+	UpdatesNone    = "none"
+	UpdatesPolling = "polling"
+	UpdatesWebhook = "webhook"
+)
+
+// Telegram - Bot API access.
+type Telegram struct {
+	Token  string `yaml:"token"`
+	APIURL string `yaml:"api_url"`
+	// Updates - how incoming messages are received: UpdatesNone (default),
+	// UpdatesPolling or UpdatesWebhook.
+	Updates string `yaml:"updates"`
+	// WebhookURL - the public URL Telegram posts updates to in webhook
+	// mode; it must end with WebhookPath, served on Listen.
+	WebhookURL  string `yaml:"webhook_url"`
+	WebhookPath string `yaml:"webhook_path"`
+	// WebhookSecret - the secret_token Telegram sends with every update.
+	WebhookSecret string `yaml:"webhook_secret"`
+	// CallbackSecret - the key signing inline keyboard callback data;
+	// derived from the bot token if empty.
+	CallbackSecret string `yaml:"callback_secret"`
+}
+
+// Jira - Jira instance settings. Credentials are needed for the
+// features calling the Jira REST API, like posting Telegram replies
+// as comments.
+type Jira struct {
+	URL string `yaml:"url"` // base URL used for issue links and API calls
+	// Cloud - the instance is Jira Cloud on a custom domain; sites on
+	// atlassian.net are recognized by their URL.
+	Cloud bool `yaml:"cloud"`
+	// User and Token - basic authentication: an email and an API token
+	// on Jira Cloud, a user name and password on Server/DC.
+	User  string `yaml:"user"`
+	Token string `yaml:"token"`
+	// PAT - a personal access token (Server/DC), used instead of User/Token.
+	PAT string `yaml:"pat"`
+	// Priorities - priority names from the lowest to the highest,
+	// used by the "Priority ↑" button.
+	Priorities []string `yaml:"priorities"`
+	// ServiceDesk - the JSM service desk id whose queues /queue lists.
+	ServiceDesk string `yaml:"service_desk"`
+}
+
+// DefaultPriorities - the priority scheme of new Jira instances.
+var DefaultPriorities = []string{"Lowest", "Low", "Medium", "High", "Highest"}
+
+// Actions users can perform with inline keyboard buttons.
+const (
+	ActionTransition = "transition"
+	ActionAssign     = "assign"
+	ActionPriority   = "priority"
+)
+
+var actions = []string{ActionTransition, ActionAssign, ActionPriority}
+
+// HasCredentials - reports whether the Jira REST API can be used.
+func (j *Jira) HasCredentials() bool {
+	return j.URL != "" && (j.PAT != "" || j.User != "" && j.Token != "")
+}
+
+// User - links a Telegram user to a Jira user.
+type User struct {
+	Telegram int64 `yaml:"telegram"`
+	// AccountID - the Jira Cloud account id; Username - the Server/DC user name.
+	AccountID string `yaml:"account_id"`
+	Username  string `yaml:"username"`
+	// Actions - the keyboard actions the user may perform; all if empty.
+	Actions []string `yaml:"actions"`
+	// JiraToken - the user's own Jira credentials: an API token of the
+	// Cloud account logging in as Email, or a Server/DC personal access
+	// token. Approvals are answered with them, so Jira records the
+	// decision of the user; without them approvers answer in Jira.
+	JiraToken string `yaml:"jira_token"`
+	Email     string `yaml:"email"`
+}
+
+// Allows - reports whether the user may perform the action.
+func (u *User) Allows(action string) bool {
+	return len(u.Actions) == 0 || slices.Contains(u.Actions, action)
+}
+
+// Mention - returns Jira markup mentioning the user.
+func (u *User) Mention() string {
+	if u.AccountID != "" {
+		return "[~accountid:" + u.AccountID + "]"
+	}
+
+	return "[~" + u.Username + "]"
+}
+
+// UserByJira - returns the user linked to a Jira account id (Cloud)
+// or user name (Server/DC).
+func (c *Config) UserByJira(accountID, username string) (User, bool) {
+	for _, u := range c.Users {
+		if accountID != "" && u.AccountID == accountID || username != "" && u.Username == username {
+			return u, true
+		}
+	}
+
+	return User{}, false
+}
+
+// UserByTelegram - returns the Jira user linked to a Telegram user.
+func (c *Config) UserByTelegram(id int64) (User, bool) {
+	for _, u := range c.Users {
+		if u.Telegram == id {
+			return u, true
+		}
+	}
+
+	return User{}, false
+}
+
+// Chat - a Telegram chat notifications are delivered to when no routing
+// rule matches. Thread selects a forum topic in supergroups with topics enabled;
+// Topics creates a topic per issue instead. Issue updates arriving within
+// Coalesce of the first one are merged into one message.
+type Chat struct {
+	ID       int64         `yaml:"id"`
+	Thread   int           `yaml:"thread"`
+	Topics   bool          `yaml:"topics"`
+	Coalesce time.Duration `yaml:"coalesce"`
+}
+
+// Topics - forum topics created per issue for destinations with topics enabled.
+type Topics struct {
+	// Color - the icon color of new topics, one of tg.TopicColors.
+	Color int `yaml:"color"`
+	// Icons - custom emoji ids of topic icons by priority name;
+	// see getForumTopicIconStickers.
+	Icons map[string]string `yaml:"icons"`
+}
+
+// Requests - JSM requests created from Telegram with /new or by
+// forwarding messages to the bot. Users linked in Users can create
+// them in any chat.
+type Requests struct {
+	// Chats - chats whose members (e.g. customers of a support group)
+	// can create requests too.
+	Chats []int64 `yaml:"chats"`
+	// RequestTypes - the ids of the request types offered; all request
+	// types of the service desk if empty.
+	RequestTypes []string `yaml:"request_types"`
+}
+
+// SLA - alerts on JSM SLAs approaching or past their breach time. SLAs are
+// read from webhook payloads and, if Interval is set, polled.
+type SLA struct {
+	// Thresholds - the remaining times alerted on, e.g. [30m, 0s];
+	// 0s alerts on the breach. Alerts are disabled if empty.
+	Thresholds []time.Duration `yaml:"thresholds"`
+	// Interval - how often the issues matching JQL are polled;
+	// 0 only checks webhook payloads.
+	Interval time.Duration `yaml:"interval"`
+	JQL      string        `yaml:"jql"`
+}
+
+// Changelog - the field changes listed in issue update messages.
+type Changelog struct {
+	// Ignore - names or ids of fields whose changes aren't listed;
+	// updates changing nothing else aren't announced.
+	Ignore []string `yaml:"ignore"`
+}
+
+// DefaultIgnoredFields - fields Jira changes as a side effect of other
+// actions, e.g. board ranking and time tracking.
+var DefaultIgnoredFields = []string{"Rank", "lastViewed", "RemoteIssueLink", "WorklogId", "timespent", "timeestimate"}
+
+// Outbox - retries of the messages Telegram failed to take. Messages
+// still failing after MaxAttempts are kept as dead letters; zero values
+// use the defaults of the delivery package.
+type Outbox struct {
+	MaxAttempts int `yaml:"max_attempts"`
+	// Backoff - the delay before the first retry, doubled on every
+	// next one up to MaxBackoff.
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// Quiet hours modes.
+const (
+	QuietSilent = "silent" // messages are sent without notification
+	QuietHold   = "hold"   // issue events are held and delivered when the quiet hours end
+)
+
+// QuietHours - a daily window, e.g. from "22:00" to "08:00" in Timezone
+// (UTC by default), when messages to the chats don't notify. Messages
+// of the Urgent priorities always notify.
+type QuietHours struct {
+	Chats    []int64  `yaml:"chats"` // all chats if empty
+	From     string   `yaml:"from"`
+	To       string   `yaml:"to"`
+	Timezone string   `yaml:"timezone"`
+	Mode     string   `yaml:"mode"` // QuietSilent (default) or QuietHold
+	Urgent   []string `yaml:"urgent"`
+}
+
+// Window - returns the start and the end of the quiet hours as minutes
+// since midnight, and their time zone.
+func (q *QuietHours) Window() (from, to int, loc *time.Location, err error) {
+	clock := func(s string) (int, error) {
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			return 0, fmt.Errorf("want HH:MM, got %q", s)
+		}
+
+		return t.Hour()*60 + t.Minute(), nil
+	}
+
+	if from, err = clock(q.From); err != nil {
+		return 0, 0, nil, fmt.Errorf("from: %w", err)
+	}
+
+	if to, err = clock(q.To); err != nil {
+		return 0, 0, nil, fmt.Errorf("to: %w", err)
+	}
+
+	loc = time.UTC
+
+	if q.Timezone != "" {
+		if loc, err = time.LoadLocation(q.Timezone); err != nil {
+			return 0, 0, nil, fmt.Errorf("timezone: %w", err)
+		}
+	}
+
+	return from, to, loc, nil
+}
+
+// MinSLAInterval - the shortest SLA polling interval.
+const MinSLAInterval = time.Minute
+
+// MaxPageSize - the largest page of a result list.
+const MaxPageSize = 20
+
+// Commands - the bot commands (/ticket, /my, /queue, /search).
+type Commands struct {
+	// PageSize - issues per page of a result list.
+	PageSize int `yaml:"page_size"`
+}
+
+// Load - reads and validates a configuration file. Errors are *Error
+// joined, with the file name and the line of the value; secret files
+// are relative to the directory of the file.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parse(b, filepath.Dir(path))
+	if err != nil {
+		var errs []error
+
+		for _, e := range Errors(err) {
+			e.File = path
+			errs = append(errs, e)
+		}
+
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+// Parse - decodes and validates a configuration; see Load.
+func Parse(b []byte) (*Config, error) {
+	return parse(b, ".")
+}
+
+// KeepStatic - keeps the settings of the running configuration that
+// take effect only on a restart, and returns those that differ in c.
+func (c *Config) KeepStatic(running *Config) []string {
+	var changed []string
+
+	keep(&changed, "listen", &c.Listen, running.Listen)
+	keep(&changed, "webhook_path", &c.WebhookPath, running.WebhookPath)
+	keep(&changed, "telegram", &c.Telegram, running.Telegram)
+	keep(&changed, "jira.url", &c.Jira.URL, running.Jira.URL)
+	keep(&changed, "jira.cloud", &c.Jira.Cloud, running.Jira.Cloud)
+	keep(&changed, "jira.user", &c.Jira.User, running.Jira.User)
+	keep(&changed, "jira.token", &c.Jira.Token, running.Jira.Token)
+	keep(&changed, "jira.pat", &c.Jira.PAT, running.Jira.PAT)
+	keep(&changed, "store", &c.Store, running.Store)
+	keep(&changed, "outbox", &c.Outbox, running.Outbox)
+	keep(&changed, "sla.interval", &c.SLA.Interval, running.SLA.Interval)
+
+	return changed
+}
+
+func keep[T any](changed *[]string, name string, v *T, running T) {
+	if !reflect.DeepEqual(*v, running) {
+		*changed = append(*changed, name)
+		*v = running
+	}
+}
+
+func (c *Config) setDefaults() {
+	if c.Listen == "" {
+		c.Listen = DefaultListen
+	}
+
+	if c.WebhookPath == "" {
+		c.WebhookPath = DefaultWebhookPath
+	}
+
+	if c.Telegram.Updates == "" {
+		c.Telegram.Updates = UpdatesNone
+	}
+
+	if c.Telegram.WebhookPath == "" {
+		c.Telegram.WebhookPath = DefaultTelegramWebhookPath
+	}
+
+	if c.SLA.JQL == "" {
+		c.SLA.JQL = DefaultSLAJQL
+	}
+
+	if c.Commands.PageSize == 0 {
+		c.Commands.PageSize = DefaultPageSize
+	}
+
+	if len(c.Jira.Priorities) == 0 {
+		c.Jira.Priorities = DefaultPriorities
+	}
+
+	if c.Changelog.Ignore == nil {
+		c.Changelog.Ignore = DefaultIgnoredFields
+	}
+
+	for i := range c.QuietHours {
+		if c.QuietHours[i].Mode == "" {
+			c.QuietHours[i].Mode = QuietSilent
+		}
+	}
+}
+
+// Validate - checks the configuration for missing and invalid values.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Telegram.Token == "" {
+		errs = append(errs, errors.New("telegram.token: required"))
+	}
+
+	if len(c.Chats) == 0 && len(c.Routing.Rules) == 0 {
+		errs = append(errs, errors.New("chats: at least one chat or routing rule is required"))
+	}
+
+	if err := c.Routing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.Telegram.Updates {
+	case UpdatesNone:
+	case UpdatesPolling, UpdatesWebhook:
+		if !c.Jira.HasCredentials() {
+			errs = append(errs, errors.New("jira: url and credentials are required to receive Telegram updates"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("telegram.updates: must be %q, %q or %q, got %q", UpdatesNone, UpdatesPolling, UpdatesWebhook, c.Telegram.Updates))
+	}
+
+	if c.Telegram.Updates == UpdatesWebhook {
+		switch {
+		case c.Telegram.WebhookURL == "":
+			errs = append(errs, errors.New("telegram.webhook_url: required for webhook updates"))
+		case !strings.HasPrefix(c.Telegram.WebhookURL, "https://"):
+			errs = append(errs, errors.New("telegram.webhook_url: must be an https URL"))
+		}
+
+		if !strings.HasPrefix(c.Telegram.WebhookPath, "/") {
+			errs = append(errs, errors.New("telegram.webhook_path: must start with /"))
+		} else if c.Telegram.WebhookPath == c.WebhookPath {
+			errs = append(errs, errors.New("telegram.webhook_path: must differ from webhook_path"))
+		}
+
+		for _, r := range c.Telegram.WebhookSecret {
+			if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+				errs = append(errs, errors.New("telegram.webhook_secret: only A-Z, a-z, 0-9, _ and - are allowed"))
+
+				break
+			}
+		}
+	}
+
+	if len(c.Requests.Chats) > 0 && c.Jira.ServiceDesk == "" {
+		errs = append(errs, errors.New("jira.service_desk: required to create requests"))
+	}
+
+	for i, th := range c.SLA.Thresholds {
+		if th < 0 {
+			errs = append(errs, fmt.Errorf("sla.thresholds[%d]: must not be negative", i))
+		}
+	}
+
+	if c.SLA.Interval != 0 {
+		if c.SLA.Interval < MinSLAInterval {
+			errs = append(errs, fmt.Errorf("sla.interval: must be at least %s", MinSLAInterval))
+		}
+
+		if !c.Jira.HasCredentials() {
+			errs = append(errs, errors.New("jira: url and credentials are required to poll SLAs"))
+		}
+	}
+
+	if c.Outbox.MaxAttempts < 0 {
+		errs = append(errs, errors.New("outbox.max_attempts: must not be negative"))
+	}
+
+	if c.Outbox.Backoff < 0 || c.Outbox.MaxBackoff < 0 {
+		errs = append(errs, errors.New("outbox: backoffs must not be negative"))
+	}
+
+	if c.Commands.PageSize < 1 || c.Commands.PageSize > MaxPageSize {
+		errs = append(errs, fmt.Errorf("commands.page_size: must be between 1 and %d", MaxPageSize))
+	}
+
+	for i, u := range c.Users {
+		if u.Telegram == 0 {
+			errs = append(errs, fmt.Errorf("users[%d].telegram: required", i))
+		}
+
+		if u.AccountID == "" && u.Username == "" {
+			errs = append(errs, fmt.Errorf("users[%d]: account_id or username is required", i))
+		}
+
+		for j, a := range u.Actions {
+			if !slices.Contains(actions, a) {
+				errs = append(errs, fmt.Errorf("users[%d].actions[%d]: unknown action %q", i, j, a))
+			}
+		}
+
+		if u.JiraToken != "" && u.AccountID != "" && u.Email == "" {
+			errs = append(errs, fmt.Errorf("users[%d].email: required with jira_token on Jira Cloud", i))
+		}
+	}
+
+	for i, ch := range c.Chats {
+		if ch.Topics && ch.Thread != 0 {
+			errs = append(errs, fmt.Errorf("chats[%d]: thread and topics are mutually exclusive", i))
+		}
+
+		if ch.Coalesce < 0 {
+			errs = append(errs, fmt.Errorf("chats[%d].coalesce: must not be negative", i))
+		}
+	}
+
+	if c.Topics.Color != 0 && !slices.Contains(tg.TopicColors, c.Topics.Color) {
+		errs = append(errs, fmt.Errorf("topics.color: %#x is not a forum topic color", c.Topics.Color))
+	}
+
+	for i, cidr := range c.Auth.Allow {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("auth.allow[%d]: %w", i, err))
+		}
+	}
+
+	if _, err := render.NewSet(c.Templates, nil, nil); err != nil {
+		errs = append(errs, fmt.Errorf("templates: %w", err))
+	}
+
+	for i, r := range c.Routing.Rules {
+		if _, err := render.NewSet(r.Templates, nil, nil); err != nil {
+			errs = append(errs, fmt.Errorf("routing.rules[%d].templates: %w", i, err))
+		}
+	}
+
+	for i, ch := range c.Chats {
+		if ch.ID == 0 {
+			errs = append(errs, fmt.Errorf("chats[%d].id: required", i))
+		}
+	}
+
+	for i, q := range c.QuietHours {
+		if _, _, _, err := q.Window(); err != nil {
+			errs = append(errs, fmt.Errorf("quiet_hours[%d].%w", i, err))
+		}
+
+		if q.Mode != QuietSilent && q.Mode != QuietHold {
+			errs = append(errs, fmt.Errorf("quiet_hours[%d].mode: must be %q or %q, got %q", i, QuietSilent, QuietHold, q.Mode))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+telegram:
+  token: "123:abc"
+jira:
+  url: https://jira.example.com
+chats:
+  - id: -1001
+  - id: -1002
+    thread: 7
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if c.Listen != DefaultListen || c.WebhookPath != DefaultWebhookPath {
+		t.Errorf("defaults not applied: %+v", c)
+	}
+
+	if len(c.Chats) != 2 || c.Chats[1].Thread != 7 {
+		t.Errorf("chats = %+v", c.Chats)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, err := Parse([]byte("chats:\n  - thread: 1\n"))
+	if err == nil {
+		t.Fatal("Parse: expected error")
+	}
+
+	for _, want := range []string{"telegram.token", "chats[0].id"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestValidateTopics(t *testing.T) {
+	_, err := Parse([]byte("telegram:\n  token: x\nchats:\n  - id: -1\n    thread: 1\n    topics: true\ntopics:\n  color: 1\n"))
+	if err == nil {
+		t.Fatal("Parse: expected error")
+	}
+
+	for _, want := range []string{"chats[0]: thread and topics", "topics.color"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestValidateUsers(t *testing.T) {
+	_, err := Parse([]byte("telegram:\n  token: x\nchats:\n  - id: -1\nusers:\n  - telegram: 1\n    account_id: acc-1\n    jira_token: t\n  - telegram: 2\n    username: jdoe\n    jira_token: t\n"))
+	if err == nil {
+		t.Fatal("Parse: expected error")
+	}
+
+	if errs := Errors(err); len(errs) != 1 || errs[0].Path != "users[0].email" {
+		t.Errorf("errors = %v, want only users[0].email", err)
+	}
+}
+
+func TestValidateTelegramWebhook(t *testing.T) {
+	_, err := Parse([]byte("telegram:\n  token: x\n  updates: webhook\n  webhook_url: http://bot.example.com/telegram\n  webhook_secret: no spaces\njira:\n  url: https://jira.example.com\n  pat: p\nchats:\n  - id: -1\ncommands:\n  page_size: 50\nrequests:\n  chats: [-2]\n"))
+	if err == nil {
+		t.Fatal("Parse: expected error")
+	}
+
+	for _, want := range []string{"telegram.webhook_url: must be an https URL", "telegram.webhook_secret", "commands.page_size", "jira.service_desk"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	c, err := Parse([]byte("telegram:\n  token: x\n  updates: webhook\n  webhook_url: https://bot.example.com/telegram\njira:\n  url: https://jira.example.com\n  pat: p\nchats:\n  - id: -1\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if c.Telegram.WebhookPath != DefaultTelegramWebhookPath || c.Commands.PageSize != DefaultPageSize {
+		t.Errorf("defaults = %q, %d", c.Telegram.WebhookPath, c.Commands.PageSize)
+	}
+}
+
+func TestSLA(t *testing.T) {
+	c, err := Parse([]byte("telegram:\n  token: x\njira:\n  url: https://jira.example.com\n  pat: p\nchats:\n  - id: -1\nusers:\n  - telegram: 1\n    account_id: acc-1\n  - telegram: 2\n    username: jdoe\nsla:\n  thresholds: [30m, 0s]\n  interval: 5m\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if len(c.SLA.Thresholds) != 2 || c.SLA.Thresholds[0] != 30*time.Minute || c.SLA.Interval != 5*time.Minute || c.SLA.JQL != DefaultSLAJQL {
+		t.Errorf("sla = %+v", c.SLA)
+	}
+
+	for _, tt := range []struct {
+		accountID, username string
+		want                int64
+	}{
+		{"acc-1", "", 1},
+		{"", "jdoe", 2},
+		{"acc-2", "", 0},
+		{"", "", 0},
+	} {
+		if u, _ := c.UserByJira(tt.accountID, tt.username); u.Telegram != tt.want {
+			t.Errorf("UserByJira(%q, %q) = %d, want %d", tt.accountID, tt.username, u.Telegram, tt.want)
+		}
+	}
+
+	_, err = Parse([]byte("telegram:\n  token: x\nchats:\n  - id: -1\nsla:\n  thresholds: [-1m]\n  interval: 10s\n"))
+	for _, want := range []string{"sla.thresholds[0]", "sla.interval", "to poll SLAs"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %q", err, want)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	c, err := Parse([]byte("telegram:\n  token: x\nchats:\n  - id: -1\noutbox:\n  max_attempts: 5\n  backoff: 1m\n  max_backoff: 1h\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if c.Outbox != (Outbox{MaxAttempts: 5, Backoff: time.Minute, MaxBackoff: time.Hour}) {
+		t.Errorf("outbox = %+v", c.Outbox)
+	}
+
+	_, err = Parse([]byte("telegram:\n  token: x\nchats:\n  - id: -1\noutbox:\n  max_attempts: -1\n  backoff: -1s\n"))
+	for _, want := range []string{"outbox.max_attempts", "backoffs"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %q", err, want)
+		}
+	}
+}
+
+func TestChangelog(t *testing.T) {
+	for _, tt := range []struct {
+		yaml string
+		want int
+	}{
+		{"", len(DefaultIgnoredFields)},
+		{"changelog:\n  ignore: []\n", 0},
+		{"changelog:\n  ignore: [Sprint]\n", 1},
+	} {
+		c, err := Parse([]byte("telegram:\n  token: x\nchats:\n  - id: -1\n" + tt.yaml))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+
+		if len(c.Changelog.Ignore) != tt.want {
+			t.Errorf("%q: ignore = %q, want %d fields", tt.yaml, c.Changelog.Ignore, tt.want)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	c, err := Parse([]byte("telegram:\n  token: x\nchats:\n  - id: -1\nquiet_hours:\n  - from: \"22:30\"\n    to: \"08:00\"\n    timezone: Europe/Moscow\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	q := c.QuietHours[0]
+	if q.Mode != QuietSilent {
+		t.Errorf("mode = %q, want %q", q.Mode, QuietSilent)
+	}
+
+	if from, to, loc, err := q.Window(); from != 22*60+30 || to != 8*60 || loc.String() != "Europe/Moscow" || err != nil {
+		t.Errorf("Window() = %d, %d, %v, %v", from, to, loc, err)
+	}
+
+	_, err = Parse([]byte("telegram:\n  token: x\nchats:\n  - id: -1\nquiet_hours:\n  - from: \"25:00\"\n    to: \"8\"\n    mode: mute\n"))
+	for _, want := range []string{"quiet_hours[0].from", "quiet_hours[0].mode"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %q", err, want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "unknown fields",
+			yaml: "telegram:\n  token: x\n  tokne: y\nchats:\n  - id: -1\n    threads: 2\n",
+			want: []string{"line 3: telegram.tokne: unknown field", "line 6: chats[0].threads: unknown field"},
+		},
+		{
+			name: "types",
+			yaml: "telegram:\n  token: x\nchats:\n  - id: first\n",
+			want: []string{"line 4: cannot unmarshal !!str `first` into int64"},
+		},
+		{
+			name: "syntax",
+			yaml: "telegram:\n  token: x\n chats: []\n",
+			want: []string{"line 2: did not find expected key"},
+		},
+		{
+			name: "validation",
+			yaml: "telegram:\n  updates: sometimes\nchats:\n  - id: -1\n  - thread: 2\nrouting:\n  rules:\n    - name: a\n      to:\n        - chat: -1\n        - thread: 1\n",
+			want: []string{
+				"line 1: telegram.token: required",
+				"line 2: telegram.updates: must be",
+				"line 5: chats[1].id: required",
+				"line 11: routing.rules[0].to[1].chat: required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.yaml))
+
+		var got []string
+		for _, e := range Errors(err) {
+			got = append(got, e.Error())
+		}
+
+		for _, want := range tt.want {
+			if !slices.ContainsFunc(got, func(s string) bool { return strings.HasPrefix(s, want) }) {
+				t.Errorf("%s: errors %q, want %q", tt.name, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jsm2tg.yaml")
+	if err := os.WriteFile(path, []byte("telegram:\n  token: x\nchats:\n  - id: 0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(path)
+	if want := path + ":4: chats[0].id: required"; err == nil || err.Error() != want {
+		t.Errorf("Load() error = %v, want %q", err, want)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("123:secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JSM2TG_CHAT", "-1001")
+	t.Setenv("JSM2TG_EMPTY", "")
+
+	path := filepath.Join(dir, "jsm2tg.yaml")
+	if err := os.WriteFile(path, []byte(`
+telegram:
+  token: ${file:token}
+jira:
+  url: ${JSM2TG_JIRA:-https://jira.example.com}
+  user: "${JSM2TG_EMPTY}"
+chats:
+  - id: ${JSM2TG_CHAT}
+templates:
+  default: "$${not expanded}"
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if c.Telegram.Token != "123:secret" || c.Jira.URL != "https://jira.example.com" || c.Chats[0].ID != -1001 || c.Templates["default"] != "${not expanded}" {
+		t.Errorf("config = %+v", c)
+	}
+
+	for _, tt := range []struct{ yaml, want string }{
+		{"telegram:\n  token: ${JSM2TG_UNSET}\n", "line 2: environment variable JSM2TG_UNSET is not set"},
+		{"telegram:\n  token: ${file:missing}\n", "line 2: open"},
+		{"telegram:\n  token: ${JSM2TG_CHAT\n", "line 2: unterminated reference"},
+	} {
+		if _, err := Parse([]byte(tt.yaml)); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%q: error = %v, want %q", tt.yaml, err, tt.want)
+		}
+	}
+}
+
+func TestKeepStatic(t *testing.T) {
+	running := &Config{Listen: ":8080", Telegram: Telegram{Token: "a"}, Jira: Jira{URL: "https://jira.example.com"}, Chats: []Chat{{ID: -1}}}
+	c := &Config{Listen: ":8080", Telegram: Telegram{Token: "b"}, Jira: Jira{URL: "https://jira.example.com", ServiceDesk: "2"}, Chats: []Chat{{ID: -2}}}
+
+	changed := c.KeepStatic(running)
+	if !slices.Equal(changed, []string{"telegram"}) {
+		t.Errorf("KeepStatic() = %q, want [telegram]", changed)
+	}
+
+	if c.Telegram.Token != "a" || c.Chats[0].ID != -2 || c.Jira.ServiceDesk != "2" {
+		t.Errorf("config = %+v", c)
+	}
+}
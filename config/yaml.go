@@ -0,0 +1,368 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error - an error in a configuration file: the line (0 if unknown)
+// and the path of the value, e.g. "chats[0].id" (empty if unknown).
+type Error struct {
+	File string
+	Line int
+	Path string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+
+	switch {
+	case e.File != "" && e.Line > 0:
+		fmt.Fprintf(&b, "%s:%d: ", e.File, e.Line)
+	case e.File != "":
+		b.WriteString(e.File + ": ")
+	case e.Line > 0:
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+
+	if e.Path != "" {
+		b.WriteString(e.Path + ": ")
+	}
+
+	b.WriteString(e.Msg)
+
+	return b.String()
+}
+
+// Errors - returns the configuration errors joined in err.
+func Errors(err error) []*Error {
+	var out []*Error
+
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range j.Unwrap() {
+			out = append(out, Errors(e)...)
+		}
+
+		return out
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return []*Error{e}
+	}
+
+	if err != nil {
+		out = append(out, &Error{Msg: err.Error()})
+	}
+
+	return out
+}
+
+var (
+	// "yaml: line 3: ..." of syntax and type errors
+	yamlLineRe = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	// "chats[0].id: ..." of Validate errors
+	pathRe = regexp.MustCompile(`(?s)^([a-z_]+(?:\[\d+\]|\.[a-z_]+)*): (.*)$`)
+	// the steps of a path
+	stepRe = regexp.MustCompile(`[a-z_]+|\[\d+\]`)
+)
+
+// parse - decodes a configuration: expands ${...} references, rejects
+// unknown fields, decodes and validates it. Errors are *Error joined,
+// with the lines of the values. Secret files are relative to dir.
+func parse(b []byte, dir string) (*Config, error) {
+	var (
+		c   Config
+		doc yaml.Node
+	)
+
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, yamlErrors(err)
+	}
+
+	if len(doc.Content) > 0 {
+		errs := expand(&doc, dir)
+		errs = append(errs, checkFields(doc.Content[0], reflect.TypeFor[Config](), "")...)
+
+		if len(errs) > 0 {
+			slices.SortStableFunc(errs, func(a, b error) int {
+				return cmp.Compare(a.(*Error).Line, b.(*Error).Line)
+			})
+
+			return nil, errors.Join(errs...)
+		}
+
+		if err := doc.Decode(&c); err != nil {
+			return nil, yamlErrors(err)
+		}
+	}
+
+	c.setDefaults()
+
+	if err := c.Validate(); err != nil {
+		var errs []error
+
+		for _, e := range Errors(err) {
+			if m := pathRe.FindStringSubmatch(e.Msg); m != nil {
+				e.Path, e.Msg = m[1], m[2]
+				e.Line = lineOf(&doc, e.Path)
+			}
+
+			errs = append(errs, e)
+		}
+
+		return nil, errors.Join(errs...)
+	}
+
+	return &c, nil
+}
+
+// yamlErrors - turns YAML syntax and type errors into *Error.
+func yamlErrors(err error) error {
+	msgs := []string{err.Error()}
+
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		msgs = te.Errors
+	}
+
+	errs := make([]error, 0, len(msgs))
+
+	for _, msg := range msgs {
+		e := &Error{Msg: msg}
+
+		if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = m[2]
+		}
+
+		errs = append(errs, e)
+	}
+
+	return errors.Join(errs...)
+}
+
+// lineOf - returns the line of the value at the path, or of its nearest
+// parent present in the document if it's missing; 0 if none is.
+func lineOf(doc *yaml.Node, path string) int {
+	if len(doc.Content) == 0 {
+		return 0
+	}
+
+	n, line := doc.Content[0], 0
+
+	for _, step := range stepRe.FindAllString(path, -1) {
+		var next *yaml.Node
+
+		if strings.HasPrefix(step, "[") {
+			i, _ := strconv.Atoi(step[1 : len(step)-1])
+			if n.Kind == yaml.SequenceNode && i < len(n.Content) {
+				next = n.Content[i]
+				line = next.Line
+			}
+		} else if n.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == step {
+					next = n.Content[i+1]
+					line = n.Content[i].Line
+
+					break
+				}
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		n = next
+	}
+
+	return line
+}
+
+// checkFields - reports the keys of the mappings under n that aren't
+// fields of t. Values of a wrong type are left to decoding.
+func checkFields(n *yaml.Node, t reflect.Type, path string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	var errs []error
+
+	switch {
+	case t.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Value == "<<" {
+				continue
+			}
+
+			p := k.Value
+			if path != "" {
+				p = path + "." + k.Value
+			}
+
+			ft, ok := fields[k.Value]
+			if !ok {
+				errs = append(errs, &Error{Line: k.Line, Path: p, Msg: "unknown field"})
+
+				continue
+			}
+
+			errs = append(errs, checkFields(v, ft, p)...)
+		}
+	case t.Kind() == reflect.Slice && n.Kind == yaml.SequenceNode:
+		for i, c := range n.Content {
+			errs = append(errs, checkFields(c, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case t.Kind() == reflect.Map && n.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			errs = append(errs, checkFields(n.Content[i+1], t.Elem(), path+"."+n.Content[i].Value)...)
+		}
+	}
+
+	return errs
+}
+
+// yamlFields - returns the types of the fields of a struct by their
+// YAML keys.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+
+		switch {
+		case name == "-":
+		case strings.Contains(opts, "inline"):
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+		case name == "":
+			fields[strings.ToLower(f.Name)] = f.Type
+		default:
+			fields[name] = f.Type
+		}
+	}
+
+	return fields
+}
+
+// expand - replaces the references in the scalars under n: ${NAME} with
+// the environment variable, ${NAME:-default} with a default if it's
+// unset or empty, and ${file:PATH} with the contents of the file
+// without the trailing newline, e.g. a secret mounted by an orchestrator.
+// $${ stays as ${.
+func expand(n *yaml.Node, dir string) []error {
+	var errs []error
+
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "${") {
+		v, err := expandString(n.Value, dir)
+		if err != nil {
+			return []error{&Error{Line: n.Line, Msg: err.Error()}}
+		}
+
+		n.Value = v
+
+		// plain scalars are typed by the expanded value, e.g. a chat id
+		if n.Style == 0 {
+			n.Tag = ""
+		}
+	}
+
+	for _, c := range n.Content {
+		errs = append(errs, expand(c, dir)...)
+	}
+
+	return errs
+}
+
+func expandString(s, dir string) (string, error) {
+	var b strings.Builder
+
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+
+			return b.String(), nil
+		}
+
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference %q", s[i:])
+		}
+
+		v, err := resolve(s[i+2:i+end], dir)
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(s[:i] + v)
+		s = s[i+end+1:]
+	}
+}
+
+// resolve - returns the value of a reference without ${ and }.
+func resolve(ref, dir string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	name, def, hasDef := strings.Cut(ref, ":-")
+
+	if v := os.Getenv(name); v != "" || !hasDef && isSet(name) {
+		return v, nil
+	}
+
+	if hasDef {
+		return def, nil
+	}
+
+	return "", fmt.Errorf("environment variable %s is not set", name)
+}
+
+func isSet(name string) bool {
+	_, ok := os.LookupEnv(name)
+
+	return ok
+}
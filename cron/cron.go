@@ -0,0 +1,215 @@
+// Package cron parses cron-like schedules and finds their next run.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field - the bounds of a schedule field.
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = [5]field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are Sunday
+}
+
+// macros - the shorthands of common schedules.
+var macros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// Schedule - a parsed schedule: the minutes, hours, days of month,
+// months and days of week it runs at, as bit sets.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// a restricted day of month or week; if both are, either may match
+	domAny, dowAny bool
+}
+
+// Parse - parses a schedule of five space-separated fields: minute,
+// hour, day of month, month and day of week. A field is "*" or a list
+// of values and ranges ("1,3-5"), each optionally with a step ("*/15",
+// "9-17/2"). The macros @hourly, @daily, @weekly, @monthly and @yearly
+// are accepted too.
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if m, ok := macros[spec]; ok {
+		spec = m
+	}
+
+	ff := strings.Fields(spec)
+	if len(ff) != len(fields) {
+		return nil, fmt.Errorf("cron: %q: want %d fields, got %d", spec, len(fields), len(ff))
+	}
+
+	var (
+		sets [5]uint64
+		errs []error
+	)
+
+	for i, f := range ff {
+		set, err := parseField(f, fields[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cron: %s: %w", fields[i].name, err))
+		}
+
+		sets[i] = set
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	s := &Schedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: ff[2] == "*",
+		dowAny: ff[4] == "*",
+	}
+
+	// Sunday is 0
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+// parseField - returns the values of a field as a bit set.
+func parseField(s string, f field) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+
+		if r, st, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(st)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step %q", st)
+			}
+
+			rng, step = r, n
+		}
+
+		lo, hi := f.min, f.max
+
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+
+			var err error
+			if lo, err = value(from, f); err != nil {
+				return 0, err
+			}
+
+			hi = lo
+			if isRange {
+				if hi, err = value(to, f); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "5/15" - from 5 on
+				hi = f.max
+			}
+
+			if lo > hi {
+				return 0, fmt.Errorf("bad range %q", rng)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+func value(s string, f field) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%q is not in %d-%d", s, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<v) != 0
+}
+
+// day - reports whether the schedule runs on the day of t.
+func (s *Schedule) day(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// maxYears - how far Next looks for a run, e.g. of "0 0 30 2 *".
+const maxYears = 5
+
+// Next - returns the first run after t, in the time zone of t; the zero
+// time if the schedule never runs. Runs at wall clock times skipped by
+// a daylight saving change are skipped too, and runs in a repeated hour
+// happen once.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.AddDate(maxYears, 0, 0)
+
+	// next - the wall clock time, if it is later than t
+	next := func(year int, month time.Month, day, hour, min int) time.Time {
+		n := time.Date(year, month, day, hour, min, 0, 0, loc)
+		if !n.After(t) {
+			n = t.Truncate(time.Minute).Add(time.Minute)
+		}
+
+		return n
+	}
+
+	t = next(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1)
+
+	for t.Before(limit) {
+		switch {
+		case !has(s.month, int(t.Month())):
+			t = next(t.Year(), t.Month()+1, 1, 0, 0)
+		case !s.day(t):
+			t = next(t.Year(), t.Month(), t.Day()+1, 0, 0)
+		case !has(s.hour, t.Hour()):
+			t = next(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0)
+		case !has(s.minute, t.Minute()):
+			t = next(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
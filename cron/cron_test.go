@@ -0,0 +1,94 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"0 9 * * *", true},
+		{"*/15 9-17 * * 1-5", true},
+		{"0 9,13,18 1 */3 7", true},
+		{"5/20 * * * *", true},
+		{"@weekly", true},
+		{"0 9 * *", false},
+		{"60 * * * *", false},
+		{"0 24 * * *", false},
+		{"0 0 0 * *", false},
+		{"0 0 * 13 *", false},
+		{"0 0 * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+	}
+
+	for _, tt := range tests {
+		if _, err := Parse(tt.spec); (err == nil) != tt.ok {
+			t.Errorf("%s: Parse() error = %v, want ok %v", tt.spec, err, tt.ok)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+
+	// Wednesday
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		now  time.Time
+		want time.Time
+	}{
+		{"0 9 * * *", now, time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * *", now.In(msk), time.Date(2024, 5, 2, 9, 0, 0, 0, msk)},
+		{"0 14 * * *", now.In(msk), time.Date(2024, 5, 1, 14, 0, 0, 0, msk)},
+		{"*/15 * * * *", now, time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC)},
+		{"*/15 * * * *", now.Add(time.Second), time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", now, time.Date(2024, 5, 2, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1", now, time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", now, time.Date(2024, 5, 5, 9, 0, 0, 0, time.UTC)},
+		{"0 9 1 * *", now, time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)},
+		// either the day of month or of week
+		{"0 9 15 * 5", now, time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", now, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", now, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", now, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", tt.spec, err)
+		}
+
+		if got := s.Next(tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: Next(%v) = %v, want %v", tt.spec, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	s, _ := Parse("30 2 * * *")
+
+	// 02:30 doesn't exist on 2024-03-31
+	got := s.Next(time.Date(2024, 3, 30, 12, 0, 0, 0, berlin))
+	if want := time.Date(2024, 3, 30, 2, 30, 0, 0, berlin).AddDate(0, 0, 2); !got.Equal(want) {
+		t.Errorf("spring forward: Next = %v, want %v", got, want)
+	}
+
+	// 02:30 happens twice on 2024-10-27
+	first := s.Next(time.Date(2024, 10, 27, 0, 0, 0, 0, berlin))
+	if second := s.Next(first); second.Sub(first) < 24*time.Hour {
+		t.Errorf("fall back: runs at %v and %v", first, second)
+	}
+}
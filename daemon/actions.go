@@ -0,0 +1,317 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/schors/jsm2tg/callback"
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Callback actions of the card buttons.
+const (
+	actionTransition = "t" // Arg is the transition id
+	actionAssign     = "a"
+	actionPriority   = "p"
+)
+
+// actionPermissions - the user permission each callback action needs.
+var actionPermissions = map[string]string{
+	actionTransition: config.ActionTransition,
+	actionAssign:     config.ActionAssign,
+	actionPriority:   config.ActionPriority,
+}
+
+// keyboardColumns - transition buttons per keyboard row.
+const keyboardColumns = 2
+
+// maxAnswerLength - the Bot API limit for callback query answers.
+const maxAnswerLength = 200
+
+// notice - an error explained to the user who pressed a button.
+type notice string
+
+func (n notice) Error() string {
+	return string(n)
+}
+
+// button - returns a callback button; false if the data doesn't fit.
+func (d *Daemon) button(text string, cd callback.Data) (tg.InlineKeyboardButton, bool) {
+	data, err := d.signer.Encode(cd)
+	if err != nil {
+		d.log.Debug("button dropped", "text", text, "issue", cd.Issue, "error", err)
+
+		return tg.InlineKeyboardButton{}, false
+	}
+
+	return tg.InlineKeyboardButton{Text: text, CallbackData: data}, true
+}
+
+// keyboard - returns the action buttons of an issue card: the available
+// transitions, "Assign to me" and "Priority ↑". It returns nil if button
+// presses aren't received.
+func (d *Daemon) keyboard(ctx context.Context, is *jira.Issue) *tg.InlineKeyboardMarkup {
+	if is == nil || d.jira == nil || !d.receivesUpdates() {
+		return nil
+	}
+
+	var rows [][]tg.InlineKeyboardButton
+
+	tt, err := d.jira.Transitions(ctx, is.Key)
+	if err != nil {
+		d.log.Warn("transitions unavailable", "issue", is.Key, "error", err)
+	}
+
+	var row []tg.InlineKeyboardButton
+
+	for _, t := range tt {
+		if b, ok := d.button(t.Name, callback.Data{Action: actionTransition, Issue: is.Key, Arg: t.ID}); ok {
+			row = append(row, b)
+		}
+
+		if len(row) == keyboardColumns {
+			rows, row = append(rows, row), nil
+		}
+	}
+
+	if len(row) > 0 {
+		rows, row = append(rows, row), nil
+	}
+
+	if b, ok := d.button("🙋 Assign to me", callback.Data{Action: actionAssign, Issue: is.Key}); ok {
+		row = append(row, b)
+	}
+
+	if d.higherPriority(name(is.Fields.Priority)) != "" {
+		if b, ok := d.button("⬆️ Priority", callback.Data{Action: actionPriority, Issue: is.Key}); ok {
+			row = append(row, b)
+		}
+	}
+
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return &tg.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// higherPriority - returns the priority above p, or "" if p is
+// the highest or unknown.
+func (d *Daemon) higherPriority(p string) string {
+	pp := d.conf().Jira.Priorities
+	for i := range pp {
+		if strings.EqualFold(pp[i], p) && i+1 < len(pp) {
+			return pp[i+1]
+		}
+	}
+
+	return ""
+}
+
+// chatTemplates - returns the templates used for cards in a chat.
+func (d *Daemon) chatTemplates(chat int64, thread int) *render.Set {
+	s := d.current()
+
+	rules := s.router.Rules()
+	for i := range rules {
+		for _, to := range rules[i].To {
+			if to.Chat == chat && (to.Thread == thread || to.Topics) {
+				if set, ok := s.ruleTemplates[&rules[i]]; ok {
+					return set
+				}
+
+				return s.templates
+			}
+		}
+	}
+
+	return s.templates
+}
+
+// handleCallback - verifies a button press and queues its action.
+func (d *Daemon) handleCallback(ctx context.Context, q *tg.CallbackQuery) {
+	cd, err := d.signer.Decode(q.Data)
+	if err != nil {
+		d.log.Warn("callback rejected", "telegram_user", q.From.ID, "error", err)
+		d.answer(ctx, q, "This button is not valid anymore.", true)
+
+		return
+	}
+
+	select {
+	case d.queue(cd.Issue) <- func(ctx context.Context) { d.callback(ctx, q, cd) }:
+	case <-ctx.Done():
+	}
+}
+
+// callback - performs a button action and answers the callback query.
+func (d *Daemon) callback(ctx context.Context, q *tg.CallbackQuery, cd callback.Data) {
+	var (
+		text string
+		err  error
+	)
+
+	switch cd.Action {
+	case actionList:
+		text, err = d.page(ctx, q, cd)
+	case actionNew:
+		text, err = d.createRequest(ctx, q, cd)
+	case actionApproval:
+		text, err = d.answerApproval(ctx, q, cd)
+	default:
+		text, err = d.act(ctx, q, cd)
+	}
+
+	var n notice
+
+	switch {
+	case errors.As(err, &n):
+		d.answer(ctx, q, string(n), true)
+	case err != nil:
+		d.log.Error("action failed", "issue", cd.Issue, "action", cd.Action, "telegram_user", q.From.ID, "error", err)
+
+		text = "Jira rejected the action."
+		if e, ok := jira.AsError(err); ok && len(e.Messages) > 0 {
+			text += " " + e.Messages[0]
+		}
+
+		d.answer(ctx, q, text, true)
+	default:
+		d.answer(ctx, q, text, false)
+	}
+}
+
+// act - checks the user's permission and performs a button action,
+// then updates the card in place. It returns the answer text.
+func (d *Daemon) act(ctx context.Context, q *tg.CallbackQuery, cd callback.Data) (string, error) {
+	perm, ok := actionPermissions[cd.Action]
+	if !ok {
+		return "", notice("Unknown action.")
+	}
+
+	u, ok := d.conf().UserByTelegram(q.From.ID)
+	if !ok {
+		return "", notice("Your Telegram account isn't linked to a Jira user.")
+	}
+
+	if !u.Allows(perm) {
+		return "", notice("You are not allowed to do this.")
+	}
+
+	var done string
+
+	switch cd.Action {
+	case actionTransition:
+		if err := d.jira.DoTransition(ctx, cd.Issue, cd.Arg); err != nil {
+			return "", err
+		}
+
+		done = "Done."
+	case actionAssign:
+		if err := d.jira.Assign(ctx, cd.Issue, jira.User{AccountID: u.AccountID, Name: u.Username}); err != nil {
+			return "", err
+		}
+
+		done = "Assigned to you."
+	case actionPriority:
+		is, err := d.jira.GetIssue(ctx, cd.Issue, jira.IssueOptions{Fields: []string{"priority"}})
+		if err != nil {
+			return "", err
+		}
+
+		next := d.higherPriority(name(is.Fields.Priority))
+		if next == "" {
+			return "", notice("The priority is already the highest.")
+		}
+
+		if err := d.jira.EditIssue(ctx, cd.Issue, map[string]any{"priority": map[string]string{"name": next}}); err != nil {
+			return "", err
+		}
+
+		done = "Priority: " + next + "."
+	}
+
+	d.log.Info("action performed", "issue", cd.Issue, "action", perm, "telegram_user", q.From.ID)
+
+	if q.Message != nil {
+		if err := d.refreshCard(ctx, q.Message, cd.Issue); err != nil {
+			d.log.Warn("card not updated", "issue", cd.Issue, "chat", q.Message.Chat.ID, "error", err)
+		}
+	}
+
+	return done, nil
+}
+
+// refreshCard - re-renders a card from the current state of its issue,
+// the way it is rendered for its routing target.
+func (d *Daemon) refreshCard(ctx context.Context, m *tg.Message, key string) error {
+	is, err := d.jira.GetIssue(ctx, key, jira.IssueOptions{})
+	if err != nil {
+		return err
+	}
+
+	e := &jira.Event{WebhookEvent: jira.EventIssueUpdated, Issue: is}
+	set := d.chatTemplates(m.Chat.ID, m.MessageThreadID)
+	data := d.eventData(e)
+
+	if t, ok := d.cardTarget(is, m.Chat.ID, m.MessageThreadID); ok {
+		set = d.templateSet(t)
+		data.OnCall = d.onCall(t)
+	}
+
+	rr, ok, err := d.renderings(set, jira.EventIssueCreated, e, data)
+	if err != nil || !ok {
+		return err
+	}
+
+	_, err = d.sender.EditRendered(ctx, tg.EditMessageTextParams{
+		ChatID:             m.Chat.ID,
+		MessageID:          m.MessageID,
+		LinkPreviewOptions: &tg.LinkPreviewOptions{IsDisabled: true},
+		ReplyMarkup:        d.keyboard(ctx, is),
+	}, rr)
+	if errors.Is(err, tg.ErrMessageNotModified) || errors.Is(err, delivery.ErrQueued) {
+		return nil
+	}
+
+	return err
+}
+
+// cardTarget - routes the issue as a new one to find the target its card
+// in the chat thread was posted for.
+func (d *Daemon) cardTarget(is *jira.Issue, chat int64, thread int) (route.Target, bool) {
+	targets, err := d.targets(&jira.Event{WebhookEvent: jira.EventIssueCreated, Issue: is})
+	if err != nil {
+		d.log.Warn("card not routed", "issue", is.Key, "chat", chat, "error", err)
+	}
+
+	for _, t := range targets {
+		if t.Chat == chat && (t.Thread == thread || t.Topics) {
+			return t, true
+		}
+	}
+
+	return route.Target{}, false
+}
+
+// answer - answers a callback query.
+func (d *Daemon) answer(ctx context.Context, q *tg.CallbackQuery, text string, alert bool) {
+	err := d.bot.AnswerCallbackQuery(ctx, tg.AnswerCallbackQueryParams{
+		CallbackQueryID: q.ID,
+		Text:            render.Truncate(text, maxAnswerLength-1),
+		ShowAlert:       alert,
+	})
+	if err != nil {
+		d.log.Warn("callback not answered", "error", err)
+	}
+}
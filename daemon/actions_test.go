@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/schors/jsm2tg/callback"
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/jira/jiratest"
+	"github.com/schors/jsm2tg/oncall"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func TestCardActions(t *testing.T) {
+	js := jiratest.NewServer()
+	defer js.Close()
+
+	js.AddIssue(jiratest.Issue{
+		Key:      "SD-42",
+		Summary:  "VPN is down",
+		Status:   "Open",
+		Priority: "High",
+		Transitions: []jiratest.Transition{
+			{ID: "11", Name: "Start progress", To: "In Progress"},
+			{ID: "31", Name: "Resolve", To: "Resolved"},
+		},
+	})
+
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Telegram.Updates = config.UpdatesPolling
+	cfg.Jira.Priorities = config.DefaultPriorities
+	cfg.Users = []config.User{
+		{Telegram: 501, AccountID: "acc-alice"},
+		{Telegram: 502, AccountID: "acc-bob", Actions: []string{config.ActionAssign}},
+	}
+
+	oc, err := oncall.Parse([]byte(`{rotations: [{start: "2024-05-01 00:00", shift: 24h, members: [{name: Alice, telegram: 501}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := newFakeSender()
+	fb := &fakeBot{}
+
+	d, err := New(cfg, fs, WithWorkers(1), WithJira(jira.NewClient(js.URL)), WithBot(fb), WithOnCall(oc))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx := context.Background()
+
+	if err := d.Handle(ctx, readEvent(t, "issue_created.json")); err != nil {
+		t.Fatalf("created: %v", err)
+	}
+
+	got := fs.wait(t, 1)
+
+	kb := got[0].params.ReplyMarkup
+	if kb == nil {
+		t.Fatal("card has no keyboard")
+	}
+
+	buttons := map[string]string{}
+
+	for _, row := range kb.InlineKeyboard {
+		for _, b := range row {
+			if len(b.CallbackData) > callback.MaxLength {
+				t.Errorf("%q: callback data is %d bytes", b.Text, len(b.CallbackData))
+			}
+
+			buttons[b.Text] = b.CallbackData
+		}
+	}
+
+	for _, want := range []string{"Start progress", "Resolve", "🙋 Assign to me", "⬆️ Priority"} {
+		if _, ok := buttons[want]; !ok {
+			t.Errorf("no %q button in %+v", want, kb.InlineKeyboard)
+		}
+	}
+
+	card := &tg.Message{MessageID: 1, Chat: tg.Chat{ID: -1001}}
+
+	press := func(user int64, data string) {
+		t.Helper()
+
+		q := &tg.CallbackQuery{ID: "q", From: tg.User{ID: user}, Message: card, Data: data}
+
+		cd, err := d.signer.Decode(data)
+		if err != nil {
+			d.handleCallback(ctx, q)
+
+			return
+		}
+
+		// run the queued job synchronously
+		d.callback(ctx, q, cd)
+	}
+
+	press(501, buttons["Start progress"])
+	press(501, buttons["⬆️ Priority"])
+	press(502, buttons["Resolve"])
+	press(502, buttons["🙋 Assign to me"])
+	press(777, buttons["Resolve"])
+	press(501, "forged-button-data")
+
+	is, _ := js.Issue("SD-42")
+	if is.Status != "In Progress" || is.Priority != "Highest" || is.Assignee != "acc-bob" {
+		t.Errorf("issue = %+v", is)
+	}
+
+	want := []string{
+		"answer Done.",
+		"answer Priority: Highest.",
+		"answer You are not allowed to do this.",
+		"answer Assigned to you.",
+		"answer Your Telegram account isn't linked to a Jira user.",
+		"answer This button is not valid anymore.",
+	}
+
+	if strings.Join(fb.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("answers = %q, want %q", fb.calls, want)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.edits) != 3 {
+		t.Fatalf("card edits = %d, want 3", len(fs.edits))
+	}
+
+	last := fs.edits[2]
+	if last.params.MessageID != 1 || !strings.Contains(last.rr[0].Text, "In Progress") || !strings.Contains(last.rr[0].Text, "Highest") ||
+		!strings.Contains(last.rr[0].Text, "\nOn call: [Alice](tg://user?id=501)\n") {
+		t.Errorf("updated card = %+v: %s", last.params, last.rr[0].Text)
+	}
+
+	// the highest priority can't be raised
+	for _, row := range last.params.ReplyMarkup.InlineKeyboard {
+		for _, b := range row {
+			if b.Text == "⬆️ Priority" {
+				t.Error("Priority button on a Highest issue")
+			}
+		}
+	}
+}
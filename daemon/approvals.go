@@ -0,0 +1,342 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/schors/jsm2tg/callback"
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// actionApproval - the Approve/Decline buttons of approval requests;
+// Arg is "<approval id>/<decision>".
+const actionApproval = "v"
+
+// checkApprovals - asks the approvers linked in users: for the decision
+// on the pending approvals of the issue in a direct message, and shows
+// the final decision in those messages once an approval is decided.
+func (d *Daemon) checkApprovals(ctx context.Context, is *jira.Issue) error {
+	var errs []error
+
+	for _, a := range is.Fields.Approvals() {
+		r, ok, err := d.messages.ApprovalRequest(is.Key, a.ID)
+
+		switch {
+		case err != nil:
+		case !ok && a.Pending():
+			err = d.requestApproval(ctx, is, a)
+		case ok && r.Decision == "" && !a.Pending():
+			err = d.finishApproval(ctx, is, a, r)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("approval %s: %w", a.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// requestApproval - sends the approval request to the approvers who
+// haven't decided yet. Approvers who never started a chat with the bot
+// can't be messaged; they are skipped.
+func (d *Daemon) requestApproval(ctx context.Context, is *jira.Issue, a jira.Approval) error {
+	rr, err := d.approvalRenderings(is, a, "")
+	if err != nil {
+		return err
+	}
+
+	var (
+		r      store.ApprovalRequest
+		errs   []error
+		queued int
+	)
+
+	for _, ap := range a.Approvers {
+		u, ok := d.conf().UserByJira(ap.User.AccountID, ap.User.Name)
+		if !ok || u.Telegram == 0 || ap.Decision != jira.ApprovalPending {
+			continue
+		}
+
+		kb := d.approvalLink()
+		if d.answersFor(u) {
+			kb = d.approvalKeyboard(is.Key, a.ID)
+		}
+
+		m, err := d.sender.SendRendered(withTag(ctx, tagApproval, is.Key+"/"+a.ID), tg.SendMessageParams{
+			ChatID:             u.Telegram,
+			LinkPreviewOptions: &tg.LinkPreviewOptions{IsDisabled: true},
+			ReplyMarkup:        kb,
+		}, rr)
+		if errors.Is(err, delivery.ErrQueued) {
+			// recorded by Delivered
+			queued++
+
+			continue
+		}
+
+		if err != nil {
+			d.log.Warn("approval request not sent", "issue", is.Key, "approval", a.ID, "telegram_user", u.Telegram, "error", err)
+			errs = append(errs, err)
+
+			continue
+		}
+
+		r.Messages = append(r.Messages, store.MessageRef{Chat: m.Chat.ID, MessageID: m.MessageID})
+	}
+
+	// retried with the next event of the issue if nothing was sent
+	if len(r.Messages) == 0 && queued == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	d.log.Info("approval requested", "issue", is.Key, "approval", a.ID, "messages", len(r.Messages))
+
+	return d.messages.SetApprovalRequest(is.Key, a.ID, r)
+}
+
+// finishApproval - shows the final decision of an approval in its
+// request messages and removes their buttons.
+func (d *Daemon) finishApproval(ctx context.Context, is *jira.Issue, a jira.Approval, r store.ApprovalRequest) error {
+	decidedBy := r.DecidedBy
+	if decidedBy == "" {
+		var names []string
+
+		for _, ap := range a.Approvers {
+			if ap.Decision == a.FinalDecision {
+				names = append(names, displayName(&ap.User))
+			}
+		}
+
+		decidedBy = strings.Join(names, ", ")
+	}
+
+	rr, err := d.approvalRenderings(is, a, decidedBy)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range r.Messages {
+		if err := d.editApproval(ctx, m, rr); err != nil {
+			d.log.Warn("approval request not updated", "issue", is.Key, "approval", a.ID, "chat", m.Chat, "error", err)
+		}
+	}
+
+	r.Decision = a.FinalDecision
+
+	return d.messages.SetApprovalRequest(is.Key, a.ID, r)
+}
+
+// editApproval - re-renders an approval request message without buttons.
+func (d *Daemon) editApproval(ctx context.Context, m store.MessageRef, rr []delivery.Rendering) error {
+	_, err := d.sender.EditRendered(ctx, tg.EditMessageTextParams{
+		ChatID:             m.Chat,
+		MessageID:          m.MessageID,
+		LinkPreviewOptions: &tg.LinkPreviewOptions{IsDisabled: true},
+	}, rr)
+	if errors.Is(err, tg.ErrMessageNotModified) || errors.Is(err, tg.ErrMessageNotFound) || errors.Is(err, delivery.ErrQueued) {
+		return nil
+	}
+
+	return err
+}
+
+// approvalRenderings - renders an approval request with the built-in
+// HTML and plain text fallbacks.
+func (d *Daemon) approvalRenderings(is *jira.Issue, a jira.Approval, decidedBy string) ([]delivery.Rendering, error) {
+	data := render.NewData(&jira.Event{WebhookEvent: render.ApprovalKey, Issue: is}, d.conf().Jira.URL)
+	data.Approval = &render.Approval{Name: a.Name, Decision: a.FinalDecision, DecidedBy: decidedBy}
+
+	text, err := d.current().templates.Lookup(render.ApprovalKey).Execute(data)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+
+	return append([]delivery.Rendering{{
+		Name:      "template",
+		Text:      strings.TrimSpace(text),
+		ParseMode: tg.ParseModeMarkdownV2,
+	}}, approvalCard(is, data.Approval, d.conf().Jira.URL).fallbacks()...), nil
+}
+
+// approvalCard - the fallback of approval requests.
+func approvalCard(is *jira.Issue, a *render.Approval, jiraURL string) card {
+	f := &is.Fields
+
+	c := card{
+		title: "🗳 " + is.Key + ": " + f.Summary,
+		lines: []string{"Approval: " + a.Name, joinNonEmpty(" · ", name(f.Priority), f.RequestType()), "Reporter: " + displayName(f.Reporter)},
+		body:  f.Description,
+	}
+
+	switch a.Decision {
+	case jira.ApprovalApproved:
+		c.title = "✅ " + is.Key + ": " + f.Summary
+		c.lines = append(c.lines, joinNonEmpty(" by ", "Approved", a.DecidedBy))
+	case jira.ApprovalDeclined:
+		c.title = "❌ " + is.Key + ": " + f.Summary
+		c.lines = append(c.lines, joinNonEmpty(" by ", "Declined", a.DecidedBy))
+	}
+
+	if jiraURL != "" {
+		c.url = jira.BrowseURL(jiraURL, is.Key)
+	}
+
+	return c
+}
+
+// approvalKeyboard - returns the Approve/Decline buttons of an approval.
+func (d *Daemon) approvalKeyboard(issue, approvalID string) *tg.InlineKeyboardMarkup {
+	var row []tg.InlineKeyboardButton
+
+	for _, b := range []struct{ text, decision string }{
+		{"✅ Approve", jira.ApprovalApprove},
+		{"❌ Decline", jira.ApprovalDecline},
+	} {
+		if btn, ok := d.button(b.text, callback.Data{Action: actionApproval, Issue: issue, Arg: approvalID + "/" + b.decision}); ok {
+			row = append(row, btn)
+		}
+	}
+
+	if len(row) == 0 {
+		return nil
+	}
+
+	return &tg.InlineKeyboardMarkup{InlineKeyboard: [][]tg.InlineKeyboardButton{row}}
+}
+
+// approvalLink - returns a button opening the approvals of the user in
+// the customer portal, for approvers who answer in Jira; nil if the
+// Jira URL isn't set.
+func (d *Daemon) approvalLink() *tg.InlineKeyboardMarkup {
+	if d.conf().Jira.URL == "" {
+		return nil
+	}
+
+	return &tg.InlineKeyboardMarkup{InlineKeyboard: [][]tg.InlineKeyboardButton{{{
+		Text: "Answer in Jira",
+		URL:  strings.TrimRight(d.conf().Jira.URL, "/") + "/servicedesk/customer/user/approvals",
+	}}}}
+}
+
+// answersFor - reports whether approvals can be answered from Telegram
+// for the user: JSM takes answers only from the approvers themselves,
+// so it needs their own Jira credentials.
+func (d *Daemon) answersFor(u config.User) bool {
+	return d.jiraAs != nil && u.JiraToken != ""
+}
+
+// answerApproval - answers an approval with the Jira credentials of the
+// approver who pressed the button, so Jira records their decision, then
+// updates the request messages.
+func (d *Daemon) answerApproval(ctx context.Context, q *tg.CallbackQuery, cd callback.Data) (string, error) {
+	id, decision, _ := strings.Cut(cd.Arg, "/")
+	if decision != jira.ApprovalApprove && decision != jira.ApprovalDecline {
+		return "", notice("Unknown action.")
+	}
+
+	u, ok := d.conf().UserByTelegram(q.From.ID)
+	if !ok {
+		return "", notice("Your Telegram account isn't linked to a Jira user.")
+	}
+
+	if !d.answersFor(u) {
+		return "", notice("Your Jira token isn't configured; answer this approval in Jira.")
+	}
+
+	aa, err := d.jira.Approvals(ctx, cd.Issue)
+	if err != nil {
+		return "", err
+	}
+
+	i := -1
+
+	for j := range aa {
+		if aa[j].ID == id {
+			i = j
+		}
+	}
+
+	if i < 0 {
+		return "", notice("This approval doesn't exist anymore.")
+	}
+
+	ap, ok := aa[i].Approver(u.AccountID, u.Username)
+	if !ok {
+		return "", notice("You are not an approver of this request.")
+	}
+
+	is, err := d.jira.GetIssue(ctx, cd.Issue, jira.IssueOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	r, ok, err := d.messages.ApprovalRequest(cd.Issue, id)
+	if err != nil {
+		return "", err
+	}
+
+	if !aa[i].Pending() {
+		if ok && r.Decision == "" {
+			if err := d.finishApproval(ctx, is, aa[i], r); err != nil {
+				d.log.Warn("approval request not updated", "issue", cd.Issue, "approval", id, "error", err)
+			}
+		}
+
+		return "", notice("This request is already " + aa[i].FinalDecision + ".")
+	}
+
+	if ap.Decision != jira.ApprovalPending {
+		return "", notice("You have already answered.")
+	}
+
+	a, err := d.jiraAs(u).AnswerApproval(ctx, cd.Issue, id, decision)
+	if errors.Is(err, jira.ErrForbidden) || errors.Is(err, jira.ErrUnauthorized) {
+		d.log.Warn("approval answer refused", "issue", cd.Issue, "approval", id, "telegram_user", q.From.ID, "error", err)
+
+		return "", notice("Jira refused your answer; check your Jira token or answer in Jira.")
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	d.log.Info("approval answered", "issue", cd.Issue, "approval", id, "decision", decision, "telegram_user", q.From.ID)
+
+	done := map[string]string{jira.ApprovalApprove: "Approved.", jira.ApprovalDecline: "Declined."}[decision]
+
+	if a.Pending() {
+		// other approvers still have to decide
+		if q.Message != nil {
+			rr, err := d.approvalRenderings(is, *a, "")
+			if err == nil {
+				err = d.editApproval(ctx, store.MessageRef{Chat: q.Message.Chat.ID, MessageID: q.Message.MessageID}, rr)
+			}
+
+			if err != nil {
+				d.log.Warn("approval request not updated", "issue", cd.Issue, "approval", id, "error", err)
+			}
+		}
+
+		return done + " Waiting for the other approvers.", nil
+	}
+
+	if !ok && q.Message != nil {
+		r.Messages = append(r.Messages, store.MessageRef{Chat: q.Message.Chat.ID, MessageID: q.Message.MessageID})
+	}
+
+	r.DecidedBy = displayName(&ap.User)
+
+	if err := d.finishApproval(ctx, is, *a, r); err != nil {
+		d.log.Warn("approval request not updated", "issue", cd.Issue, "approval", id, "error", err)
+	}
+
+	return done, nil
+}
@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/jira/jiratest"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func TestApprovals(t *testing.T) {
+	js := jiratest.NewServer()
+	defer js.Close()
+
+	js.AddIssue(jiratest.Issue{Key: "SD-5", Summary: "Upgrade the firewall", Status: "Waiting for approval", Priority: "High",
+		Description: "Maintenance window: Saturday 02:00",
+		Approvals:   []jiratest.Approval{{ID: "7", Name: "Change approval", Approvers: []string{"acc-1", "acc-2", "acc-3"}}}})
+
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Telegram.Updates = config.UpdatesPolling
+	cfg.Users = []config.User{
+		{Telegram: 501, AccountID: "acc-1", JiraToken: "expired", Email: "alice@example.com"},
+		{Telegram: 502, AccountID: "acc-2", JiraToken: "acc-2", Email: "bob@example.com"},
+		{Telegram: 503, AccountID: "acc-3"},
+	}
+
+	fs := newFakeSender()
+	fb := &fakeBot{}
+	jc := jira.NewClient(js.URL)
+
+	// the fake server takes the account id as the token
+	as := func(u config.User) Jira { return jira.NewClient(js.URL, jira.WithToken(u.JiraToken)) }
+
+	d, err := New(cfg, fs, WithWorkers(1), WithJira(jc), WithJiraAs(as), WithBot(fb))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx := context.Background()
+
+	event := func() *jira.Event {
+		is, err := jc.GetIssue(ctx, "SD-5", jira.IssueOptions{})
+		if err != nil {
+			t.Fatalf("GetIssue: %v", err)
+		}
+
+		return &jira.Event{WebhookEvent: jira.EventIssueUpdated, Issue: is}
+	}
+
+	// the update goes to the chat, the approval requests to the linked approvers
+	d.process(ctx, event())
+	d.process(ctx, event())
+
+	got := fs.wait(t, 5)
+
+	var requests []sent
+
+	for _, s := range got {
+		if s.params.ChatID > 0 {
+			requests = append(requests, s)
+		}
+	}
+
+	if len(requests) != 3 || requests[0].params.ChatID != 501 || requests[1].params.ChatID != 502 || requests[2].params.ChatID != 503 {
+		t.Fatalf("approval requests = %+v", requests)
+	}
+
+	for _, want := range []string{"🗳 *SD\\-5: Upgrade the firewall*", "Approval: Change approval", "Maintenance window: Saturday 02:00"} {
+		if !strings.Contains(requests[0].rr[0].Text, want) {
+			t.Errorf("request does not contain %q:\n%s", want, requests[0].rr[0].Text)
+		}
+	}
+
+	kb := requests[0].params.ReplyMarkup
+	if kb == nil || len(kb.InlineKeyboard) != 1 || len(kb.InlineKeyboard[0]) != 2 {
+		t.Fatalf("request keyboard = %+v", kb)
+	}
+
+	// without a token of their own the approver answers in the portal
+	if kb := requests[2].params.ReplyMarkup; kb == nil || kb.InlineKeyboard[0][0].URL != "https://jira.example.com/servicedesk/customer/user/approvals" {
+		t.Errorf("keyboard of an approver without a token = %+v", kb)
+	}
+
+	press := func(user int64, msg sent, button int) {
+		data := msg.params.ReplyMarkup.InlineKeyboard[0][button].CallbackData
+
+		cd, err := d.signer.Decode(data)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+
+		d.callback(ctx, &tg.CallbackQuery{ID: "q", From: tg.User{ID: user}, Message: &tg.Message{MessageID: 2, Chat: tg.Chat{ID: msg.params.ChatID}}, Data: data}, cd)
+	}
+
+	// Jira refuses Alice's token
+	press(501, requests[0], 0)
+
+	// Bob approves as himself: Jira gets the answer, all requests show the decision
+	press(502, requests[1], 0)
+
+	if answers := js.Answers(); strings.Join(answers, "\n") != "SD-5/7 approve by acc-2" {
+		t.Errorf("answers = %q", answers)
+	}
+
+	fs.mu.Lock()
+	edits := append([]edit(nil), fs.edits...)
+	fs.mu.Unlock()
+
+	if len(edits) != 3 {
+		t.Fatalf("edits = %d, want 3", len(edits))
+	}
+
+	for _, e := range edits {
+		if e.params.ReplyMarkup != nil || !strings.Contains(e.rr[0].Text, "✅ *SD\\-5") || !strings.Contains(e.rr[0].Text, "*Approved by acc\\-2*") {
+			t.Errorf("edit of chat %d = %+v: %s", e.params.ChatID, e.params.ReplyMarkup, e.rr[0].Text)
+		}
+
+		if plain := e.rr[len(e.rr)-1].Text; !strings.Contains(plain, "Approved by acc\\-2") {
+			t.Errorf("plain fallback = %s", plain)
+		}
+	}
+
+	// the echo of the decision and late presses change nothing
+	d.process(ctx, event())
+	press(501, requests[0], 1)
+
+	fs.mu.Lock()
+	n := len(fs.edits)
+	fs.mu.Unlock()
+
+	if n != 3 || len(js.Answers()) != 1 {
+		t.Errorf("edits = %d, answers = %d; want 3, 1", n, len(js.Answers()))
+	}
+
+	want := "answer Jira refused your answer; check your Jira token or answer in Jira.\nanswer Approved.\nanswer This request is already approved."
+	if calls := strings.Join(fb.calls, "\n"); calls != want {
+		t.Errorf("answers = %q, want %q", calls, want)
+	}
+}
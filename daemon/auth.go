@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/schors/jsm2tg/config"
+)
+
+// Webhook authentication failures.
+var (
+	ErrBadSignature = errors.New("bad signature")
+	ErrBadToken     = errors.New("bad token")
+	ErrForbiddenIP  = errors.New("address not allowed")
+)
+
+// SignatureHeader - the header Jira Cloud puts the payload HMAC in.
+const SignatureHeader = "X-Hub-Signature"
+
+// TokenHeader - the header carrying the static webhook token.
+const TokenHeader = "X-Webhook-Token"
+
+// Auth - verifies webhook requests.
+type Auth struct {
+	secret         []byte
+	token          []byte
+	allow          []netip.Prefix
+	trustForwarded bool
+}
+
+// NewAuth - creates an authenticator. The configuration must be validated.
+func NewAuth(c config.Auth) *Auth {
+	a := &Auth{
+		secret:         []byte(c.Secret),
+		token:          []byte(c.Token),
+		trustForwarded: c.TrustForwarded,
+	}
+
+	for _, cidr := range c.Allow {
+		if p, err := netip.ParsePrefix(cidr); err == nil {
+			a.allow = append(a.allow, p.Masked())
+		}
+	}
+
+	return a
+}
+
+// Check - verifies the request and its already read body.
+func (a *Auth) Check(r *http.Request, body []byte) error {
+	if err := a.CheckRequest(r); err != nil {
+		return err
+	}
+
+	return a.CheckBody(r, body)
+}
+
+// CheckRequest - verifies what can be checked before the body is read:
+// the client address, the token and that a signature is given.
+func (a *Auth) CheckRequest(r *http.Request) error {
+	if len(a.allow) > 0 && !a.allowed(a.clientAddr(r)) {
+		return ErrForbiddenIP
+	}
+
+	if len(a.token) > 0 && !constantTimeEqual(requestToken(r), a.token) {
+		return ErrBadToken
+	}
+
+	if len(a.secret) > 0 && !strings.HasPrefix(r.Header.Get(SignatureHeader), "sha256=") {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// CheckBody - verifies the signature of the body.
+func (a *Auth) CheckBody(r *http.Request, body []byte) error {
+	if len(a.secret) > 0 && !a.validSignature(r.Header.Get(SignatureHeader), body) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+func constantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+func requestToken(r *http.Request) []byte {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return []byte(t)
+	}
+
+	if t := r.Header.Get(TokenHeader); t != "" {
+		return []byte(t)
+	}
+
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return []byte(t)
+	}
+
+	return nil
+}
+
+// validSignature - checks a "sha256=<hex>" HMAC of the body.
+func (a *Auth) validSignature(header string, body []byte) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(body)
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// Sign - returns the X-Hub-Signature value for a body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *Auth) clientAddr(r *http.Request) netip.Addr {
+	if a.trustForwarded {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			// the last hop is the one added by our proxy
+			parts := strings.Split(xff, ",")
+			if addr, err := netip.ParseAddr(strings.TrimSpace(parts[len(parts)-1])); err == nil {
+				return addr.Unmap()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+func (a *Auth) allowed(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+
+	for _, p := range a.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,200 @@
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/schors/jsm2tg/config"
+)
+
+func TestAuthCheck(t *testing.T) {
+	body := []byte(`{"webhookEvent":"jira:issue_created"}`)
+
+	tests := []struct {
+		name   string
+		cfg    config.Auth
+		target string
+		remote string
+		header map[string]string
+		want   error
+	}{
+		{
+			name: "nothing configured",
+			want: nil,
+		},
+		{
+			name:   "valid signature",
+			cfg:    config.Auth{Secret: "s3cret"},
+			header: map[string]string{SignatureHeader: Sign("s3cret", body)},
+		},
+		{
+			name:   "signature with another secret",
+			cfg:    config.Auth{Secret: "s3cret"},
+			header: map[string]string{SignatureHeader: Sign("other", body)},
+			want:   ErrBadSignature,
+		},
+		{
+			name:   "signature without prefix",
+			cfg:    config.Auth{Secret: "s3cret"},
+			header: map[string]string{SignatureHeader: strings.TrimPrefix(Sign("s3cret", body), "sha256=")},
+			want:   ErrBadSignature,
+		},
+		{
+			name: "missing signature",
+			cfg:  config.Auth{Secret: "s3cret"},
+			want: ErrBadSignature,
+		},
+		{
+			name:   "token in query",
+			cfg:    config.Auth{Token: "t0k"},
+			target: "/webhook?token=t0k",
+		},
+		{
+			name:   "token in header",
+			cfg:    config.Auth{Token: "t0k"},
+			header: map[string]string{TokenHeader: "t0k"},
+		},
+		{
+			name:   "bearer token",
+			cfg:    config.Auth{Token: "t0k"},
+			header: map[string]string{"Authorization": "Bearer t0k"},
+		},
+		{
+			name:   "wrong token",
+			cfg:    config.Auth{Token: "t0k"},
+			target: "/webhook?token=t0",
+			want:   ErrBadToken,
+		},
+		{
+			name:   "allowed address",
+			cfg:    config.Auth{Allow: []string{"10.0.0.0/8"}},
+			remote: "10.1.2.3:5555",
+		},
+		{
+			name:   "denied address",
+			cfg:    config.Auth{Allow: []string{"10.0.0.0/8"}},
+			remote: "192.168.1.1:5555",
+			want:   ErrForbiddenIP,
+		},
+		{
+			name:   "forwarded address is ignored by default",
+			cfg:    config.Auth{Allow: []string{"10.0.0.0/8"}},
+			remote: "192.168.1.1:5555",
+			header: map[string]string{"X-Forwarded-For": "10.1.2.3"},
+			want:   ErrForbiddenIP,
+		},
+		{
+			name:   "trusted forwarded address",
+			cfg:    config.Auth{Allow: []string{"10.0.0.0/8"}, TrustForwarded: true},
+			remote: "127.0.0.1:5555",
+			header: map[string]string{"X-Forwarded-For": "1.1.1.1, 10.1.2.3"},
+		},
+		{
+			name:   "all checks at once",
+			cfg:    config.Auth{Secret: "s3cret", Token: "t0k", Allow: []string{"::1/128", "127.0.0.0/8"}},
+			target: "/webhook?token=t0k",
+			remote: "[::ffff:127.0.0.1]:5555",
+			header: map[string]string{SignatureHeader: Sign("s3cret", body)},
+		},
+	}
+
+	for _, tt := range tests {
+		target := tt.target
+		if target == "" {
+			target = "/webhook"
+		}
+
+		r := httptest.NewRequest(http.MethodPost, target, nil)
+		if tt.remote != "" {
+			r.RemoteAddr = tt.remote
+		}
+
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+
+		if err := NewAuth(tt.cfg).Check(r, body); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Check() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWebhookRejectsUnauthenticated(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth = config.Auth{Token: "t0k"}
+
+	d := mustNew(t, cfg, newFakeSender())
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	resp := postFixture(t, srv.URL+"/webhook?token=nope", "issue_created.json")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	resp = postFixture(t, srv.URL+"/webhook?token=t0k", "issue_created.json")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+}
+
+// readCounter - a request body counting the bytes read from it.
+type readCounter struct {
+	r io.Reader
+	n int
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+
+	return n, err
+}
+
+func TestWebhookAuthBeforeBody(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth = config.Auth{Token: "t0k", Secret: "s3cret"}
+
+	d := mustNew(t, cfg, newFakeSender())
+
+	body, err := os.ReadFile("../jira/testdata/issue_created.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		signature  string
+		wantStatus int
+		wantRead   bool
+	}{
+		{"bad token", "nope", Sign("s3cret", body), http.StatusForbidden, false},
+		{"no signature", "t0k", "", http.StatusForbidden, false},
+		{"bad signature", "t0k", Sign("other", body), http.StatusForbidden, true},
+		{"authenticated", "t0k", Sign("s3cret", body), http.StatusAccepted, true},
+	}
+
+	for _, tt := range tests {
+		rc := &readCounter{r: bytes.NewReader(body)}
+
+		r := httptest.NewRequest(http.MethodPost, "/webhook?token="+tt.token, rc)
+		if tt.signature != "" {
+			r.Header.Set(SignatureHeader, tt.signature)
+		}
+
+		w := httptest.NewRecorder()
+		d.Handler().ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus || (rc.n > 0) != tt.wantRead {
+			t.Errorf("%s: status = %d, read %d bytes; want %d, read %v", tt.name, w.Code, rc.n, tt.wantStatus, tt.wantRead)
+		}
+	}
+}
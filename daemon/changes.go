@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// eventData - returns the template data of an event without the changes
+// of ignored fields, mentioning the changed users linked to Telegram.
+func (d *Daemon) eventData(e *jira.Event) render.Data {
+	data := render.NewData(e, d.conf().Jira.URL)
+
+	data.Changes = slices.DeleteFunc(data.Changes, func(c render.Change) bool {
+		return d.ignored(c.Field, c.FieldID)
+	})
+
+	for i := range data.Changes {
+		c := &data.Changes[i]
+		if c.IsUser() {
+			c.FromMention = d.mention(c.FromID, c.From)
+			c.ToMention = d.mention(c.ToID, c.To)
+		}
+	}
+
+	return data
+}
+
+// ignored - reports whether changes of the field aren't listed.
+func (d *Daemon) ignored(field, id string) bool {
+	ignore := d.current().ignore
+
+	return ignore[strings.ToLower(field)] || id != "" && ignore[strings.ToLower(id)]
+}
+
+// ignoredUpdate - reports whether the event is an update changing
+// ignored fields only.
+func (d *Daemon) ignoredUpdate(e *jira.Event) bool {
+	if e.Kind() != jira.EventIssueUpdated || e.Comment != nil || e.Changelog == nil || len(e.Changelog.Items) == 0 {
+		return false
+	}
+
+	return !slices.ContainsFunc(e.Changelog.Items, func(it jira.ChangelogItem) bool {
+		return !d.ignored(it.Field, it.FieldID)
+	})
+}
+
+// mention - returns a Telegram mention of the Jira user identified by
+// an account id (Cloud) or username (Server), if they are linked.
+func (d *Daemon) mention(id, name string) render.Markdown {
+	if id == "" {
+		return ""
+	}
+
+	u, ok := d.conf().UserByJira(id, id)
+	if !ok || u.Telegram == 0 {
+		return ""
+	}
+
+	return userLink(name, u.Telegram)
+}
+
+// userLink - mentions a Telegram user by name.
+func userLink(name string, id int64) render.Markdown {
+	return render.Markdown("[" + tg.EscapeTelegram(name) + "](tg://user?id=" + strconv.FormatInt(id, 10) + ")")
+}
@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+)
+
+func TestChangelog(t *testing.T) {
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Changelog.Ignore = config.DefaultIgnoredFields
+	cfg.Users = []config.User{{Telegram: 501, AccountID: "acc-1"}}
+
+	fs := newFakeSender()
+	d := mustNew(t, cfg, fs)
+	ctx := context.Background()
+
+	assigned := change(t, "1", "assignee", "", "Alice")
+	assigned.Changelog.Items[0].To = "acc-1"
+	assigned.Changelog.Items = append(assigned.Changelog.Items, jira.ChangelogItem{Field: "Rank", FromString: "a", ToString: "b"})
+
+	for _, e := range []*jira.Event{change(t, "2", "Rank", "", "Ranked higher"), assigned} {
+		if err := d.Handle(ctx, e); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(fs.sent))
+	}
+
+	text, plain := fs.sent[0].rr[0].Text, fs.sent[0].rr[len(fs.sent[0].rr)-1].Text
+
+	if !strings.Contains(text, "Assignee: — → [Alice](tg://user?id=501)\n") || strings.Contains(text, "Rank") {
+		t.Errorf("update = %s", text)
+	}
+
+	if !strings.Contains(plain, "Assignee: — → Alice\n") || strings.Contains(plain, "Rank") {
+		t.Errorf("plain fallback = %s", plain)
+	}
+}
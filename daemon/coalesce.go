@@ -0,0 +1,241 @@
+package daemon
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+)
+
+// eventTTL - how long handled webhook events are remembered; Jira
+// gives up retrying long before.
+const eventTTL = 24 * time.Hour
+
+// duplicate - reports whether the event was handled before.
+func (d *Daemon) duplicate(e *jira.Event) bool {
+	id := e.ID()
+	if id == "" {
+		return false
+	}
+
+	seen, err := d.messages.EventSeen(id)
+	if err != nil {
+		d.log.Error("event deduplication failed", "event", e.WebhookEvent, "issue", issueKey(e), "error", err)
+
+		return false
+	}
+
+	return seen
+}
+
+// handled - remembers a handled event, forgetting the events older
+// than eventTTL once an hour.
+func (d *Daemon) handled(e *jira.Event) {
+	id := e.ID()
+	if id == "" {
+		return
+	}
+
+	now := d.clock.Now()
+
+	if err := d.messages.SetEventSeen(id, now); err != nil {
+		d.log.Error("event deduplication failed", "event", e.WebhookEvent, "issue", issueKey(e), "error", err)
+	}
+
+	d.mu.Lock()
+	prune := now.Sub(d.pruned) >= time.Hour
+	if prune {
+		d.pruned = now
+	}
+	d.mu.Unlock()
+
+	if !prune {
+		return
+	}
+
+	if _, err := d.messages.ForgetEvents(now.Add(-eventTTL)); err != nil {
+		d.log.Error("handled events not pruned", "error", err)
+	}
+}
+
+// pendingKey - identifies the updates of an issue waiting for a target.
+type pendingKey struct {
+	issue  string
+	chat   int64
+	thread int
+}
+
+// pendingUpdates - issue updates waiting for the end of the coalescing
+// window of their target, and their ids in the store.
+type pendingUpdates struct {
+	target route.Target
+	until  time.Time
+	events []*jira.Event
+	ids    []string
+}
+
+// window - returns the coalescing window of issue updates for a target.
+func (d *Daemon) window(t route.Target) time.Duration {
+	switch {
+	case t.Rule != nil:
+		return t.Rule.Coalesce
+	case t.Public:
+		return 0
+	}
+
+	for _, ch := range d.conf().Chats {
+		if ch.ID == t.Chat && ch.Thread == t.Thread {
+			return ch.Coalesce
+		}
+	}
+
+	return 0
+}
+
+// coalescable - reports whether the event is an issue update that can
+// be merged with others.
+func coalescable(e *jira.Event) bool {
+	return e.Kind() == jira.EventIssueUpdated && e.Issue != nil && e.Comment == nil
+}
+
+// coalesce - holds an issue update for the target until the window
+// started by the first held update ends, then delivers the held
+// updates as one on the worker of the issue. The update is saved in the
+// store first, so it isn't lost if the daemon stops meanwhile.
+func (d *Daemon) coalesce(e *jira.Event, t route.Target, window time.Duration) error {
+	k := pendingKey{issue: e.Issue.Key, chat: t.Chat, thread: t.Thread}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	until := d.clock.Now().Add(window)
+	if p, ok := d.pending[k]; ok {
+		until = p.until
+	}
+
+	id, err := d.persistHeld(store.HeldCoalesce, e, t, until)
+	if err != nil {
+		return err
+	}
+
+	d.pendUpdate(k, t, e, id, until)
+
+	return nil
+}
+
+// pendUpdate - adds a saved update to the pending ones of the target,
+// starting the window if it is the first; d.mu must be held.
+func (d *Daemon) pendUpdate(k pendingKey, t route.Target, e *jira.Event, id string, until time.Time) {
+	if p, ok := d.pending[k]; ok {
+		p.events = append(p.events, e)
+		p.ids = append(p.ids, id)
+
+		return
+	}
+
+	d.pending[k] = &pendingUpdates{target: t, until: until, events: []*jira.Event{e}, ids: []string{id}}
+
+	d.after(until, func() {
+		d.enqueue(k.issue, func(ctx context.Context) {
+			if err := d.flushUpdates(ctx, k); err != nil {
+				d.log.Error("event delivery failed", "event", jira.EventIssueUpdated, "issue", k.issue, "error", err)
+			}
+		})
+	})
+}
+
+// flushUpdates - delivers the updates held for the target, if any, and
+// drops them from the store.
+func (d *Daemon) flushUpdates(ctx context.Context, k pendingKey) error {
+	d.mu.Lock()
+	p, ok := d.pending[k]
+	delete(d.pending, k)
+	d.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	defer d.forgetHeld(p.ids)
+
+	e := mergeUpdates(p.events)
+	if e == nil || d.ignoredUpdate(e) {
+		d.log.Debug("coalesced updates cancel out", "issue", k.issue, "chat", k.chat, "updates", len(p.events))
+
+		return nil
+	}
+
+	return d.deliver(ctx, e, p.target, d.eventData(e))
+}
+
+// flushAll - delivers all held updates, e.g. on shutdown; with an
+// outbox they are queued for the next start.
+func (d *Daemon) flushAll(ctx context.Context) {
+	d.mu.Lock()
+	keys := make([]pendingKey, 0, len(d.pending))
+	for k := range d.pending {
+		keys = append(keys, k)
+	}
+	d.mu.Unlock()
+
+	for _, k := range keys {
+		if err := d.flushUpdates(ctx, k); err != nil {
+			d.log.Error("event delivery failed", "event", jira.EventIssueUpdated, "issue", k.issue, "error", err)
+		}
+	}
+}
+
+// mergeUpdates - merges issue updates into the last one: the issue as of
+// the last update with the net change of every field, in the order the
+// fields first changed. It returns nil if the changes cancel out.
+func mergeUpdates(events []*jira.Event) *jira.Event {
+	last := events[len(events)-1]
+	if len(events) == 1 {
+		return last
+	}
+
+	var (
+		items []jira.ChangelogItem
+		index = make(map[string]int)
+	)
+
+	for _, e := range events {
+		if e.Changelog == nil {
+			continue
+		}
+
+		for _, it := range e.Changelog.Items {
+			f := cmp.Or(it.FieldID, it.Field)
+
+			if i, ok := index[f]; ok {
+				items[i].To, items[i].ToString = it.To, it.ToString
+
+				continue
+			}
+
+			index[f] = len(items)
+			items = append(items, it)
+		}
+	}
+
+	items = slices.DeleteFunc(items, func(it jira.ChangelogItem) bool {
+		return it.From == it.To && it.FromString == it.ToString
+	})
+
+	if len(index) > 0 && len(items) == 0 {
+		return nil
+	}
+
+	merged := *last
+	merged.Changelog = &jira.Changelog{Items: items}
+
+	if last.Changelog != nil {
+		merged.Changelog.ID = last.Changelog.ID
+	}
+
+	return &merged
+}
@@ -0,0 +1,169 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/store"
+)
+
+// change - an issue_updated event of SD-42 with one field change.
+func change(t *testing.T, id, field, from, to string) *jira.Event {
+	t.Helper()
+
+	e := readEvent(t, "issue_created.json")
+	e.WebhookEvent = jira.EventIssueUpdated
+	e.Changelog = &jira.Changelog{ID: id, Items: []jira.ChangelogItem{{Field: field, FromString: from, ToString: to}}}
+
+	return e
+}
+
+func TestDuplicateEvents(t *testing.T) {
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+
+	fs := newFakeSender()
+	d := mustNew(t, cfg, fs)
+	ctx := context.Background()
+
+	// a retry and the JSM twin of the same change
+	e := change(t, "100", "status", "Open", "In Progress")
+
+	jsm := change(t, "100", "status", "Open", "In Progress")
+	jsm.WebhookEvent = jira.EventRequestUpdated
+
+	for _, e := range []*jira.Event{e, e, jsm, change(t, "101", "status", "In Progress", "Open")} {
+		d.process(ctx, e)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.sent) != 2 || !strings.Contains(fs.sent[1].rr[0].Text, "Status: In Progress → Open") {
+		t.Fatalf("sent %d messages, want 2: %+v", len(fs.sent), fs.sent)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Chats[0].Coalesce = time.Minute
+
+	clk := clock.NewFake(slaEpoch)
+	fs := newFakeSender()
+
+	d, err := New(cfg, fs, WithWorkers(1), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	// the window starts with the first update; priority changes back
+	for _, e := range []*jira.Event{
+		change(t, "1", "status", "Open", "In Progress"),
+		change(t, "2", "priority", "High", "Low"),
+		change(t, "3", "assignee", "", "Alice"),
+		change(t, "4", "priority", "Low", "High"),
+	} {
+		d.queue("SD-42") <- func(ctx context.Context) { d.process(ctx, e) }
+	}
+
+	flush(d)
+	clk.BlockUntil(1)
+
+	fs.mu.Lock()
+	n := len(fs.sent)
+	fs.mu.Unlock()
+
+	if n != 0 {
+		t.Fatalf("sent %d messages within the window", n)
+	}
+
+	clk.Advance(time.Minute)
+
+	got := fs.wait(t, 1)[0]
+
+	if want := "✏️ *SD\\-42: VPN is down*\nStatus: Open → In Progress, Assignee: — → Alice\n"; !strings.HasPrefix(got.rr[0].Text, want) {
+		t.Errorf("update = %q, want prefix %q", got.rr[0].Text, want)
+	}
+
+	if plain := got.rr[len(got.rr)-1].Text; !strings.Contains(plain, "Status: Open → In Progress, Assignee: — → Alice") {
+		t.Errorf("plain fallback = %s", plain)
+	}
+
+	// other events of the issue flush the held updates first
+	comment := readEvent(t, "comment_created.json")
+
+	for _, e := range []*jira.Event{change(t, "5", "status", "In Progress", "Resolved"), comment} {
+		d.queue("SD-42") <- func(ctx context.Context) { d.process(ctx, e) }
+	}
+
+	got = fs.wait(t, 2)[1]
+	if !strings.Contains(got.rr[0].Text, "Status: In Progress → Resolved") {
+		t.Errorf("flushed update = %s", got.rr[0].Text)
+	}
+
+	clk.Advance(time.Minute)
+	flush(d)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.sent) != 3 || !strings.HasPrefix(fs.sent[2].rr[0].Text, "💬") {
+		t.Errorf("sent %d messages, want the update and the comment", len(fs.sent))
+	}
+}
+
+func TestCoalesceRestart(t *testing.T) {
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Chats[0].Coalesce = time.Minute
+
+	st := store.NewMemory()
+	clk := clock.NewFake(slaEpoch)
+
+	d, err := New(cfg, newFakeSender(), WithStore(st), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, e := range []*jira.Event{
+		change(t, "1", "status", "Open", "In Progress"),
+		change(t, "2", "assignee", "", "Alice"),
+	} {
+		d.process(context.Background(), e)
+	}
+
+	// restarted within the window and stopped at once: the saved updates
+	// are delivered at the shutdown
+	fs := newFakeSender()
+
+	d, err = New(cfg, fs, WithStore(st), WithWorkers(1), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	d.Run(ctx)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.sent) != 1 || !strings.Contains(fs.sent[0].rr[0].Text, "Status: Open → In Progress, Assignee: — → Alice") {
+		t.Fatalf("sent %d messages, want the merged update: %+v", len(fs.sent), fs.sent)
+	}
+
+	if held, err := d.messages.HeldEvents(); err != nil || len(held) != 0 {
+		t.Errorf("HeldEvents() after the delivery = %+v, %v", held, err)
+	}
+}
@@ -0,0 +1,432 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/schors/jsm2tg/callback"
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Bot commands.
+const (
+	CommandTicket = "/ticket"
+	CommandMy     = "/my"
+	CommandQueue  = "/queue"
+	CommandSearch = "/search"
+)
+
+var commands = map[string]bool{
+	CommandTicket: true,
+	CommandMy:     true,
+	CommandQueue:  true,
+	CommandSearch: true,
+	CommandNew:    true,
+	CommandCancel: true,
+}
+
+// actionList - the callback action paging through a result list;
+// Issue is the saved query id, Arg the index of the first issue.
+const actionList = "l"
+
+// listFields - the issue fields shown in result lists.
+var listFields = []string{"summary", "status", "priority"}
+
+var issueKeyRe = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+
+// WithUsername - sets the bot user name, so that commands addressed
+// to other bots ("/my@otherbot") are ignored.
+func WithUsername(name string) Option {
+	return func(d *Daemon) {
+		d.username = strings.TrimPrefix(name, "@")
+	}
+}
+
+// parseCommand - splits "/cmd@bot args" into the lowercased command
+// and its arguments. It returns false if the text isn't a command
+// for this bot.
+func (d *Daemon) parseCommand(text string) (string, string, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+
+	cmd, args, _ := strings.Cut(text, " ")
+	if i := strings.IndexAny(cmd, "\n\t"); i >= 0 {
+		cmd, args = cmd[:i], text[i+1:]
+	}
+
+	cmd, bot, addressed := strings.Cut(cmd, "@")
+	if addressed && d.username != "" && !strings.EqualFold(bot, d.username) {
+		return "", "", false
+	}
+
+	return strings.ToLower(cmd), strings.TrimSpace(args), true
+}
+
+// handleCommand - queues a bot command. It returns false if the
+// message isn't a known command.
+func (d *Daemon) handleCommand(ctx context.Context, m *tg.Message) bool {
+	cmd, args, ok := d.parseCommand(m.Text)
+	if !ok || !commands[cmd] {
+		return false
+	}
+
+	select {
+	case d.queue(store.DraftKey(m.Chat.ID, m.From.ID)) <- func(ctx context.Context) {
+		if err := d.command(ctx, m, cmd, args); err != nil {
+			d.log.Error("command failed", "command", cmd, "chat", m.Chat.ID, "telegram_user", m.From.ID, "error", err)
+		}
+	}:
+	case <-ctx.Done():
+	}
+
+	return true
+}
+
+// command - runs a bot command. Besides the request dialog,
+// commands are only available to linked users, and not in customer chats.
+func (d *Daemon) command(ctx context.Context, m *tg.Message, cmd, args string) error {
+	switch cmd {
+	case CommandNew:
+		return d.newRequest(ctx, m, args)
+	case CommandCancel:
+		return d.cancelDraft(ctx, m)
+	}
+
+	u, ok := d.conf().UserByTelegram(m.From.ID)
+	if !ok {
+		return d.notify(ctx, m, "Your Telegram account isn't linked to a Jira user.")
+	}
+
+	if !d.mayQuery(m.Chat) {
+		return d.notify(ctx, m, "Jira can't be queried in this chat; ask me in a private chat.")
+	}
+
+	var q store.Query
+
+	switch cmd {
+	case CommandTicket:
+		key := strings.ToUpper(args)
+		if !issueKeyRe.MatchString(key) {
+			return d.notify(ctx, m, "Usage: "+CommandTicket+" KEY, e.g. "+CommandTicket+" SD-42")
+		}
+
+		return d.ticket(ctx, m, key)
+	case CommandMy:
+		who := u.AccountID
+		if who == "" {
+			who = u.Username
+		}
+
+		q = store.Query{Title: "My issues", JQL: "assignee = " + jqlString(who) + " AND resolution = Unresolved ORDER BY updated DESC"}
+	case CommandQueue:
+		if args == "" {
+			return d.notify(ctx, m, "Usage: "+CommandQueue+" NAME")
+		}
+
+		var err error
+		if q, err = d.queueQuery(ctx, args); err != nil {
+			return d.commandError(ctx, m, err)
+		}
+	case CommandSearch:
+		if args == "" {
+			return d.notify(ctx, m, "Usage: "+CommandSearch+" JQL")
+		}
+
+		q = store.Query{Title: "Search: " + args, JQL: args}
+	}
+
+	id, err := d.messages.SetQuery(q)
+	if err != nil {
+		return err
+	}
+
+	rr, kb, err := d.listPage(ctx, id, q, 0)
+	if err != nil {
+		return d.commandError(ctx, m, err)
+	}
+
+	_, err = d.sender.SendRendered(ctx, tg.SendMessageParams{
+		ChatID:             m.Chat.ID,
+		MessageThreadID:    m.MessageThreadID,
+		ReplyParameters:    &tg.ReplyParameters{MessageID: m.MessageID, AllowSendingWithoutReply: true},
+		LinkPreviewOptions: &tg.LinkPreviewOptions{IsDisabled: true},
+		ReplyMarkup:        kb,
+	}, rr)
+
+	return err
+}
+
+// mayQuery - reports whether Jira may be queried from the chat: the
+// answers are read with the bot credentials, so only private chats and
+// the internal chats issues are routed to get them, not customer chats
+// (public destinations, requests.chats and the chats of requests).
+func (d *Daemon) mayQuery(chat tg.Chat) bool {
+	if chat.Type == "private" {
+		return true
+	}
+
+	cfg := d.conf()
+
+	if slices.Contains(cfg.Requests.Chats, chat.ID) {
+		return false
+	}
+
+	if slices.ContainsFunc(cfg.Chats, func(c config.Chat) bool { return c.ID == chat.ID }) {
+		return true
+	}
+
+	for _, r := range d.current().router.Rules() {
+		if slices.ContainsFunc(r.To, func(to route.Destination) bool { return to.Chat == chat.ID && !to.Public }) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commandError - explains a failed command to the user.
+func (d *Daemon) commandError(ctx context.Context, m *tg.Message, err error) error {
+	var n notice
+	if errors.As(err, &n) {
+		return d.notify(ctx, m, string(n))
+	}
+
+	text := "Jira rejected the request."
+	if e, ok := jira.AsError(err); ok && len(e.Messages) > 0 {
+		text += " " + e.Messages[0]
+	}
+
+	return errors.Join(err, d.notify(ctx, m, text))
+}
+
+// queueQuery - returns the query of a service desk queue by name.
+func (d *Daemon) queueQuery(ctx context.Context, name string) (store.Query, error) {
+	if d.conf().Jira.ServiceDesk == "" {
+		return store.Query{}, notice("No service desk is configured.")
+	}
+
+	qs, err := d.jira.Queues(ctx, d.conf().Jira.ServiceDesk)
+	if err != nil {
+		return store.Query{}, err
+	}
+
+	names := make([]string, 0, len(qs))
+
+	for _, q := range qs {
+		if strings.EqualFold(q.Name, name) {
+			return store.Query{Title: "Queue: " + q.Name, JQL: q.JQL}, nil
+		}
+
+		names = append(names, q.Name)
+	}
+
+	return store.Query{}, notice(fmt.Sprintf("Unknown queue %q. Queues: %s.", name, strings.Join(names, ", ")))
+}
+
+// jqlString - quotes a JQL string literal.
+func jqlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// listPage - renders a page of a result list starting at the start-th
+// issue, with the buttons of the previous and next pages.
+func (d *Daemon) listPage(ctx context.Context, id string, q store.Query, start int) ([]delivery.Rendering, *tg.InlineKeyboardMarkup, error) {
+	size := d.conf().Commands.PageSize
+
+	o := jira.SearchOptions{Fields: listFields, StartAt: start, MaxResults: size}
+
+	r, err := d.jira.Search(ctx, q.JQL, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var md, plain strings.Builder
+
+	md.WriteString("*" + tg.EscapeTelegram(q.Title) + "*\n")
+	plain.WriteString(q.Title + "\n")
+
+	if len(r.Issues) == 0 {
+		md.WriteString("No issues found\\.")
+		plain.WriteString("No issues found.")
+	} else {
+		n := fmt.Sprintf("%d–%d", start+1, start+len(r.Issues))
+		// Jira Cloud doesn't count the issues
+		if r.Total > 0 {
+			n += fmt.Sprintf(" of %d", r.Total)
+		}
+
+		md.WriteString(tg.EscapeTelegram(n) + "\n")
+		plain.WriteString(n + "\n")
+	}
+
+	for _, is := range r.Issues {
+		line := is.Fields.Summary
+		if is.Fields.Status != nil {
+			line += " · " + is.Fields.Status.Name
+		}
+
+		emoji := render.EmojiForPriority(name(is.Fields.Priority))
+
+		key := tg.EscapeTelegram(is.Key)
+		if d.conf().Jira.URL != "" {
+			key = "[" + key + "](" + tg.EscapeTelegramLink(jira.BrowseURL(d.conf().Jira.URL, is.Key)) + ")"
+		}
+
+		md.WriteString("\n" + emoji + " " + key + ": " + tg.EscapeTelegram(line))
+		plain.WriteString("\n" + emoji + " " + is.Key + ": " + line)
+	}
+
+	var row []tg.InlineKeyboardButton
+
+	if start > 0 {
+		if b, ok := d.button("◀️", callback.Data{Action: actionList, Issue: id, Arg: strconv.Itoa(max(start-size, 0))}); ok {
+			row = append(row, b)
+		}
+	}
+
+	if next, more := r.Next(o); more {
+		if b, ok := d.button("▶️", callback.Data{Action: actionList, Issue: id, Arg: strconv.Itoa(next.StartAt)}); ok {
+			row = append(row, b)
+		}
+	}
+
+	var kb *tg.InlineKeyboardMarkup
+	if len(row) > 0 {
+		kb = &tg.InlineKeyboardMarkup{InlineKeyboard: [][]tg.InlineKeyboardButton{row}}
+	}
+
+	return []delivery.Rendering{
+		{Name: "list", Text: md.String(), ParseMode: tg.ParseModeMarkdownV2},
+		delivery.PlainRendering(plain.String()),
+	}, kb, nil
+}
+
+// page - shows another page of a result list in place.
+func (d *Daemon) page(ctx context.Context, cq *tg.CallbackQuery, cd callback.Data) (string, error) {
+	if _, ok := d.conf().UserByTelegram(cq.From.ID); !ok {
+		return "", notice("Your Telegram account isn't linked to a Jira user.")
+	}
+
+	q, ok, err := d.messages.Query(cd.Issue)
+	if err != nil {
+		return "", err
+	}
+
+	start, aerr := strconv.Atoi(cd.Arg)
+	if !ok || aerr != nil || start < 0 || cq.Message == nil {
+		return "", notice("This list is not available anymore.")
+	}
+
+	rr, kb, err := d.listPage(ctx, cd.Issue, q, start)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = d.sender.EditRendered(ctx, tg.EditMessageTextParams{
+		ChatID:             cq.Message.Chat.ID,
+		MessageID:          cq.Message.MessageID,
+		LinkPreviewOptions: &tg.LinkPreviewOptions{IsDisabled: true},
+		ReplyMarkup:        kb,
+	}, rr)
+	if err != nil && !errors.Is(err, tg.ErrMessageNotModified) {
+		return "", err
+	}
+
+	return "", nil
+}
+
+// ticket - shows an issue with its full description, split into as
+// many messages as needed; the last one carries the action buttons.
+func (d *Daemon) ticket(ctx context.Context, m *tg.Message, key string) error {
+	is, err := d.jira.GetIssue(ctx, key, jira.IssueOptions{})
+	if errors.Is(err, jira.ErrNotFound) {
+		return d.notify(ctx, m, key+" not found.")
+	}
+
+	if err != nil {
+		return d.commandError(ctx, m, err)
+	}
+
+	e := &jira.Event{WebhookEvent: jira.EventIssueCreated, Issue: is}
+
+	tmpl := d.chatTemplates(m.Chat.ID, m.MessageThreadID).Lookup(render.TicketKey)
+	if tmpl == nil {
+		return nil
+	}
+
+	text, err := tmpl.Execute(render.NewData(e, d.conf().Jira.URL))
+	if err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+
+	c, _ := formatEvent(e, jira.EventIssueCreated, d.conf().Jira.URL)
+
+	return d.sendLong(ctx, m, is, tg.SplitMarkdownV2(strings.TrimSpace(text), 0), c.fallbacks())
+}
+
+// sendLong - sends MarkdownV2 chunks as replies to m and records them
+// as messages of the issue. If Telegram can't parse the first chunk,
+// the short fallbacks are sent instead.
+func (d *Daemon) sendLong(ctx context.Context, m *tg.Message, is *jira.Issue, chunks []string, fallbacks []delivery.Rendering) error {
+	for i, chunk := range chunks {
+		p := tg.SendMessageParams{
+			ChatID:             m.Chat.ID,
+			MessageThreadID:    m.MessageThreadID,
+			LinkPreviewOptions: &tg.LinkPreviewOptions{IsDisabled: true},
+		}
+
+		if i == 0 {
+			p.ReplyParameters = &tg.ReplyParameters{MessageID: m.MessageID, AllowSendingWithoutReply: true}
+		}
+
+		rr := []delivery.Rendering{{Name: "template", Text: chunk, ParseMode: tg.ParseModeMarkdownV2}}
+		if len(chunks) == 1 {
+			rr = append(rr, fallbacks...)
+		}
+
+		last := i == len(chunks)-1
+		if last {
+			p.ReplyMarkup = d.keyboard(ctx, is)
+		}
+
+		tctx := withTag(ctx, tagMessage, is.Key)
+
+		sent, err := d.sender.SendRendered(tctx, p, rr)
+		if i == 0 && len(chunks) > 1 && errors.Is(err, tg.ErrCantParseEntities) {
+			p.ReplyMarkup = d.keyboard(ctx, is)
+			sent, err = d.sender.SendRendered(tctx, p, fallbacks)
+			last = true
+		}
+
+		if errors.Is(err, delivery.ErrQueued) && !last {
+			// the next chunks queue up behind it
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := d.messages.AddMessage(is.Key, store.MessageRef{Chat: sent.Chat.ID, Thread: m.MessageThreadID, MessageID: sent.MessageID}); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package daemon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/jira/jiratest"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func TestParseCommand(t *testing.T) {
+	d := &Daemon{username: "jsm2tg_bot"}
+
+	tests := []struct {
+		text, cmd, args string
+		ok              bool
+	}{
+		{"/ticket SD-42", "/ticket", "SD-42", true},
+		{"/My@JSM2TG_bot", "/my", "", true},
+		{"/search@jsm2tg_bot project = SD\nORDER BY key", "/search", "project = SD\nORDER BY key", true},
+		{"/queue\nOpen", "/queue", "Open", true},
+		{"/my@otherbot", "", "", false},
+		{"hello /my", "", "", false},
+	}
+
+	for _, tt := range tests {
+		cmd, args, ok := d.parseCommand(tt.text)
+		if cmd != tt.cmd || args != tt.args || ok != tt.ok {
+			t.Errorf("%q: got %q, %q, %v; want %q, %q, %v", tt.text, cmd, args, ok, tt.cmd, tt.args, tt.ok)
+		}
+	}
+}
+
+func commandDaemon(t *testing.T, fs *fakeSender, fb *fakeBot) (*Daemon, *jiratest.Server) {
+	t.Helper()
+
+	js := jiratest.NewServer()
+	t.Cleanup(js.Close)
+
+	for _, is := range []jiratest.Issue{
+		{Key: "SD-1", Summary: "Printer jam", Status: "Open", Priority: "Low"},
+		{Key: "SD-2", Summary: "VPN is down", Status: "In Progress", Priority: "High"},
+		{Key: "SD-3", Summary: "New laptop", Status: "Open", Priority: "Medium", Description: strings.Repeat("Lorem ipsum dolor sit amet. ", 300)},
+	} {
+		js.AddIssue(is)
+	}
+
+	js.AddQueue(jiratest.Queue{ID: "10", Name: "Open", JQL: "project = SD AND status = Open"})
+
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Telegram.Updates = config.UpdatesPolling
+	cfg.Jira.ServiceDesk = "1"
+	cfg.Commands.PageSize = 2
+	cfg.Users = []config.User{{Telegram: 501, AccountID: "acc-1"}}
+
+	d, err := New(cfg, fs, WithWorkers(1), WithJira(jira.NewClient(js.URL)), WithBot(fb))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return d, js
+}
+
+func TestListCommands(t *testing.T) {
+	fs := newFakeSender()
+	fb := &fakeBot{}
+	d, js := commandDaemon(t, fs, fb)
+	ctx := context.Background()
+
+	msg := func(text string) *tg.Message {
+		return &tg.Message{MessageID: 70, From: &tg.User{ID: 501}, Chat: tg.Chat{ID: -1001}, Text: text}
+	}
+
+	for _, text := range []string{"/my", "/queue open", "/search project = SD", "/queue Closed"} {
+		cmd, args, _ := d.parseCommand(text)
+		if err := d.command(ctx, msg(text), cmd, args); err != nil {
+			t.Errorf("%s: %v", text, err)
+		}
+	}
+
+	wantJQL := []string{
+		`assignee = "acc-1" AND resolution = Unresolved ORDER BY updated DESC`,
+		"project = SD AND status = Open",
+		"project = SD",
+	}
+
+	if got := js.Searches(); strings.Join(got, "\n") != strings.Join(wantJQL, "\n") {
+		t.Errorf("searches = %q, want %q", got, wantJQL)
+	}
+
+	got := fs.wait(t, 4)
+
+	first := got[0]
+	for _, want := range []string{"*My issues*", "1–2 of 3", "[SD\\-1](https://jira.example.com/browse/SD-1): Printer jam · Open", "SD\\-2"} {
+		if !strings.Contains(first.rr[0].Text, want) {
+			t.Errorf("list does not contain %q:\n%s", want, first.rr[0].Text)
+		}
+	}
+
+	if strings.Contains(first.rr[0].Text, "SD\\-3") {
+		t.Errorf("first page shows SD-3:\n%s", first.rr[0].Text)
+	}
+
+	if !strings.Contains(got[1].rr[0].Text, "*Queue: Open*") {
+		t.Errorf("queue list = %s", got[1].rr[0].Text)
+	}
+
+	if !strings.Contains(got[3].rr[0].Text, `Unknown queue "Closed"\. Queues: Open\.`) {
+		t.Errorf("unknown queue notice = %s", got[3].rr[0].Text)
+	}
+
+	kb := first.params.ReplyMarkup
+	if kb == nil || len(kb.InlineKeyboard) != 1 || len(kb.InlineKeyboard[0]) != 1 || kb.InlineKeyboard[0][0].Text != "▶️" {
+		t.Fatalf("first page keyboard = %+v", kb)
+	}
+
+	// the next page is shown in place
+	list := &tg.Message{MessageID: 1, Chat: tg.Chat{ID: -1001}}
+	q := &tg.CallbackQuery{ID: "q", From: tg.User{ID: 501}, Message: list, Data: kb.InlineKeyboard[0][0].CallbackData}
+
+	cd, err := d.signer.Decode(q.Data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	d.callback(ctx, q, cd)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.edits) != 1 {
+		t.Fatalf("edits = %d, want 1", len(fs.edits))
+	}
+
+	page := fs.edits[0]
+	if page.params.MessageID != 1 || !strings.Contains(page.rr[0].Text, "3–3 of 3") || !strings.Contains(page.rr[0].Text, "New laptop") {
+		t.Errorf("second page = %+v: %s", page.params, page.rr[0].Text)
+	}
+
+	if kb := page.params.ReplyMarkup; kb == nil || kb.InlineKeyboard[0][0].Text != "◀️" || len(kb.InlineKeyboard[0]) != 1 {
+		t.Errorf("second page keyboard = %+v", kb)
+	}
+
+	if strings.Join(fb.calls, "\n") != "answer " {
+		t.Errorf("answers = %q", fb.calls)
+	}
+}
+
+func TestTicketCommand(t *testing.T) {
+	fs := newFakeSender()
+	d, _ := commandDaemon(t, fs, &fakeBot{})
+	ctx := context.Background()
+
+	m := &tg.Message{MessageID: 70, From: &tg.User{ID: 501}, Chat: tg.Chat{ID: -1001}, Text: "/ticket sd-3"}
+	if err := d.command(ctx, m, CommandTicket, "sd-3"); err != nil {
+		t.Fatalf("ticket: %v", err)
+	}
+
+	got := fs.wait(t, 3)
+
+	if !strings.HasPrefix(got[0].rr[0].Text, "📋 *SD\\-3: New laptop*") || got[0].params.ReplyParameters == nil {
+		t.Errorf("first chunk = %+v: %.80s", got[0].params, got[0].rr[0].Text)
+	}
+
+	for i, s := range got {
+		if n := tg.UTF16Len(s.rr[0].Text); n > tg.MaxMessageLength {
+			t.Errorf("chunk %d is %d long", i, n)
+		}
+
+		if (s.params.ReplyMarkup != nil) != (i == len(got)-1) {
+			t.Errorf("chunk %d keyboard = %+v", i, s.params.ReplyMarkup)
+		}
+
+		// replies to any chunk become comments of the issue
+		if issue, _, _ := d.messages.Issue(-1001, i+1); issue != "SD-3" {
+			t.Errorf("chunk %d issue = %q", i, issue)
+		}
+	}
+
+	for _, text := range []string{"nope", "SD-404"} {
+		if err := d.command(ctx, m, CommandTicket, text); err != nil {
+			t.Errorf("%s: %v", text, err)
+		}
+	}
+
+	got = fs.wait(t, 2)
+	if !strings.Contains(got[3].rr[0].Text, "Usage") || !strings.Contains(got[4].rr[0].Text, "SD\\-404 not found") {
+		t.Errorf("notices = %q, %q", got[3].rr[0].Text, got[4].rr[0].Text)
+	}
+
+	// commands need a linked user
+	m.From.ID = 777
+	if err := d.command(ctx, m, CommandMy, ""); err != nil {
+		t.Errorf("unlinked: %v", err)
+	}
+
+	if got = fs.wait(t, 1); !strings.Contains(got[5].rr[0].Text, "isn't linked") {
+		t.Errorf("unlinked notice = %q", got[5].rr[0].Text)
+	}
+}
+
+func TestTelegramWebhook(t *testing.T) {
+	fs := newFakeSender()
+	d, _ := commandDaemon(t, fs, &fakeBot{})
+	d.conf().Telegram.Updates = config.UpdatesWebhook
+	d.conf().Telegram.WebhookPath = "/telegram"
+	d.conf().Telegram.WebhookSecret = "s3cret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	body := `{"update_id":1,"message":{"message_id":70,"from":{"id":501},"chat":{"id":-1001},"text":"/my"}}`
+
+	for _, secret := range []string{"wrong", "s3cret"} {
+		r := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(body))
+		r.Header.Set(tg.SecretTokenHeader, secret)
+
+		w := httptest.NewRecorder()
+		d.Handler().ServeHTTP(w, r)
+
+		if want := map[string]int{"wrong": http.StatusForbidden, "s3cret": http.StatusOK}[secret]; w.Code != want {
+			t.Errorf("%s: status = %d, want %d", secret, w.Code, want)
+		}
+	}
+
+	if got := fs.wait(t, 1); !strings.Contains(got[0].rr[0].Text, "*My issues*") {
+		t.Errorf("reply = %s", got[0].rr[0].Text)
+	}
+}
+
+func TestQueryChats(t *testing.T) {
+	fs := newFakeSender()
+	d, _ := commandDaemon(t, fs, &fakeBot{})
+	d.conf().Requests.Chats = []int64{-3001}
+
+	tests := []struct {
+		name string
+		chat tg.Chat
+		want bool
+	}{
+		{"private", tg.Chat{ID: 501, Type: "private"}, true},
+		{"routed", tg.Chat{ID: -1001, Type: "supergroup"}, true},
+		{"requests chat", tg.Chat{ID: -3001, Type: "group"}, false},
+		{"other group", tg.Chat{ID: -4001, Type: "group"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := d.mayQuery(tt.chat); got != tt.want {
+			t.Errorf("%s: mayQuery() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	m := &tg.Message{MessageID: 70, From: &tg.User{ID: 501}, Chat: tg.Chat{ID: -3001, Type: "group"}, Text: "/search project = SD"}
+	if err := d.command(context.Background(), m, CommandSearch, "project = SD"); err != nil {
+		t.Fatalf("search: %v", err)
+	}
+
+	if got := fs.wait(t, 1); !strings.Contains(got[0].rr[0].Text, "can't be queried in this chat") {
+		t.Errorf("notice = %q", got[0].rr[0].Text)
+	}
+}
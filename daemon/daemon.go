@@ -0,0 +1,456 @@
+package daemon
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schors/jsm2tg/callback"
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/oncall"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// MaxPayloadSize - the largest webhook payload accepted.
+const MaxPayloadSize = 10 << 20
+
+// queueSize - jobs buffered per worker.
+const queueSize = 64
+
+// drainTimeout - the time given to the jobs left at shutdown.
+const drainTimeout = 30 * time.Second
+
+// Sender - delivers rendered messages; *delivery.Sender implements it.
+type Sender interface {
+	SendRendered(ctx context.Context, p tg.SendMessageParams, rr []delivery.Rendering) (*tg.Message, error)
+	EditRendered(ctx context.Context, p tg.EditMessageTextParams, rr []delivery.Rendering) (*tg.Message, error)
+}
+
+// Daemon - receives Jira webhooks and forwards them to Telegram.
+type Daemon struct {
+	settings atomic.Pointer[settings]
+	sender   Sender
+	log      *slog.Logger
+	store    store.Store
+	messages *store.Messages
+	bot      Bot
+	limiter  Limiter
+	jira     Jira
+	jiraAs   func(u config.User) Jira
+	signer   *callback.Signer
+	username string
+	clock    clock.Clock
+
+	// the on-call schedule given with WithOnCall
+	oncall *oncall.Schedule
+
+	// jobs of one issue (webhook events, Telegram replies) always go
+	// to the same worker, so they are handled in order
+	queues  []chan func(context.Context)
+	wg      sync.WaitGroup
+	running atomic.Bool   // the workers run
+	stop    chan struct{} // closed when Run is cancelled
+
+	metrics     instruments
+	readyChecks []readyCheck
+
+	mu      sync.Mutex
+	pending map[pendingKey]*pendingUpdates // issue updates being coalesced
+	pruned  time.Time                      // when old handled events were last forgotten
+	held    map[heldKey]*heldEvents        // issue events held for quiet hours
+
+	digests  []digest
+	digestMu sync.Mutex // guards the digest issues in the store
+}
+
+// Option - configures a Daemon.
+type Option func(*Daemon)
+
+// WithLogger - sets the logger; the default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(d *Daemon) {
+		d.log = l
+	}
+}
+
+// WithStore - sets the persistent store; the default is an in-memory one.
+func WithStore(s store.Store) Option {
+	return func(d *Daemon) {
+		d.store = s
+	}
+}
+
+// Jira - the Jira REST API calls used by the daemon; *jira.Client implements it.
+type Jira interface {
+	GetIssue(ctx context.Context, key string, o jira.IssueOptions) (*jira.Issue, error)
+	AddComment(ctx context.Context, key, body string, internal bool) (*jira.Comment, error)
+	Transitions(ctx context.Context, key string) ([]jira.Transition, error)
+	DoTransition(ctx context.Context, key, transitionID string) error
+	Assign(ctx context.Context, key string, u jira.User) error
+	EditIssue(ctx context.Context, key string, fields map[string]any) error
+	Search(ctx context.Context, jql string, o jira.SearchOptions) (*jira.SearchResult, error)
+	Queues(ctx context.Context, serviceDeskID string) ([]jira.Queue, error)
+	RequestTypes(ctx context.Context, serviceDeskID string) ([]jira.RequestType, error)
+	CreateRequest(ctx context.Context, p jira.CreateRequestParams) (*jira.CreatedRequest, error)
+	AttachTemporaryFile(ctx context.Context, serviceDeskID, name string, r io.Reader) (string, error)
+	AddAttachments(ctx context.Context, key string, temporaryIDs []string, public bool) error
+	Approvals(ctx context.Context, key string) ([]jira.Approval, error)
+	AnswerApproval(ctx context.Context, key, approvalID, decision string) (*jira.Approval, error)
+}
+
+// Bot - the Bot API calls besides sending messages; *tg.Client implements it.
+type Bot interface {
+	Forum
+	AnswerCallbackQuery(ctx context.Context, p tg.AnswerCallbackQueryParams) error
+	GetFile(ctx context.Context, fileID string) (*tg.File, error)
+	DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error)
+}
+
+// WithBot - sets the Bot API used for forum topics and callback queries,
+// required by destinations with topics enabled and by Telegram updates.
+func WithBot(b Bot) Option {
+	return func(d *Daemon) {
+		d.bot = b
+	}
+}
+
+// WithJira - sets the Jira REST API, required to receive Telegram updates.
+func WithJira(j Jira) Option {
+	return func(d *Daemon) {
+		d.jira = j
+	}
+}
+
+// WithJiraAs - sets how to call the Jira REST API as a linked user with
+// their own credentials (users[].jira_token); approval buttons are only
+// offered to approvers it can act for.
+func WithJiraAs(fn func(u config.User) Jira) Option {
+	return func(d *Daemon) {
+		d.jiraAs = fn
+	}
+}
+
+// WithClock - sets the clock of SLA alerts and templates; the default
+// is the wall clock.
+func WithClock(c clock.Clock) Option {
+	return func(d *Daemon) {
+		d.clock = c
+	}
+}
+
+// WithOnCall - sets the on-call schedule; who is on call for a route
+// is mentioned in its new issue messages and SLA alerts.
+func WithOnCall(s *oncall.Schedule) Option {
+	return func(d *Daemon) {
+		d.oncall = s
+	}
+}
+
+// WithWorkers - sets the number of event workers; the default is 4.
+func WithWorkers(n int) Option {
+	return func(d *Daemon) {
+		if n > 0 {
+			d.queues = make([]chan func(context.Context), n)
+		}
+	}
+}
+
+// New - creates a daemon.
+func New(cfg *config.Config, sender Sender, opts ...Option) (*Daemon, error) {
+	d := &Daemon{
+		sender:  sender,
+		log:     slog.Default(),
+		clock:   clock.Real{},
+		queues:  make([]chan func(context.Context), 4),
+		stop:    make(chan struct{}),
+		pending: make(map[pendingKey]*pendingUpdates),
+		held:    make(map[heldKey]*heldEvents),
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.store == nil {
+		d.store = store.NewMemory()
+	}
+
+	d.messages = store.NewMessages(d.store)
+	d.initMetrics()
+
+	if d.bot == nil && usesTopics(cfg) {
+		return nil, errors.New("forum topics are enabled but no Bot API is set")
+	}
+
+	st, err := d.newSettings(cfg, d.oncall)
+	if err != nil {
+		return nil, err
+	}
+
+	d.settings.Store(st)
+
+	if d.receivesUpdates() && (d.jira == nil || d.bot == nil) {
+		return nil, errors.New("telegram updates are enabled but no Jira or Bot API is set")
+	}
+
+	if cfg.SLA.Interval > 0 && len(st.thresholds) > 0 && d.jira == nil {
+		return nil, errors.New("SLA polling is enabled but no Jira API is set")
+	}
+
+	secret := []byte(cfg.Telegram.CallbackSecret)
+	if len(secret) == 0 {
+		sum := sha256.Sum256([]byte("jsm2tg callback " + cfg.Telegram.Token))
+		secret = sum[:]
+	}
+
+	d.signer = callback.NewSigner(secret)
+
+	for i := range d.queues {
+		d.queues[i] = make(chan func(context.Context), queueSize)
+	}
+
+	d.digests, err = newDigests(st.router.Rules())
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Handler - returns the HTTP handler serving the webhook endpoint, the
+// Telegram webhook endpoint if updates are received that way, the
+// health checks and the metrics if collected.
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+d.conf().WebhookPath, d.serveWebhook)
+	mux.HandleFunc("GET /healthz", d.serveHealthz)
+	mux.HandleFunc("GET /readyz", d.serveReadyz)
+
+	if d.metrics.registry != nil {
+		mux.Handle("GET /metrics", d.metrics.registry)
+	}
+
+	if d.conf().Telegram.Updates == config.UpdatesWebhook {
+		mux.Handle("POST "+d.conf().Telegram.WebhookPath, tg.WebhookHandler(d.conf().Telegram.WebhookSecret, d))
+	}
+
+	return mux
+}
+
+func (d *Daemon) serveWebhook(w http.ResponseWriter, r *http.Request) {
+	auth := d.current().auth
+
+	// unauthenticated clients don't get to send a body
+	if err := auth.CheckRequest(r); err != nil {
+		d.rejectForbidden(w, r, err)
+
+		return
+	}
+
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxPayloadSize))
+	if err != nil {
+		d.log.Warn("webhook rejected", "reason", "read body", "remote", r.RemoteAddr, "error", err)
+		d.metrics.webhooksRejected.Inc("unknown", rejectReadBody)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+
+		return
+	}
+
+	if err := auth.CheckBody(r, b); err != nil {
+		d.rejectForbidden(w, r, err)
+
+		return
+	}
+
+	e, err := jira.ParseEvent(b)
+	if err != nil || e.WebhookEvent == "" {
+		d.log.Warn("webhook rejected", "reason", "bad payload", "remote", r.RemoteAddr, "error", err)
+		d.metrics.webhooksRejected.Inc("unknown", rejectBadPayload)
+		http.Error(w, "bad payload", http.StatusBadRequest)
+
+		return
+	}
+
+	d.metrics.webhooksReceived.Inc(e.WebhookEvent)
+
+	select {
+	case d.queue(issueKey(e)) <- func(ctx context.Context) { d.process(ctx, e) }:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		// let Jira retry later
+		d.log.Warn("webhook dropped", "reason", "queue full", "event", e.WebhookEvent)
+		d.metrics.webhooksRejected.Inc(e.WebhookEvent, rejectQueueFull)
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}
+}
+
+func (d *Daemon) rejectForbidden(w http.ResponseWriter, r *http.Request, err error) {
+	d.log.Warn("webhook rejected", "reason", err.Error(), "remote", r.RemoteAddr)
+	d.metrics.webhooksRejected.Inc("unknown", rejectForbidden)
+	http.Error(w, "forbidden", http.StatusForbidden)
+}
+
+// queue - returns the worker queue of an issue.
+func (d *Daemon) queue(issue string) chan func(context.Context) {
+	h := fnv.New32a()
+	h.Write([]byte(issue))
+
+	return d.queues[h.Sum32()%uint32(len(d.queues))]
+}
+
+// Run - processes queued jobs until ctx is cancelled, then finishes the
+// jobs left in the queues: their webhooks were accepted and Jira won't
+// send them again. Stop the HTTP server before cancelling ctx, so no
+// job is queued after that.
+func (d *Daemon) Run(ctx context.Context) {
+	d.running.Store(true)
+	defer d.running.Store(false)
+
+	// jobs get a context of their own, so the jobs running or queued
+	// at shutdown finish; it ends drainTimeout after ctx
+	work, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	context.AfterFunc(ctx, func() {
+		close(d.stop)
+		time.AfterFunc(drainTimeout, cancelWork)
+	})
+
+	d.restoreHeld()
+
+	for _, q := range d.queues {
+		d.wg.Add(1)
+
+		go func() {
+			defer d.wg.Done()
+
+			for {
+				select {
+				case job := <-q:
+					job(work)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	if polling(d.current()) {
+		d.wg.Add(1)
+
+		go func() {
+			defer d.wg.Done()
+			d.pollSLA(ctx)
+		}()
+	}
+
+	for _, dg := range d.digests {
+		d.wg.Add(1)
+
+		go func() {
+			defer d.wg.Done()
+			d.runDigest(ctx, dg)
+		}()
+	}
+
+	d.wg.Wait()
+	d.drain(work)
+
+	// updates held by coalescing windows; events held by quiet hours stay
+	// in the store for the next start
+	d.flushAll(work)
+}
+
+// drain - runs the jobs left in the queues, those of a queue in order.
+func (d *Daemon) drain(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, q := range d.queues {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case job := <-q:
+					job(ctx)
+				default:
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (d *Daemon) process(ctx context.Context, e *jira.Event) {
+	if d.duplicate(e) {
+		d.log.Debug("duplicate event ignored", "event", e.WebhookEvent, "issue", issueKey(e), "id", e.ID())
+
+		return
+	}
+
+	defer d.handled(e)
+
+	if err := d.Handle(ctx, e); err != nil {
+		d.log.Error("event delivery failed", "event", e.WebhookEvent, "issue", issueKey(e), "error", err)
+	}
+
+	if e.Issue != nil && e.Kind() != jira.EventIssueDeleted && d.receivesUpdates() {
+		if err := d.checkApprovals(ctx, e.Issue); err != nil {
+			d.log.Error("approval request failed", "issue", e.Issue.Key, "error", err)
+		}
+	}
+
+	if e.Issue != nil && e.Kind() != jira.EventIssueDeleted && len(d.current().thresholds) > 0 {
+		if err := d.checkSLA(ctx, e.Issue); err != nil {
+			d.log.Error("SLA alert failed", "issue", e.Issue.Key, "error", err)
+		}
+	}
+}
+
+// receivesUpdates - reports whether Telegram updates (replies, button
+// presses) are received.
+func (d *Daemon) receivesUpdates() bool {
+	return d.conf().Telegram.Updates != config.UpdatesNone && d.conf().Telegram.Updates != ""
+}
+
+// usesTopics - reports whether any destination has topics enabled.
+func usesTopics(cfg *config.Config) bool {
+	for _, ch := range cfg.Chats {
+		if ch.Topics {
+			return true
+		}
+	}
+
+	for _, r := range cfg.Routing.Rules {
+		for _, to := range r.To {
+			if to.Topics {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func issueKey(e *jira.Event) string {
+	if e.Issue == nil {
+		return ""
+	}
+
+	return e.Issue.Key
+}
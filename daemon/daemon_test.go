@@ -0,0 +1,392 @@
+package daemon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/tg"
+)
+
+type sent struct {
+	params tg.SendMessageParams
+	rr     []delivery.Rendering
+}
+
+type edit struct {
+	params tg.EditMessageTextParams
+	rr     []delivery.Rendering
+}
+
+type fakeSender struct {
+	mu      sync.Mutex
+	sent    []sent
+	edits   []edit
+	editErr error
+	sendErr error
+	ch      chan struct{}
+}
+
+func newFakeSender() *fakeSender {
+	return &fakeSender{ch: make(chan struct{}, 16)}
+}
+
+func (f *fakeSender) SendRendered(_ context.Context, p tg.SendMessageParams, rr []delivery.Rendering) (*tg.Message, error) {
+	f.mu.Lock()
+	if f.sendErr != nil {
+		defer f.mu.Unlock()
+
+		return nil, f.sendErr
+	}
+
+	f.sent = append(f.sent, sent{p, rr})
+	n := len(f.sent)
+	f.mu.Unlock()
+
+	f.ch <- struct{}{}
+
+	return &tg.Message{MessageID: n, Chat: tg.Chat{ID: p.ChatID}}, nil
+}
+
+func (f *fakeSender) EditRendered(_ context.Context, p tg.EditMessageTextParams, rr []delivery.Rendering) (*tg.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.editErr != nil {
+		return nil, f.editErr
+	}
+
+	f.edits = append(f.edits, edit{p, rr})
+
+	return &tg.Message{MessageID: p.MessageID, Chat: tg.Chat{ID: p.ChatID}}, nil
+}
+
+func (f *fakeSender) wait(t *testing.T, n int) []sent {
+	t.Helper()
+
+	for range n {
+		select {
+		case <-f.ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d messages", n)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]sent(nil), f.sent...)
+}
+
+func mustNew(t *testing.T, cfg *config.Config, s Sender) *Daemon {
+	t.Helper()
+
+	d, err := New(cfg, s, WithWorkers(1))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return d
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		WebhookPath: "/webhook",
+		Jira:        config.Jira{URL: "https://jira.example.com"},
+		Chats:       []config.Chat{{ID: -1001}, {ID: -1002, Thread: 7}},
+	}
+}
+
+func readEvent(t *testing.T, name string) *jira.Event {
+	t.Helper()
+
+	b, err := os.ReadFile("../jira/testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := jira.ParseEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// updated - turns an issue_created event into an issue_updated one
+// changing the given field.
+func updated(e *jira.Event, field string) *jira.Event {
+	u := *e
+	u.WebhookEvent = jira.EventIssueUpdated
+	u.Changelog = &jira.Changelog{ID: "1", Items: []jira.ChangelogItem{{Field: field, FromString: "a", ToString: "b"}}}
+
+	return &u
+}
+
+func postFixture(t *testing.T, url, name string) *http.Response {
+	t.Helper()
+
+	f, err := os.Open("../jira/testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	resp, err := http.Post(url, "application/json", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	return resp
+}
+
+func TestWebhookForwardsToChats(t *testing.T) {
+	fs := newFakeSender()
+	d := mustNew(t, testConfig(), fs)
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	if resp := postFixture(t, srv.URL+"/webhook", "issue_created.json"); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	got := fs.wait(t, 2)
+
+	if got[0].params.ChatID != -1001 || got[1].params.ChatID != -1002 || got[1].params.MessageThreadID != 7 {
+		t.Errorf("destinations = %+v, %+v", got[0].params, got[1].params)
+	}
+
+	md := got[0].rr[0].Text
+	for _, want := range []string{
+		"🆕 *SD\\-42: VPN is down*",
+		"High · Incident · Report an incident · Open",
+		"*h2\\. Symptoms*",
+		"`vpn.example.com`",
+		"(https://jira.example.com/browse/SD-42)",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("message does not contain %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestRunDrainsQueues(t *testing.T) {
+	fs := newFakeSender()
+	d := mustNew(t, testConfig(), fs)
+
+	srv := httptest.NewServer(d.Handler())
+
+	// accepted while the daemon isn't running, then the server stops
+	if resp := postFixture(t, srv.URL+"/webhook", "issue_created.json"); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	d.Run(ctx)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.sent) != 2 {
+		t.Errorf("sent %d messages at shutdown, want 2", len(fs.sent))
+	}
+}
+
+func TestWebhookRejectsBadPayload(t *testing.T) {
+	d := mustNew(t, testConfig(), newFakeSender())
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/webhook", "application/json", strings.NewReader("{not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp, err = http.Get(srv.URL + "/webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandleComment(t *testing.T) {
+	fs := newFakeSender()
+	d := mustNew(t, testConfig(), fs)
+
+	b, err := os.ReadFile("../jira/testdata/comment_created.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := jira.ParseEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Handle(context.Background(), e); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	got := fs.wait(t, 2)
+	if md := got[0].rr[0].Text; !strings.Contains(md, "Bob commented \\(internal\\):") {
+		t.Errorf("message = %s", md)
+	}
+}
+
+func TestHandleRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Routing = route.Config{Rules: []route.Rule{
+		{Name: "dev", Match: route.Match{Projects: []string{"DEV"}}, To: []route.Destination{{Chat: -2001}}},
+		{
+			Name:      "sd",
+			Match:     route.Match{Projects: []string{"SD"}},
+			To:        []route.Destination{{Chat: -3001, Thread: 3}},
+			Templates: map[string]string{"default": "{{.Issue.Key}} {{.Issue.Summary}}"},
+		},
+	}}
+
+	fs := newFakeSender()
+	d := mustNew(t, cfg, fs)
+
+	b, err := os.ReadFile("../jira/testdata/issue_created.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := jira.ParseEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Handle(context.Background(), e); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	got := fs.wait(t, 1)
+	if len(got) != 1 || got[0].params.ChatID != -3001 || got[0].params.MessageThreadID != 3 {
+		t.Errorf("sent = %+v", got)
+	}
+
+	if text := got[0].rr[0].Text; text != "SD\\-42 VPN is down" {
+		t.Errorf("rule template output = %q", text)
+	}
+}
+
+func TestHandleEditsCardAndReplies(t *testing.T) {
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+
+	fs := newFakeSender()
+	d := mustNew(t, cfg, fs)
+	ctx := context.Background()
+
+	created := readEvent(t, "issue_created.json")
+	if err := d.Handle(ctx, created); err != nil {
+		t.Fatalf("created: %v", err)
+	}
+
+	fs.wait(t, 1)
+
+	// a status change edits the card
+	created.Issue.Fields.Status = &jira.Status{Name: "In Progress"}
+	if err := d.Handle(ctx, updated(created, "status")); err != nil {
+		t.Fatalf("updated: %v", err)
+	}
+
+	fs.mu.Lock()
+	edits := append([]edit(nil), fs.edits...)
+	fs.mu.Unlock()
+
+	if len(edits) != 1 || edits[0].params.ChatID != -1001 || edits[0].params.MessageID != 1 {
+		t.Fatalf("edits = %+v", edits)
+	}
+
+	if md := edits[0].rr[0].Text; !strings.Contains(md, "🆕 *SD\\-42: VPN is down*") || !strings.Contains(md, "In Progress") {
+		t.Errorf("edited card = %s", md)
+	}
+
+	// other changes and comments are replies to the card
+	if err := d.Handle(ctx, updated(created, "labels")); err != nil {
+		t.Fatalf("labels: %v", err)
+	}
+
+	if err := d.Handle(ctx, readEvent(t, "comment_created.json")); err != nil {
+		t.Fatalf("comment: %v", err)
+	}
+
+	got := fs.wait(t, 2)
+	if len(got) != 3 {
+		t.Fatalf("sent %d messages, want 3", len(got))
+	}
+
+	for i, s := range got[1:] {
+		if r := s.params.ReplyParameters; r == nil || r.MessageID != 1 {
+			t.Errorf("message %d: reply = %+v, want a reply to the card", i+1, r)
+		}
+	}
+
+	if issue, ok, err := d.messages.Issue(-1001, 3); err != nil || !ok || issue != "SD-42" {
+		t.Errorf("Issue(-1001, 3) = %q, %v, %v", issue, ok, err)
+	}
+}
+
+func TestHandleRepostsDeletedCard(t *testing.T) {
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+
+	fs := newFakeSender()
+	d := mustNew(t, cfg, fs)
+	ctx := context.Background()
+
+	created := readEvent(t, "issue_created.json")
+	if err := d.Handle(ctx, created); err != nil {
+		t.Fatalf("created: %v", err)
+	}
+
+	fs.editErr = &tg.Error{Method: "editMessageText", Code: 400, Description: "Bad Request: message to edit not found"}
+
+	if err := d.Handle(ctx, updated(created, "assignee")); err != nil {
+		t.Fatalf("updated: %v", err)
+	}
+
+	got := fs.wait(t, 2)
+	if got[1].params.ReplyParameters != nil || !strings.HasPrefix(got[1].rr[0].Text, "🆕") {
+		t.Errorf("reposted card = %+v", got[1])
+	}
+
+	card, ok, err := d.messages.Card("SD-42", -1001)
+	if err != nil || !ok || card.MessageID != 2 {
+		t.Errorf("Card = %+v, %v, %v; want message 2", card, ok, err)
+	}
+}
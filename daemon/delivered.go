@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Tags of the messages an outbox may deliver after a retry, as
+// "kind:issue"; the issue of approval requests is "KEY/approval id".
+const (
+	tagCard     = "card"
+	tagMessage  = "message"
+	tagApproval = "approval"
+)
+
+// withTag - labels the messages sent with ctx for Delivered.
+func withTag(ctx context.Context, kind, issue string) context.Context {
+	if issue == "" {
+		return ctx
+	}
+
+	return delivery.WithTag(ctx, kind+":"+issue)
+}
+
+// Delivered - records the issue messages an outbox delivered after
+// their sender stopped waiting, e.g. after a retry or a restart; set
+// it as delivery.OutboxConfig.Delivered.
+func (d *Daemon) Delivered(tag string, m *tg.Message) {
+	kind, issue, _ := strings.Cut(tag, ":")
+	ref := store.MessageRef{Chat: m.Chat.ID, Thread: m.MessageThreadID, MessageID: m.MessageID}
+
+	var err error
+
+	switch kind {
+	case tagCard:
+		err = d.messages.SetCard(issue, ref)
+	case tagMessage:
+		err = d.messages.AddMessage(issue, ref)
+	case tagApproval:
+		key, id, _ := strings.Cut(issue, "/")
+
+		job := func(context.Context) {
+			r, ok, err := d.messages.ApprovalRequest(key, id)
+			if err == nil && ok {
+				r.Messages = append(r.Messages, ref)
+				err = d.messages.SetApprovalRequest(key, id, r)
+			}
+
+			if err != nil {
+				d.log.Error("delivered message not recorded", "tag", tag, "error", err)
+			}
+		}
+
+		// on the worker of the issue, after the approval request is saved;
+		// the outbox must not wait for a worker, which may wait for it
+		go func() {
+			if !d.enqueue(key, job) {
+				d.log.Warn("delivered message not recorded: stopping", "tag", tag)
+			}
+		}()
+
+		return
+	default:
+		return
+	}
+
+	if err != nil {
+		d.log.Error("delivered message not recorded", "tag", tag, "error", err)
+	}
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func TestDelivered(t *testing.T) {
+	d, err := New(testConfig(), newFakeSender(), WithWorkers(1))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	if err := d.messages.SetApprovalRequest("SD-1", "7", store.ApprovalRequest{Messages: []store.MessageRef{{Chat: 501, MessageID: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Delivered("card:SD-1", &tg.Message{MessageID: 10, Chat: tg.Chat{ID: -1001}})
+	d.Delivered("message:SD-1", &tg.Message{MessageID: 11, Chat: tg.Chat{ID: -1002}})
+	d.Delivered("approval:SD-1/7", &tg.Message{MessageID: 12, Chat: tg.Chat{ID: 502}})
+	d.Delivered("", &tg.Message{MessageID: 13, Chat: tg.Chat{ID: -1001}})
+
+	if ref, ok, err := d.messages.Card("SD-1", -1001); err != nil || !ok || ref.MessageID != 10 {
+		t.Errorf("card = %+v, %t, %v", ref, ok, err)
+	}
+
+	for _, m := range []struct {
+		chat int64
+		id   int
+		want string
+	}{
+		{-1001, 10, "SD-1"},
+		{-1002, 11, "SD-1"},
+		{-1001, 13, ""},
+	} {
+		if issue, _, err := d.messages.Issue(m.chat, m.id); err != nil || issue != m.want {
+			t.Errorf("issue of %d/%d = %q, %v; want %q", m.chat, m.id, issue, err, m.want)
+		}
+	}
+
+	// the approval request is updated on the worker of the issue
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		r, _, err := d.messages.ApprovalRequest("SD-1", "7")
+		if err == nil && len(r.Messages) == 2 && r.Messages[1].Chat == 502 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("approval request = %+v, %v", r, err)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package daemon
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schors/jsm2tg/cron"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// digest - a destination of a rule with a digest.
+type digest struct {
+	rule     *route.Rule
+	chat     int64
+	thread   int
+	schedule *cron.Schedule
+	loc      *time.Location
+}
+
+// newDigests - returns the digest destinations of the rules.
+func newDigests(rules []route.Rule) ([]digest, error) {
+	var digests []digest
+
+	for i := range rules {
+		r := &rules[i]
+		if r.Digest == nil {
+			continue
+		}
+
+		s, err := cron.Parse(r.Digest.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("routing.rules[%d].digest: %w", i, err)
+		}
+
+		loc, err := r.Digest.Location()
+		if err != nil {
+			return nil, fmt.Errorf("routing.rules[%d].digest: %w", i, err)
+		}
+
+		for _, to := range r.To {
+			digests = append(digests, digest{rule: r, chat: to.Chat, thread: to.Thread, schedule: s, loc: loc})
+		}
+	}
+
+	return digests, nil
+}
+
+// digested - reports whether the target gets a digest instead of events.
+func (d *Daemon) digested(t route.Target) bool {
+	return t.Rule != nil && t.Rule.Digest != nil && !t.Public &&
+		slices.ContainsFunc(d.digests, func(dg digest) bool { return dg.chat == t.Chat && dg.thread == t.Thread })
+}
+
+// collect - records the event of an issue in the digest of the target,
+// with the SLAs breached according to the issue and the given ones.
+// SLA alerts aren't counted as events.
+func (d *Daemon) collect(e *jira.Event, t route.Target, breached ...string) error {
+	if e.Issue == nil {
+		return nil
+	}
+
+	d.digestMu.Lock()
+	defer d.digestMu.Unlock()
+
+	key := e.Issue.Key
+
+	if e.Kind() == jira.EventIssueDeleted {
+		return d.messages.DeleteDigestIssue(t.Chat, t.Thread, key)
+	}
+
+	is, known, err := d.messages.DigestIssue(t.Chat, t.Thread, key)
+	if err != nil {
+		return err
+	}
+
+	f := &e.Issue.Fields
+	open := f.Resolution == nil && (f.Status == nil || f.Status.StatusCategory.Key != "done")
+
+	if !open && (known && is.Open || resolves(e)) {
+		is.Resolved = true
+	}
+
+	is.Key, is.Summary, is.Priority, is.Open = key, f.Summary, name(f.Priority), open
+	is.Created = f.Created.Time
+
+	if f.Status != nil {
+		is.Status = f.Status.Name
+	}
+
+	if e.Kind() == jira.EventIssueCreated {
+		is.New = true
+	}
+
+	if e.Kind() != render.SLAKey {
+		is.Events++
+	}
+
+	for _, s := range f.SLAs() {
+		if s.Breached {
+			breached = append(breached, s.Name)
+		}
+	}
+
+	for _, s := range breached {
+		if !slices.Contains(is.Reported, s) {
+			is.Reported = append(is.Reported, s)
+			is.Breached = append(is.Breached, s)
+		}
+	}
+
+	return d.messages.SetDigestIssue(t.Chat, t.Thread, is)
+}
+
+// resolves - reports whether the event sets the resolution of its issue.
+func resolves(e *jira.Event) bool {
+	if e.Changelog == nil {
+		return false
+	}
+
+	return slices.ContainsFunc(e.Changelog.Items, func(it jira.ChangelogItem) bool {
+		return strings.EqualFold(it.Field, "resolution") && it.ToString != ""
+	})
+}
+
+// runDigest - sends the digest on schedule until ctx is cancelled.
+func (d *Daemon) runDigest(ctx context.Context, dg digest) {
+	for {
+		now := d.clock.Now().In(dg.loc)
+
+		next := dg.schedule.Next(now)
+		if next.IsZero() {
+			d.log.Warn("digest never scheduled", "chat", dg.chat, "schedule", dg.rule.Digest.Schedule)
+
+			return
+		}
+
+		select {
+		case <-d.clock.After(next.Sub(now)):
+		case <-ctx.Done():
+			return
+		}
+
+		if err := d.sendDigest(ctx, dg, next); err != nil {
+			d.log.Error("digest delivery failed", "chat", dg.chat, "thread", dg.thread, "error", err)
+		}
+	}
+}
+
+// digestIssues - returns the digest issues of a destination.
+func (d *Daemon) digestIssues(dg digest) ([]store.DigestIssue, error) {
+	d.digestMu.Lock()
+	defer d.digestMu.Unlock()
+
+	return d.messages.DigestIssues(dg.chat, dg.thread)
+}
+
+// resetDigest - resets what happened to the issues of a sent digest and
+// drops the closed ones. What was collected since the digest was taken
+// is kept for the next one.
+func (d *Daemon) resetDigest(dg digest, sent []store.DigestIssue) error {
+	d.digestMu.Lock()
+	defer d.digestMu.Unlock()
+
+	var errs []error
+
+	for _, s := range sent {
+		is, ok, err := d.messages.DigestIssue(dg.chat, dg.thread, s.Key)
+		if err != nil || !ok {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		is.Events = max(is.Events-s.Events, 0)
+		is.New = is.New && !s.New
+		is.Resolved = is.Resolved && !s.Resolved
+		is.Breached = slices.DeleteFunc(is.Breached, func(b string) bool { return slices.Contains(s.Breached, b) })
+
+		if !is.Open && is.Events == 0 && !is.New && !is.Resolved && len(is.Breached) == 0 {
+			errs = append(errs, d.messages.DeleteDigestIssue(dg.chat, dg.thread, is.Key))
+
+			continue
+		}
+
+		errs = append(errs, d.messages.SetDigestIssue(dg.chat, dg.thread, is))
+	}
+
+	return errors.Join(errs...)
+}
+
+// summary - summarizes the digest issues of a destination.
+func (d *Daemon) summary(dg digest, issues []store.DigestIssue, at time.Time) *render.Digest {
+	top := cmp.Or(dg.rule.Digest.Top, route.DefaultDigestTop)
+	s := &render.Digest{Rule: dg.rule.Name, Time: at}
+
+	var oldest, active []store.DigestIssue
+
+	for _, is := range issues {
+		if is.New {
+			s.Created++
+		}
+
+		if is.Resolved {
+			s.Resolved++
+		}
+
+		if is.Open {
+			s.Open++
+			oldest = append(oldest, is)
+		}
+
+		if is.Events > 0 {
+			active = append(active, is)
+		}
+
+		if len(is.Breached) > 0 && len(s.Breached) < top {
+			s.Breached = append(s.Breached, d.digestIssue(is))
+		}
+	}
+
+	slices.SortStableFunc(oldest, func(a, b store.DigestIssue) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	slices.SortStableFunc(active, func(a, b store.DigestIssue) int {
+		return b.Events - a.Events
+	})
+
+	for _, is := range oldest[:min(top, len(oldest))] {
+		s.Oldest = append(s.Oldest, d.digestIssue(is))
+	}
+
+	for _, is := range active[:min(top, len(active))] {
+		s.Top = append(s.Top, d.digestIssue(is))
+	}
+
+	return s
+}
+
+func (d *Daemon) digestIssue(is store.DigestIssue) render.DigestIssue {
+	i := render.DigestIssue{
+		Key:      is.Key,
+		Summary:  is.Summary,
+		Priority: is.Priority,
+		Status:   is.Status,
+		Created:  is.Created,
+		Events:   is.Events,
+		Breached: is.Breached,
+	}
+
+	if d.conf().Jira.URL != "" {
+		i.URL = jira.BrowseURL(d.conf().Jira.URL, is.Key)
+	}
+
+	return i
+}
+
+// sendDigest - sends the digest of a destination due at the given time,
+// split into several messages if it is too long. Nothing is sent if no
+// issue happened since the last digest and none is open. The issues are
+// reset once the digest is sent or queued; a digest that failed is sent
+// with the next one.
+func (d *Daemon) sendDigest(ctx context.Context, dg digest, at time.Time) error {
+	issues, err := d.digestIssues(dg)
+	if err != nil {
+		return err
+	}
+
+	if len(issues) == 0 {
+		d.log.Debug("empty digest skipped", "chat", dg.chat, "thread", dg.thread)
+
+		return nil
+	}
+
+	if err := d.postDigest(ctx, dg, issues, at); err != nil {
+		return err
+	}
+
+	return d.resetDigest(dg, issues)
+}
+
+// postDigest - renders the digest issues and sends them.
+func (d *Daemon) postDigest(ctx context.Context, dg digest, issues []store.DigestIssue, at time.Time) error {
+	data := render.Data{Event: render.DigestKey, Digest: d.summary(dg, issues, at)}
+
+	tmpl := d.templateSet(route.Target{Rule: dg.rule}).Lookup(render.DigestKey)
+	if tmpl == nil {
+		return nil
+	}
+
+	text, err := tmpl.Execute(data)
+	if err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+
+	chunks := tg.SplitMarkdownV2(strings.TrimSpace(text), tg.MaxMessageLength)
+	fallbacks := digestCard(data.Digest).fallbacks()
+
+	for i, chunk := range chunks {
+		rr := []delivery.Rendering{{Name: "template", Text: chunk, ParseMode: tg.ParseModeMarkdownV2}}
+		if len(chunks) == 1 {
+			rr = append(rr, fallbacks...)
+		}
+
+		p := tg.SendMessageParams{
+			ChatID:              dg.chat,
+			MessageThreadID:     dg.thread,
+			LinkPreviewOptions:  &tg.LinkPreviewOptions{IsDisabled: true},
+			DisableNotification: d.silent(dg.chat, nil),
+		}
+
+		_, err := d.sender.SendRendered(ctx, p, rr)
+		if i == 0 && len(chunks) > 1 && errors.Is(err, tg.ErrCantParseEntities) {
+			// the fallbacks are sent whole instead
+			if _, err := d.sender.SendRendered(ctx, p, fallbacks); !errors.Is(err, delivery.ErrQueued) {
+				return err
+			}
+
+			return nil
+		}
+
+		if errors.Is(err, delivery.ErrQueued) {
+			// the next parts queue up behind it
+			d.log.Warn("digest queued for retry", "chat", dg.chat, "part", i+1, "error", err)
+
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("part %d of %d: %w", i+1, len(chunks), err)
+		}
+	}
+
+	return nil
+}
+
+// digestCard - the fallback of digests.
+func digestCard(s *render.Digest) card {
+	c := card{title: "📊 Digest"}
+	if s.Rule != "" {
+		c.title += ": " + s.Rule
+	}
+
+	c.lines = append(c.lines,
+		s.Time.Format("Mon, 02 Jan 2006 15:04 MST"),
+		"🆕 Created: "+strconv.Itoa(s.Created)+" · ✅ Resolved: "+strconv.Itoa(s.Resolved)+" · 📂 Open: "+strconv.Itoa(s.Open))
+
+	section := func(title string, issues []render.DigestIssue, detail func(render.DigestIssue) string) {
+		if len(issues) == 0 {
+			return
+		}
+
+		c.lines = append(c.lines, "", title)
+
+		for _, is := range issues {
+			c.lines = append(c.lines, render.EmojiForPriority(is.Priority)+" "+is.Key+": "+render.Truncate(is.Summary, 100)+" · "+detail(is))
+		}
+	}
+
+	section("🔥 SLA breaches", s.Breached, func(is render.DigestIssue) string {
+		return strings.Join(is.Breached, ", ")
+	})
+	section("⏳ Oldest open", s.Oldest, func(is render.DigestIssue) string {
+		return joinNonEmpty(" · ", is.Status, is.Created.Format("2006-01-02"))
+	})
+	section("📈 Most active", s.Top, func(is render.DigestIssue) string {
+		return strconv.Itoa(is.Events) + " events"
+	})
+
+	return c
+}
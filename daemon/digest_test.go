@@ -0,0 +1,211 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func digestConfig(top int) *config.Config {
+	cfg := testConfig()
+	cfg.Routing = route.Config{Rules: []route.Rule{{
+		Name:   "support",
+		Match:  route.Match{Projects: []string{"SD"}},
+		To:     []route.Destination{{Chat: -1003}},
+		Digest: &route.Digest{Schedule: "0 9 * * *", Timezone: "Europe/Moscow", Top: top},
+	}}}
+
+	return cfg
+}
+
+// otherIssue - an issue_created event of another issue without SLAs.
+func otherIssue(t *testing.T, key, summary string, created time.Time) *jira.Event {
+	t.Helper()
+
+	e := readEvent(t, "issue_created.json")
+	e.Issue.Key, e.Issue.Fields.Summary = key, summary
+	e.Issue.Fields.Created.Time = created
+	e.Issue.Fields.Custom = nil
+
+	return e
+}
+
+func TestDigest(t *testing.T) {
+	clk := clock.NewFake(slaEpoch) // 15:00 MSK
+	fs := newFakeSender()
+	st := store.NewMemory()
+
+	d, err := New(digestConfig(0), fs, WithWorkers(1), WithClock(clk), WithStore(st))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resolved := otherIssue(t, "SD-43", "Printer jam", slaEpoch)
+	resolved.WebhookEvent = jira.EventIssueUpdated
+	resolved.Issue.Fields.Resolution = &jira.Named{Name: "Done"}
+	resolved.Issue.Fields.Status = &jira.Status{Name: "Resolved"}
+	resolved.Issue.Fields.Status.StatusCategory.Key = "done"
+	resolved.Changelog = &jira.Changelog{ID: "2", Items: []jira.ChangelogItem{{Field: "resolution", ToString: "Done"}}}
+
+	for _, e := range []*jira.Event{
+		readEvent(t, "issue_created.json"),
+		change(t, "1", "status", "Open", "In Progress"),
+		otherIssue(t, "SD-43", "Printer jam", slaEpoch),
+		resolved,
+	} {
+		if err := d.Handle(ctx, e); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+
+	if len(fs.sent) != 0 {
+		t.Fatalf("sent %d messages to a digest chat", len(fs.sent))
+	}
+
+	go d.Run(ctx)
+
+	// at 09:00 MSK
+	clk.BlockUntil(1)
+	clk.Advance(18 * time.Hour)
+
+	got := fs.wait(t, 1)[0]
+
+	if got.params.ChatID != -1003 {
+		t.Errorf("digest sent to %d", got.params.ChatID)
+	}
+
+	for _, want := range []string{
+		"📊 *Digest: support*\nThu, 02 May 2024 09:00 MSK\n🆕 Created: 2 · ✅ Resolved: 1 · 📂 Open: 1",
+		"*🔥 SLA breaches*\n🟠 [SD\\-42](https://jira.example.com/browse/SD-42): VPN is down · Time to first response\n",
+		"*⏳ Oldest open*\n🟠 [SD\\-42](https://jira.example.com/browse/SD-42): VPN is down · Open ·",
+		"*📈 Most active*\n🟠 [SD\\-42](https://jira.example.com/browse/SD-42): VPN is down · 2 events\n🟠 [SD\\-43]",
+	} {
+		if !strings.Contains(got.rr[0].Text, want) {
+			t.Errorf("digest does not contain %q:\n%s", want, got.rr[0].Text)
+		}
+	}
+
+	if plain := got.rr[len(got.rr)-1].Text; !strings.Contains(plain, "Created: 2") {
+		t.Errorf("plain fallback = %s", plain)
+	}
+
+	// the resolved issue is dropped, the open one is listed until resolved
+	clk.BlockUntil(1)
+	clk.Advance(24 * time.Hour)
+
+	got = fs.wait(t, 1)[1]
+
+	for want, ok := range map[string]bool{
+		"Fri, 03 May 2024 09:00 MSK":               true,
+		"🆕 Created: 0 · ✅ Resolved: 0 · 📂 Open: 1": true,
+		"*⏳ Oldest open*\n🟠 [SD\\-42]":             true,
+		"SLA breaches":                             false,
+		"Most active":                              false,
+		"SD\\-43":                                  false,
+	} {
+		if strings.Contains(got.rr[0].Text, want) != ok {
+			t.Errorf("second digest contains %q: %v, want %v:\n%s", want, !ok, ok, got.rr[0].Text)
+		}
+	}
+}
+
+func TestDigestSplit(t *testing.T) {
+	fs := newFakeSender()
+
+	d, err := New(digestConfig(50), fs, WithClock(clock.NewFake(slaEpoch)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	dg := d.digests[0]
+	summary := strings.Repeat("Cannot connect to the VPN gateway ", 5)
+
+	for i := range 50 {
+		e := otherIssue(t, fmt.Sprintf("SD-%d", 100+i), summary, slaEpoch.Add(time.Duration(i)*time.Minute))
+		if err := d.collect(e, route.Target{Destination: route.Destination{Chat: dg.chat}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.sendDigest(context.Background(), dg, slaEpoch); err != nil {
+		t.Fatalf("sendDigest: %v", err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.sent) < 2 {
+		t.Fatalf("sent %d messages, want the digest split", len(fs.sent))
+	}
+
+	var all strings.Builder
+
+	for i, s := range fs.sent {
+		if len(s.rr) != 1 || tg.UTF16Len(s.rr[0].Text) > tg.MaxMessageLength {
+			t.Errorf("part %d: %d renderings of %d characters", i, len(s.rr), tg.UTF16Len(s.rr[0].Text))
+		}
+
+		all.WriteString(s.rr[0].Text)
+	}
+
+	if n := strings.Count(all.String(), "[SD\\-149]"); n != 2 {
+		t.Errorf("the last issue is listed %d times, want 2", n)
+	}
+}
+
+func TestDigestKeptUntilSent(t *testing.T) {
+	fs := newFakeSender()
+	fs.sendErr = &tg.Error{Method: "sendMessage", Code: 400, Description: "Bad Request: chat not found"}
+
+	d, err := New(digestConfig(0), fs, WithClock(clock.NewFake(slaEpoch)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	dg := d.digests[0]
+	target := route.Target{Destination: route.Destination{Chat: dg.chat}}
+
+	closed := otherIssue(t, "SD-43", "Printer jam", slaEpoch)
+	closed.Issue.Fields.Resolution = &jira.Named{Name: "Done"}
+
+	for _, e := range []*jira.Event{otherIssue(t, "SD-42", "VPN is down", slaEpoch), closed} {
+		if err := d.collect(e, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.sendDigest(context.Background(), dg, slaEpoch); err == nil {
+		t.Fatal("sendDigest succeeded with a failing sender")
+	}
+
+	taken, err := d.digestIssues(dg)
+	if err != nil || len(taken) != 2 || !taken[0].New || taken[0].Events != 1 {
+		t.Fatalf("issues after a failed digest = %+v, %v", taken, err)
+	}
+
+	// an event collected while the digest is sent stays for the next one
+	if err := d.collect(change(t, "1", "status", "Open", "In Progress"), target); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.resetDigest(dg, taken); err != nil {
+		t.Fatalf("resetDigest: %v", err)
+	}
+
+	left, err := d.digestIssues(dg)
+	if err != nil || len(left) != 1 || left[0].Key != "SD-42" || left[0].New || left[0].Events != 1 {
+		t.Errorf("issues after the digest = %+v, %v", left, err)
+	}
+}
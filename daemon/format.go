@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"strings"
+
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/parser"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// MaxBodyRunes - Jira text longer than this is cut before conversion,
+// so that the escaped result stays within the Telegram message limit.
+const MaxBodyRunes = 1500
+
+// card - a notification made of a bold title, plain text lines,
+// a Jira markup body and a link.
+type card struct {
+	title string
+	lines []string
+	body  string
+	url   string
+}
+
+// fallbacks - returns the card in HTML and plain text, tried when
+// Telegram rejects the MarkdownV2 rendered from a template.
+func (c card) fallbacks() []delivery.Rendering {
+	body := render.Truncate(c.body, MaxBodyRunes)
+
+	var html, plain strings.Builder
+
+	html.WriteString("<b>" + tg.EscapeTelegramHTML(c.title) + "</b>")
+	plain.WriteString(c.title)
+
+	for _, l := range c.lines {
+		html.WriteString("\n" + tg.EscapeTelegramHTML(l))
+		plain.WriteString("\n" + l)
+	}
+
+	if strings.TrimSpace(body) != "" {
+		html.WriteString("\n\n" + parser.ConvertJiraToTgHTML(body))
+		plain.WriteString("\n\n" + body)
+	}
+
+	if c.url != "" {
+		html.WriteString("\n\n<a href=\"" + tg.EscapeTelegramHTML(c.url) + "\">Open in Jira</a>")
+		plain.WriteString("\n\n" + c.url)
+	}
+
+	return []delivery.Rendering{
+		{Name: "html", Text: html.String(), ParseMode: tg.ParseModeHTML},
+		delivery.PlainRendering(plain.String()),
+	}
+}
+
+// assigneeName - returns the assignee's display name, "Unassigned" for nil.
+func assigneeName(u *jira.User) string {
+	if u == nil {
+		return "Unassigned"
+	}
+
+	return displayName(u)
+}
+
+// displayName - returns the user's display name, "unknown" for nil.
+func displayName(u *jira.User) string {
+	switch {
+	case u == nil:
+		return "unknown"
+	case u.DisplayName != "":
+		return u.DisplayName
+	default:
+		return u.ID()
+	}
+}
+
+func name(n *jira.Named) string {
+	if n == nil {
+		return ""
+	}
+
+	return n.Name
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	var out []string
+
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+
+	return strings.Join(out, sep)
+}
+
+// formatEvent - builds the notification for a webhook event as the given
+// event kind. It returns false for events that aren't announced.
+func formatEvent(e *jira.Event, kind, jiraURL string) (card, bool) {
+	if e.Issue == nil {
+		return card{}, false
+	}
+
+	is := e.Issue
+	f := &is.Fields
+
+	c := card{title: is.Key + ": " + f.Summary}
+	if jiraURL != "" {
+		c.url = jira.BrowseURL(jiraURL, is.Key)
+	}
+
+	status := ""
+	if f.Status != nil {
+		status = f.Status.Name
+	}
+
+	switch kind {
+	case jira.EventIssueCreated:
+		c.title = "🆕 " + c.title
+		c.lines = append(c.lines,
+			joinNonEmpty(" · ", name(f.IssueType), f.RequestType(), name(f.Priority), status),
+			"Reporter: "+displayName(f.Reporter))
+		c.body = f.Description
+	case jira.EventIssueUpdated:
+		c.title = "✏️ " + c.title
+		c.lines = append(c.lines,
+			joinNonEmpty(" · ", name(f.Priority), status),
+			"Assignee: "+assigneeName(f.Assignee))
+
+		if e.User != nil {
+			c.lines = append(c.lines, "Updated by "+displayName(e.User))
+		}
+	case jira.EventCommentCreated, jira.EventCommentUpdated:
+		if e.Comment == nil {
+			return card{}, false
+		}
+
+		c.title = "💬 " + c.title
+
+		verb := "commented"
+		if kind == jira.EventCommentUpdated {
+			verb = "edited a comment"
+		}
+
+		if e.Comment.Internal() {
+			verb += " (internal)"
+		}
+
+		c.lines = append(c.lines, displayName(e.Comment.Author)+" "+verb+":")
+		c.body = e.Comment.Body
+	case jira.EventIssueDeleted:
+		c.title = "🗑 " + c.title
+		c.lines = append(c.lines, "Issue deleted")
+		c.url = ""
+	default:
+		return card{}, false
+	}
+
+	return c, true
+}
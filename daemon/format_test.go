@@ -0,0 +1,30 @@
+package daemon
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/schors/jsm2tg/jira"
+)
+
+func TestFormatEventUnknownUsers(t *testing.T) {
+	is := &jira.Issue{Key: "SD-1", Fields: jira.Fields{Summary: "VPN"}}
+
+	tests := []struct {
+		name string
+		e    *jira.Event
+		kind string
+		want string
+	}{
+		{"reporter", &jira.Event{Issue: is}, jira.EventIssueCreated, "Reporter: unknown"},
+		{"assignee", &jira.Event{Issue: is}, jira.EventIssueUpdated, "Assignee: Unassigned"},
+		{"comment author", &jira.Event{Issue: is, Comment: &jira.Comment{Body: "hi"}}, jira.EventCommentCreated, "unknown commented:"},
+	}
+
+	for _, tt := range tests {
+		c, ok := formatEvent(tt.e, tt.kind, "")
+		if !ok || !slices.Contains(c.lines, tt.want) {
+			t.Errorf("%s: lines = %q, want %q", tt.name, c.lines, tt.want)
+		}
+	}
+}
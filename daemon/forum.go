@@ -0,0 +1,177 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Forum - manages forum topics; *tg.Client implements it.
+type Forum interface {
+	CreateForumTopic(ctx context.Context, p tg.CreateForumTopicParams) (*tg.ForumTopic, error)
+	EditForumTopic(ctx context.Context, p tg.EditForumTopicParams) error
+	CloseForumTopic(ctx context.Context, p tg.ForumTopicParams) error
+	ReopenForumTopic(ctx context.Context, p tg.ForumTopicParams) error
+}
+
+// Limiter - runs Bot API calls for a chat under the rate limits and
+// retries of the messages; *delivery.Sender implements it.
+type Limiter interface {
+	Do(ctx context.Context, chatID int64, fn func(context.Context) error) error
+}
+
+// WithLimiter - runs the forum topic calls under the limiter; without it
+// they are made directly.
+func WithLimiter(l Limiter) Option {
+	return func(d *Daemon) {
+		d.limiter = l
+	}
+}
+
+// limited - runs a Bot API call for the chat under the limiter.
+func (d *Daemon) limited(ctx context.Context, chat int64, fn func(context.Context) error) error {
+	if d.limiter == nil {
+		return fn(ctx)
+	}
+
+	return d.limiter.Do(ctx, chat, fn)
+}
+
+// topicName - returns the forum topic name of an issue.
+func topicName(is *jira.Issue) string {
+	// Truncate appends an ellipsis
+	return render.Truncate(is.Key+": "+is.Fields.Summary, tg.MaxTopicNameLength-1)
+}
+
+// topicIcon - returns the custom emoji id of the topic icon for a priority.
+func (d *Daemon) topicIcon(priority string) string {
+	for p, id := range d.conf().Topics.Icons {
+		if strings.EqualFold(p, priority) {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// topic - returns the issue's forum topic in the chat, creating it
+// if there is none yet. Deleted issues don't get a topic; 0 is
+// returned for them.
+func (d *Daemon) topic(ctx context.Context, e *jira.Event, chat int64) (int, error) {
+	key := issueKey(e)
+
+	thread, ok, err := d.messages.Topic(key, chat)
+	if err != nil || ok {
+		return thread, err
+	}
+
+	if e.Kind() == jira.EventIssueDeleted {
+		return 0, nil
+	}
+
+	var t *tg.ForumTopic
+
+	err = d.limited(ctx, chat, func(ctx context.Context) (err error) {
+		t, err = d.bot.CreateForumTopic(ctx, tg.CreateForumTopicParams{
+			ChatID:            chat,
+			Name:              topicName(e.Issue),
+			IconColor:         d.conf().Topics.Color,
+			IconCustomEmojiID: d.topicIcon(name(e.Issue.Fields.Priority)),
+		})
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	d.log.Debug("forum topic created", "issue", key, "chat", chat, "thread", t.MessageThreadID)
+
+	return t.MessageThreadID, d.messages.SetTopic(key, chat, t.MessageThreadID)
+}
+
+// resolution - reports how the update changes the issue resolution:
+// 1 if it is resolved, -1 if it is reopened, 0 if it isn't touched.
+func resolution(e *jira.Event) int {
+	if e.Changelog == nil {
+		return 0
+	}
+
+	for _, it := range e.Changelog.Items {
+		if !strings.EqualFold(it.Field, "resolution") {
+			continue
+		}
+
+		if it.To == "" && it.ToString == "" {
+			return -1
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+// updateTopic - follows an issue update in its forum topic: renames the
+// topic when the summary changes, changes the icon with the priority
+// and reopens the topic of a reopened issue. Closing is left to
+// closeTopic, after the update is posted.
+func (d *Daemon) updateTopic(ctx context.Context, e *jira.Event, chat int64, thread int) error {
+	if e.Changelog == nil {
+		return nil
+	}
+
+	p := tg.EditForumTopicParams{ChatID: chat, MessageThreadID: thread}
+
+	for _, it := range e.Changelog.Items {
+		switch strings.ToLower(it.Field) {
+		case "summary":
+			p.Name = topicName(e.Issue)
+		case "priority":
+			if len(d.conf().Topics.Icons) > 0 {
+				// a priority without an icon removes the old one
+				icon := d.topicIcon(it.ToString)
+				p.IconCustomEmojiID = &icon
+			}
+		}
+	}
+
+	var errs []error
+
+	if p.Name != "" || p.IconCustomEmojiID != nil {
+		errs = append(errs, ignoreNotModified(d.limited(ctx, chat, func(ctx context.Context) error {
+			return d.bot.EditForumTopic(ctx, p)
+		})))
+	}
+
+	if resolution(e) < 0 {
+		errs = append(errs, ignoreNotModified(d.limited(ctx, chat, func(ctx context.Context) error {
+			return d.bot.ReopenForumTopic(ctx, tg.ForumTopicParams{ChatID: chat, MessageThreadID: thread})
+		})))
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeTopic - closes the forum topic of a resolved issue.
+func (d *Daemon) closeTopic(ctx context.Context, e *jira.Event, chat int64, thread int) error {
+	if resolution(e) <= 0 {
+		return nil
+	}
+
+	return ignoreNotModified(d.limited(ctx, chat, func(ctx context.Context) error {
+		return d.bot.CloseForumTopic(ctx, tg.ForumTopicParams{ChatID: chat, MessageThreadID: thread})
+	}))
+}
+
+func ignoreNotModified(err error) error {
+	if errors.Is(err, tg.ErrTopicNotModified) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/tg"
+)
+
+type fakeBot struct {
+	mu    sync.Mutex
+	calls []string
+	next  int
+}
+
+func (f *fakeBot) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeBot) CreateForumTopic(_ context.Context, p tg.CreateForumTopicParams) (*tg.ForumTopic, error) {
+	f.record("create " + p.Name + " " + p.IconCustomEmojiID)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.next++
+
+	return &tg.ForumTopic{MessageThreadID: 100 + f.next, Name: p.Name}, nil
+}
+
+func (f *fakeBot) EditForumTopic(_ context.Context, p tg.EditForumTopicParams) error {
+	icon := "-"
+	if p.IconCustomEmojiID != nil {
+		icon = *p.IconCustomEmojiID
+	}
+
+	f.record("edit " + p.Name + " " + icon)
+
+	return nil
+}
+
+func (f *fakeBot) CloseForumTopic(_ context.Context, _ tg.ForumTopicParams) error {
+	f.record("close")
+
+	return nil
+}
+
+func (f *fakeBot) AnswerCallbackQuery(_ context.Context, p tg.AnswerCallbackQueryParams) error {
+	f.record("answer " + p.Text)
+
+	return nil
+}
+
+func (f *fakeBot) GetFile(_ context.Context, fileID string) (*tg.File, error) {
+	f.record("getFile " + fileID)
+
+	return &tg.File{FileID: fileID, FileSize: 4, FilePath: "photos/" + fileID + ".jpg"}, nil
+}
+
+func (f *fakeBot) DownloadFile(_ context.Context, path string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("JPEG")), nil
+}
+
+func (f *fakeBot) ReopenForumTopic(_ context.Context, _ tg.ForumTopicParams) error {
+	f.record("reopen")
+
+	return &tg.Error{Code: 400, Description: "Bad Request: TOPIC_NOT_MODIFIED"}
+}
+
+type countingLimiter struct {
+	mu    sync.Mutex
+	calls map[int64]int
+}
+
+func (l *countingLimiter) Do(ctx context.Context, chatID int64, fn func(context.Context) error) error {
+	l.mu.Lock()
+	if l.calls == nil {
+		l.calls = map[int64]int{}
+	}
+
+	l.calls[chatID]++
+	l.mu.Unlock()
+
+	return fn(ctx)
+}
+
+func TestForumTopics(t *testing.T) {
+	cfg := testConfig()
+	cfg.Chats = []config.Chat{{ID: -1001, Topics: true}}
+	cfg.Topics.Icons = map[string]string{"High": "5001", "low": "5002"}
+
+	fs := newFakeSender()
+	fb := &fakeBot{}
+	lim := &countingLimiter{}
+
+	d, err := New(cfg, fs, WithBot(fb), WithLimiter(lim))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx := context.Background()
+	created := readEvent(t, "issue_created.json")
+
+	change := func(field, to string) *jira.Event {
+		e := updated(created, field)
+		e.Changelog.Items[0].To = to
+		e.Changelog.Items[0].ToString = to
+
+		return e
+	}
+
+	resolved := change("resolution", "Done")
+
+	events := []*jira.Event{
+		created,
+		readEvent(t, "comment_created.json"),
+		change("priority", "Low"),
+		change("priority", "Medium"),
+		resolved,
+		change("resolution", ""),
+	}
+
+	for i, e := range events {
+		// the resolution message is left in the outbox, the topic is closed anyway
+		fs.sendErr = nil
+		if e == resolved {
+			fs.sendErr = fmt.Errorf("send: %w", delivery.ErrQueued)
+		}
+
+		if err := d.Handle(ctx, e); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+
+	got := fs.wait(t, len(events)-1)
+	for i, s := range got {
+		if s.params.ChatID != -1001 || s.params.MessageThreadID != 101 {
+			t.Errorf("message %d sent to %d/%d, want -1001/101", i, s.params.ChatID, s.params.MessageThreadID)
+		}
+	}
+
+	want := []string{
+		"create SD-42: VPN is down 5001",
+		"edit  5002",
+		"edit  ",
+		"close",
+		"reopen",
+	}
+
+	if strings.Join(fb.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("forum calls = %q, want %q", fb.calls, want)
+	}
+
+	if issue, ok, _ := d.messages.TopicIssue(-1001, 101); !ok || issue != "SD-42" {
+		t.Errorf("TopicIssue = %q, %v", issue, ok)
+	}
+
+	if lim.calls[-1001] != len(want) {
+		t.Errorf("limited calls = %v, want %d in -1001", lim.calls, len(want))
+	}
+
+	// the priority changes also edit the card in the topic
+	if len(fs.edits) != 2 {
+		t.Errorf("card edits = %d, want 2", len(fs.edits))
+	}
+}
+
+func TestForumTopicsRequireForum(t *testing.T) {
+	cfg := testConfig()
+	cfg.Chats = []config.Chat{{ID: -1001, Topics: true}}
+
+	if _, err := New(cfg, newFakeSender()); err == nil {
+		t.Error("New without a Bot API: expected error")
+	}
+}
@@ -0,0 +1,371 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// cardFields - changes of these fields update the issue card in place
+// instead of posting a new message.
+var cardFields = map[string]bool{
+	"status":   true,
+	"assignee": true,
+	"priority": true,
+	"summary":  true,
+}
+
+// cardChanged - reports whether the update touches the card fields.
+func cardChanged(e *jira.Event) bool {
+	if e.Changelog == nil {
+		return false
+	}
+
+	for _, it := range e.Changelog.Items {
+		if cardFields[strings.ToLower(it.Field)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// targets - returns the routing targets of the event, or the
+// default chats if no rule matches, and the chats linked to the issue.
+func (d *Daemon) targets(e *jira.Event) ([]route.Target, error) {
+	s := d.current()
+
+	targets := s.router.Route(e)
+	if len(targets) == 0 {
+		for _, ch := range s.cfg.Chats {
+			targets = append(targets, route.Target{Destination: route.Destination{Chat: ch.ID, Thread: ch.Thread, Topics: ch.Topics}})
+		}
+	}
+
+	if issueKey(e) == "" {
+		return targets, nil
+	}
+
+	links, err := d.messages.Links(issueKey(e))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range links {
+		if !slices.ContainsFunc(targets, func(t route.Target) bool { return t.Chat == l.Chat }) {
+			targets = append(targets, route.Target{Destination: route.Destination{Chat: l.Chat, Thread: l.Thread, Public: true}})
+		}
+	}
+
+	return targets, nil
+}
+
+// templateSet - returns the templates for a routing target. A target
+// routed before a reload gets the templates of its rule compiled again.
+func (d *Daemon) templateSet(t route.Target) *render.Set {
+	s := d.current()
+
+	if set, ok := s.ruleTemplates[t.Rule]; ok {
+		return set
+	}
+
+	if t.Rule != nil && len(t.Rule.Templates) > 0 {
+		set, err := render.NewSet(t.Rule.Templates, s.templates, d.clock)
+		if err == nil {
+			return set
+		}
+
+		d.log.Warn("rule templates not compiled", "rule", t.Rule.Name, "error", err)
+	}
+
+	return s.templates
+}
+
+// renderings - renders the event as the given kind: the template output
+// first, then the built-in HTML and plain text fallbacks. It returns
+// false if the event kind isn't announced.
+func (d *Daemon) renderings(set *render.Set, kind string, e *jira.Event, data render.Data) ([]delivery.Rendering, bool, error) {
+	c, ok := formatEvent(e, kind, d.conf().Jira.URL)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if kind == jira.EventIssueUpdated && len(data.Changes) > 0 {
+		c.lines = append([]string{render.PlainChanges(data.Changes)}, c.lines...)
+	}
+
+	tmpl := set.Lookup(kind)
+	if tmpl == nil {
+		return nil, false, nil
+	}
+
+	start := time.Now()
+
+	text, err := tmpl.Execute(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("template: %w", err)
+	}
+
+	rr := append([]delivery.Rendering{{
+		Name:      "template",
+		Text:      strings.TrimSpace(text),
+		ParseMode: tg.ParseModeMarkdownV2,
+	}}, c.fallbacks()...)
+
+	d.metrics.conversions.Inc(kind)
+	d.metrics.conversionTime.Observe(time.Since(start).Seconds())
+
+	return rr, true, nil
+}
+
+// Handle - formats an event and delivers it to its routing targets.
+// Issue updates for targets with a coalescing window are held and
+// delivered merged when it ends; targets with a digest collect events,
+// and targets in quiet hours holding messages get them when these end;
+// an urgent event of an issue delivers its held events first.
+func (d *Daemon) Handle(ctx context.Context, e *jira.Event) error {
+	if _, ok := formatEvent(e, e.Kind(), d.conf().Jira.URL); !ok {
+		d.log.Debug("event ignored", "event", e.WebhookEvent, "issue", issueKey(e))
+
+		return nil
+	}
+
+	if d.ignoredUpdate(e) {
+		d.log.Debug("update of ignored fields", "event", e.WebhookEvent, "issue", issueKey(e))
+
+		return nil
+	}
+
+	if e.Comment != nil && e.Comment.ID != "" {
+		own, err := d.messages.OwnComment(issueKey(e), e.Comment.ID)
+		if err != nil {
+			return err
+		}
+
+		// the echo of a Telegram reply
+		if own {
+			d.log.Debug("own comment ignored", "event", e.WebhookEvent, "issue", issueKey(e), "comment", e.Comment.ID)
+
+			return nil
+		}
+	}
+
+	targets, err := d.targets(e)
+	if err != nil {
+		return err
+	}
+
+	if len(targets) == 0 {
+		d.log.Debug("event not routed", "event", e.WebhookEvent, "issue", issueKey(e))
+
+		return nil
+	}
+
+	data := d.eventData(e)
+
+	var errs []error
+
+	for _, t := range targets {
+		if d.digested(t) {
+			if err := d.collect(e, t); err != nil {
+				errs = append(errs, fmt.Errorf("chat %d: digest: %w", t.Chat, err))
+			}
+
+			continue
+		}
+
+		if q, until, ok := d.quiet(t.Chat, e.Issue); ok && q.hold && issueKey(e) != "" {
+			err := d.hold(e, t, until)
+			if err == nil {
+				continue
+			}
+
+			// rather delivered now than lost
+			d.log.Error("event not held", "issue", issueKey(e), "chat", t.Chat, "error", err)
+		}
+
+		// events of the issue held for quiet hours go first
+		if issueKey(e) != "" {
+			d.releaseIssue(ctx, t, issueKey(e))
+		}
+
+		if w := d.window(t); w > 0 && coalescable(e) {
+			err := d.coalesce(e, t, w)
+			if err == nil {
+				continue
+			}
+
+			// rather delivered alone than lost
+			d.log.Error("update not held", "issue", issueKey(e), "chat", t.Chat, "error", err)
+		}
+
+		// the held updates go first
+		if err := d.flushUpdates(ctx, pendingKey{issue: issueKey(e), chat: t.Chat, thread: t.Thread}); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", t.Chat, err))
+		}
+
+		if err := d.deliver(ctx, e, t, data); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", t.Chat, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliver - delivers the event to one target: new issues post a card,
+// changes of the card fields edit it, everything else replies to it.
+// For targets with topics enabled every issue gets its own forum topic
+// and the card field changes are posted to it too.
+func (d *Daemon) deliver(ctx context.Context, e *jira.Event, t route.Target, data render.Data) error {
+	set := d.templateSet(t)
+	key := issueKey(e)
+	data.OnCall = d.onCall(t)
+
+	if t.Public && e.Comment != nil && e.Comment.Internal() {
+		return nil
+	}
+
+	if t.Topics && key != "" {
+		thread, err := d.topic(ctx, e, t.Chat)
+		if err != nil {
+			return fmt.Errorf("forum topic: %w", err)
+		}
+
+		t.Thread = thread
+
+		if thread != 0 && e.Kind() == jira.EventIssueUpdated {
+			if err := d.updateTopic(ctx, e, t.Chat, thread); err != nil {
+				d.log.Warn("forum topic update failed", "issue", key, "chat", t.Chat, "error", err)
+			}
+		}
+	}
+
+	card, hasCard, err := d.messages.Card(key, t.Chat)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case e.Kind() == jira.EventIssueCreated && hasCard:
+		// already posted, e.g. for a request created from Telegram
+		return nil
+	case e.Kind() == jira.EventIssueCreated:
+		return d.forgetTopic(key, t, d.postCard(ctx, e, t, set, data))
+	case e.Kind() == jira.EventIssueUpdated && hasCard && cardChanged(e):
+		if err := d.editCard(ctx, e, t, set, data, card); err != nil || !t.Topics {
+			return d.forgetTopic(key, t, err)
+		}
+	}
+
+	rr, ok, err := d.renderings(set, e.Kind(), e, data)
+	if err != nil || !ok {
+		return err
+	}
+
+	p := tg.SendMessageParams{
+		ChatID:              t.Chat,
+		MessageThreadID:     t.Thread,
+		LinkPreviewOptions:  &tg.LinkPreviewOptions{IsDisabled: true},
+		DisableNotification: d.silent(t.Chat, e.Issue),
+	}
+
+	if hasCard {
+		p.ReplyParameters = &tg.ReplyParameters{MessageID: card.MessageID, AllowSendingWithoutReply: true}
+	}
+
+	m, err := d.sender.SendRendered(withTag(ctx, tagMessage, key), p, rr)
+
+	switch {
+	case errors.Is(err, delivery.ErrQueued):
+		// the outbox records it once delivered; the topic is closed anyway
+		d.log.Warn("message queued for retry", "event", e.WebhookEvent, "issue", key, "chat", t.Chat, "error", err)
+	case err != nil:
+		return d.forgetTopic(key, t, err)
+	case key != "":
+		if err := d.messages.AddMessage(key, store.MessageRef{Chat: t.Chat, Thread: t.Thread, MessageID: m.MessageID}); err != nil {
+			return err
+		}
+	}
+
+	if key != "" && t.Topics && t.Thread != 0 {
+		return d.closeTopic(ctx, e, t.Chat, t.Thread)
+	}
+
+	return nil
+}
+
+// editCard - re-renders the issue card; a card deleted in Telegram
+// is posted again.
+func (d *Daemon) editCard(ctx context.Context, e *jira.Event, t route.Target, set *render.Set, data render.Data, card store.MessageRef) error {
+	rr, _, err := d.renderings(set, jira.EventIssueCreated, e, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.sender.EditRendered(ctx, tg.EditMessageTextParams{
+		ChatID:             card.Chat,
+		MessageID:          card.MessageID,
+		LinkPreviewOptions: &tg.LinkPreviewOptions{IsDisabled: true},
+		ReplyMarkup:        d.keyboard(ctx, e.Issue),
+	}, rr)
+
+	switch {
+	case errors.Is(err, tg.ErrMessageNotModified), errors.Is(err, delivery.ErrQueued):
+		return nil
+	case errors.Is(err, tg.ErrMessageNotFound):
+		if err := d.messages.DeleteCard(issueKey(e), t.Chat); err != nil {
+			return err
+		}
+
+		return d.postCard(ctx, e, t, set, data)
+	}
+
+	return err
+}
+
+// forgetTopic - drops the mapping of a forum topic deleted in Telegram,
+// so that the next event of the issue creates a new one. It returns err.
+func (d *Daemon) forgetTopic(issue string, t route.Target, err error) error {
+	if !t.Topics || !errors.Is(err, tg.ErrTopicNotFound) {
+		return err
+	}
+
+	return errors.Join(err, d.messages.DeleteTopic(issue, t.Chat), d.messages.DeleteCard(issue, t.Chat))
+}
+
+// postCard - posts the issue card and remembers it.
+func (d *Daemon) postCard(ctx context.Context, e *jira.Event, t route.Target, set *render.Set, data render.Data) error {
+	rr, ok, err := d.renderings(set, jira.EventIssueCreated, e, data)
+	if err != nil || !ok {
+		return err
+	}
+
+	m, err := d.sender.SendRendered(withTag(ctx, tagCard, issueKey(e)), tg.SendMessageParams{
+		ChatID:              t.Chat,
+		MessageThreadID:     t.Thread,
+		LinkPreviewOptions:  &tg.LinkPreviewOptions{IsDisabled: true},
+		DisableNotification: d.silent(t.Chat, e.Issue),
+		ReplyMarkup:         d.keyboard(ctx, e.Issue),
+	}, rr)
+	if errors.Is(err, delivery.ErrQueued) {
+		d.log.Warn("issue card queued for retry", "issue", issueKey(e), "chat", t.Chat, "error", err)
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return d.messages.SetCard(issueKey(e), store.MessageRef{Chat: t.Chat, Thread: t.Thread, MessageID: m.MessageID})
+}
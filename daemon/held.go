@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+)
+
+// persistHeld - saves an event held for the target until the given time,
+// so that it is held again after a restart; d.mu must be held.
+func (d *Daemon) persistHeld(kind string, e *jira.Event, t route.Target, until time.Time) (string, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	return d.messages.HoldEvent(store.HeldEvent{Kind: kind, Chat: t.Chat, Thread: t.Thread, Until: until, Event: b})
+}
+
+// forgetHeld - drops delivered held events from the store.
+func (d *Daemon) forgetHeld(ids []string) {
+	for _, id := range ids {
+		if err := d.messages.DeleteHeldEvent(id); err != nil {
+			d.log.Error("held event not dropped", "id", id, "error", err)
+		}
+	}
+}
+
+// restoreHeld - holds the events saved before a restart again; those
+// whose time has come are delivered right away.
+func (d *Daemon) restoreHeld() {
+	held, err := d.messages.HeldEvents()
+	if err != nil {
+		d.log.Error("held events not restored", "error", err)
+
+		return
+	}
+
+	for _, h := range held {
+		e, err := jira.ParseEvent(h.Event)
+		if err != nil || e.Issue == nil {
+			d.log.Error("held event dropped", "id", h.ID, "error", err)
+			d.forgetHeld([]string{h.ID})
+
+			continue
+		}
+
+		t := d.heldTarget(e, h)
+
+		d.mu.Lock()
+
+		switch h.Kind {
+		case store.HeldCoalesce:
+			d.pendUpdate(pendingKey{issue: e.Issue.Key, chat: t.Chat, thread: t.Thread}, t, e, h.ID, h.Until)
+		case store.HeldQuiet:
+			d.holdEvent(heldKey{chat: t.Chat, thread: t.Thread}, t, e, h.ID, h.Until)
+		default:
+			d.log.Warn("held event of unknown kind", "id", h.ID, "kind", h.Kind)
+		}
+
+		d.mu.Unlock()
+	}
+}
+
+// heldTarget - routes a restored event again to find the target it was
+// held for; the rule may be gone since, then the bare chat thread is used.
+func (d *Daemon) heldTarget(e *jira.Event, h store.HeldEvent) route.Target {
+	targets, err := d.targets(e)
+	if err != nil {
+		d.log.Warn("held event not routed", "id", h.ID, "issue", issueKey(e), "error", err)
+	}
+
+	for _, t := range targets {
+		if t.Chat == h.Chat && t.Thread == h.Thread {
+			return t
+		}
+	}
+
+	return route.Target{Destination: route.Destination{Chat: h.Chat, Thread: h.Thread}}
+}
+
+// after - calls fn at the given time, unless Run stops first: what is
+// still held is then flushed by Run or kept in the store for the next
+// start.
+func (d *Daemon) after(at time.Time, fn func()) {
+	d.wg.Add(1)
+
+	go func() {
+		defer d.wg.Done()
+
+		select {
+		case <-d.clock.After(at.Sub(d.clock.Now())):
+			fn()
+		case <-d.stop:
+		}
+	}()
+}
+
+// enqueue - queues a job on the worker of the issue; false if Run stops
+// first.
+func (d *Daemon) enqueue(issue string, job func(context.Context)) bool {
+	select {
+	case d.queue(issue) <- job:
+		return true
+	case <-d.stop:
+		return false
+	}
+}
@@ -0,0 +1,150 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/schors/jsm2tg/metrics"
+	"github.com/schors/jsm2tg/store"
+)
+
+// Reasons of rejected webhooks in jsm2tg_webhooks_rejected_total.
+const (
+	rejectReadBody   = "read_body"
+	rejectForbidden  = "forbidden"
+	rejectBadPayload = "bad_payload"
+	rejectQueueFull  = "queue_full"
+)
+
+// healthBucket - the bucket read to check the store; it's never written.
+const healthBucket = "health"
+
+// instruments - the metrics of a daemon; all nil without WithMetrics.
+type instruments struct {
+	registry *metrics.Registry
+
+	webhooksReceived *metrics.Counter
+	webhooksRejected *metrics.Counter
+	conversions      *metrics.Counter
+	conversionTime   *metrics.Histogram
+}
+
+// WithMetrics - collects the daemon metrics in the registry and serves
+// them on GET /metrics.
+func WithMetrics(r *metrics.Registry) Option {
+	return func(d *Daemon) {
+		d.metrics.registry = r
+	}
+}
+
+// readyCheck - a named readiness check.
+type readyCheck struct {
+	name string
+	fn   func() error
+}
+
+// WithReadyCheck - adds a check to GET /readyz, e.g. that the outbox
+// is running.
+func WithReadyCheck(name string, fn func() error) Option {
+	return func(d *Daemon) {
+		d.readyChecks = append(d.readyChecks, readyCheck{name: name, fn: fn})
+	}
+}
+
+// initMetrics - registers the daemon metrics.
+func (d *Daemon) initMetrics() {
+	r := d.metrics.registry
+
+	d.metrics.webhooksReceived = r.Counter("jsm2tg_webhooks_received_total", "Jira webhooks received with a valid payload, by event.", "event")
+	d.metrics.webhooksRejected = r.Counter("jsm2tg_webhooks_rejected_total", "Jira webhooks rejected, by event (unknown if not parsed) and reason.", "event", "reason")
+	d.metrics.conversions = r.Counter("jsm2tg_conversions_total", "Events rendered to Telegram messages, by event.", "event")
+	d.metrics.conversionTime = r.Histogram("jsm2tg_conversion_duration_seconds", "Time to render an event to a Telegram message.", metrics.ExponentialBuckets(0.0001, 4, 8))
+
+	r.GaugeFunc("jsm2tg_event_queue_depth", "Jobs waiting for an event worker.", func() float64 {
+		return float64(d.queueDepth())
+	})
+}
+
+// queueDepth - returns the number of jobs waiting for a worker.
+func (d *Daemon) queueDepth() int {
+	n := 0
+	for _, q := range d.queues {
+		n += len(q)
+	}
+
+	return n
+}
+
+// checkStore - returns an error unless the store can be read.
+func (d *Daemon) checkStore() error {
+	if _, err := d.store.Get(healthBucket, "ping"); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// checkWorkers - returns an error unless the workers run.
+func (d *Daemon) checkWorkers() error {
+	if !d.running.Load() {
+		return errors.New("workers not running")
+	}
+
+	return nil
+}
+
+// checkQueues - returns an error if a worker queue is full, so new
+// webhooks would be turned away.
+func (d *Daemon) checkQueues() error {
+	for i, q := range d.queues {
+		if len(q) == cap(q) {
+			return fmt.Errorf("worker queue %d is full", i)
+		}
+	}
+
+	return nil
+}
+
+// serveHealthz - liveness: the store can be read and the workers run.
+func (d *Daemon) serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeChecks(w, []readyCheck{
+		{"store", d.checkStore},
+		{"workers", d.checkWorkers},
+	})
+}
+
+// serveReadyz - readiness: besides liveness, webhooks can be queued and
+// the checks added with WithReadyCheck pass.
+func (d *Daemon) serveReadyz(w http.ResponseWriter, _ *http.Request) {
+	writeChecks(w, append([]readyCheck{
+		{"store", d.checkStore},
+		{"workers", d.checkWorkers},
+		{"queue", d.checkQueues},
+	}, d.readyChecks...))
+}
+
+// writeChecks - runs the checks and writes "name: ok" or the error of
+// each, with 503 Service Unavailable if any failed.
+func writeChecks(w http.ResponseWriter, checks []readyCheck) {
+	var b strings.Builder
+
+	status := http.StatusOK
+
+	for _, c := range checks {
+		if err := c.fn(); err != nil {
+			status = http.StatusServiceUnavailable
+
+			fmt.Fprintf(&b, "%s: %v\n", c.name, err)
+
+			continue
+		}
+
+		fmt.Fprintf(&b, "%s: ok\n", c.name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(b.String()))
+}
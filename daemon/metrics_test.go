@@ -0,0 +1,125 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/metrics"
+	"github.com/schors/jsm2tg/store"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(b)
+}
+
+func TestMetrics(t *testing.T) {
+	fs := newFakeSender()
+
+	d, err := New(testConfig(), fs, WithWorkers(1), WithMetrics(metrics.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	postFixture(t, srv.URL+"/webhook", "issue_created.json")
+	fs.wait(t, 2)
+
+	resp, err := http.Post(srv.URL+"/webhook", "application/json", strings.NewReader("{not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	status, scrape := get(t, srv.URL+"/metrics")
+	if status != http.StatusOK {
+		t.Fatalf("/metrics status = %d", status)
+	}
+
+	for _, want := range []string{
+		`jsm2tg_webhooks_received_total{event="jira:issue_created"} 1`,
+		`jsm2tg_webhooks_rejected_total{event="unknown",reason="bad_payload"} 1`,
+		`jsm2tg_conversions_total{event="jira:issue_created"} 2`,
+		`jsm2tg_conversion_duration_seconds_count 2`,
+		`jsm2tg_event_queue_depth 0`,
+	} {
+		if !strings.Contains(scrape, want+"\n") {
+			t.Errorf("/metrics doesn't contain %q:\n%s", want, scrape)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	st, err := store.OpenBolt(filepath.Join(t.TempDir(), "jsm2tg.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := errors.New("outbox not started")
+
+	d, err := New(testConfig(), newFakeSender(), WithWorkers(1), WithStore(st), WithReadyCheck("outbox", func() error { return outbox }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	check := func(name, path string, wantStatus int, want string) {
+		t.Helper()
+
+		status, body := get(t, srv.URL+path)
+		if status != wantStatus || !strings.Contains(body, want) {
+			t.Errorf("%s: %s = %d %q, want %d with %q", name, path, status, body, wantStatus, want)
+		}
+	}
+
+	check("not running", "/healthz", http.StatusServiceUnavailable, "workers: workers not running\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	for deadline := time.Now().Add(5 * time.Second); !d.running.Load() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	check("running", "/healthz", http.StatusOK, "store: ok\nworkers: ok\n")
+	check("outbox not started", "/readyz", http.StatusServiceUnavailable, "queue: ok\noutbox: outbox not started\n")
+
+	outbox = nil
+
+	check("ready", "/readyz", http.StatusOK, "store: ok\nworkers: ok\nqueue: ok\noutbox: ok\n")
+
+	st.Close()
+
+	check("store closed", "/healthz", http.StatusServiceUnavailable, "store: database not open\n")
+	check("store closed", "/readyz", http.StatusServiceUnavailable, "store: database not open\n")
+}
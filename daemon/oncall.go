@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// onCall - mentions who is on call for the target now, if anyone;
+// customer chats don't get it.
+func (d *Daemon) onCall(t route.Target) render.Markdown {
+	schedule := d.current().oncall
+	if schedule == nil || t.Public {
+		return ""
+	}
+
+	rule := ""
+	if t.Rule != nil {
+		rule = t.Rule.Name
+	}
+
+	m, ok := schedule.OnCall(rule, d.clock.Now())
+	if !ok {
+		return ""
+	}
+
+	if m.Telegram == 0 {
+		return render.Markdown(tg.EscapeTelegram(m.Name))
+	}
+
+	return userLink(m.Name, m.Telegram)
+}
@@ -0,0 +1,300 @@
+package daemon
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+)
+
+// quietHours - a parsed config.QuietHours.
+type quietHours struct {
+	chats    []int64
+	from, to int // minutes since midnight
+	loc      *time.Location
+	hold     bool
+	urgent   []string
+}
+
+// newQuietHours - parses the quiet hours; the configuration must be
+// validated.
+func newQuietHours(qq []config.QuietHours) ([]quietHours, error) {
+	out := make([]quietHours, 0, len(qq))
+
+	for _, q := range qq {
+		from, to, loc, err := q.Window()
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, quietHours{chats: q.Chats, from: from, to: to, loc: loc, hold: q.Mode == config.QuietHold, urgent: q.Urgent})
+	}
+
+	return out, nil
+}
+
+// end - returns the end of the quiet hours at t; false if t is outside.
+func (q *quietHours) end(t time.Time) (time.Time, bool) {
+	t = t.In(q.loc)
+	now := t.Hour()*60 + t.Minute()
+
+	at := func(days, minutes int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+days, 0, minutes, 0, 0, q.loc)
+	}
+
+	switch {
+	case q.from <= q.to && now >= q.from && now < q.to:
+		return at(0, q.to), true
+	case q.from > q.to && now >= q.from:
+		// over midnight
+		return at(1, q.to), true
+	case q.from > q.to && now < q.to:
+		return at(0, q.to), true
+	}
+
+	return time.Time{}, false
+}
+
+// quiet - returns the quiet hours of the chat the issue is announced in
+// now and when they end; false if messages should notify. Issues of an
+// urgent priority always notify.
+func (d *Daemon) quiet(chat int64, is *jira.Issue) (*quietHours, time.Time, bool) {
+	priority := ""
+	if is != nil {
+		priority = name(is.Fields.Priority)
+	}
+
+	now := d.clock.Now()
+
+	quietHours := d.current().quietHours
+
+	for i := range quietHours {
+		q := &quietHours[i]
+
+		if len(q.chats) > 0 && !slices.Contains(q.chats, chat) {
+			continue
+		}
+
+		if priority != "" && slices.ContainsFunc(q.urgent, func(p string) bool { return strings.EqualFold(p, priority) }) {
+			continue
+		}
+
+		if end, ok := q.end(now); ok {
+			return q, end, true
+		}
+	}
+
+	return nil, time.Time{}, false
+}
+
+// silent - reports whether messages about the issue are sent to the
+// chat without notification now.
+func (d *Daemon) silent(chat int64, is *jira.Issue) bool {
+	_, _, ok := d.quiet(chat, is)
+
+	return ok
+}
+
+// heldKey - identifies the events held for a target.
+type heldKey struct {
+	chat   int64
+	thread int
+}
+
+// heldEvents - issue events held for a target until its quiet hours
+// end, and their ids in the store.
+type heldEvents struct {
+	target route.Target
+	events []*jira.Event
+	ids    []string
+}
+
+// hold - holds an event for the target until its quiet hours end, then
+// delivers the held events on the workers of their issues. The event is
+// saved in the store first; events still held when the daemon stops are
+// held again at the next start.
+func (d *Daemon) hold(e *jira.Event, t route.Target, until time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id, err := d.persistHeld(store.HeldQuiet, e, t, until)
+	if err != nil {
+		return err
+	}
+
+	d.holdEvent(heldKey{chat: t.Chat, thread: t.Thread}, t, e, id, until)
+
+	return nil
+}
+
+// holdEvent - adds a saved event to the events held for the target,
+// starting the wait if it is the first; d.mu must be held.
+func (d *Daemon) holdEvent(k heldKey, t route.Target, e *jira.Event, id string, until time.Time) {
+	if h, ok := d.held[k]; ok {
+		h.events = append(h.events, e)
+		h.ids = append(h.ids, id)
+
+		return
+	}
+
+	d.held[k] = &heldEvents{target: t, events: []*jira.Event{e}, ids: []string{id}}
+
+	d.after(until, func() {
+		h := d.takeHeld(k)
+		if h == nil {
+			return
+		}
+
+		ids := h.issueIDs()
+
+		for _, events := range batchHeld(h.events) {
+			issue := issueKey(events[0])
+
+			if !d.enqueue(issue, func(ctx context.Context) { d.deliverHeld(ctx, h.target, events, ids[issue]) }) {
+				// still in the store for the next start
+				return
+			}
+		}
+	})
+}
+
+// issueIDs - returns the store ids of the held events by issue.
+func (h *heldEvents) issueIDs() map[string][]string {
+	ids := make(map[string][]string)
+	for i, e := range h.events {
+		ids[issueKey(e)] = append(ids[issueKey(e)], h.ids[i])
+	}
+
+	return ids
+}
+
+func (d *Daemon) takeHeld(k heldKey) *heldEvents {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h := d.held[k]
+	delete(d.held, k)
+
+	return h
+}
+
+// releaseIssue - delivers the events of the issue held for the target
+// before a later event of it that isn't held, e.g. of an urgent priority.
+func (d *Daemon) releaseIssue(ctx context.Context, t route.Target, issue string) {
+	k := heldKey{chat: t.Chat, thread: t.Thread}
+
+	d.mu.Lock()
+
+	h, ok := d.held[k]
+	if !ok {
+		d.mu.Unlock()
+
+		return
+	}
+
+	var (
+		events []*jira.Event
+		ids    []string
+	)
+
+	for i := 0; i < len(h.events); {
+		if issueKey(h.events[i]) != issue {
+			i++
+
+			continue
+		}
+
+		events = append(events, h.events[i])
+		ids = append(ids, h.ids[i])
+		h.events = slices.Delete(h.events, i, i+1)
+		h.ids = slices.Delete(h.ids, i, i+1)
+	}
+
+	if len(h.events) == 0 {
+		delete(d.held, k)
+	}
+
+	d.mu.Unlock()
+
+	for _, batch := range batchHeld(events) {
+		d.deliverHeld(ctx, h.target, batch, nil)
+	}
+
+	d.forgetHeld(ids)
+}
+
+// batchHeld - groups held events by issue, in the order the issues were
+// first held, merging the consecutive updates of every issue into one.
+func batchHeld(events []*jira.Event) [][]*jira.Event {
+	var (
+		order   []string
+		byIssue = make(map[string][]*jira.Event)
+	)
+
+	for _, e := range events {
+		k := issueKey(e)
+		if _, ok := byIssue[k]; !ok {
+			order = append(order, k)
+		}
+
+		byIssue[k] = append(byIssue[k], e)
+	}
+
+	batches := make([][]*jira.Event, 0, len(order))
+
+	for _, k := range order {
+		var batch, updates []*jira.Event
+
+		merge := func() {
+			if len(updates) == 0 {
+				return
+			}
+
+			if e := mergeUpdates(updates); e != nil {
+				batch = append(batch, e)
+			}
+
+			updates = nil
+		}
+
+		for _, e := range byIssue[k] {
+			if coalescable(e) {
+				updates = append(updates, e)
+
+				continue
+			}
+
+			merge()
+			batch = append(batch, e)
+		}
+
+		merge()
+
+		if len(batch) > 0 {
+			batches = append(batches, batch)
+		}
+	}
+
+	return batches
+}
+
+// deliverHeld - delivers the held events of an issue to the target and
+// drops them from the store.
+func (d *Daemon) deliverHeld(ctx context.Context, t route.Target, events []*jira.Event, ids []string) {
+	defer d.forgetHeld(ids)
+
+	for _, e := range events {
+		if d.ignoredUpdate(e) {
+			continue
+		}
+
+		if err := d.deliver(ctx, e, t, d.eventData(e)); err != nil {
+			d.log.Error("event delivery failed", "event", e.WebhookEvent, "issue", issueKey(e), "chat", t.Chat, "error", err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/oncall"
+	"github.com/schors/jsm2tg/store"
+)
+
+// night - within the quiet hours of quietConfig.
+var night = time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+
+func quietConfig(mode string) *config.Config {
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.QuietHours = []config.QuietHours{{From: "22:00", To: "08:00", Mode: mode, Urgent: []string{"Highest"}}}
+
+	return cfg
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+
+	tests := []struct {
+		from, to int
+		at       time.Time
+		want     time.Time
+	}{
+		{22 * 60, 8 * 60, time.Date(2024, 5, 1, 23, 0, 0, 0, msk), time.Date(2024, 5, 2, 8, 0, 0, 0, msk)},
+		{22 * 60, 8 * 60, time.Date(2024, 5, 1, 7, 59, 0, 0, msk), time.Date(2024, 5, 1, 8, 0, 0, 0, msk)},
+		{22 * 60, 8 * 60, time.Date(2024, 5, 1, 8, 0, 0, 0, msk), time.Time{}},
+		{22 * 60, 8 * 60, time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 8, 0, 0, 0, msk)},
+		{13 * 60, 14 * 60, time.Date(2024, 5, 1, 13, 30, 0, 0, msk), time.Date(2024, 5, 1, 14, 0, 0, 0, msk)},
+		{13 * 60, 14 * 60, time.Date(2024, 5, 1, 12, 59, 0, 0, msk), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		q := quietHours{from: tt.from, to: tt.to, loc: msk}
+
+		got, ok := q.end(tt.at)
+		if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+			t.Errorf("%d-%d at %v: end() = %v, %v; want %v", tt.from, tt.to, tt.at, got, ok, tt.want)
+		}
+	}
+}
+
+func TestQuietHoursSilent(t *testing.T) {
+	clk := clock.NewFake(night)
+	fs := newFakeSender()
+
+	d, err := New(quietConfig(config.QuietSilent), fs, WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	urgent := readEvent(t, "comment_created.json")
+	urgent.Issue.Fields.Priority = &jira.Named{Name: "Highest"}
+
+	for _, e := range []*jira.Event{readEvent(t, "issue_created.json"), urgent} {
+		if err := d.Handle(context.Background(), e); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+
+	clk.Advance(9 * time.Hour)
+
+	if err := d.Handle(context.Background(), readEvent(t, "comment_created.json")); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	got := fs.wait(t, 3)
+
+	for i, want := range []bool{true, false, false} {
+		if got[i].params.DisableNotification != want {
+			t.Errorf("message %d: disable_notification = %v, want %v", i, got[i].params.DisableNotification, want)
+		}
+	}
+}
+
+func TestQuietHoursHold(t *testing.T) {
+	clk := clock.NewFake(night)
+	fs := newFakeSender()
+
+	d, err := New(quietConfig(config.QuietHold), fs, WithWorkers(1), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	for _, e := range []*jira.Event{
+		readEvent(t, "issue_created.json"),
+		change(t, "1", "labels", "", "vpn"),
+		change(t, "2", "labels", "vpn", "vpn network"),
+		readEvent(t, "comment_created.json"),
+	} {
+		d.queue("SD-42") <- func(ctx context.Context) { d.process(ctx, e) }
+	}
+
+	flush(d)
+	clk.BlockUntil(1)
+
+	fs.mu.Lock()
+	n := len(fs.sent)
+	fs.mu.Unlock()
+
+	if n != 0 {
+		t.Fatalf("sent %d messages in quiet hours", n)
+	}
+
+	// at 08:00 the card, the merged update and the comment
+	clk.Advance(9 * time.Hour)
+
+	got := fs.wait(t, 3)
+
+	for i, want := range []string{"🆕", "Labels: \\+vpn \\+network\n", "💬"} {
+		if !strings.Contains(got[i].rr[0].Text, want) || got[i].params.DisableNotification {
+			t.Errorf("message %d = %q (silent %v), want %q", i, got[i].rr[0].Text, got[i].params.DisableNotification, want)
+		}
+	}
+
+	if got[1].params.ReplyParameters == nil || got[1].params.ReplyParameters.MessageID != 1 {
+		t.Errorf("update reply parameters = %+v, want a reply to the card", got[1].params.ReplyParameters)
+	}
+}
+
+func TestQuietHoursHeldFirst(t *testing.T) {
+	fs := newFakeSender()
+
+	d, err := New(quietConfig(config.QuietHold), fs, WithClock(clock.NewFake(night)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	urgent := readEvent(t, "comment_created.json")
+	urgent.Issue.Fields.Priority = &jira.Named{Name: "Highest"}
+
+	for _, e := range []*jira.Event{readEvent(t, "issue_created.json"), urgent} {
+		if err := d.Handle(context.Background(), e); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+
+	// the held card goes before the urgent comment replying to it
+	got := fs.wait(t, 2)
+
+	for i, want := range []string{"🆕", "💬"} {
+		if !strings.HasPrefix(got[i].rr[0].Text, want) {
+			t.Errorf("message %d = %q, want %q", i, got[i].rr[0].Text, want)
+		}
+	}
+
+	if held, err := d.messages.HeldEvents(); err != nil || len(held) != 0 {
+		t.Errorf("HeldEvents() after the delivery = %+v, %v", held, err)
+	}
+}
+
+func TestQuietHoursRestart(t *testing.T) {
+	clk := clock.NewFake(night)
+	st := store.NewMemory()
+
+	d, err := New(quietConfig(config.QuietHold), newFakeSender(), WithStore(st), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err := d.Handle(context.Background(), readEvent(t, "issue_created.json")); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	// restarted in the night: the event is held again
+	fs := newFakeSender()
+
+	d, err = New(quietConfig(config.QuietHold), fs, WithStore(st), WithWorkers(1), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	// the waits of both daemons
+	clk.BlockUntil(2)
+	clk.Advance(9 * time.Hour)
+
+	if got := fs.wait(t, 1); !strings.HasPrefix(got[0].rr[0].Text, "🆕") || got[0].params.DisableNotification {
+		t.Errorf("message = %q (silent %v), want the card", got[0].rr[0].Text, got[0].params.DisableNotification)
+	}
+}
+
+func TestOnCall(t *testing.T) {
+	s, err := oncall.Parse([]byte(`{rotations: [{start: "2024-05-01 00:00", shift: 24h, members: [{name: Alice, telegram: 501}, {name: Bob}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := newFakeSender()
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+
+	d, err := New(cfg, fs, WithClock(clock.NewFake(slaEpoch)), WithOnCall(s))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err := d.Handle(context.Background(), readEvent(t, "issue_created.json")); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	if got := fs.wait(t, 1)[0].rr[0].Text; !strings.Contains(got, "\nOn call: [Alice](tg://user?id=501)\n") {
+		t.Errorf("card does not mention who is on call:\n%s", got)
+	}
+}
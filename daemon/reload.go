@@ -0,0 +1,153 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/oncall"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+)
+
+// settings - the configuration Reload swaps. It's never modified, so
+// a job keeps working with the routes, templates and users it started
+// with while a reload takes place.
+type settings struct {
+	cfg    *config.Config
+	auth   *Auth
+	router *route.Router
+	oncall *oncall.Schedule
+
+	// SLA alert thresholds, ascending
+	thresholds []time.Duration
+
+	// lowercased names and ids of the fields whose changes aren't listed
+	ignore map[string]bool
+
+	quietHours []quietHours
+
+	templates     *render.Set
+	ruleTemplates map[*route.Rule]*render.Set
+}
+
+// newSettings - compiles the reloadable part of the configuration.
+func (d *Daemon) newSettings(cfg *config.Config, schedule *oncall.Schedule) (*settings, error) {
+	s := &settings{
+		cfg:           cfg,
+		auth:          NewAuth(cfg.Auth),
+		router:        route.New(cfg.Routing),
+		oncall:        schedule,
+		ignore:        make(map[string]bool, len(cfg.Changelog.Ignore)),
+		ruleTemplates: make(map[*route.Rule]*render.Set),
+	}
+
+	for _, f := range cfg.Changelog.Ignore {
+		s.ignore[strings.ToLower(f)] = true
+	}
+
+	quiet, err := newQuietHours(cfg.QuietHours)
+	if err != nil {
+		return nil, fmt.Errorf("quiet_hours: %w", err)
+	}
+
+	s.quietHours = quiet
+
+	s.thresholds = slices.Clone(cfg.SLA.Thresholds)
+	slices.Sort(s.thresholds)
+
+	s.templates, err = render.NewSet(cfg.Templates, render.Defaults(d.clock), d.clock)
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+
+	rules := s.router.Rules()
+
+	for i := range rules {
+		if len(rules[i].Templates) == 0 {
+			continue
+		}
+
+		set, err := render.NewSet(rules[i].Templates, s.templates, d.clock)
+		if err != nil {
+			return nil, fmt.Errorf("routing.rules[%d].templates: %w", i, err)
+		}
+
+		s.ruleTemplates[&rules[i]] = set
+	}
+
+	return s, nil
+}
+
+// current - returns the settings in effect.
+func (d *Daemon) current() *settings {
+	return d.settings.Load()
+}
+
+// conf - returns the configuration in effect.
+func (d *Daemon) conf() *config.Config {
+	return d.current().cfg
+}
+
+// Reload - switches to a new validated configuration and on-call
+// schedule: routing rules, chats, templates, users, quiet hours and
+// everything else read while handling events. Jobs already running
+// finish with the old configuration; nothing queued or held is lost.
+//
+// The settings the daemon is built around at start (listen addresses,
+// webhook paths, Telegram and Jira access, the store, the outbox) are
+// kept; Reload returns the names of those that changed and need a
+// restart. Changes of SLA polling and of digests, which run their own
+// schedules, are refused.
+func (d *Daemon) Reload(cfg *config.Config, schedule *oncall.Schedule) ([]string, error) {
+	prev := d.current()
+
+	if d.bot == nil && usesTopics(cfg) {
+		return nil, errors.New("forum topics are enabled but no Bot API is set")
+	}
+
+	next := *cfg
+	changed := next.KeepStatic(prev.cfg)
+
+	s, err := d.newSettings(&next, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	digests, err := newDigests(s.router.Rules())
+	if err != nil {
+		return nil, err
+	}
+
+	var restart []string
+
+	if cfg.SLA.Interval != prev.cfg.SLA.Interval || polling(s) != polling(prev) {
+		restart = append(restart, "SLA polling (sla.interval, sla.thresholds)")
+	}
+
+	if !slices.EqualFunc(d.digests, digests, sameDigest) {
+		restart = append(restart, "digests (routing.rules.digest)")
+	}
+
+	if len(restart) > 0 {
+		return nil, fmt.Errorf("changes of %s need a restart", strings.Join(restart, " and "))
+	}
+
+	d.settings.Store(s)
+
+	return changed, nil
+}
+
+// polling - reports whether SLAs are polled with the settings.
+func polling(s *settings) bool {
+	return s.cfg.SLA.Interval > 0 && len(s.thresholds) > 0
+}
+
+// sameDigest - reports whether two digests go to the same destination
+// on the same schedule.
+func sameDigest(a, b digest) bool {
+	return a.chat == b.chat && a.thread == b.thread && *a.rule.Digest == *b.rule.Digest
+}
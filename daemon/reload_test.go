@@ -0,0 +1,141 @@
+package daemon
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/route"
+)
+
+func TestReload(t *testing.T) {
+	fs := newFakeSender()
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Telegram.Token = "old"
+
+	d := mustNew(t, cfg, fs)
+
+	// routed before the reload
+	targets, err := d.targets(readEvent(t, "issue_created.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := testConfig()
+	next.Chats = nil
+	next.Telegram.Token = "new"
+	next.Routing.Rules = []route.Rule{{
+		Name:      "all",
+		To:        []route.Destination{{Chat: -1003}},
+		Templates: map[string]string{"default": "{{.Issue.Key}} routed"},
+	}}
+
+	changed, err := d.Reload(next, nil)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if !slices.Equal(changed, []string{"telegram"}) || d.conf().Telegram.Token != "old" {
+		t.Errorf("Reload() = %q, token %q; want the token kept", changed, d.conf().Telegram.Token)
+	}
+
+	e := readEvent(t, "issue_created.json")
+
+	if err := d.deliver(context.Background(), e, targets[0], d.eventData(e)); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	if err := d.Handle(context.Background(), readEvent(t, "comment_created.json")); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	got := fs.wait(t, 2)
+
+	if got[0].params.ChatID != -1001 {
+		t.Errorf("event routed before the reload sent to %d, want -1001", got[0].params.ChatID)
+	}
+
+	if got[1].params.ChatID != -1003 || got[1].rr[0].Text != "SD\\-42 routed" {
+		t.Errorf("event after the reload = %d %q, want -1003 by the new rule", got[1].params.ChatID, got[1].rr[0].Text)
+	}
+
+	bad := testConfig()
+	bad.Chats = []config.Chat{{ID: -1, Topics: true}}
+
+	if _, err := d.Reload(bad, nil); err == nil || len(d.conf().Chats) > 0 {
+		t.Errorf("Reload() of a config with topics and no Bot API = %v", err)
+	}
+}
+
+func TestReloadRuleTemplates(t *testing.T) {
+	fs := newFakeSender()
+	cfg := testConfig()
+	cfg.Routing.Rules = []route.Rule{{
+		Name:      "sd",
+		To:        []route.Destination{{Chat: -1003}},
+		Templates: map[string]string{"default": "{{.Issue.Key}} by sd"},
+	}}
+
+	d := mustNew(t, cfg, fs)
+
+	// routed before the reloads
+	targets, err := d.targets(readEvent(t, "issue_created.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if _, err := d.Reload(cfg, nil); err != nil {
+			t.Fatalf("Reload: %v", err)
+		}
+	}
+
+	if n := len(d.current().ruleTemplates); n != 1 {
+		t.Errorf("%d rule template sets after the reloads, want 1", n)
+	}
+
+	e := readEvent(t, "issue_created.json")
+	if err := d.deliver(context.Background(), e, targets[0], d.eventData(e)); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	if got := fs.wait(t, 1); got[0].rr[0].Text != "SD\\-42 by sd" {
+		t.Errorf("event routed before the reloads = %q, want the template of its rule", got[0].rr[0].Text)
+	}
+}
+
+func TestReloadRefusesSchedules(t *testing.T) {
+	cfg := testConfig()
+
+	digest := testConfig()
+	digest.Routing.Rules = []route.Rule{{To: []route.Destination{{Chat: -1003}}, Digest: &route.Digest{Schedule: "0 9 * * *"}}}
+
+	sla := testConfig()
+	sla.SLA = config.SLA{Interval: time.Minute, Thresholds: []time.Duration{0}}
+
+	tests := []struct {
+		name string
+		cfg  *config.Config
+		want string
+	}{
+		{"digest", digest, "digests"},
+		{"SLA polling", sla, "SLA polling"},
+	}
+
+	for _, tt := range tests {
+		d := mustNew(t, cfg, newFakeSender())
+
+		_, err := d.Reload(tt.cfg, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Reload() = %v, want an error about %s", tt.name, err, tt.want)
+		}
+
+		if len(d.current().router.Rules()) != 0 || d.conf().SLA.Interval != 0 {
+			t.Errorf("%s: the configuration was switched", tt.name)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/parser"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// InternalCommand - prefixes replies posted as internal JSM comments.
+const InternalCommand = "/internal"
+
+// HandleUpdate - processes an incoming Telegram update: bot commands are
+// run, messages of a request dialog are collected, replies to issue messages and messages in issue topics are posted
+// as Jira comments, button presses perform their actions. The work is queued to the
+// issue's worker, so that it is ordered with the issue's webhook events.
+func (d *Daemon) HandleUpdate(ctx context.Context, u tg.Update) {
+	if u.CallbackQuery != nil {
+		d.handleCallback(ctx, u.CallbackQuery)
+
+		return
+	}
+
+	m := u.Message
+	if m == nil || m.From == nil || m.From.IsBot {
+		return
+	}
+
+	if d.handleCommand(ctx, m) || d.handleDraft(ctx, m) {
+		return
+	}
+
+	issue, err := d.replyIssue(m)
+	if err != nil {
+		d.log.Error("reply lookup failed", "chat", m.Chat.ID, "message", m.MessageID, "error", err)
+
+		return
+	}
+
+	if issue == "" {
+		return
+	}
+
+	select {
+	case d.queue(issue) <- func(ctx context.Context) {
+		if err := d.comment(ctx, m, issue); err != nil {
+			d.log.Error("reply not posted", "issue", issue, "chat", m.Chat.ID, "message", m.MessageID, "error", err)
+		}
+	}:
+	case <-ctx.Done():
+	}
+}
+
+// replyIssue - returns the issue a message replies to, or "".
+func (d *Daemon) replyIssue(m *tg.Message) (string, error) {
+	if r := m.ReplyToMessage; r != nil {
+		issue, ok, err := d.messages.Issue(m.Chat.ID, r.MessageID)
+		if err != nil || ok {
+			return issue, err
+		}
+	}
+
+	if m.IsTopicMessage && m.MessageThreadID != 0 {
+		issue, _, err := d.messages.TopicIssue(m.Chat.ID, m.MessageThreadID)
+
+		return issue, err
+	}
+
+	return "", nil
+}
+
+// comment - posts a Telegram message as a comment of the issue.
+func (d *Daemon) comment(ctx context.Context, m *tg.Message, issue string) error {
+	text, entities := m.Text, m.Entities
+	if text == "" {
+		text, entities = m.Caption, m.CaptionEntities
+	}
+
+	text, entities, internal := stripCommand(text, entities, InternalCommand)
+
+	// other commands aren't comments
+	if strings.HasPrefix(text, "/") {
+		return nil
+	}
+
+	body := strings.TrimSpace(parser.ConvertTgToJira(text, entities))
+	if body == "" {
+		return nil
+	}
+
+	var author string
+
+	if u, ok := d.conf().UserByTelegram(m.From.ID); ok {
+		author = u.Mention()
+	} else {
+		// customers can reply in the chats their requests were created in
+		linked, err := d.messages.Linked(issue, m.Chat.ID)
+		if err != nil {
+			return err
+		}
+
+		if !linked {
+			return d.notify(ctx, m, "Your Telegram account isn't linked to a Jira user; the reply was not posted to "+issue+".")
+		}
+
+		author, internal = parser.EscapeJira(telegramName(m.From)), false
+	}
+
+	c, err := d.jira.AddComment(ctx, issue, author+" via Telegram:\n\n"+body, internal)
+	if err != nil {
+		return errors.Join(err, d.notify(ctx, m, "Couldn't post the reply to "+issue+"."))
+	}
+
+	d.log.Info("reply posted", "issue", issue, "comment", c.ID, "internal", internal, "telegram_user", m.From.ID)
+
+	if err := d.messages.SetOwnComment(issue, c.ID); err != nil {
+		return err
+	}
+
+	return d.messages.AddMessage(issue, store.MessageRef{Chat: m.Chat.ID, Thread: m.MessageThreadID, MessageID: m.MessageID})
+}
+
+// notify - replies to a message with a plain text notice.
+func (d *Daemon) notify(ctx context.Context, m *tg.Message, text string) error {
+	_, err := d.sender.SendRendered(ctx, tg.SendMessageParams{
+		ChatID:          m.Chat.ID,
+		MessageThreadID: m.MessageThreadID,
+		ReplyParameters: &tg.ReplyParameters{MessageID: m.MessageID, AllowSendingWithoutReply: true},
+	}, []delivery.Rendering{delivery.PlainRendering(text)})
+	if err != nil {
+		return fmt.Errorf("notice: %w", err)
+	}
+
+	return nil
+}
+
+// stripCommand - removes a leading bot command ("/cmd" or "/cmd@bot")
+// and the spaces after it, shifting the entities. It reports whether
+// the command was found.
+func stripCommand(text string, entities []tg.MessageEntity, cmd string) (string, []tg.MessageEntity, bool) {
+	if !strings.HasPrefix(text, cmd) {
+		return text, entities, false
+	}
+
+	rest := text[len(cmd):]
+	if strings.HasPrefix(rest, "@") {
+		i := strings.IndexFunc(rest, unicode.IsSpace)
+		if i < 0 {
+			i = len(rest)
+		}
+
+		rest = rest[i:]
+	} else if rest != "" && !unicode.IsSpace([]rune(rest)[0]) {
+		// "/internals" is another command
+		return text, entities, false
+	}
+
+	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	shift := len(utf16.Encode([]rune(text[:len(text)-len(rest)])))
+
+	var out []tg.MessageEntity
+
+	for _, e := range entities {
+		end := e.Offset + e.Length - shift
+		if end <= 0 {
+			continue
+		}
+
+		e.Offset = max(e.Offset-shift, 0)
+		e.Length = end - e.Offset
+		out = append(out, e)
+	}
+
+	return rest, out, true
+}
@@ -0,0 +1,150 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/jira/jiratest"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func waitComments(t *testing.T, js *jiratest.Server, n int) []jiratest.Comment {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		if cc := js.Comments(); len(cc) >= n {
+			return cc
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d comments", n)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRepliesBecomeComments(t *testing.T) {
+	js := jiratest.NewServer()
+	defer js.Close()
+
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Telegram.Updates = config.UpdatesPolling
+	cfg.Users = []config.User{{Telegram: 501, AccountID: "acc-1"}}
+
+	fs := newFakeSender()
+
+	d, err := New(cfg, fs, WithWorkers(1), WithJira(jira.NewClient(js.URL)), WithBot(&fakeBot{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	if err := d.Handle(ctx, readEvent(t, "issue_created.json")); err != nil {
+		t.Fatalf("created: %v", err)
+	}
+
+	fs.wait(t, 1)
+
+	card := &tg.Message{MessageID: 1, Chat: tg.Chat{ID: -1001}}
+
+	d.HandleUpdate(ctx, tg.Update{Message: &tg.Message{
+		MessageID:      50,
+		From:           &tg.User{ID: 501, FirstName: "Alice"},
+		Chat:           tg.Chat{ID: -1001},
+		ReplyToMessage: card,
+		Text:           "/internal restarted the gateway",
+		Entities: []tg.MessageEntity{
+			{Type: "bot_command", Offset: 0, Length: 9},
+			{Type: "bold", Offset: 10, Length: 9},
+		},
+	}})
+
+	d.HandleUpdate(ctx, tg.Update{Message: &tg.Message{
+		MessageID:      51,
+		From:           &tg.User{ID: 501},
+		Chat:           tg.Chat{ID: -1001},
+		ReplyToMessage: card,
+		Text:           "fixed [again]",
+	}})
+
+	// not a reply to an issue message, and a bot
+	d.HandleUpdate(ctx, tg.Update{Message: &tg.Message{MessageID: 52, From: &tg.User{ID: 501}, Chat: tg.Chat{ID: -1001}, Text: "hello"}})
+	d.HandleUpdate(ctx, tg.Update{Message: &tg.Message{MessageID: 53, From: &tg.User{ID: 9, IsBot: true}, Chat: tg.Chat{ID: -1001}, ReplyToMessage: card, Text: "beep"}})
+
+	cc := waitComments(t, js, 2)
+
+	want := []jiratest.Comment{
+		{Issue: "SD-42", Body: "[~accountid:acc-1] via Telegram:\n\n*restarted* the gateway", Public: false},
+		{Issue: "SD-42", Body: "[~accountid:acc-1] via Telegram:\n\nfixed \\[again\\]", Public: true},
+	}
+
+	for i, w := range want {
+		if cc[i].Issue != w.Issue || cc[i].Body != w.Body || cc[i].Public != w.Public {
+			t.Errorf("comment %d = %+v, want %+v", i, cc[i], w)
+		}
+	}
+
+	// the webhook echo of our own comment isn't announced
+	echo := readEvent(t, "comment_created.json")
+	echo.Comment.ID = cc[0].ID
+
+	if err := d.Handle(ctx, echo); err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+
+	// a reply to the agent's message is still linked to the issue
+	if issue, ok, _ := d.messages.Issue(-1001, 51); !ok || issue != "SD-42" {
+		t.Errorf("Issue(-1001, 51) = %q, %v", issue, ok)
+	}
+
+	// an unlinked user gets a notice
+	d.HandleUpdate(ctx, tg.Update{Message: &tg.Message{
+		MessageID:      60,
+		From:           &tg.User{ID: 777},
+		Chat:           tg.Chat{ID: -1001},
+		ReplyToMessage: card,
+		Text:           "me too",
+	}})
+
+	got := fs.wait(t, 1)
+	if n := len(got); n != 2 || got[1].params.ReplyParameters == nil || got[1].params.ReplyParameters.MessageID != 60 {
+		t.Fatalf("sent = %+v, want a single notice", got)
+	}
+
+	if len(js.Comments()) != 2 {
+		t.Errorf("comments = %+v", js.Comments())
+	}
+}
+
+func TestStripCommand(t *testing.T) {
+	tests := []struct {
+		text     string
+		entities []tg.MessageEntity
+		want     string
+		found    bool
+		offset   int
+	}{
+		{"/internal note", []tg.MessageEntity{{Type: "bold", Offset: 10, Length: 4}}, "note", true, 0},
+		{"/internal@jsm2tg_bot  🔥 note", []tg.MessageEntity{{Type: "bold", Offset: 25, Length: 4}}, "🔥 note", true, 3},
+		{"/internals note", []tg.MessageEntity{{Type: "bold", Offset: 11, Length: 4}}, "/internals note", false, 11},
+		{"note", []tg.MessageEntity{{Type: "bold", Offset: 0, Length: 4}}, "note", false, 0},
+	}
+
+	for _, tt := range tests {
+		got, ee, found := stripCommand(tt.text, tt.entities, InternalCommand)
+		if got != tt.want || found != tt.found || len(ee) != 1 || ee[0].Offset != tt.offset || ee[0].Length != 4 {
+			t.Errorf("%q: stripCommand() = %q, %+v, %v", tt.text, got, ee, found)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package daemon
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/schors/jsm2tg/callback"
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/parser"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Request dialog commands.
+const (
+	CommandNew    = "/new"
+	CommandCancel = "/cancel"
+)
+
+// actionNew - the callback action of the request type buttons; Issue is
+// the draft key, Arg the request type id or empty to cancel.
+const actionNew = "n"
+
+// maxSummaryLength - the Jira limit for issue summaries.
+const maxSummaryLength = 255
+
+// mayCreate - reports whether a Telegram user may create requests in a chat.
+func (d *Daemon) mayCreate(chat, user int64) bool {
+	if _, ok := d.conf().UserByTelegram(user); ok {
+		return true
+	}
+
+	return slices.Contains(d.conf().Requests.Chats, chat)
+}
+
+// telegramName - returns the name of a Telegram user with the @username.
+func telegramName(u *tg.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if u.Username != "" {
+		name += " (@" + u.Username + ")"
+	}
+
+	return name
+}
+
+// summaryOf - returns the first line of a text as an issue summary.
+func summaryOf(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+
+	return render.Truncate(strings.TrimSpace(line), maxSummaryLength-1)
+}
+
+// handleDraft - queues a message continuing the user's request dialog,
+// or a message forwarded to the bot in a private chat, which starts one.
+// It returns false if the message is neither.
+func (d *Daemon) handleDraft(ctx context.Context, m *tg.Message) bool {
+	if d.conf().Jira.ServiceDesk == "" {
+		return false
+	}
+
+	// commands aren't part of the request
+	if strings.HasPrefix(m.Text, "/") {
+		return false
+	}
+
+	_, ok, err := d.messages.Draft(m.Chat.ID, m.From.ID)
+	if err != nil {
+		d.log.Error("draft lookup failed", "chat", m.Chat.ID, "telegram_user", m.From.ID, "error", err)
+
+		return false
+	}
+
+	if !ok && (m.ForwardOrigin == nil || m.Chat.Type != "private" || !d.mayCreate(m.Chat.ID, m.From.ID)) {
+		return false
+	}
+
+	select {
+	case d.queue(store.DraftKey(m.Chat.ID, m.From.ID)) <- func(ctx context.Context) {
+		if err := d.draftStep(ctx, m); err != nil {
+			d.log.Error("request dialog failed", "chat", m.Chat.ID, "telegram_user", m.From.ID, "error", err)
+		}
+	}:
+	case <-ctx.Done():
+	}
+
+	return true
+}
+
+// newRequest - starts a request dialog; the summary may be given
+// with the command.
+func (d *Daemon) newRequest(ctx context.Context, m *tg.Message, summary string) error {
+	if d.conf().Jira.ServiceDesk == "" {
+		return d.notify(ctx, m, "No service desk is configured.")
+	}
+
+	if !d.mayCreate(m.Chat.ID, m.From.ID) {
+		return d.notify(ctx, m, "You can't create requests in this chat.")
+	}
+
+	draft := store.Draft{Chat: m.Chat.ID, Thread: m.MessageThreadID, User: m.From.ID, Step: store.DraftSummary}
+
+	if summary != "" {
+		draft.Summary = summaryOf(summary)
+
+		return d.askDescription(ctx, m, draft)
+	}
+
+	if err := d.messages.SetDraft(draft); err != nil {
+		return err
+	}
+
+	return d.notify(ctx, m, "What is the request about? Send a short summary, or "+CommandCancel+".")
+}
+
+// cancelDraft - discards the user's request dialog.
+func (d *Daemon) cancelDraft(ctx context.Context, m *tg.Message) error {
+	if err := d.messages.DeleteDraft(m.Chat.ID, m.From.ID); err != nil {
+		return err
+	}
+
+	return d.notify(ctx, m, "Request cancelled.")
+}
+
+// draftStep - adds a message to the request being collected;
+// a forwarded message starts a new one.
+func (d *Daemon) draftStep(ctx context.Context, m *tg.Message) error {
+	draft, ok, err := d.messages.Draft(m.Chat.ID, m.From.ID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		if m.ForwardOrigin == nil {
+			return nil
+		}
+
+		draft = store.Draft{Chat: m.Chat.ID, Thread: m.MessageThreadID, User: m.From.ID, Step: store.DraftDescription}
+	}
+
+	if draft.Step == store.DraftSummary && m.ForwardOrigin == nil {
+		if draft.Summary = summaryOf(m.Text); draft.Summary == "" {
+			return d.notify(ctx, m, "Please send the summary as text, or "+CommandCancel+".")
+		}
+
+		// more lines start the description
+		if strings.Contains(strings.TrimSpace(m.Text), "\n") {
+			collect(&draft, m)
+		}
+
+		return d.askDescription(ctx, m, draft)
+	}
+
+	collect(&draft, m)
+
+	if draft.Summary == "" {
+		draft.Summary = summaryOf(m.Text + m.Caption)
+	}
+
+	// the first forwarded message asks for the summary or the request type
+	if draft.Prompt == 0 {
+		if draft.Summary == "" {
+			draft.Step = store.DraftSummary
+			if err := d.messages.SetDraft(draft); err != nil {
+				return err
+			}
+
+			return d.notify(ctx, m, "What is the request about? Send a short summary, or "+CommandCancel+".")
+		}
+
+		return d.askDescription(ctx, m, draft)
+	}
+
+	return d.messages.SetDraft(draft)
+}
+
+// collect - appends the text and files of a message to a draft.
+func collect(draft *store.Draft, m *tg.Message) {
+	text, entities := m.Text, m.Entities
+	if text == "" {
+		text, entities = m.Caption, m.CaptionEntities
+	}
+
+	if body := strings.TrimSpace(parser.ConvertTgToJira(text, entities)); body != "" {
+		if draft.Description != "" {
+			draft.Description += "\n\n"
+		}
+
+		draft.Description += body
+	}
+
+	if n := len(m.Photo); n > 0 {
+		// the last size is the largest
+		p := m.Photo[n-1]
+		draft.Files = append(draft.Files, store.DraftFile{ID: p.FileID, Name: "photo_" + p.FileUniqueID + ".jpg"})
+	}
+
+	if doc := m.Document; doc != nil {
+		name := doc.FileName
+		if name == "" {
+			name = "document_" + doc.FileUniqueID
+		}
+
+		draft.Files = append(draft.Files, store.DraftFile{ID: doc.FileID, Name: name})
+	}
+}
+
+// askDescription - asks for the description and offers the request types.
+func (d *Daemon) askDescription(ctx context.Context, m *tg.Message, draft store.Draft) error {
+	types, err := d.jira.RequestTypes(ctx, d.conf().Jira.ServiceDesk)
+	if err != nil {
+		return errors.Join(err, d.notify(ctx, m, "Couldn't load the request types."))
+	}
+
+	key := store.DraftKey(draft.Chat, draft.User)
+
+	var rows [][]tg.InlineKeyboardButton
+
+	var row []tg.InlineKeyboardButton
+
+	for _, t := range types {
+		if len(d.conf().Requests.RequestTypes) > 0 && !slices.Contains(d.conf().Requests.RequestTypes, t.ID) {
+			continue
+		}
+
+		if b, ok := d.button(t.Name, callback.Data{Action: actionNew, Issue: key, Arg: t.ID}); ok {
+			row = append(row, b)
+		}
+
+		if len(row) == keyboardColumns {
+			rows, row = append(rows, row), nil
+		}
+	}
+
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return d.notify(ctx, m, "No request types are available.")
+	}
+
+	if b, ok := d.button("❌ Cancel", callback.Data{Action: actionNew, Issue: key}); ok {
+		rows = append(rows, []tg.InlineKeyboardButton{b})
+	}
+
+	text := "📝 " + draft.Summary + "\n\nSend the description, with photos or documents if needed, then choose the request type:"
+	if draft.Description != "" || len(draft.Files) > 0 {
+		text = "📝 " + draft.Summary + "\n\nAdd more details if needed, then choose the request type:"
+	}
+
+	sent, err := d.sender.SendRendered(ctx, tg.SendMessageParams{
+		ChatID:          m.Chat.ID,
+		MessageThreadID: m.MessageThreadID,
+		ReplyParameters: &tg.ReplyParameters{MessageID: m.MessageID, AllowSendingWithoutReply: true},
+		ReplyMarkup:     &tg.InlineKeyboardMarkup{InlineKeyboard: rows},
+	}, []delivery.Rendering{delivery.PlainRendering(text)})
+	if err != nil {
+		return err
+	}
+
+	draft.Step = store.DraftDescription
+	draft.Prompt = sent.MessageID
+
+	return d.messages.SetDraft(draft)
+}
+
+// createRequest - creates the request of a draft when its request type
+// is chosen, or discards the draft.
+func (d *Daemon) createRequest(ctx context.Context, q *tg.CallbackQuery, cd callback.Data) (string, error) {
+	chatID, userID, _ := strings.Cut(cd.Issue, "/")
+	chat, cerr := strconv.ParseInt(chatID, 10, 64)
+	user, uerr := strconv.ParseInt(userID, 10, 64)
+
+	if cerr != nil || uerr != nil {
+		return "", notice("Unknown request.")
+	}
+
+	if q.From.ID != user {
+		return "", notice("This is someone else's request.")
+	}
+
+	draft, ok, err := d.messages.Draft(chat, user)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return "", notice("This request is not available anymore.")
+	}
+
+	if cd.Arg == "" {
+		if err := d.messages.DeleteDraft(chat, user); err != nil {
+			return "", err
+		}
+
+		d.closePrompt(ctx, q, "Request cancelled.")
+
+		return "Cancelled.", nil
+	}
+
+	if strings.TrimSpace(draft.Description) == "" && len(draft.Files) == 0 {
+		return "", notice("Send the description first.")
+	}
+
+	p := jira.CreateRequestParams{
+		ServiceDeskID: d.conf().Jira.ServiceDesk,
+		RequestTypeID: cd.Arg,
+		Summary:       draft.Summary,
+		Description:   draft.Description,
+	}
+
+	// linked users are the reporters; others are named in the description
+	if u, ok := d.conf().UserByTelegram(q.From.ID); ok {
+		p.RaiseOnBehalfOf = cmp.Or(u.AccountID, u.Username)
+	} else {
+		if p.Description != "" {
+			p.Description += "\n\n"
+		}
+
+		p.Description += "----\n_Raised via Telegram by " + parser.EscapeJira(telegramName(&q.From)) + "_"
+	}
+
+	r, err := d.jira.CreateRequest(ctx, p)
+	if err != nil {
+		return "", err
+	}
+
+	d.log.Info("request created", "issue", r.IssueKey, "chat", chat, "telegram_user", user, "files", len(draft.Files))
+
+	if err := d.messages.DeleteDraft(chat, user); err != nil {
+		return "", err
+	}
+
+	done := "Created " + r.IssueKey + "."
+
+	if err := d.attach(ctx, r.IssueKey, draft.Files); err != nil {
+		d.log.Warn("attachments not added", "issue", r.IssueKey, "error", err)
+
+		done += " Some files couldn't be attached."
+	}
+
+	d.closePrompt(ctx, q, "✅ Request "+r.IssueKey+" created.")
+
+	// the chat follows the request from now on
+	t := route.Target{Destination: route.Destination{Chat: chat, Thread: draft.Thread, Public: true}}
+	if err := d.messages.Link(r.IssueKey, store.MessageRef{Chat: chat, Thread: draft.Thread}); err != nil {
+		return "", err
+	}
+
+	is, err := d.jira.GetIssue(ctx, r.IssueKey, jira.IssueOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	e := &jira.Event{WebhookEvent: jira.EventIssueCreated, Issue: is}
+	if err := d.postCard(ctx, e, t, d.chatTemplates(chat, draft.Thread), render.NewData(e, d.conf().Jira.URL)); err != nil {
+		return "", err
+	}
+
+	return done, nil
+}
+
+// attach - uploads Telegram files to a request as public attachments.
+func (d *Daemon) attach(ctx context.Context, key string, files []store.DraftFile) error {
+	var (
+		ids  []string
+		errs []error
+	)
+
+	for _, f := range files {
+		id, err := d.upload(ctx, f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Name, err))
+
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	if len(ids) > 0 {
+		errs = append(errs, d.jira.AddAttachments(ctx, key, ids, true))
+	}
+
+	return errors.Join(errs...)
+}
+
+// upload - copies a Telegram file to a temporary service desk attachment.
+func (d *Daemon) upload(ctx context.Context, f store.DraftFile) (string, error) {
+	tf, err := d.bot.GetFile(ctx, f.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if tf.FileSize > tg.MaxDownloadSize {
+		return "", fmt.Errorf("%d bytes is too large to download", tf.FileSize)
+	}
+
+	r, err := d.bot.DownloadFile(ctx, tf.FilePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	return d.jira.AttachTemporaryFile(ctx, d.conf().Jira.ServiceDesk, f.Name, r)
+}
+
+// closePrompt - replaces the request type prompt with a plain text,
+// removing its buttons.
+func (d *Daemon) closePrompt(ctx context.Context, q *tg.CallbackQuery, text string) {
+	if q.Message == nil {
+		return
+	}
+
+	_, err := d.sender.EditRendered(ctx, tg.EditMessageTextParams{
+		ChatID:    q.Message.Chat.ID,
+		MessageID: q.Message.MessageID,
+	}, []delivery.Rendering{delivery.PlainRendering(text)})
+	if err != nil {
+		d.log.Warn("prompt not updated", "chat", q.Message.Chat.ID, "error", err)
+	}
+}
@@ -0,0 +1,255 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/jira/jiratest"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func requestDaemon(t *testing.T, fs *fakeSender, fb *fakeBot) (*Daemon, *jiratest.Server) {
+	t.Helper()
+
+	js := jiratest.NewServer()
+	t.Cleanup(js.Close)
+
+	js.AddRequestType(jiratest.RequestType{ID: "25", Name: "Get IT help"})
+	js.AddRequestType(jiratest.RequestType{ID: "26", Name: "Report a problem"})
+	js.AddRequestType(jiratest.RequestType{ID: "27", Name: "Internal only"})
+
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Telegram.Updates = config.UpdatesPolling
+	cfg.Jira.ServiceDesk = "1"
+	cfg.Requests.Chats = []int64{-2001}
+	cfg.Requests.RequestTypes = []string{"25", "26"}
+	cfg.Users = []config.User{{Telegram: 501, AccountID: "acc-1"}}
+
+	d, err := New(cfg, fs, WithWorkers(1), WithJira(jira.NewClient(js.URL)), WithBot(fb))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return d, js
+}
+
+func buttonData(t *testing.T, kb *tg.InlineKeyboardMarkup) map[string]string {
+	t.Helper()
+
+	if kb == nil {
+		t.Fatal("no keyboard")
+	}
+
+	buttons := map[string]string{}
+
+	for _, row := range kb.InlineKeyboard {
+		for _, b := range row {
+			buttons[b.Text] = b.CallbackData
+		}
+	}
+
+	return buttons
+}
+
+func TestNewRequest(t *testing.T) {
+	fs := newFakeSender()
+	fb := &fakeBot{}
+	d, js := requestDaemon(t, fs, fb)
+	ctx := context.Background()
+
+	carol := &tg.User{ID: 601, FirstName: "Carol", Username: "carol"}
+	msg := func(id int, text string) *tg.Message {
+		return &tg.Message{MessageID: id, From: carol, Chat: tg.Chat{ID: -2001, Type: "supergroup"}, Text: text}
+	}
+
+	if err := d.command(ctx, msg(10, "/new"), CommandNew, ""); err != nil {
+		t.Fatalf("/new: %v", err)
+	}
+
+	steps := []*tg.Message{
+		msg(11, "VPN is down\nsince the morning"),
+		{
+			MessageID: 12, From: carol, Chat: tg.Chat{ID: -2001},
+			Text: "it fails with error 809", Entities: []tg.MessageEntity{{Type: "bold", Offset: 3, Length: 5}},
+		},
+		{
+			MessageID: 13, From: carol, Chat: tg.Chat{ID: -2001},
+			Caption: "the screen", Photo: []tg.PhotoSize{{FileID: "small", FileUniqueID: "s"}, {FileID: "big", FileUniqueID: "b"}},
+		},
+	}
+
+	for _, m := range steps {
+		if err := d.draftStep(ctx, m); err != nil {
+			t.Fatalf("step %d: %v", m.MessageID, err)
+		}
+	}
+
+	got := fs.wait(t, 2)
+	if !strings.Contains(got[0].rr[0].Text, "Send a short summary") {
+		t.Errorf("summary question = %s", got[0].rr[0].Text)
+	}
+
+	buttons := buttonData(t, got[1].params.ReplyMarkup)
+	if len(buttons) != 3 || buttons["Internal only"] != "" || buttons["❌ Cancel"] == "" {
+		t.Errorf("prompt buttons = %v", buttons)
+	}
+
+	press := func(user int64, data string) {
+		t.Helper()
+
+		cd, err := d.signer.Decode(data)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+
+		d.callback(ctx, &tg.CallbackQuery{ID: "q", From: tg.User{ID: user, FirstName: "Carol", Username: "carol"}, Message: &tg.Message{MessageID: 2, Chat: tg.Chat{ID: -2001}}, Data: data}, cd)
+	}
+
+	press(602, buttons["Get IT help"])
+	press(601, buttons["Get IT help"])
+
+	rr := js.Requests()
+	if len(rr) != 1 {
+		t.Fatalf("requests = %+v", rr)
+	}
+
+	r := rr[0]
+	if r.RequestTypeID != "25" || r.Summary != "VPN is down" || !r.Public || strings.Join(r.Attachments, ",") != "photo_b.jpg" || r.RaiseOnBehalfOf != "" {
+		t.Errorf("request = %+v", r)
+	}
+
+	for _, want := range []string{"VPN is down\nsince the morning", "it *fails* with error 809", "the screen", "_Raised via Telegram by Carol (@carol)_"} {
+		if !strings.Contains(r.Description, want) {
+			t.Errorf("description does not contain %q:\n%s", want, r.Description)
+		}
+	}
+
+	if strings.Join(fb.calls, "\n") != "answer This is someone else's request.\ngetFile big\nanswer Created "+r.Key+"." {
+		t.Errorf("bot calls = %q", fb.calls)
+	}
+
+	// the prompt is closed and the chat gets the card
+	got = fs.wait(t, 1)
+	if card := got[2]; card.params.ChatID != -2001 || !strings.Contains(card.rr[0].Text, "VPN is down") || card.params.ReplyMarkup == nil {
+		t.Errorf("card = %+v: %s", card.params, card.rr[0].Text)
+	}
+
+	fs.mu.Lock()
+	if len(fs.edits) != 1 || !strings.Contains(fs.edits[0].rr[0].Text, "✅ Request") {
+		t.Errorf("prompt edits = %+v", fs.edits)
+	}
+	fs.mu.Unlock()
+
+	if _, ok, _ := d.messages.Draft(-2001, 601); ok {
+		t.Error("draft kept after the request was created")
+	}
+
+	// the webhook of the new request doesn't post a second card, and
+	// internal comments don't reach the customer chat
+	created := readEvent(t, "issue_created.json")
+	created.Issue.Key = r.Key
+
+	if err := d.Handle(ctx, created); err != nil {
+		t.Fatalf("created: %v", err)
+	}
+
+	for _, public := range []bool{false, true} {
+		c := readEvent(t, "comment_created.json")
+		c.Issue.Key = r.Key
+		c.Comment.JSDPublic = &public
+
+		if err := d.Handle(ctx, c); err != nil {
+			t.Fatalf("comment: %v", err)
+		}
+	}
+
+	got = fs.wait(t, 4)
+
+	var chats []int64
+	for _, s := range got[3:] {
+		chats = append(chats, s.params.ChatID)
+	}
+
+	if want := []int64{-1001, -1001, -1001, -2001}; fmt.Sprint(chats) != fmt.Sprint(want) {
+		t.Errorf("deliveries = %v, want %v", chats, want)
+	}
+
+	// customers reply in their chat without a linked account
+	reply := msg(20, "thanks, /internal works now")
+	reply.ReplyToMessage = &tg.Message{MessageID: 3, Chat: tg.Chat{ID: -2001}}
+
+	if err := d.comment(ctx, reply, r.Key); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+
+	cc := js.Comments()
+	if len(cc) != 1 || !cc[0].Public || !strings.HasPrefix(cc[0].Body, "Carol (@carol) via Telegram:") {
+		t.Errorf("comments = %+v", cc)
+	}
+}
+
+func TestForwardToCreate(t *testing.T) {
+	fs := newFakeSender()
+	fb := &fakeBot{}
+	d, js := requestDaemon(t, fs, fb)
+	ctx := context.Background()
+
+	alice := &tg.User{ID: 501, FirstName: "Alice"}
+	private := tg.Chat{ID: 501, Type: "private"}
+	origin := &tg.MessageOrigin{Type: "hidden_user", SenderUserName: "Bob"}
+
+	fwd := &tg.Message{MessageID: 5, From: alice, Chat: private, ForwardOrigin: origin, Text: "Printer jam on 3rd floor\nIt shows E42"}
+
+	// forwards start a request only in a private chat with the bot
+	group := *fwd
+	group.Chat = tg.Chat{ID: -2001, Type: "supergroup"}
+
+	if d.handleDraft(ctx, &group) {
+		t.Error("forward in a group started a request")
+	}
+
+	if err := d.draftStep(ctx, fwd); err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+
+	got := fs.wait(t, 1)
+	if !strings.Contains(got[0].rr[0].Text, "Printer jam on 3rd floor") {
+		t.Errorf("prompt = %s", got[0].rr[0].Text)
+	}
+
+	buttons := buttonData(t, got[0].params.ReplyMarkup)
+
+	cd, _ := d.signer.Decode(buttons["Report a problem"])
+	d.callback(ctx, &tg.CallbackQuery{ID: "q", From: *alice, Message: &tg.Message{MessageID: 1, Chat: private}, Data: buttons["Report a problem"]}, cd)
+
+	// raised for the linked user, without the Telegram footer
+	rr := js.Requests()
+	if len(rr) != 1 || rr[0].Summary != "Printer jam on 3rd floor" || !strings.Contains(rr[0].Description, "It shows E42") || rr[0].RequestTypeID != "26" ||
+		rr[0].RaiseOnBehalfOf != "acc-1" || strings.Contains(rr[0].Description, "Raised via Telegram") {
+		t.Errorf("requests = %+v", rr)
+	}
+
+	// a cancelled dialog creates nothing
+	if err := d.command(ctx, &tg.Message{MessageID: 8, From: alice, Chat: private, Text: "/new Mouse"}, CommandNew, "Mouse"); err != nil {
+		t.Fatal(err)
+	}
+
+	got = fs.wait(t, 2)
+	buttons = buttonData(t, got[2].params.ReplyMarkup)
+
+	cd, _ = d.signer.Decode(buttons["❌ Cancel"])
+	d.callback(ctx, &tg.CallbackQuery{ID: "q", From: *alice, Message: &tg.Message{MessageID: 3, Chat: private}, Data: buttons["❌ Cancel"]}, cd)
+
+	if len(js.Requests()) != 1 {
+		t.Errorf("requests after cancel = %+v", js.Requests())
+	}
+
+	if _, ok, _ := d.messages.Draft(501, 501); ok {
+		t.Error("draft kept after cancel")
+	}
+}
@@ -0,0 +1,259 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/schors/jsm2tg/delivery"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/render"
+	"github.com/schors/jsm2tg/route"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// pollSLA - checks the SLAs of the issues matching the SLA query every
+// interval until ctx is cancelled.
+func (d *Daemon) pollSLA(ctx context.Context) {
+	for {
+		select {
+		case <-d.clock.After(d.conf().SLA.Interval):
+		case <-ctx.Done():
+			return
+		}
+
+		if err := d.scanSLA(ctx); err != nil && ctx.Err() == nil {
+			d.log.Error("SLA poll failed", "jql", d.conf().SLA.JQL, "error", err)
+		}
+	}
+}
+
+// scanSLA - queues an SLA check of every issue matching the SLA query
+// on the worker of the issue.
+func (d *Daemon) scanSLA(ctx context.Context) error {
+	var o jira.SearchOptions
+
+	for {
+		res, err := d.jira.Search(ctx, d.conf().SLA.JQL, o)
+		if err != nil {
+			return err
+		}
+
+		for i := range res.Issues {
+			is := &res.Issues[i]
+
+			select {
+			case d.queue(is.Key) <- func(ctx context.Context) {
+				if err := d.checkSLA(ctx, is); err != nil {
+					d.log.Error("SLA alert failed", "issue", is.Key, "error", err)
+				}
+			}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		next, ok := res.Next(o)
+		if !ok {
+			return nil
+		}
+
+		o = next
+	}
+}
+
+// checkSLA - alerts on the ongoing SLAs of the issue that crossed a
+// threshold. Only the most severe threshold crossed is alerted; the
+// thresholds above it are marked alerted too, so that a late check
+// doesn't send a burst of stale alerts.
+func (d *Daemon) checkSLA(ctx context.Context, is *jira.Issue) error {
+	now := d.clock.Now()
+	thresholds := d.current().thresholds
+
+	var errs []error
+
+	for _, s := range is.Fields.SLAs() {
+		if s.Paused || s.Completed || s.BreachTime.IsZero() {
+			continue
+		}
+
+		s.Remaining = s.BreachTime.Sub(now)
+
+		i, _ := slices.BinarySearch(thresholds, s.Remaining)
+		if i == len(thresholds) {
+			continue
+		}
+
+		alerted, err := d.messages.SLAAlerted(is.Key, s.Name, s.BreachTime, thresholds[i])
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if alerted {
+			continue
+		}
+
+		a := &render.Alert{SLA: s, Threshold: thresholds[i], Breached: s.Breached || s.Remaining <= 0}
+		if a.Breached && s.Remaining < 0 {
+			a.Overdue = -s.Remaining
+		}
+
+		if err := d.alert(ctx, is, a); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+
+			continue
+		}
+
+		for _, th := range thresholds[i:] {
+			if err := d.messages.SetSLAAlerted(is.Key, s.Name, s.BreachTime, th); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// alert - delivers an SLA alert to the routing targets of the
+// sla_alert event, replying to the issue card where there is one.
+// Customer chats linked to the issue aren't alerted; targets with a
+// digest collect the breaches.
+func (d *Daemon) alert(ctx context.Context, is *jira.Issue, a *render.Alert) error {
+	e := &jira.Event{WebhookEvent: render.SLAKey, Timestamp: d.clock.Now().UnixMilli(), Issue: is}
+
+	targets, err := d.targets(e)
+	if err != nil {
+		return err
+	}
+
+	data := render.NewData(e, d.conf().Jira.URL)
+	data.Alert = a
+
+	if a := is.Fields.Assignee; a != nil {
+		data.Alert.Mention = d.mention(a.ID(), displayName(a))
+	}
+
+	var errs []error
+
+	for _, t := range targets {
+		if t.Public {
+			continue
+		}
+
+		if d.digested(t) {
+			if !a.Breached {
+				continue
+			}
+
+			if err := d.collect(e, t, a.SLA.Name); err != nil {
+				errs = append(errs, fmt.Errorf("chat %d: digest: %w", t.Chat, err))
+			}
+
+			continue
+		}
+
+		if err := d.deliverAlert(ctx, e, t, data); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", t.Chat, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d *Daemon) deliverAlert(ctx context.Context, e *jira.Event, t route.Target, data render.Data) error {
+	data.OnCall = d.onCall(t)
+
+	tmpl := d.templateSet(t).Lookup(render.SLAKey)
+	if tmpl == nil {
+		return nil
+	}
+
+	text, err := tmpl.Execute(data)
+	if err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+
+	rr := append([]delivery.Rendering{{
+		Name:      "template",
+		Text:      strings.TrimSpace(text),
+		ParseMode: tg.ParseModeMarkdownV2,
+	}}, alertCard(e.Issue, data.Alert, d.conf().Jira.URL).fallbacks()...)
+
+	card, hasCard, err := d.messages.Card(e.Issue.Key, t.Chat)
+	if err != nil {
+		return err
+	}
+
+	p := tg.SendMessageParams{
+		ChatID:              t.Chat,
+		MessageThreadID:     t.Thread,
+		LinkPreviewOptions:  &tg.LinkPreviewOptions{IsDisabled: true},
+		DisableNotification: d.silent(t.Chat, e.Issue),
+	}
+
+	switch {
+	case hasCard:
+		p.MessageThreadID = card.Thread
+		p.ReplyParameters = &tg.ReplyParameters{MessageID: card.MessageID, AllowSendingWithoutReply: true}
+	case t.Topics:
+		thread, err := d.topic(ctx, e, t.Chat)
+		if err != nil {
+			return fmt.Errorf("forum topic: %w", err)
+		}
+
+		p.MessageThreadID = thread
+	}
+
+	m, err := d.sender.SendRendered(withTag(ctx, tagMessage, e.Issue.Key), p, rr)
+	if errors.Is(err, delivery.ErrQueued) {
+		d.log.Warn("SLA alert queued for retry", "issue", e.Issue.Key, "chat", t.Chat, "error", err)
+
+		return nil
+	}
+
+	if err != nil {
+		return d.forgetTopic(e.Issue.Key, t, err)
+	}
+
+	return d.messages.AddMessage(e.Issue.Key, store.MessageRef{Chat: t.Chat, Thread: p.MessageThreadID, MessageID: m.MessageID})
+}
+
+// alertCard - the fallback of SLA alerts.
+func alertCard(is *jira.Issue, a *render.Alert, jiraURL string) card {
+	f := &is.Fields
+
+	c := card{title: "⏰ " + is.Key + ": " + f.Summary}
+	if a.Breached {
+		c.title = "🔥 " + is.Key + ": " + f.Summary
+	}
+
+	state := render.Duration(a.SLA.Remaining) + " left"
+
+	switch {
+	case a.Breached && a.Overdue > 0:
+		state = "breached " + render.Duration(a.Overdue) + " ago"
+	case a.Breached:
+		state = "breached"
+	}
+
+	status := ""
+	if f.Status != nil {
+		status = f.Status.Name
+	}
+
+	c.lines = append(c.lines,
+		a.SLA.Name+": "+state,
+		joinNonEmpty(" · ", name(f.Priority), status),
+		"Assignee: "+assigneeName(f.Assignee))
+
+	if jiraURL != "" {
+		c.url = jira.BrowseURL(jiraURL, is.Key)
+	}
+
+	return c
+}
@@ -0,0 +1,164 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/config"
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/jira/jiratest"
+	"github.com/schors/jsm2tg/route"
+)
+
+var slaEpoch = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func slaConfig() *config.Config {
+	cfg := testConfig()
+	cfg.Chats = cfg.Chats[:1]
+	cfg.Jira.User, cfg.Jira.Token = "bot", "secret"
+	cfg.SLA = config.SLA{Thresholds: []time.Duration{30 * time.Minute, 0}, Interval: 5 * time.Minute, JQL: config.DefaultSLAJQL}
+	cfg.Users = []config.User{{Telegram: 501, AccountID: "acc-1"}}
+
+	return cfg
+}
+
+// flush - waits until the jobs queued so far have run.
+func flush(d *Daemon) {
+	done := make(chan struct{})
+	d.queue("") <- func(context.Context) { close(done) }
+	<-done
+}
+
+func TestSLAPolling(t *testing.T) {
+	js := jiratest.NewServer()
+	defer js.Close()
+
+	js.AddIssue(jiratest.Issue{Key: "SD-1", Summary: "VPN is down", Status: "Open", Priority: "High", Assignee: "acc-1",
+		SLAs: []jiratest.SLA{{Name: "Time to resolution", BreachTime: slaEpoch.Add(45 * time.Minute)}}})
+	js.AddIssue(jiratest.Issue{Key: "SD-2", Summary: "Printer jam", Status: "Waiting for customer", Priority: "Low",
+		SLAs: []jiratest.SLA{{Name: "Time to resolution", BreachTime: slaEpoch, Paused: true}}})
+
+	clk := clock.NewFake(slaEpoch)
+	fs := newFakeSender()
+
+	d, err := New(slaConfig(), fs, WithWorkers(1), WithJira(jira.NewClient(js.URL)), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.Run(ctx)
+
+	// poll - advances the clock to the next poll and waits for its checks
+	poll := func(dt time.Duration) []sent {
+		clk.BlockUntil(1)
+		clk.Advance(dt)
+		clk.BlockUntil(1)
+		flush(d)
+
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+
+		return append([]sent(nil), fs.sent...)
+	}
+
+	tests := []struct {
+		advance time.Duration
+		want    []string // the text of a new alert, nil if none
+	}{
+		{5 * time.Minute, nil},
+		{10 * time.Minute, []string{"⏰ *SD\\-1: VPN is down*", "Time to resolution: 30m left", "Assignee: [acc\\-1](tg://user?id=501)"}},
+		{5 * time.Minute, nil},
+		{30 * time.Minute, []string{"🔥 *SD\\-1: VPN is down*", "Time to resolution: breached 5m ago"}},
+		{5 * time.Minute, nil},
+	}
+
+	alerts := 0
+
+	for i, tt := range tests {
+		got := poll(tt.advance)
+
+		if tt.want != nil {
+			alerts++
+		}
+
+		if len(got) != alerts {
+			t.Fatalf("poll %d: %d alerts, want %d", i, len(got), alerts)
+		}
+
+		for _, want := range tt.want {
+			if text := got[alerts-1].rr[0].Text; !strings.Contains(text, want) {
+				t.Errorf("poll %d: alert does not contain %q:\n%s", i, want, text)
+			}
+		}
+	}
+
+	if n := len(js.Searches()); n != len(tests) {
+		t.Errorf("searches = %d, want %d", n, len(tests))
+	}
+}
+
+func TestSLAWebhook(t *testing.T) {
+	cfg := slaConfig()
+	cfg.SLA.Interval = 0
+	cfg.Routing = route.Config{Rules: []route.Rule{
+		{Match: route.Match{Events: []string{"sla_alert"}}, To: []route.Destination{{Chat: -1009}}},
+	}}
+
+	clk := clock.NewFake(slaEpoch)
+	fs := newFakeSender()
+
+	d, err := New(cfg, fs, WithWorkers(1), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	is := &jira.Issue{Key: "SD-3", Fields: jira.Fields{
+		Summary:  "Mail bounces",
+		Assignee: &jira.User{AccountID: "acc-2", DisplayName: "Bob"},
+		Custom: map[string]json.RawMessage{
+			"customfield_10100": json.RawMessage(`{"name":"Time to first response","ongoingCycle":{"breached":true,"breachTime":{"epochMillis":` +
+				strconv.FormatInt(slaEpoch.Add(-10*time.Minute).UnixMilli(), 10) + `}}}`),
+		},
+	}}
+
+	// a late check sends the breach alert only, once
+	for range 2 {
+		d.process(context.Background(), &jira.Event{WebhookEvent: "jira:issue_updated", Issue: is})
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// the updates go to the default chat, the alerts are routed by the rule
+	var alerts []sent
+
+	for _, s := range fs.sent {
+		if s.params.ChatID == -1009 {
+			alerts = append(alerts, s)
+		}
+	}
+
+	if len(fs.sent) != 3 || len(alerts) != 1 {
+		t.Fatalf("sent %d messages, %d alerts; want 3, 1", len(fs.sent), len(alerts))
+	}
+
+	a := alerts[0]
+
+	for _, want := range []string{"🔥", "Time to first response: breached 10m ago", "Assignee: Bob"} {
+		if !strings.Contains(a.rr[0].Text, want) {
+			t.Errorf("alert does not contain %q:\n%s", want, a.rr[0].Text)
+		}
+	}
+
+	if plain := a.rr[len(a.rr)-1].Text; !strings.Contains(plain, "Time to first response: breached 10m ago") {
+		t.Errorf("plain fallback = %s", plain)
+	}
+}
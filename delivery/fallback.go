@@ -0,0 +1,131 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/schors/jsm2tg/parser"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Rendering - one presentation of a message: the text and its parse mode.
+type Rendering struct {
+	Name      string
+	Text      string
+	ParseMode string
+}
+
+// JiraRenderings - returns the renderings of a Jira markup text in the order
+// they are tried: MarkdownV2, HTML and fully escaped plain text.
+func JiraRenderings(input string) []Rendering {
+	return []Rendering{
+		{Name: "markdownv2", Text: parser.ConvertJiraToTgMarkup(input), ParseMode: tg.ParseModeMarkdownV2},
+		{Name: "html", Text: parser.ConvertJiraToTgHTML(input), ParseMode: tg.ParseModeHTML},
+		PlainRendering(input),
+	}
+}
+
+// PlainRendering - returns the text escaped so that Telegram
+// shows it verbatim; this rendering never fails to parse.
+func PlainRendering(input string) Rendering {
+	return Rendering{Name: "plain", Text: tg.EscapeTelegram(input), ParseMode: tg.ParseModeMarkdownV2}
+}
+
+// SendJira - converts a Jira markup text and sends it, falling back
+// to HTML and then to plain text if Telegram can't parse the entities.
+func (s *Sender) SendJira(ctx context.Context, p tg.SendMessageParams, input string) (*tg.Message, error) {
+	return s.SendRendered(ctx, p, JiraRenderings(input))
+}
+
+// SendRendered - sends the first rendering Telegram accepts.
+// Text and ParseMode of p are overwritten.
+func (s *Sender) SendRendered(ctx context.Context, p tg.SendMessageParams, rr []Rendering) (*tg.Message, error) {
+	var m *tg.Message
+
+	err := s.fallback(p.ChatID, rr, func(r Rendering) error {
+		var err error
+
+		p.Text, p.ParseMode = r.Text, r.ParseMode
+		m, err = s.SendMessage(ctx, p)
+
+		return err
+	})
+
+	return m, err
+}
+
+// EditRendered - edits a message with the first rendering Telegram accepts.
+// Text and ParseMode of p are overwritten.
+func (s *Sender) EditRendered(ctx context.Context, p tg.EditMessageTextParams, rr []Rendering) (*tg.Message, error) {
+	var m *tg.Message
+
+	err := s.fallback(p.ChatID, rr, func(r Rendering) error {
+		var err error
+
+		p.Text, p.ParseMode = r.Text, r.ParseMode
+		m, err = s.EditMessageText(ctx, p)
+
+		return err
+	})
+
+	return m, err
+}
+
+func (s *Sender) fallback(chatID int64, rr []Rendering, fn func(Rendering) error) error {
+	err := s.tryRenderings(chatID, rr, fn)
+
+	switch {
+	case err == nil:
+		s.sent.Inc(chatLabel(chatID))
+	case errors.Is(err, context.Canceled), errors.Is(err, tg.ErrMessageNotModified):
+		// not a delivery failure
+	default:
+		s.failed.Inc(chatLabel(chatID))
+	}
+
+	return err
+}
+
+// tryRenderings - calls fn with the renderings until Telegram accepts one.
+func (s *Sender) tryRenderings(chatID int64, rr []Rendering, fn func(Rendering) error) error {
+	var err error
+
+	for _, r := range rr {
+		err = fn(r)
+		if err == nil || !errors.Is(err, tg.ErrCantParseEntities) {
+			return err
+		}
+
+		s.fallbacks.Inc(r.Name)
+
+		e, _ := tg.AsError(err)
+		attrs := []any{"chat", chatID, "rendering", r.Name, "error", e.Description}
+
+		if off, ok := e.EntityOffset(); ok {
+			attrs = append(attrs, "offset", off, "context", Surrounding(r.Text, off, 40))
+		}
+
+		s.cfg.Logger.Warn("telegram rejected message markup", attrs...)
+	}
+
+	return err
+}
+
+// Surrounding - returns up to n bytes of text on both sides of the byte
+// offset, trimmed to rune boundaries.
+func Surrounding(text string, offset, n int) string {
+	offset = min(max(offset, 0), len(text))
+
+	from := max(offset-n, 0)
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+
+	to := min(offset+n, len(text))
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+
+	return text[from:offset] + "⟪here⟫" + text[offset:to]
+}
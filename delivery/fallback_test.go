@@ -0,0 +1,96 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/metrics"
+	"github.com/schors/jsm2tg/tg"
+)
+
+func TestSendJiraFallback(t *testing.T) {
+	var modes []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p tg.SendMessageParams
+		json.NewDecoder(r.Body).Decode(&p)
+
+		modes = append(modes, p.ParseMode)
+
+		if len(modes) < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities: Can't find end of the entity starting at byte offset 3"}`)
+
+			return
+		}
+
+		if p.Text != `a \*b` {
+			t.Errorf("plain text = %q, want %q", p.Text, `a \*b`)
+		}
+
+		io.WriteString(w, `{"ok":true,"result":{"message_id":9,"chat":{"id":1,"type":"private"},"date":1}}`)
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+
+	reg := metrics.NewRegistry()
+
+	s := NewSender(tg.NewClient("T", tg.WithBaseURL(srv.URL)),
+		Config{PerChatBurst: 3, Logger: slog.New(slog.NewTextHandler(&logs, nil)), Metrics: reg}, clock.NewFake(epoch))
+
+	m, err := s.SendJira(context.Background(), tg.SendMessageParams{ChatID: 1}, "a *b")
+	if err != nil {
+		t.Fatalf("SendJira: %v", err)
+	}
+
+	if m.MessageID != 9 {
+		t.Errorf("message id = %d, want 9", m.MessageID)
+	}
+
+	want := []string{tg.ParseModeMarkdownV2, tg.ParseModeHTML, tg.ParseModeMarkdownV2}
+	if strings.Join(modes, ",") != strings.Join(want, ",") {
+		t.Errorf("parse modes = %v, want %v", modes, want)
+	}
+
+	if !strings.Contains(logs.String(), "offset=3") || !strings.Contains(logs.String(), "rendering=html") {
+		t.Errorf("log does not report the offending offset:\n%s", logs.String())
+	}
+
+	fallbacks := reg.Counter("jsm2tg_conversion_fallbacks_total", "", "rendering")
+	if fallbacks.Value("markdownv2") != 1 || fallbacks.Value("html") != 1 || fallbacks.Value("plain") != 0 {
+		t.Errorf("fallbacks = %v markdownv2, %v html, %v plain, want 1, 1, 0",
+			fallbacks.Value("markdownv2"), fallbacks.Value("html"), fallbacks.Value("plain"))
+	}
+
+	if n := reg.Counter("jsm2tg_messages_sent_total", "", "chat").Value("1"); n != 1 {
+		t.Errorf("messages sent = %v, want 1", n)
+	}
+}
+
+func TestSurrounding(t *testing.T) {
+	tests := []struct {
+		text   string
+		offset int
+		n      int
+		want   string
+	}{
+		{"abcdef", 3, 2, "bc⟪here⟫de"},
+		{"abc", 10, 2, "bc⟪here⟫"},
+		{"жжж", 2, 1, "ж⟪here⟫ж"},
+	}
+
+	for _, tt := range tests {
+		if got := Surrounding(tt.text, tt.offset, tt.n); got != tt.want {
+			t.Errorf("Surrounding(%q, %d, %d) = %q, want %q", tt.text, tt.offset, tt.n, got, tt.want)
+		}
+	}
+}
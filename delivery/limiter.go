@@ -0,0 +1,154 @@
+package delivery
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+)
+
+// Telegram's documented broadcast limits.
+const (
+	DefaultPerChatRate = 1.0
+	DefaultGlobalRate  = 30.0
+)
+
+type bucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	until  time.Time // no tokens are handed out before this moment
+}
+
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+
+		b.last = now
+	}
+}
+
+// delay - returns how long to wait before a token is available.
+func (b *bucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+
+	if now.Before(b.until) {
+		return b.until.Sub(now)
+	}
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Limiter - a pair of token buckets: one per chat and one shared by all chats.
+// Limiter is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	clock  clock.Clock
+	global *bucket
+	chats  map[int64]*bucket
+
+	chatRate  float64
+	chatBurst int
+}
+
+// NewLimiter - creates a limiter allowing chatRate messages per second per chat
+// and globalRate messages per second overall. Zero values select the defaults;
+// the global burst defaults to one second worth of messages.
+func NewLimiter(clk clock.Clock, chatRate float64, chatBurst int, globalRate float64, globalBurst int) *Limiter {
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	if chatRate <= 0 {
+		chatRate = DefaultPerChatRate
+	}
+
+	if globalRate <= 0 {
+		globalRate = DefaultGlobalRate
+	}
+
+	if globalBurst <= 0 {
+		globalBurst = int(math.Ceil(globalRate))
+	}
+
+	return &Limiter{
+		clock:     clk,
+		global:    newBucket(globalRate, globalBurst, clk.Now()),
+		chats:     make(map[int64]*bucket),
+		chatRate:  chatRate,
+		chatBurst: chatBurst,
+	}
+}
+
+func (l *Limiter) chat(chatID int64, now time.Time) *bucket {
+	b, ok := l.chats[chatID]
+	if !ok {
+		b = newBucket(l.chatRate, l.chatBurst, now)
+		l.chats[chatID] = b
+	}
+
+	return b
+}
+
+// Wait - blocks until both the chat and the global bucket have a token,
+// then consumes one from each.
+func (l *Limiter) Wait(ctx context.Context, chatID int64) error {
+	for {
+		l.mu.Lock()
+
+		now := l.clock.Now()
+		c := l.chat(chatID, now)
+		d := max(c.delay(now), l.global.delay(now))
+
+		if d == 0 {
+			c.tokens--
+			l.global.tokens--
+			l.mu.Unlock()
+
+			return nil
+		}
+
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.clock.After(d):
+		}
+	}
+}
+
+// Pause - holds back all sends for d after a 429 response: Telegram's
+// flood control counts the requests of the whole bot, so the other chats
+// wait too.
+func (l *Limiter) Pause(chatID int64, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	until := now.Add(d)
+
+	for _, b := range []*bucket{l.chat(chatID, now), l.global} {
+		if until.After(b.until) {
+			b.until = until
+		}
+	}
+}
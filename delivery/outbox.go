@@ -0,0 +1,653 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/metrics"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// ErrQueued - the message wasn't delivered right away and stays queued
+// for a retry; OutboxConfig.Delivered reports its delivery.
+var ErrQueued = errors.New("delivery: queued for retry")
+
+const (
+	bucketOutbox      = "outbox"
+	bucketDeadLetters = "dead_letters"
+	bucketOutboxMeta  = "outbox_meta"
+	keySeq            = "seq"
+)
+
+// RenderedSender - sends and edits messages with rendering fallbacks;
+// *Sender implements it.
+type RenderedSender interface {
+	SendRendered(ctx context.Context, p tg.SendMessageParams, rr []Rendering) (*tg.Message, error)
+	EditRendered(ctx context.Context, p tg.EditMessageTextParams, rr []Rendering) (*tg.Message, error)
+}
+
+// OutboxConfig - the retry policy of an outbox. Zero values select the defaults.
+type OutboxConfig struct {
+	MaxAttempts int           // default 8
+	BaseBackoff time.Duration // default 30s, doubled on every attempt
+	MaxBackoff  time.Duration // default 30m
+
+	// Delivered - called with the tag and the message of jobs delivered
+	// after their sender stopped waiting: retried jobs and jobs queued
+	// before a restart.
+	Delivered func(tag string, m *tg.Message)
+
+	Logger  *slog.Logger      // default slog.Default()
+	Metrics *metrics.Registry // optional
+}
+
+func (c OutboxConfig) withDefaults() OutboxConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 8
+	}
+
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = 30 * time.Second
+	}
+
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 30 * time.Minute
+	}
+
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+
+	return c
+}
+
+// Job - a queued message send or edit. Tag is an opaque label
+// of the message set with WithTag.
+type Job struct {
+	ID          string                    `json:"id"`
+	Chat        int64                     `json:"chat"`
+	Tag         string                    `json:"tag,omitempty"`
+	Send        *tg.SendMessageParams     `json:"send,omitempty"`
+	Edit        *tg.EditMessageTextParams `json:"edit,omitempty"`
+	Renderings  []Rendering               `json:"renderings"`
+	Attempts    int                       `json:"attempts"`
+	Created     time.Time                 `json:"created"`
+	NextAttempt time.Time                 `json:"next_attempt,omitzero"`
+	LastError   string                    `json:"last_error,omitempty"`
+}
+
+// Text - returns the text of the first rendering.
+func (j *Job) Text() string {
+	if len(j.Renderings) == 0 {
+		return ""
+	}
+
+	return j.Renderings[0].Text
+}
+
+type tagKey struct{}
+
+// WithTag - labels the messages sent with ctx for OutboxConfig.Delivered.
+func WithTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagKey{}, tag)
+}
+
+func tagOf(ctx context.Context) string {
+	tag, _ := ctx.Value(tagKey{}).(string)
+
+	return tag
+}
+
+type result struct {
+	m   *tg.Message
+	err error
+}
+
+// entry - a job in a lane; done is nil once nobody waits for it.
+type entry struct {
+	job  *Job
+	done chan result
+}
+
+// lane - the jobs of one chat, delivered one by one in order.
+type lane struct {
+	jobs    []*entry
+	blocked bool // the head job waits for a retry
+	running bool
+}
+
+// Outbox - a persistent queue in front of a RenderedSender. Every job
+// is saved before it is tried and removed once delivered, so jobs
+// queued when the process stops are delivered after the restart (at
+// least once). Jobs of a chat are delivered in order; failing ones
+// are retried with backoff and moved to the dead letters when the
+// attempts run out. Outbox is safe for concurrent use.
+type Outbox struct {
+	sender RenderedSender
+	store  store.Store
+	clock  clock.Clock
+	cfg    OutboxConfig
+
+	latency     *metrics.Histogram
+	deadLetters *metrics.Counter
+
+	mu    sync.Mutex
+	seq   uint64
+	lanes map[int64]*lane
+	ctx   context.Context // set by Run
+	wg    sync.WaitGroup
+}
+
+// NewOutbox - creates an outbox keeping its jobs in the store.
+// A nil clock selects the wall clock.
+func NewOutbox(sender RenderedSender, st store.Store, cfg OutboxConfig, clk clock.Clock) (*Outbox, error) {
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	seq, err := loadSeq(st)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		sender: sender,
+		store:  st,
+		clock:  clk,
+		cfg:    cfg.withDefaults(),
+		seq:    seq,
+		lanes:  make(map[int64]*lane),
+
+		latency:     cfg.Metrics.Histogram("jsm2tg_delivery_latency_seconds", "Time from queueing a message to its delivery, retries included.", latencyBuckets),
+		deadLetters: cfg.Metrics.Counter("jsm2tg_dead_letters_total", "Messages moved to the dead letters."),
+	}
+
+	cfg.Metrics.GaugeFunc("jsm2tg_outbox_queue_depth", "Messages queued for delivery.", func() float64 {
+		return float64(o.Depth())
+	})
+
+	return o, nil
+}
+
+// latencyBuckets - delivery latencies from a quick send to the
+// retries of an outage.
+var latencyBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 900, 1800, 3600}
+
+// Depth - returns the number of queued jobs.
+func (o *Outbox) Depth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := 0
+	for _, l := range o.lanes {
+		n += len(l.jobs)
+	}
+
+	return n
+}
+
+// Ready - returns an error unless the outbox is running.
+func (o *Outbox) Ready() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch {
+	case o.ctx == nil:
+		return errors.New("outbox not started")
+	case o.ctx.Err() != nil:
+		return errors.New("outbox stopped")
+	}
+
+	return nil
+}
+
+// SendRendered - queues a message and waits for its first attempt.
+// It returns ErrQueued if the message is left for a retry, and the
+// error of the attempt if Telegram rejects the message for good.
+func (o *Outbox) SendRendered(ctx context.Context, p tg.SendMessageParams, rr []Rendering) (*tg.Message, error) {
+	return o.enqueue(ctx, &Job{Chat: p.ChatID, Tag: tagOf(ctx), Send: &p, Renderings: rr})
+}
+
+// EditRendered - queues a message edit like SendRendered.
+func (o *Outbox) EditRendered(ctx context.Context, p tg.EditMessageTextParams, rr []Rendering) (*tg.Message, error) {
+	return o.enqueue(ctx, &Job{Chat: p.ChatID, Tag: tagOf(ctx), Edit: &p, Renderings: rr})
+}
+
+func (o *Outbox) enqueue(ctx context.Context, job *Job) (*tg.Message, error) {
+	e, done, err := o.submit(job)
+	if err != nil {
+		return nil, err
+	}
+
+	if done == nil {
+		return nil, ErrQueued
+	}
+
+	select {
+	case r := <-done:
+		return r.m, r.err
+	case <-ctx.Done():
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	select {
+	case r := <-done:
+		return r.m, r.err
+	default:
+		// delivered in the background
+		e.done = nil
+
+		return nil, ctx.Err()
+	}
+}
+
+// submit - saves a job and appends it to the lane of its chat. It
+// returns the channel receiving the result of the first attempt, or
+// nil if the chat waits for a retry.
+func (o *Outbox) submit(job *Job) (*entry, chan result, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+	job.ID = jobID(o.seq)
+	job.Created = o.clock.Now()
+
+	if err := o.store.Put(bucketOutboxMeta, keySeq, []byte(strconv.FormatUint(o.seq, 10))); err != nil {
+		return nil, nil, fmt.Errorf("outbox: %w", err)
+	}
+
+	if err := putJob(o.store, bucketOutbox, job); err != nil {
+		return nil, nil, fmt.Errorf("outbox: %w", err)
+	}
+
+	l, ok := o.lanes[job.Chat]
+	if !ok {
+		l = &lane{}
+		o.lanes[job.Chat] = l
+	}
+
+	e := &entry{job: job}
+	if !l.blocked {
+		e.done = make(chan result, 1)
+	}
+
+	l.jobs = append(l.jobs, e)
+	o.start(job.Chat, l)
+
+	return e, e.done, nil
+}
+
+// start - starts delivering a lane; the caller holds o.mu.
+func (o *Outbox) start(chat int64, l *lane) {
+	if l.running || o.ctx == nil {
+		return
+	}
+
+	l.running = true
+	o.wg.Add(1)
+
+	go o.deliver(chat, l)
+}
+
+// Run - loads the jobs queued before and delivers queued jobs until
+// ctx is cancelled. Jobs not delivered by then stay queued.
+func (o *Outbox) Run(ctx context.Context) error {
+	o.mu.Lock()
+
+	queued := make(map[string]*entry)
+	for _, l := range o.lanes {
+		for _, e := range l.jobs {
+			queued[e.job.ID] = e
+		}
+	}
+
+	lanes := make(map[int64]*lane)
+
+	err := o.store.ForEach(bucketOutbox, func(_ string, v []byte) error {
+		var job Job
+		if err := json.Unmarshal(v, &job); err != nil {
+			return err
+		}
+
+		e, ok := queued[job.ID]
+		if !ok {
+			e = &entry{job: &job}
+		}
+
+		l, ok := lanes[job.Chat]
+		if !ok {
+			l = &lane{}
+			lanes[job.Chat] = l
+		}
+
+		l.jobs = append(l.jobs, e)
+
+		return nil
+	})
+	if err != nil {
+		o.mu.Unlock()
+
+		return fmt.Errorf("outbox: %w", err)
+	}
+
+	o.lanes, o.ctx = lanes, ctx
+
+	for chat, l := range lanes {
+		o.start(chat, l)
+	}
+
+	o.mu.Unlock()
+
+	<-ctx.Done()
+	o.wg.Wait()
+
+	return nil
+}
+
+// deliver - delivers the jobs of a lane until it is empty.
+func (o *Outbox) deliver(chat int64, l *lane) {
+	defer o.wg.Done()
+
+	for {
+		o.mu.Lock()
+
+		if len(l.jobs) == 0 || o.ctx.Err() != nil {
+			l.running = false
+
+			if len(l.jobs) == 0 {
+				delete(o.lanes, chat)
+			}
+
+			o.mu.Unlock()
+
+			return
+		}
+
+		e := l.jobs[0]
+		job := *e.job
+
+		wait := job.NextAttempt.Sub(o.clock.Now())
+		if wait > 0 {
+			o.block(l, nil)
+		}
+
+		o.mu.Unlock()
+
+		if wait > 0 {
+			select {
+			case <-o.clock.After(wait):
+			case <-o.ctx.Done():
+				continue
+			}
+		}
+
+		var (
+			m   *tg.Message
+			err error
+		)
+
+		if job.Send != nil {
+			m, err = o.sender.SendRendered(o.ctx, *job.Send, job.Renderings)
+		} else {
+			m, err = o.sender.EditRendered(o.ctx, *job.Edit, job.Renderings)
+		}
+
+		if o.ctx.Err() != nil {
+			// stays queued for the next start
+			o.mu.Lock()
+			o.block(l, o.ctx.Err())
+			o.mu.Unlock()
+
+			continue
+		}
+
+		o.settle(l, e, m, err)
+	}
+}
+
+// settle - records the outcome of an attempt at the head job of a lane.
+func (o *Outbox) settle(l *lane, e *entry, m *tg.Message, err error) {
+	log := o.cfg.Logger
+
+	o.mu.Lock()
+
+	job := e.job
+	job.Attempts++
+
+	var (
+		background bool
+		storeErr   error
+	)
+
+	switch {
+	case err == nil:
+		storeErr = o.store.Delete(bucketOutbox, job.ID)
+		background = !o.notify(e, result{m: m})
+
+		o.latency.Observe(o.clock.Now().Sub(job.Created).Seconds())
+	case retryable(err) && job.Attempts < o.cfg.MaxAttempts:
+		job.LastError = err.Error()
+		job.NextAttempt = o.clock.Now().Add(o.backoff(job.Attempts))
+		storeErr = putJob(o.store, bucketOutbox, job)
+
+		o.block(l, err)
+		o.mu.Unlock()
+
+		log.Warn("delivery failed, will retry", "chat", job.Chat, "job", job.ID, "attempt", job.Attempts, "retry_at", job.NextAttempt, "error", err)
+
+		if storeErr != nil {
+			log.Error("outbox not saved", "job", job.ID, "error", storeErr)
+		}
+
+		return
+	case !retryable(err) && o.notify(e, result{err: err}):
+		// the sender handles the error
+		storeErr = o.store.Delete(bucketOutbox, job.ID)
+	case errors.Is(err, tg.ErrMessageNotModified):
+		storeErr = o.store.Delete(bucketOutbox, job.ID)
+	default:
+		job.LastError = err.Error()
+		job.NextAttempt = time.Time{}
+		storeErr = errors.Join(putJob(o.store, bucketDeadLetters, job), o.store.Delete(bucketOutbox, job.ID))
+		o.notify(e, result{err: err})
+		o.deadLetters.Inc()
+
+		log.Error("delivery failed, moved to dead letters", "chat", job.Chat, "job", job.ID, "attempts", job.Attempts, "error", err)
+	}
+
+	l.jobs = l.jobs[1:]
+	l.blocked = false
+
+	o.mu.Unlock()
+
+	if storeErr != nil {
+		log.Error("outbox not saved", "job", job.ID, "error", storeErr)
+	}
+
+	if background && o.cfg.Delivered != nil && job.Tag != "" {
+		o.cfg.Delivered(job.Tag, m)
+	}
+}
+
+// block - marks a lane as waiting for a retry and stops its senders
+// from waiting; the caller holds o.mu.
+func (o *Outbox) block(l *lane, cause error) {
+	l.blocked = true
+
+	err := ErrQueued
+	if cause != nil {
+		err = fmt.Errorf("%w: %w", ErrQueued, cause)
+	}
+
+	for _, e := range l.jobs {
+		o.notify(e, result{err: err})
+	}
+}
+
+// notify - passes the result to the sender of a job if it still waits;
+// the caller holds o.mu.
+func (o *Outbox) notify(e *entry, r result) bool {
+	if e.done == nil {
+		return false
+	}
+
+	e.done <- r
+	e.done = nil
+
+	return true
+}
+
+// backoff - returns the delay after the given number of attempts.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.cfg.BaseBackoff
+
+	for i := 1; i < attempts && d < o.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, o.cfg.MaxBackoff)
+}
+
+// retryable - reports whether a failed attempt may succeed later:
+// rate limits, server and transient transport errors.
+func retryable(err error) bool {
+	e, ok := tg.AsError(err)
+	if !ok {
+		return transient(err)
+	}
+
+	return errors.Is(e, tg.ErrTooManyRequests) || errors.Is(e, tg.ErrServer)
+}
+
+// DeadLetters - returns the jobs that ran out of attempts, oldest first.
+func DeadLetters(st store.Store) ([]Job, error) {
+	var jobs []Job
+
+	err := st.ForEach(bucketDeadLetters, func(_ string, v []byte) error {
+		var job Job
+		if err := json.Unmarshal(v, &job); err != nil {
+			return err
+		}
+
+		jobs = append(jobs, job)
+
+		return nil
+	})
+
+	return jobs, err
+}
+
+// ReplayDeadLetters - queues the dead letters with the ids, or all of
+// them if none are given, for delivery on the next start of an outbox.
+// It returns the number of letters queued.
+func ReplayDeadLetters(st store.Store, ids ...string) (int, error) {
+	jobs, err := selectDeadLetters(st, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	seq, err := loadSeq(st)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, job := range jobs {
+		dead := job.ID
+
+		seq++
+		job.ID = jobID(seq)
+		job.Attempts = 0
+		job.LastError = ""
+
+		err := errors.Join(
+			st.Put(bucketOutboxMeta, keySeq, []byte(strconv.FormatUint(seq, 10))),
+			putJob(st, bucketOutbox, &job),
+			st.Delete(bucketDeadLetters, dead),
+		)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(jobs), nil
+}
+
+// PurgeDeadLetters - deletes the dead letters with the ids, or all of
+// them if none are given. It returns the number of letters deleted.
+func PurgeDeadLetters(st store.Store, ids ...string) (int, error) {
+	jobs, err := selectDeadLetters(st, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, job := range jobs {
+		if err := st.Delete(bucketDeadLetters, job.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(jobs), nil
+}
+
+// selectDeadLetters - returns the dead letters with the ids, or all.
+func selectDeadLetters(st store.Store, ids []string) ([]Job, error) {
+	jobs, err := DeadLetters(st)
+	if err != nil || len(ids) == 0 {
+		return jobs, err
+	}
+
+	byID := make(map[string]Job, len(jobs))
+	for _, job := range jobs {
+		byID[job.ID] = job
+	}
+
+	var out []Job
+
+	for _, id := range ids {
+		job, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("dead letter %q not found", id)
+		}
+
+		out = append(out, job)
+	}
+
+	return out, nil
+}
+
+// jobID - formats a sequence number so that ids sort in queue order.
+func jobID(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+func loadSeq(st store.Store) (uint64, error) {
+	b, err := st.Get(bucketOutboxMeta, keySeq)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("outbox: %w", err)
+	}
+
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+func putJob(st store.Store, bucket string, job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return st.Put(bucket, job.ID, b)
+}
@@ -0,0 +1,348 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/metrics"
+	"github.com/schors/jsm2tg/store"
+	"github.com/schors/jsm2tg/tg"
+)
+
+var (
+	errGateway  = &tg.Error{Method: "sendMessage", Code: 502, Description: "Bad Gateway"}
+	errNoChat   = &tg.Error{Method: "sendMessage", Code: 400, Description: "Bad Request: chat not found"}
+	errNetwork  = &net.OpError{Op: "write", Net: "tcp", Err: errors.New("connection reset by peer")}
+	outboxEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// faultySender - a sender failing the attempts at a text with the
+// injected errors, in order, then delivering it.
+type faultySender struct {
+	mu     sync.Mutex
+	faults map[string][]error
+	sent   []string // "chat:text"
+}
+
+func newFaultySender() *faultySender {
+	return &faultySender{faults: make(map[string][]error)}
+}
+
+func (f *faultySender) inject(text string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults[text] = append(f.faults[text], errs...)
+}
+
+func (f *faultySender) attempt(chat int64, rr []Rendering) (*tg.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	text := rr[0].Text
+
+	if errs := f.faults[text]; len(errs) > 0 {
+		f.faults[text] = errs[1:]
+
+		return nil, errs[0]
+	}
+
+	f.sent = append(f.sent, strconv.FormatInt(chat, 10)+":"+text)
+
+	return &tg.Message{MessageID: len(f.sent), Chat: tg.Chat{ID: chat}}, nil
+}
+
+func (f *faultySender) SendRendered(_ context.Context, p tg.SendMessageParams, rr []Rendering) (*tg.Message, error) {
+	return f.attempt(p.ChatID, rr)
+}
+
+func (f *faultySender) EditRendered(_ context.Context, p tg.EditMessageTextParams, rr []Rendering) (*tg.Message, error) {
+	return f.attempt(p.ChatID, rr)
+}
+
+func (f *faultySender) delivered() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return strings.Join(f.sent, " ")
+}
+
+// eventually - waits for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func queued(t *testing.T, st store.Store) int {
+	t.Helper()
+
+	n := 0
+	if err := st.ForEach(bucketOutbox, func(string, []byte) error { n++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func send(ctx context.Context, o *Outbox, chat int64, text string) (*tg.Message, error) {
+	return o.SendRendered(ctx, tg.SendMessageParams{ChatID: chat}, []Rendering{{Name: "plain", Text: text}})
+}
+
+type tags struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (tt *tags) add(tag string, m *tg.Message) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	tt.got = append(tt.got, tag+"#"+strconv.Itoa(m.MessageID))
+}
+
+func (tt *tags) String() string {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	return strings.Join(tt.got, " ")
+}
+
+func TestOutboxRetry(t *testing.T) {
+	fs := newFaultySender()
+	st := store.NewMemory()
+	clk := clock.NewFake(outboxEpoch)
+	delivered := &tags{}
+	reg := metrics.NewRegistry()
+
+	o, err := NewOutbox(fs, st, OutboxConfig{Delivered: delivered.add, Metrics: reg}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Ready(); err == nil {
+		t.Errorf("Ready() before Run = nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go o.Run(ctx)
+
+	eventually(t, "Run", func() bool { return o.Ready() == nil })
+
+	if m, err := send(ctx, o, 1, "a"); err != nil || m.MessageID != 1 {
+		t.Fatalf("a: %+v, %v", m, err)
+	}
+
+	fs.inject("b", errGateway, errNetwork)
+
+	if _, err := send(WithTag(ctx, "b"), o, 1, "b"); !errors.Is(err, ErrQueued) || !errors.Is(err, tg.ErrServer) {
+		t.Fatalf("b: error = %v, want %v", err, ErrQueued)
+	}
+
+	// the chat waits behind b, other chats don't
+	if _, err := send(WithTag(ctx, "c"), o, 1, "c"); !errors.Is(err, ErrQueued) {
+		t.Fatalf("c: error = %v, want %v", err, ErrQueued)
+	}
+
+	if _, err := send(ctx, o, 2, "x"); err != nil {
+		t.Fatalf("x: %v", err)
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Second)
+	clk.BlockUntil(1) // failed again, retried after twice the backoff
+
+	if got := fs.delivered(); got != "1:a 2:x" {
+		t.Errorf("delivered = %q before the retry", got)
+	}
+
+	if n := o.Depth(); n != 2 {
+		t.Errorf("Depth() = %d before the retry, want 2", n)
+	}
+
+	clk.Advance(time.Minute)
+
+	eventually(t, "retries", func() bool { return delivered.String() == "b#3 c#4" })
+
+	if got := fs.delivered(); got != "1:a 2:x 1:b 1:c" {
+		t.Errorf("delivered = %q", got)
+	}
+
+	if n := queued(t, st); n != 0 {
+		t.Errorf("%d jobs left queued", n)
+	}
+
+	var scrape strings.Builder
+	reg.Write(&scrape)
+
+	// b and c waited for 90s
+	for _, want := range []string{"jsm2tg_delivery_latency_seconds_count 4\n", "jsm2tg_delivery_latency_seconds_sum 180\n", "jsm2tg_outbox_queue_depth 0\n"} {
+		if !strings.Contains(scrape.String(), want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, scrape.String())
+		}
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+	fs := newFaultySender()
+	st := store.NewMemory()
+	clk := clock.NewFake(outboxEpoch)
+
+	o, err := NewOutbox(fs, st, OutboxConfig{MaxAttempts: 2}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		o.Run(ctx)
+	}()
+
+	eventually(t, "Run", func() bool { o.mu.Lock(); defer o.mu.Unlock(); return o.ctx != nil })
+
+	// errors the sender can handle are returned, not dead-lettered
+	fs.inject("p", errNoChat)
+
+	if _, err := send(ctx, o, 1, "p"); !errors.Is(err, tg.ErrChatNotFound) {
+		t.Errorf("p: error = %v, want %v", err, tg.ErrChatNotFound)
+	}
+
+	fs.inject("d", errGateway, errGateway)
+	fs.inject("f", errNetwork, errNetwork)
+
+	for _, m := range []struct {
+		chat int64
+		text string
+	}{{1, "d"}, {3, "f"}} {
+		if _, err := send(ctx, o, m.chat, m.text); !errors.Is(err, ErrQueued) {
+			t.Fatalf("%s: error = %v, want %v", m.text, err, ErrQueued)
+		}
+	}
+
+	clk.BlockUntil(2)
+	clk.Advance(30 * time.Second)
+
+	eventually(t, "dead letters", func() bool { dl, _ := DeadLetters(st); return len(dl) == 2 })
+
+	// the chat goes on after a dead letter
+	if _, err := send(ctx, o, 1, "e"); err != nil {
+		t.Errorf("e: %v", err)
+	}
+
+	cancel()
+	<-done
+
+	dl, err := DeadLetters(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dl[0].Text() != "d" || dl[0].Attempts != 2 || !strings.Contains(dl[0].LastError, "502") || dl[1].Text() != "f" {
+		t.Errorf("dead letters = %+v", dl)
+	}
+
+	if _, err := PurgeDeadLetters(st, "nope"); err == nil {
+		t.Error("PurgeDeadLetters of an unknown id succeeded")
+	}
+
+	if n, err := ReplayDeadLetters(st, dl[0].ID); n != 1 || err != nil {
+		t.Errorf("ReplayDeadLetters = %d, %v", n, err)
+	}
+
+	if n, err := PurgeDeadLetters(st); n != 1 || err != nil {
+		t.Errorf("PurgeDeadLetters = %d, %v", n, err)
+	}
+
+	if dl, _ := DeadLetters(st); len(dl) != 0 {
+		t.Errorf("dead letters left: %+v", dl)
+	}
+
+	// replayed letters are delivered on the next start
+	o, err = NewOutbox(fs, st, OutboxConfig{}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	go o.Run(ctx)
+
+	eventually(t, "replay", func() bool { return queued(t, st) == 0 })
+
+	if got := fs.delivered(); got != "1:e 1:d" {
+		t.Errorf("delivered = %q", got)
+	}
+}
+
+func TestOutboxRestart(t *testing.T) {
+	fs := newFaultySender()
+	st := store.NewMemory()
+	clk := clock.NewFake(outboxEpoch)
+
+	// a process stopping before it could deliver anything
+	o, err := NewOutbox(fs, st, OutboxConfig{}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for i, m := range []struct {
+		chat int64
+		text string
+	}{{1, "r1"}, {2, "s1"}, {1, "r2"}} {
+		if _, err := send(WithTag(stopped, m.text), o, m.chat, m.text); !errors.Is(err, context.Canceled) {
+			t.Fatalf("%d: error = %v", i, err)
+		}
+	}
+
+	delivered := &tags{}
+
+	o, err = NewOutbox(fs, st, OutboxConfig{Delivered: delivered.add}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go o.Run(ctx)
+
+	eventually(t, "restart", func() bool { return queued(t, st) == 0 })
+
+	if got := fs.delivered(); !strings.Contains(got, "1:r1") || strings.Index(got, "1:r1") > strings.Index(got, "1:r2") || !strings.Contains(got, "2:s1") {
+		t.Errorf("delivered = %q", got)
+	}
+
+	eventually(t, "Delivered", func() bool { return len(strings.Fields(delivered.String())) == 3 })
+
+	// new jobs continue the sequence
+	if m, err := send(ctx, o, 1, "r3"); err != nil || m.MessageID != 4 {
+		t.Errorf("r3: %+v, %v", m, err)
+	}
+
+	if o.seq != 4 {
+		t.Errorf("seq = %d, want 4", o.seq)
+	}
+}
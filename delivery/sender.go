@@ -0,0 +1,190 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/metrics"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Bot - the subset of the Bot API used for delivery; *tg.Client implements it.
+type Bot interface {
+	SendMessage(ctx context.Context, p tg.SendMessageParams) (*tg.Message, error)
+	EditMessageText(ctx context.Context, p tg.EditMessageTextParams) (*tg.Message, error)
+}
+
+// Config - delivery limits and retry policy. Zero values select the defaults.
+type Config struct {
+	PerChatRate  float64
+	PerChatBurst int
+	GlobalRate   float64
+	GlobalBurst  int
+
+	MaxRetries  int           // default 5, negative for none
+	BaseBackoff time.Duration // default 1s, doubled on every 5xx
+	MaxBackoff  time.Duration // default 1m
+
+	Logger  *slog.Logger      // default slog.Default()
+	Metrics *metrics.Registry // optional
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 5
+	}
+
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = time.Second
+	}
+
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = time.Minute
+	}
+
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+
+	return c
+}
+
+// Sender - rate-limited, retrying delivery on top of a Bot.
+// Sender is safe for concurrent use.
+type Sender struct {
+	bot     Bot
+	clock   clock.Clock
+	limiter *Limiter
+	cfg     Config
+
+	sent        *metrics.Counter
+	failed      *metrics.Counter
+	rateLimited *metrics.Counter
+	fallbacks   *metrics.Counter
+}
+
+// NewSender - creates a sender. A nil clock selects the wall clock.
+func NewSender(bot Bot, cfg Config, clk clock.Clock) *Sender {
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	cfg = cfg.withDefaults()
+
+	return &Sender{
+		bot:     bot,
+		clock:   clk,
+		limiter: NewLimiter(clk, cfg.PerChatRate, cfg.PerChatBurst, cfg.GlobalRate, cfg.GlobalBurst),
+		cfg:     cfg,
+
+		sent:        cfg.Metrics.Counter("jsm2tg_messages_sent_total", "Messages sent or edited, by chat.", "chat"),
+		failed:      cfg.Metrics.Counter("jsm2tg_messages_failed_total", "Messages not sent or edited after the retries, by chat.", "chat"),
+		rateLimited: cfg.Metrics.Counter("jsm2tg_telegram_rate_limited_total", "Bot API requests answered with 429 Too Many Requests, by chat.", "chat"),
+		fallbacks:   cfg.Metrics.Counter("jsm2tg_conversion_fallbacks_total", "Renderings Telegram rejected the markup of, so the next one was tried, by rendering.", "rendering"),
+	}
+}
+
+// Do - runs fn for the chat under the rate limits, honouring retry_after
+// on 429 and backing off exponentially on 5xx, network errors and
+// timeouts. Other errors are returned immediately.
+func (s *Sender) Do(ctx context.Context, chatID int64, fn func(context.Context) error) error {
+	var err error
+
+	backoff := s.cfg.BaseBackoff
+	retries := max(s.cfg.MaxRetries, 0)
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if err = s.limiter.Wait(ctx, chatID); err != nil {
+			return err
+		}
+
+		err = fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var wait time.Duration
+
+		e, ok := tg.AsError(err)
+
+		switch {
+		case ok && errors.Is(e, tg.ErrTooManyRequests):
+			s.rateLimited.Inc(chatLabel(chatID))
+
+			wait = max(e.RetryAfter(), time.Second)
+			s.limiter.Pause(chatID, wait)
+
+			continue // the limiter does the waiting
+		case ok && errors.Is(e, tg.ErrServer), !ok && transient(err):
+			wait = backoff
+			backoff = min(backoff*2, s.cfg.MaxBackoff)
+		default:
+			return err
+		}
+
+		if attempt == retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.clock.After(wait):
+		}
+	}
+
+	return err
+}
+
+// SendMessage - sends a message through Do.
+func (s *Sender) SendMessage(ctx context.Context, p tg.SendMessageParams) (*tg.Message, error) {
+	var m *tg.Message
+
+	err := s.Do(ctx, p.ChatID, func(ctx context.Context) error {
+		var err error
+
+		m, err = s.bot.SendMessage(ctx, p)
+
+		return err
+	})
+
+	return m, err
+}
+
+// EditMessageText - edits a message through Do.
+func (s *Sender) EditMessageText(ctx context.Context, p tg.EditMessageTextParams) (*tg.Message, error) {
+	var m *tg.Message
+
+	err := s.Do(ctx, p.ChatID, func(ctx context.Context) error {
+		var err error
+
+		m, err = s.bot.EditMessageText(ctx, p)
+
+		return err
+	})
+
+	return m, err
+}
+
+// transient - reports whether an error outside the Bot API may pass on
+// a retry: network errors, timeouts and responses cut short.
+func transient(err error) bool {
+	var ne net.Error
+
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// chatLabel - formats a chat id as a metric label value.
+func chatLabel(chatID int64) string {
+	return strconv.FormatInt(chatID, 10)
+}
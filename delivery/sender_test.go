@@ -0,0 +1,247 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/metrics"
+	"github.com/schors/jsm2tg/tg"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// stub - a Bot API stand-in answering with the queued responses in order,
+// then with success.
+func stub(t *testing.T, responses ...string) (*tg.Client, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1)) - 1
+		if n < len(responses) {
+			io.WriteString(w, responses[n])
+
+			return
+		}
+
+		io.WriteString(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1,"type":"private"},"date":1}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	return tg.NewClient("T", tg.WithBaseURL(srv.URL)), &calls
+}
+
+func TestLimiterPerChat(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := NewLimiter(clk, 1, 1, 30, 30)
+
+	if err := l.Wait(context.Background(), 1); err != nil {
+		t.Fatalf("first Wait: %v", err)
+	}
+
+	// another chat is not affected
+	if err := l.Wait(context.Background(), 2); err != nil {
+		t.Fatalf("other chat Wait: %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- l.Wait(context.Background(), 1) }()
+
+	clk.BlockUntil(1)
+
+	select {
+	case <-done:
+		t.Fatal("second Wait for the same chat returned before a second passed")
+	default:
+	}
+
+	clk.Advance(time.Second)
+
+	if err := <-done; err != nil {
+		t.Fatalf("second Wait: %v", err)
+	}
+}
+
+func TestLimiterGlobal(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := NewLimiter(clk, 1, 1, 2, 2)
+
+	for chat := int64(1); chat <= 2; chat++ {
+		if err := l.Wait(context.Background(), chat); err != nil {
+			t.Fatalf("Wait(%d): %v", chat, err)
+		}
+	}
+
+	done := make(chan error)
+	go func() { done <- l.Wait(context.Background(), 3) }()
+
+	clk.BlockUntil(1)
+	clk.Advance(500 * time.Millisecond)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Wait(3): %v", err)
+	}
+}
+
+func TestLimiterPause(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := NewLimiter(clk, 1, 1, 30, 30)
+
+	l.Pause(1, 3*time.Second)
+
+	// flood control holds back the other chats too
+	done := make(chan error)
+	go func() { done <- l.Wait(context.Background(), 2) }()
+
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Second)
+
+	select {
+	case <-done:
+		t.Fatal("Wait for another chat returned during the pause")
+	default:
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+}
+
+func TestSenderRetryAfter(t *testing.T) {
+	bot, calls := stub(t,
+		`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 3","parameters":{"retry_after":3}}`,
+	)
+	clk := clock.NewFake(epoch)
+	reg := metrics.NewRegistry()
+	s := NewSender(bot, Config{Metrics: reg}, clk)
+
+	done := make(chan error)
+	go func() {
+		_, err := s.SendMessage(context.Background(), tg.SendMessageParams{ChatID: 1, Text: "x"})
+		done <- err
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Second)
+
+	if calls.Load() != 1 {
+		t.Fatalf("calls before retry_after elapsed = %d, want 1", calls.Load())
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+
+	if err := <-done; err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, want 2", calls.Load())
+	}
+
+	if n := reg.Counter("jsm2tg_telegram_rate_limited_total", "", "chat").Value("1"); n != 1 {
+		t.Errorf("rate limited = %v, want 1", n)
+	}
+}
+
+func TestSenderBackoff(t *testing.T) {
+	bot, calls := stub(t,
+		`{"ok":false,"error_code":502,"description":"Bad Gateway"}`,
+		`{"ok":false,"error_code":502,"description":"Bad Gateway"}`,
+	)
+	clk := clock.NewFake(epoch)
+	s := NewSender(bot, Config{BaseBackoff: time.Second}, clk)
+
+	done := make(chan error)
+	go func() {
+		_, err := s.SendMessage(context.Background(), tg.SendMessageParams{ChatID: 1, Text: "x"})
+		done <- err
+	}()
+
+	// 1s after the first failure, 2s after the second
+	for _, d := range []time.Duration{time.Second, 2 * time.Second} {
+		clk.BlockUntil(1)
+		clk.Advance(d)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+}
+
+func TestSenderNoRetries(t *testing.T) {
+	bot, calls := stub(t, `{"ok":false,"error_code":502,"description":"Bad Gateway"}`)
+	s := NewSender(bot, Config{MaxRetries: -1}, clock.NewFake(epoch))
+
+	if _, err := s.SendMessage(context.Background(), tg.SendMessageParams{ChatID: 1, Text: "x"}); !errors.Is(err, tg.ErrServer) {
+		t.Errorf("SendMessage error = %v, want %v", err, tg.ErrServer)
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestSenderPermanentError(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{"api error", `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`, "chat not found"},
+		{"bad response", `<html>`, "decode response"},
+	}
+
+	for _, tt := range tests {
+		bot, calls := stub(t, tt.response)
+		s := NewSender(bot, Config{}, clock.NewFake(epoch))
+
+		_, err := s.SendMessage(context.Background(), tg.SendMessageParams{ChatID: 1, Text: "x"})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: SendMessage error = %v, want %q", tt.name, err, tt.want)
+		}
+
+		if calls.Load() != 1 {
+			t.Errorf("%s: calls = %d, want 1", tt.name, calls.Load())
+		}
+	}
+}
+
+func TestSenderContextCancel(t *testing.T) {
+	bot, _ := stub(t,
+		`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 30","parameters":{"retry_after":30}}`,
+	)
+	clk := clock.NewFake(epoch)
+	s := NewSender(bot, Config{}, clk)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		_, err := s.SendMessage(ctx, tg.SendMessageParams{ChatID: 1, Text: "x"})
+		done <- err
+	}()
+
+	clk.BlockUntil(1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("SendMessage error = %v, want %v", err, context.Canceled)
+	}
+}
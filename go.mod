@@ -1,3 +1,10 @@
 module github.com/schors/jsm2tg
 
 go 1.24.1
+
+require (
+	go.etcd.io/bbolt v1.4.3
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.40.0 // indirect
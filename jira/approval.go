@@ -0,0 +1,86 @@
+package jira
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Approval decisions.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDeclined = "declined"
+)
+
+// Answers of AnswerApproval.
+const (
+	ApprovalApprove = "approve"
+	ApprovalDecline = "decline"
+)
+
+// Approver - an approver of a JSM approval and their decision.
+type Approver struct {
+	User     User   `json:"approver"`
+	Decision string `json:"approverDecision"`
+}
+
+// Approval - a JSM approval of a request. CanAnswer reports whether
+// the calling user may answer it.
+type Approval struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	FinalDecision string     `json:"finalDecision"`
+	CanAnswer     bool       `json:"canAnswerApproval"`
+	Approvers     []Approver `json:"approvers"`
+}
+
+// Pending - reports whether the approval awaits a decision.
+func (a *Approval) Pending() bool {
+	return a.FinalDecision == ApprovalPending
+}
+
+// Approver - returns the approver with the account id (Cloud) or
+// user name (Server/DC).
+func (a *Approval) Approver(accountID, username string) (Approver, bool) {
+	for _, ap := range a.Approvers {
+		if accountID != "" && ap.User.AccountID == accountID || username != "" && ap.User.Name == username {
+			return ap, true
+		}
+	}
+
+	return Approver{}, false
+}
+
+// ParseApprovals - decodes a JSM approvals field value. It returns
+// false if the value isn't a list of approvals.
+func ParseApprovals(raw json.RawMessage) ([]Approval, bool) {
+	var aa []Approval
+	if json.Unmarshal(raw, &aa) != nil || len(aa) == 0 {
+		return nil, false
+	}
+
+	for _, a := range aa {
+		if a.ID == "" || a.FinalDecision == "" {
+			return nil, false
+		}
+	}
+
+	return aa, true
+}
+
+// Approvals - returns the approvals found in the custom fields, by id.
+func (f *Fields) Approvals() []Approval {
+	var out []Approval
+
+	for _, raw := range f.Custom {
+		if aa, ok := ParseApprovals(raw); ok {
+			out = append(out, aa...)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+
+	return out
+}
@@ -0,0 +1,596 @@
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPageSize - the page size requested by the pagination helpers.
+const DefaultPageSize = 50
+
+// Client - a Jira / Jira Service Management REST API client.
+// Client is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	auth    func(*http.Request)
+	cloud   bool
+}
+
+// ClientOption - configures a Client.
+type ClientOption func(*Client)
+
+// WithBasicAuth - authenticates with a user name (email on Jira Cloud)
+// and an API token or password.
+func WithBasicAuth(user, token string) ClientOption {
+	return func(c *Client) {
+		c.auth = func(r *http.Request) {
+			r.SetBasicAuth(user, token)
+		}
+	}
+}
+
+// WithToken - authenticates with a personal access token (Jira Server/DC).
+func WithToken(pat string) ClientOption {
+	return func(c *Client) {
+		c.auth = func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+pat)
+		}
+	}
+}
+
+// WithHTTPClient - sets the HTTP client used for requests.
+func WithHTTPClient(h *http.Client) ClientOption {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// WithCloud - tells whether the instance is Jira Cloud, whose API
+// differs in places; by default instances on atlassian.net are.
+func WithCloud(cloud bool) ClientOption {
+	return func(c *Client) {
+		c.cloud = cloud
+	}
+}
+
+// IsCloudURL - reports whether baseURL is a Jira Cloud site.
+func IsCloudURL(baseURL string) bool {
+	u, err := url.Parse(baseURL)
+
+	return err == nil && strings.HasSuffix(strings.ToLower(u.Hostname()), ".atlassian.net")
+}
+
+// NewClient - creates a client for the Jira instance at baseURL.
+func NewClient(baseURL string, opts ...ClientOption) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+		cloud:   IsCloudURL(baseURL),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// IssueOptions - options of GetIssue.
+type IssueOptions struct {
+	Fields   []string // the fields to return; all by default
+	Rendered bool     // also return the fields rendered to HTML
+}
+
+// GetIssue - returns an issue.
+func (c *Client) GetIssue(ctx context.Context, key string, o IssueOptions) (*Issue, error) {
+	q := url.Values{}
+	if len(o.Fields) > 0 {
+		q.Set("fields", strings.Join(o.Fields, ","))
+	}
+
+	if o.Rendered {
+		q.Set("expand", "renderedFields")
+	}
+
+	var is Issue
+	if err := c.do(ctx, http.MethodGet, issuePath(key, ""), q, nil, &is); err != nil {
+		return nil, err
+	}
+
+	return &is, nil
+}
+
+// AddComment - adds a comment to a JSM request. Internal comments
+// are only visible to agents.
+func (c *Client) AddComment(ctx context.Context, key, body string, internal bool) (*Comment, error) {
+	req := struct {
+		Body   string `json:"body"`
+		Public bool   `json:"public"`
+	}{body, !internal}
+
+	var resp struct {
+		ID     string `json:"id"`
+		Body   string `json:"body"`
+		Public bool   `json:"public"`
+	}
+
+	if err := c.do(ctx, http.MethodPost, requestPath(key, "/comment"), nil, req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &Comment{ID: resp.ID, Body: resp.Body, JSDPublic: &resp.Public}, nil
+}
+
+// Transition - a workflow transition available for an issue.
+type Transition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	To   Status `json:"to"`
+}
+
+// Transitions - returns the transitions the user can perform on an issue.
+func (c *Client) Transitions(ctx context.Context, key string) ([]Transition, error) {
+	var resp struct {
+		Transitions []Transition `json:"transitions"`
+	}
+
+	if err := c.do(ctx, http.MethodGet, issuePath(key, "/transitions"), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Transitions, nil
+}
+
+// DoTransition - moves an issue through a workflow transition.
+func (c *Client) DoTransition(ctx context.Context, key, transitionID string) error {
+	req := map[string]any{"transition": map[string]string{"id": transitionID}}
+
+	return c.do(ctx, http.MethodPut, issuePath(key, "/transitions"), nil, req, nil)
+}
+
+// Assign - assigns an issue to the user identified by AccountID (Cloud)
+// or Name (Server/DC). A zero user unassigns the issue.
+func (c *Client) Assign(ctx context.Context, key string, u User) error {
+	var req map[string]any
+
+	switch {
+	case u.AccountID != "":
+		req = map[string]any{"accountId": u.AccountID}
+	case u.Name != "":
+		req = map[string]any{"name": u.Name}
+	default:
+		req = map[string]any{"accountId": nil}
+	}
+
+	return c.do(ctx, http.MethodPut, issuePath(key, "/assignee"), nil, req, nil)
+}
+
+// EditIssue - sets issue fields, e.g. {"priority": {"name": "High"}}.
+func (c *Client) EditIssue(ctx context.Context, key string, fields map[string]any) error {
+	return c.do(ctx, http.MethodPut, issuePath(key, ""), nil, map[string]any{"fields": fields}, nil)
+}
+
+// Attachments - returns the files attached to an issue.
+func (c *Client) Attachments(ctx context.Context, key string) ([]Attachment, error) {
+	is, err := c.GetIssue(ctx, key, IssueOptions{Fields: []string{"attachment"}})
+	if err != nil {
+		return nil, err
+	}
+
+	return is.Fields.Attachments, nil
+}
+
+// SearchOptions - options of Search.
+type SearchOptions struct {
+	Fields     []string
+	Rendered   bool
+	StartAt    int
+	MaxResults int // DefaultPageSize if 0
+
+	// NextPageToken - the page to fetch on Jira Cloud, from the previous
+	// page; without it Cloud pages are skipped up to StartAt.
+	NextPageToken string
+}
+
+// SearchResult - a page of search results.
+type SearchResult struct {
+	StartAt    int     `json:"startAt"`
+	MaxResults int     `json:"maxResults"`
+	Total      int     `json:"total"` // 0 on Jira Cloud, which doesn't count
+	Issues     []Issue `json:"issues"`
+
+	// the pagination of Jira Cloud
+	NextPageToken string `json:"nextPageToken"`
+	IsLast        bool   `json:"isLast"`
+
+	cloud bool
+}
+
+// Next - returns the options fetching the next page, and false on the last page.
+func (r *SearchResult) Next(o SearchOptions) (SearchOptions, bool) {
+	o.StartAt = r.StartAt + len(r.Issues)
+
+	if r.cloud {
+		o.NextPageToken = r.NextPageToken
+
+		return o, !r.IsLast && r.NextPageToken != ""
+	}
+
+	return o, len(r.Issues) > 0 && o.StartAt < r.Total
+}
+
+// Search - returns a page of the issues matching a JQL query. Jira Cloud
+// is searched with GET /rest/api/3/search/jql, which pages by token and
+// returns descriptions in the Atlassian Document Format, so they are
+// left out there; Server/DC with GET /rest/api/2/search.
+func (c *Client) Search(ctx context.Context, jql string, o SearchOptions) (*SearchResult, error) {
+	if o.MaxResults == 0 {
+		o.MaxResults = DefaultPageSize
+	}
+
+	q := url.Values{}
+	q.Set("jql", jql)
+	q.Set("maxResults", strconv.Itoa(o.MaxResults))
+
+	if o.Rendered {
+		q.Set("expand", "renderedFields")
+	}
+
+	if c.cloud {
+		return c.searchCloud(ctx, q, o)
+	}
+
+	q.Set("startAt", strconv.Itoa(o.StartAt))
+
+	if len(o.Fields) > 0 {
+		q.Set("fields", strings.Join(o.Fields, ","))
+	}
+
+	var r SearchResult
+	if err := c.do(ctx, http.MethodGet, "/rest/api/2/search", q, nil, &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// searchCloud - returns a page of search results from Jira Cloud. The
+// pages before StartAt are fetched and skipped unless a page token is
+// given.
+func (c *Client) searchCloud(ctx context.Context, q url.Values, o SearchOptions) (*SearchResult, error) {
+	fields := slices.DeleteFunc(slices.Clone(o.Fields), func(f string) bool { return f == "description" })
+	if len(fields) == 0 {
+		// the default is the issue id only
+		fields = []string{"*all", "-description"}
+	}
+
+	q.Set("fields", strings.Join(fields, ","))
+
+	skip := o.StartAt
+	if o.NextPageToken != "" {
+		q.Set("nextPageToken", o.NextPageToken)
+
+		skip = 0
+	}
+
+	for {
+		var r SearchResult
+		if err := c.do(ctx, http.MethodGet, "/rest/api/3/search/jql", q, nil, &r); err != nil {
+			return nil, err
+		}
+
+		if skip < len(r.Issues) || r.IsLast || r.NextPageToken == "" {
+			r.Issues = r.Issues[min(skip, len(r.Issues)):]
+			r.StartAt = o.StartAt
+			r.MaxResults = o.MaxResults
+			r.cloud = true
+
+			return &r, nil
+		}
+
+		skip -= len(r.Issues)
+		q.Set("nextPageToken", r.NextPageToken)
+	}
+}
+
+// SearchAll - returns up to limit issues matching a JQL query, fetching
+// as many pages as needed. A limit of 0 returns every issue.
+func (c *Client) SearchAll(ctx context.Context, jql string, o SearchOptions, limit int) ([]Issue, error) {
+	var out []Issue
+
+	for {
+		r, err := c.Search(ctx, jql, o)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, r.Issues...)
+
+		if limit > 0 && len(out) >= limit {
+			return out[:limit], nil
+		}
+
+		var more bool
+		if o, more = r.Next(o); !more {
+			return out, nil
+		}
+	}
+}
+
+// SLA - returns the SLA metrics of a JSM request.
+func (c *Client) SLA(ctx context.Context, key string) ([]SLA, error) {
+	values, err := pages[json.RawMessage](ctx, c, requestPath(key, "/sla"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []SLA
+
+	for _, raw := range values {
+		if s, ok := ParseSLA(raw); ok {
+			out = append(out, s)
+		}
+	}
+
+	return out, nil
+}
+
+// Approvals - returns the approvals of a JSM request.
+func (c *Client) Approvals(ctx context.Context, key string) ([]Approval, error) {
+	return pages[Approval](ctx, c, requestPath(key, "/approval"), nil)
+}
+
+// AnswerApproval - approves or declines a JSM approval as the calling
+// user; decision is ApprovalApprove or ApprovalDecline.
+func (c *Client) AnswerApproval(ctx context.Context, key, approvalID, decision string) (*Approval, error) {
+	var a Approval
+	if err := c.do(ctx, http.MethodPost, requestPath(key, "/approval/"+url.PathEscape(approvalID)), nil, map[string]string{"decision": decision}, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// Queue - a JSM service desk queue.
+type Queue struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	JQL  string `json:"jql"`
+}
+
+// Queues - returns the queues of a service desk.
+func (c *Client) Queues(ctx context.Context, serviceDeskID string) ([]Queue, error) {
+	return pages[Queue](ctx, c, serviceDeskPath(serviceDeskID, "/queue"), nil)
+}
+
+// RequestType - a JSM request type customers can raise.
+type RequestType struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// RequestTypes - returns the request types of a service desk.
+func (c *Client) RequestTypes(ctx context.Context, serviceDeskID string) ([]RequestType, error) {
+	return pages[RequestType](ctx, c, serviceDeskPath(serviceDeskID, "/requesttype"), nil)
+}
+
+// CreateRequestParams - parameters of CreateRequest. Description is Jira
+// markup; RaiseOnBehalfOf optionally names the customer (an account id
+// on Cloud, a user name on Server/DC).
+type CreateRequestParams struct {
+	ServiceDeskID   string
+	RequestTypeID   string
+	Summary         string
+	Description     string
+	RaiseOnBehalfOf string
+}
+
+// CreatedRequest - the request created by CreateRequest.
+type CreatedRequest struct {
+	IssueID  string `json:"issueId"`
+	IssueKey string `json:"issueKey"`
+}
+
+// CreateRequest - raises a JSM customer request.
+func (c *Client) CreateRequest(ctx context.Context, p CreateRequestParams) (*CreatedRequest, error) {
+	req := map[string]any{
+		"serviceDeskId": p.ServiceDeskID,
+		"requestTypeId": p.RequestTypeID,
+		"requestFieldValues": map[string]string{
+			"summary":     p.Summary,
+			"description": p.Description,
+		},
+	}
+
+	if p.RaiseOnBehalfOf != "" {
+		req["raiseOnBehalfOf"] = p.RaiseOnBehalfOf
+	}
+
+	var r CreatedRequest
+	if err := c.do(ctx, http.MethodPost, "/rest/servicedeskapi/request", nil, req, &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// AttachTemporaryFile - uploads a file to a service desk and returns its
+// temporary attachment id, to be attached to a request with AddAttachments.
+func (c *Client) AttachTemporaryFile(ctx context.Context, serviceDeskID, name string, r io.Reader) (string, error) {
+	var body bytes.Buffer
+
+	w := multipart.NewWriter(&body)
+
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(part, r); err != nil {
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		TemporaryAttachments []struct {
+			TemporaryAttachmentID string `json:"temporaryAttachmentId"`
+		} `json:"temporaryAttachments"`
+	}
+
+	path := serviceDeskPath(serviceDeskID, "/attachTemporaryFile")
+	if err := c.send(ctx, http.MethodPost, path, nil, &body, w.FormDataContentType(), &resp); err != nil {
+		return "", err
+	}
+
+	if len(resp.TemporaryAttachments) == 0 {
+		return "", fmt.Errorf("jira: POST %s: no temporary attachment returned", path)
+	}
+
+	return resp.TemporaryAttachments[0].TemporaryAttachmentID, nil
+}
+
+// AddAttachments - attaches temporary files to a JSM request;
+// public attachments are visible to customers.
+func (c *Client) AddAttachments(ctx context.Context, key string, temporaryIDs []string, public bool) error {
+	req := struct {
+		TemporaryAttachmentIDs []string `json:"temporaryAttachmentIds"`
+		Public                 bool     `json:"public"`
+	}{temporaryIDs, public}
+
+	return c.do(ctx, http.MethodPost, requestPath(key, "/attachment"), nil, req, nil)
+}
+
+// page - a page of the JSM servicedesk API.
+type page[T any] struct {
+	Start      int  `json:"start"`
+	Limit      int  `json:"limit"`
+	Size       int  `json:"size"`
+	IsLastPage bool `json:"isLastPage"`
+	Values     []T  `json:"values"`
+}
+
+// pages - fetches every page of a JSM servicedesk API list.
+func pages[T any](ctx context.Context, c *Client, path string, q url.Values) ([]T, error) {
+	if q == nil {
+		q = url.Values{}
+	}
+
+	q.Set("limit", strconv.Itoa(DefaultPageSize))
+
+	var out []T
+
+	for start := 0; ; {
+		q.Set("start", strconv.Itoa(start))
+
+		var p page[T]
+		if err := c.do(ctx, http.MethodGet, path, q, nil, &p); err != nil {
+			return nil, err
+		}
+
+		out = append(out, p.Values...)
+
+		if p.IsLastPage || len(p.Values) == 0 {
+			return out, nil
+		}
+
+		start += len(p.Values)
+	}
+}
+
+func issuePath(key, suffix string) string {
+	return "/rest/api/2/issue/" + url.PathEscape(key) + suffix
+}
+
+func requestPath(key, suffix string) string {
+	return "/rest/servicedeskapi/request/" + url.PathEscape(key) + suffix
+}
+
+func serviceDeskPath(id, suffix string) string {
+	return "/rest/servicedeskapi/servicedesk/" + url.PathEscape(id) + suffix
+}
+
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, in, out any) error {
+	if in == nil {
+		return c.send(ctx, method, path, q, nil, "", out)
+	}
+
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	return c.send(ctx, method, path, q, bytes.NewReader(b), "application/json", out)
+}
+
+// send - performs a request with a body of the content type
+// and decodes the JSON response into out.
+func (c *Client) send(ctx context.Context, method, path string, q url.Values, body io.Reader, contentType string, out any) error {
+	u := c.baseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("jira: %s %s: %w", method, path, err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if strings.HasPrefix(contentType, "multipart/") {
+		// uploads are rejected as XSRF without it
+		req.Header.Set("X-Atlassian-Token", "no-check")
+	}
+
+	if c.auth != nil {
+		c.auth(req)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("jira: %s %s: %w", method, path, ctx.Err())
+		}
+
+		return fmt.Errorf("jira: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("jira: %s %s: %w", method, path, err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return newError(method, path, resp, b)
+	}
+
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("jira: %s %s: decode response: %w", method, path, err)
+	}
+
+	return nil
+}
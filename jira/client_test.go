@@ -0,0 +1,453 @@
+package jira
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/jira/jiratest"
+)
+
+func TestClientAddComment(t *testing.T) {
+	js := jiratest.NewServer()
+	defer js.Close()
+
+	c := NewClient(js.URL + "/")
+
+	got, err := c.AddComment(context.Background(), "SD-1", "internal note", true)
+	if err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+
+	if got.ID == "" || !got.Internal() || got.Body != "internal note" {
+		t.Errorf("AddComment = %+v", got)
+	}
+
+	cc := js.Comments()
+	if len(cc) != 1 || cc[0].Issue != "SD-1" || cc[0].Public {
+		t.Errorf("comments = %+v", cc)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  ClientOption
+		want string
+	}{
+		{"basic", WithBasicAuth("bot@example.com", "api-token"), "Basic Ym90QGV4YW1wbGUuY29tOmFwaS10b2tlbg=="},
+		{"token", WithToken("pat"), "Bearer pat"},
+	}
+
+	for _, tt := range tests {
+		var got string
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+
+		_, err := NewClient(srv.URL, tt.opt).AddComment(context.Background(), "SD-1", "x", false)
+
+		srv.Close()
+
+		if err == nil {
+			t.Errorf("%s: expected an error for 401", tt.name)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: Authorization = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fixtureServer - serves testdata/api fixtures by request; routes maps
+// "METHOD /path" (with the start query parameter for pages) to a file.
+func fixtureServer(t *testing.T, routes map[string]string, got *[]string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+
+		switch {
+		case r.URL.Query().Has("startAt"):
+			key += "?startAt=" + r.URL.Query().Get("startAt")
+		case r.URL.Query().Has("start"):
+			key += "?start=" + r.URL.Query().Get("start")
+		case r.URL.Query().Has("nextPageToken"):
+			key += "?nextPageToken=" + r.URL.Query().Get("nextPageToken")
+		}
+
+		if got != nil {
+			b, _ := io.ReadAll(r.Body)
+			*got = append(*got, key+" "+r.URL.RawQuery+" "+string(b))
+		}
+
+		name, ok := routes[key]
+		if !ok {
+			t.Errorf("unexpected request %s", key)
+			http.NotFound(w, r)
+
+			return
+		}
+
+		status := http.StatusOK
+		if strings.HasPrefix(name, "error_") {
+			status, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "error_"), ".json"))
+		}
+
+		if name == "" {
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		b, err := os.ReadFile("testdata/api/" + name)
+		if err != nil {
+			t.Errorf("fixture: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(b)
+	}))
+}
+
+func TestClientGetIssue(t *testing.T) {
+	var got []string
+
+	srv := fixtureServer(t, map[string]string{"GET /rest/api/2/issue/SD-42": "issue.json"}, &got)
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+
+	is, err := c.GetIssue(context.Background(), "SD-42", IssueOptions{Fields: []string{"summary", "description"}, Rendered: true})
+	if err != nil {
+		t.Fatalf("GetIssue: %v", err)
+	}
+
+	if is.Key != "SD-42" || is.Fields.Summary != "VPN is down" || is.Fields.RequestType() != "Report an incident" {
+		t.Errorf("GetIssue = %+v", is)
+	}
+
+	if is.RenderedFields == nil || !strings.Contains(is.RenderedFields.Description, "<b>vpn.example.com</b>") {
+		t.Errorf("RenderedFields = %+v", is.RenderedFields)
+	}
+
+	if want := "expand=renderedFields&fields=summary%2Cdescription"; !strings.Contains(got[0], want) {
+		t.Errorf("request = %q, want query %q", got[0], want)
+	}
+
+	aa, err := c.Attachments(context.Background(), "SD-42")
+	if err != nil {
+		t.Fatalf("Attachments: %v", err)
+	}
+
+	if len(aa) != 1 || aa[0].Filename != "vpn.log" || aa[0].Size != 2048 || aa[0].Created.IsZero() {
+		t.Errorf("Attachments = %+v", aa)
+	}
+}
+
+func TestClientTransitions(t *testing.T) {
+	var got []string
+
+	srv := fixtureServer(t, map[string]string{
+		"GET /rest/api/2/issue/SD-42/transitions": "transitions.json",
+		"PUT /rest/api/2/issue/SD-42/transitions": "",
+		"PUT /rest/api/2/issue/SD-42/assignee":    "",
+		"PUT /rest/api/2/issue/SD-42":             "",
+	}, &got)
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	ctx := context.Background()
+
+	tt, err := c.Transitions(ctx, "SD-42")
+	if err != nil {
+		t.Fatalf("Transitions: %v", err)
+	}
+
+	if len(tt) != 2 || tt[1].ID != "31" || tt[1].To.Name != "Resolved" || tt[1].To.StatusCategory.Key != "done" {
+		t.Errorf("Transitions = %+v", tt)
+	}
+
+	if err := c.DoTransition(ctx, "SD-42", "31"); err != nil {
+		t.Fatalf("DoTransition: %v", err)
+	}
+
+	for _, u := range []User{{AccountID: "acc-bob"}, {Name: "bob"}, {}} {
+		if err := c.Assign(ctx, "SD-42", u); err != nil {
+			t.Fatalf("Assign(%+v): %v", u, err)
+		}
+	}
+
+	if err := c.EditIssue(ctx, "SD-42", map[string]any{"priority": map[string]string{"name": "High"}}); err != nil {
+		t.Fatalf("EditIssue: %v", err)
+	}
+
+	want := []string{
+		`PUT /rest/api/2/issue/SD-42/transitions  {"transition":{"id":"31"}}`,
+		`PUT /rest/api/2/issue/SD-42/assignee  {"accountId":"acc-bob"}`,
+		`PUT /rest/api/2/issue/SD-42/assignee  {"name":"bob"}`,
+		`PUT /rest/api/2/issue/SD-42/assignee  {"accountId":null}`,
+		`PUT /rest/api/2/issue/SD-42  {"fields":{"priority":{"name":"High"}}}`,
+	}
+
+	if strings.Join(got[1:], "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", strings.Join(got[1:], "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestClientSearch(t *testing.T) {
+	tests := []struct {
+		name   string
+		cloud  bool
+		routes map[string]string
+		fields string // the fields requested
+	}{
+		{"server", false, map[string]string{
+			"GET /rest/api/2/search?startAt=0": "search_0.json",
+			"GET /rest/api/2/search?startAt=2": "search_2.json",
+		}, "summary,description"},
+		{"cloud", true, map[string]string{
+			"GET /rest/api/3/search/jql":                  "search_jql_0.json",
+			"GET /rest/api/3/search/jql?nextPageToken=p2": "search_jql_p2.json",
+		}, "summary"},
+	}
+
+	for _, tt := range tests {
+		var got []string
+
+		srv := fixtureServer(t, tt.routes, &got)
+		c := NewClient(srv.URL, WithCloud(tt.cloud))
+		o := SearchOptions{MaxResults: 2, Fields: []string{"summary", "description"}}
+
+		r, err := c.Search(context.Background(), "project = SD", o)
+		if err != nil {
+			t.Fatalf("%s: Search: %v", tt.name, err)
+		}
+
+		if q := strings.Fields(got[0])[2]; !strings.Contains(q, "fields="+url.QueryEscape(tt.fields)+"&") {
+			t.Errorf("%s: query = %s, want fields %s", tt.name, q, tt.fields)
+		}
+
+		if next, more := r.Next(o); !more || next.StartAt != 2 {
+			t.Errorf("%s: Next = %+v, %v", tt.name, next, more)
+		}
+
+		all, err := c.SearchAll(context.Background(), "project = SD", o, 0)
+		if err != nil {
+			t.Fatalf("%s: SearchAll: %v", tt.name, err)
+		}
+
+		if keys := issueKeys(all); keys != "SD-1,SD-2,SD-3" {
+			t.Errorf("%s: SearchAll = %v", tt.name, keys)
+		}
+
+		limited, err := c.SearchAll(context.Background(), "project = SD", o, 1)
+		if err != nil || len(limited) != 1 {
+			t.Errorf("%s: SearchAll with limit 1 = %d issues, %v", tt.name, len(limited), err)
+		}
+
+		// a page by offset, as the list commands fetch them
+		r, err = c.Search(context.Background(), "project = SD", SearchOptions{MaxResults: 2, StartAt: 2})
+		if err != nil || issueKeys(r.Issues) != "SD-3" || r.StartAt != 2 {
+			t.Errorf("%s: Search from 2 = %+v, %v", tt.name, r, err)
+		}
+
+		if _, more := r.Next(o); more {
+			t.Errorf("%s: Next of the last page = true", tt.name)
+		}
+
+		srv.Close()
+	}
+}
+
+func issueKeys(issues []Issue) string {
+	keys := make([]string, 0, len(issues))
+	for _, is := range issues {
+		keys = append(keys, is.Key)
+	}
+
+	return strings.Join(keys, ",")
+}
+
+func TestIsCloudURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://acme.atlassian.net", true},
+		{"https://ACME.atlassian.net/", true},
+		{"https://jira.example.com", false},
+		{"https://atlassian.net.example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsCloudURL(tt.url); got != tt.want {
+			t.Errorf("%s: IsCloudURL() = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestClientSLA(t *testing.T) {
+	srv := fixtureServer(t, map[string]string{
+		"GET /rest/servicedeskapi/request/SD-42/sla?start=0": "sla_0.json",
+		"GET /rest/servicedeskapi/request/SD-42/sla?start=1": "sla_1.json",
+	}, nil)
+	defer srv.Close()
+
+	ss, err := NewClient(srv.URL).SLA(context.Background(), "SD-42")
+	if err != nil {
+		t.Fatalf("SLA: %v", err)
+	}
+
+	if len(ss) != 2 {
+		t.Fatalf("SLA = %+v", ss)
+	}
+
+	if ss[0].Name != "Time to first response" || ss[0].Remaining != time.Hour || ss[0].Goal != 4*time.Hour {
+		t.Errorf("first SLA = %+v", ss[0])
+	}
+
+	if !ss[1].Breached || !ss[1].Completed || ss[1].Remaining != -10*time.Minute {
+		t.Errorf("second SLA = %+v", ss[1])
+	}
+}
+
+func TestClientApprovals(t *testing.T) {
+	var got []string
+
+	srv := fixtureServer(t, map[string]string{
+		"GET /rest/servicedeskapi/request/SD-42/approval?start=0": "approvals.json",
+		"POST /rest/servicedeskapi/request/SD-42/approval/7":      "approval_answered.json",
+	}, &got)
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+
+	aa, err := c.Approvals(context.Background(), "SD-42")
+	if err != nil {
+		t.Fatalf("Approvals: %v", err)
+	}
+
+	if len(aa) != 1 || aa[0].ID != "7" || !aa[0].Pending() || !aa[0].CanAnswer || len(aa[0].Approvers) != 2 {
+		t.Fatalf("Approvals = %+v", aa)
+	}
+
+	a, err := c.AnswerApproval(context.Background(), "SD-42", "7", ApprovalApprove)
+	if err != nil {
+		t.Fatalf("AnswerApproval: %v", err)
+	}
+
+	if a.FinalDecision != ApprovalApproved || a.Approvers[0].Decision != ApprovalApproved {
+		t.Errorf("AnswerApproval = %+v", a)
+	}
+
+	if !strings.HasSuffix(got[1], ` {"decision":"approve"}`) {
+		t.Errorf("request = %q", got[1])
+	}
+}
+
+func TestClientQueues(t *testing.T) {
+	srv := fixtureServer(t, map[string]string{
+		"GET /rest/servicedeskapi/servicedesk/1/queue?start=0": "queues.json",
+	}, nil)
+	defer srv.Close()
+
+	qs, err := NewClient(srv.URL).Queues(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("Queues: %v", err)
+	}
+
+	if len(qs) != 2 || qs[1].Name != "Open" || qs[1].JQL != "project = SD AND resolution = Unresolved" {
+		t.Errorf("Queues = %+v", qs)
+	}
+}
+
+func TestClientCreateRequest(t *testing.T) {
+	var got []string
+
+	srv := fixtureServer(t, map[string]string{
+		"GET /rest/servicedeskapi/servicedesk/1/requesttype?start=0":  "requesttypes.json",
+		"POST /rest/servicedeskapi/request":                           "request_created.json",
+		"POST /rest/servicedeskapi/servicedesk/1/attachTemporaryFile": "temporary_attachment.json",
+		"POST /rest/servicedeskapi/request/SD-43/attachment":          "",
+	}, &got)
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	ctx := context.Background()
+
+	types, err := c.RequestTypes(ctx, "1")
+	if err != nil || len(types) != 2 || types[0].Name != "Get IT help" {
+		t.Fatalf("RequestTypes = %+v, %v", types, err)
+	}
+
+	r, err := c.CreateRequest(ctx, CreateRequestParams{ServiceDeskID: "1", RequestTypeID: "25", Summary: "VPN is down", Description: "*since* 9:00"})
+	if err != nil || r.IssueKey != "SD-43" {
+		t.Fatalf("CreateRequest = %+v, %v", r, err)
+	}
+
+	id, err := c.AttachTemporaryFile(ctx, "1", "screenshot.png", strings.NewReader("PNG"))
+	if err != nil || id != "temp8186986167181208346" {
+		t.Fatalf("AttachTemporaryFile = %q, %v", id, err)
+	}
+
+	if err := c.AddAttachments(ctx, "SD-43", []string{id}, true); err != nil {
+		t.Fatalf("AddAttachments: %v", err)
+	}
+
+	for i, want := range []string{
+		`{"requestFieldValues":{"description":"*since* 9:00","summary":"VPN is down"},"requestTypeId":"25","serviceDeskId":"1"}`,
+		`filename="screenshot.png"`,
+		`{"temporaryAttachmentIds":["temp8186986167181208346"],"public":true}`,
+	} {
+		if !strings.Contains(got[i+1], want) {
+			t.Errorf("request %d = %s, want %s", i+1, got[i+1], want)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	srv := fixtureServer(t, map[string]string{"PUT /rest/api/2/issue/SD-42/transitions": "error_400.json"}, nil)
+	defer srv.Close()
+
+	err := NewClient(srv.URL).DoTransition(context.Background(), "SD-42", "99")
+	if !errors.Is(err, ErrBadRequest) || errors.Is(err, ErrNotFound) {
+		t.Errorf("error %v is not ErrBadRequest", err)
+	}
+
+	e, ok := AsError(err)
+	if !ok {
+		t.Fatalf("error %v is not *Error", err)
+	}
+
+	if len(e.Messages) != 1 || e.Fields["resolution"] != "Resolution is required." {
+		t.Errorf("Error = %+v", e)
+	}
+
+	want := "jira: PUT /rest/api/2/issue/SD-42/transitions: 400 Bad Request: Transition id '99' is not valid for this issue.; resolution: Resolution is required."
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	rl := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer rl.Close()
+
+	_, err = NewClient(rl.URL).GetIssue(context.Background(), "SD-1", IssueOptions{})
+	if e, ok := AsError(err); !ok || !errors.Is(err, ErrRateLimited) || e.Retry != 7*time.Second {
+		t.Errorf("rate limited error = %v", err)
+	}
+}
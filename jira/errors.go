@@ -0,0 +1,114 @@
+package jira
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors matched by *Error via errors.Is.
+var (
+	ErrBadRequest   = errors.New("jira: bad request")
+	ErrUnauthorized = errors.New("jira: unauthorized")
+	ErrForbidden    = errors.New("jira: forbidden")
+	ErrNotFound     = errors.New("jira: not found")
+	ErrConflict     = errors.New("jira: conflict")
+	ErrRateLimited  = errors.New("jira: rate limited")
+	ErrServer       = errors.New("jira: server error")
+)
+
+// Error - an unsuccessful REST API response.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Messages - general error messages ("errorMessages" of the Jira API,
+	// "errorMessage" of the JSM API).
+	Messages []string
+	// Fields - errors by field name.
+	Fields map[string]string
+	// Retry - the Retry-After delay of 429 responses.
+	Retry time.Duration
+}
+
+func (e *Error) Error() string {
+	msgs := append([]string(nil), e.Messages...)
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		msgs = append(msgs, k+": "+e.Fields[k])
+	}
+
+	s := fmt.Sprintf("jira: %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if len(msgs) > 0 {
+		s += ": " + strings.Join(msgs, "; ")
+	}
+
+	return s
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+
+	return false
+}
+
+// AsError - returns the *Error in err's chain.
+func AsError(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+
+	return e, ok
+}
+
+// newError - builds an *Error from a response.
+func newError(method, path string, resp *http.Response, body []byte) *Error {
+	e := &Error{Method: method, Path: path, StatusCode: resp.StatusCode}
+
+	var b struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+		ErrorMessage  string            `json:"errorMessage"`
+	}
+
+	if json.Unmarshal(body, &b) == nil {
+		e.Messages = b.ErrorMessages
+		e.Fields = b.Errors
+
+		if b.ErrorMessage != "" {
+			e.Messages = append(e.Messages, b.ErrorMessage)
+		}
+	}
+
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.Retry = time.Duration(secs) * time.Second
+	}
+
+	return e
+}
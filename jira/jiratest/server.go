@@ -0,0 +1,595 @@
+// Package jiratest provides a fake Jira REST API server for tests.
+package jiratest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Comment - a comment added through the fake server.
+type Comment struct {
+	ID     string
+	Issue  string
+	Body   string
+	Public bool
+}
+
+// Transition - a workflow transition leading to the To status.
+type Transition struct {
+	ID   string
+	Name string
+	To   string
+}
+
+// Issue - an issue known to the fake server. Assignee is an account id.
+type Issue struct {
+	Key         string
+	Summary     string
+	Status      string
+	Priority    string
+	Assignee    string
+	Description string
+	Transitions []Transition
+	SLAs        []SLA
+	Approvals   []Approval
+}
+
+// Approval - a JSM approval of an issue. Approvers are account ids;
+// Decision is the final decision, "pending" if empty. The first answer
+// decides it. Like Jira, the server takes answers only from approvers:
+// the caller is the account id sent as a bearer token.
+type Approval struct {
+	ID        string
+	Name      string
+	Approvers []string
+	Decision  string
+}
+
+// SLA - the ongoing cycle of an SLA of an issue.
+type SLA struct {
+	Name       string
+	BreachTime time.Time
+	Paused     bool
+}
+
+// Request - a customer request raised through the fake server; it is
+// also added as an issue with status "Open". Attachments are file names.
+type Request struct {
+	Key           string
+	ServiceDeskID string
+	RequestTypeID string
+	Summary       string
+	Description   string
+	Attachments   []string
+	Public        bool
+	// RaiseOnBehalfOf - the customer the request was raised for.
+	RaiseOnBehalfOf string
+}
+
+// RequestType - a service desk request type.
+type RequestType struct {
+	ID   string
+	Name string
+}
+
+// Queue - a service desk queue.
+type Queue struct {
+	ID   string
+	Name string
+	JQL  string
+}
+
+// Server - a fake Jira REST API backed by memory.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	issues   map[string]*Issue
+	comments []Comment
+	queues   []Queue
+	searches []string
+	types    []RequestType
+	requests []Request
+	temp     map[string]string // temporary attachment id -> file name
+	answers  []string
+	nextID   int
+}
+
+// NewServer - starts a fake Jira server; Close it when done.
+func NewServer() *Server {
+	s := &Server{issues: make(map[string]*Issue), temp: make(map[string]string), nextID: 10000}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /rest/servicedeskapi/request/{key}/comment", s.addComment)
+	mux.HandleFunc("GET /rest/api/2/issue/{key}", s.withIssue(s.getIssue))
+	mux.HandleFunc("PUT /rest/api/2/issue/{key}", s.withIssue(s.editIssue))
+	mux.HandleFunc("GET /rest/api/2/issue/{key}/transitions", s.withIssue(s.getTransitions))
+	mux.HandleFunc("PUT /rest/api/2/issue/{key}/transitions", s.withIssue(s.doTransition))
+	mux.HandleFunc("PUT /rest/api/2/issue/{key}/assignee", s.withIssue(s.assign))
+	mux.HandleFunc("GET /rest/api/2/search", s.search)
+	mux.HandleFunc("GET /rest/servicedeskapi/servicedesk/{id}/queue", s.getQueues)
+	mux.HandleFunc("GET /rest/servicedeskapi/servicedesk/{id}/requesttype", s.getRequestTypes)
+	mux.HandleFunc("POST /rest/servicedeskapi/servicedesk/{id}/attachTemporaryFile", s.attachTemporaryFile)
+	mux.HandleFunc("POST /rest/servicedeskapi/request", s.createRequest)
+	mux.HandleFunc("POST /rest/servicedeskapi/request/{key}/attachment", s.addAttachments)
+	mux.HandleFunc("GET /rest/servicedeskapi/request/{key}/approval", s.withIssue(s.getApprovals))
+	mux.HandleFunc("POST /rest/servicedeskapi/request/{key}/approval/{id}", s.withIssue(s.answerApproval))
+
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// AddIssue - adds or replaces an issue.
+func (s *Server) AddIssue(is Issue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.issues[is.Key] = &is
+}
+
+// Issue - returns the current state of an issue.
+func (s *Server) Issue(key string) (Issue, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	is, ok := s.issues[key]
+	if !ok {
+		return Issue{}, false
+	}
+
+	return *is, true
+}
+
+// AddQueue - adds a service desk queue.
+func (s *Server) AddQueue(q Queue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queues = append(s.queues, q)
+}
+
+// AddRequestType - adds a service desk request type.
+func (s *Server) AddRequestType(t RequestType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.types = append(s.types, t)
+}
+
+// Requests - returns the requests raised so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// Searches - returns the JQL of the searches made so far.
+func (s *Server) Searches() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.searches...)
+}
+
+// Comments - returns the comments added so far.
+func (s *Server) Comments() []Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Comment(nil), s.comments...)
+}
+
+func (s *Server) addComment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Body   string `json:"body"`
+		Public bool   `json:"public"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	c := Comment{ID: strconv.Itoa(s.nextID), Issue: r.PathValue("key"), Body: req.Body, Public: req.Public}
+	s.comments = append(s.comments, c)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]any{"id": c.ID, "body": c.Body, "public": c.Public})
+}
+
+// withIssue - runs h with the issue of the request locked,
+// or responds 404 for unknown issues.
+func (s *Server) withIssue(h func(http.ResponseWriter, *http.Request, *Issue)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		is, ok := s.issues[r.PathValue("key")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+
+			return
+		}
+
+		h(w, r, is)
+	}
+}
+
+func (s *Server) getIssue(w http.ResponseWriter, _ *http.Request, is *Issue) {
+	writeJSON(w, http.StatusOK, issueJSON(is))
+}
+
+func issueJSON(is *Issue) map[string]any {
+	fields := map[string]any{
+		"summary":     is.Summary,
+		"description": is.Description,
+		"status":      map[string]string{"name": is.Status},
+		"priority":    map[string]string{"name": is.Priority},
+		"assignee":    nil,
+	}
+
+	if is.Assignee != "" {
+		fields["assignee"] = map[string]string{"accountId": is.Assignee, "displayName": is.Assignee}
+	}
+
+	for i, sla := range is.SLAs {
+		fields["customfield_"+strconv.Itoa(10100+i)] = map[string]any{
+			"name": sla.Name,
+			"ongoingCycle": map[string]any{
+				"paused":     sla.Paused,
+				"breachTime": map[string]int64{"epochMillis": sla.BreachTime.UnixMilli()},
+			},
+		}
+	}
+
+	if len(is.Approvals) > 0 {
+		approvals := []map[string]any{}
+		for i := range is.Approvals {
+			approvals = append(approvals, approvalJSON(&is.Approvals[i]))
+		}
+
+		fields["customfield_10200"] = approvals
+	}
+
+	return map[string]any{"id": "1", "key": is.Key, "fields": fields}
+}
+
+func approvalJSON(a *Approval) map[string]any {
+	decision := a.Decision
+	if decision == "" {
+		decision = "pending"
+	}
+
+	approvers := []map[string]any{}
+	for _, id := range a.Approvers {
+		approvers = append(approvers, map[string]any{
+			"approver":         map[string]string{"accountId": id, "displayName": id},
+			"approverDecision": decision,
+		})
+	}
+
+	return map[string]any{
+		"id":                a.ID,
+		"name":              a.Name,
+		"finalDecision":     decision,
+		"canAnswerApproval": decision == "pending",
+		"approvers":         approvers,
+	}
+}
+
+// search - pages through every issue in key order; the JQL is
+// recorded but not evaluated.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, _ := strconv.Atoi(q.Get("startAt"))
+
+	limit, err := strconv.Atoi(q.Get("maxResults"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.searches = append(s.searches, q.Get("jql"))
+
+	keys := make([]string, 0, len(s.issues))
+	for k := range s.issues {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	issues := []map[string]any{}
+	for i := start; i < len(keys) && i < start+limit; i++ {
+		issues = append(issues, issueJSON(s.issues[keys[i]]))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"startAt": start, "maxResults": limit, "total": len(keys), "issues": issues})
+}
+
+func (s *Server) getQueues(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := []map[string]string{}
+	for _, q := range s.queues {
+		values = append(values, map[string]string{"id": q.ID, "name": q.Name, "jql": q.JQL})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"start": 0, "limit": 50, "size": len(values), "isLastPage": true, "values": values})
+}
+
+func (s *Server) editIssue(w http.ResponseWriter, r *http.Request, is *Issue) {
+	var req struct {
+		Fields struct {
+			Priority *struct {
+				Name string `json:"name"`
+			} `json:"priority"`
+		} `json:"fields"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	if req.Fields.Priority != nil {
+		is.Priority = req.Fields.Priority.Name
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getTransitions(w http.ResponseWriter, _ *http.Request, is *Issue) {
+	tt := []map[string]any{}
+	for _, t := range is.Transitions {
+		tt = append(tt, map[string]any{"id": t.ID, "name": t.Name, "to": map[string]string{"name": t.To}})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"transitions": tt})
+}
+
+func (s *Server) doTransition(w http.ResponseWriter, r *http.Request, is *Issue) {
+	var req struct {
+		Transition struct {
+			ID string `json:"id"`
+		} `json:"transition"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	for _, t := range is.Transitions {
+		if t.ID == req.Transition.ID {
+			is.Status = t.To
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+	}
+
+	writeError(w, http.StatusBadRequest, "Transition id '"+req.Transition.ID+"' is not valid for this issue.")
+}
+
+func (s *Server) assign(w http.ResponseWriter, r *http.Request, is *Issue) {
+	var req struct {
+		AccountID *string `json:"accountId"`
+		Name      *string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	switch {
+	case req.AccountID != nil:
+		is.Assignee = *req.AccountID
+	case req.Name != nil:
+		is.Assignee = *req.Name
+	default:
+		is.Assignee = ""
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getRequestTypes(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := []map[string]string{}
+	for _, t := range s.types {
+		values = append(values, map[string]string{"id": t.ID, "name": t.Name})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"start": 0, "limit": 50, "size": len(values), "isLastPage": true, "values": values})
+}
+
+func (s *Server) createRequest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ServiceDeskID      string            `json:"serviceDeskId"`
+		RequestTypeID      string            `json:"requestTypeId"`
+		RequestFieldValues map[string]string `json:"requestFieldValues"`
+		RaiseOnBehalfOf    string            `json:"raiseOnBehalfOf"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	key := "SD-" + strconv.Itoa(s.nextID)
+
+	s.requests = append(s.requests, Request{
+		Key:           key,
+		ServiceDeskID: req.ServiceDeskID,
+		RequestTypeID: req.RequestTypeID,
+		Summary:       req.RequestFieldValues["summary"],
+		Description:   req.RequestFieldValues["description"],
+
+		RaiseOnBehalfOf: req.RaiseOnBehalfOf,
+	})
+	s.issues[key] = &Issue{
+		Key:         key,
+		Summary:     req.RequestFieldValues["summary"],
+		Description: req.RequestFieldValues["description"],
+		Status:      "Open",
+		Priority:    "Medium",
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{"issueId": strconv.Itoa(s.nextID), "issueKey": key})
+}
+
+func (s *Server) attachTemporaryFile(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Atlassian-Token") != "no-check" {
+		writeError(w, http.StatusForbidden, "XSRF check failed")
+
+		return
+	}
+
+	_, fh, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	id := "temp" + strconv.Itoa(s.nextID)
+	s.temp[id] = fh.Filename
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"temporaryAttachments": []map[string]string{{"temporaryAttachmentId": id, "fileName": fh.Filename}},
+	})
+}
+
+func (s *Server) addAttachments(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TemporaryAttachmentIDs []string `json:"temporaryAttachmentIds"`
+		Public                 bool     `json:"public"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.requests, func(rq Request) bool { return rq.Key == r.PathValue("key") })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "Request does not exist.")
+
+		return
+	}
+
+	for _, id := range req.TemporaryAttachmentIDs {
+		name, ok := s.temp[id]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Unknown temporary attachment "+id)
+
+			return
+		}
+
+		s.requests[i].Attachments = append(s.requests[i].Attachments, name)
+	}
+
+	s.requests[i].Public = req.Public
+
+	writeJSON(w, http.StatusCreated, map[string]any{})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{"errorMessages": []string{msg}})
+}
+
+// Answers - returns the approval answers, as "KEY/id decision by caller".
+func (s *Server) Answers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.answers)
+}
+
+func (s *Server) getApprovals(w http.ResponseWriter, _ *http.Request, is *Issue) {
+	values := []map[string]any{}
+	for i := range is.Approvals {
+		values = append(values, approvalJSON(&is.Approvals[i]))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"start": 0, "limit": 50, "size": len(values), "isLastPage": true, "values": values})
+}
+
+func (s *Server) answerApproval(w http.ResponseWriter, r *http.Request, is *Issue) {
+	var req struct {
+		Decision string `json:"decision"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Decision != "approve" && req.Decision != "decline" {
+		writeError(w, http.StatusBadRequest, "The decision must be approve or decline.")
+
+		return
+	}
+
+	for i := range is.Approvals {
+		a := &is.Approvals[i]
+		if a.ID != r.PathValue("id") {
+			continue
+		}
+
+		caller := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !slices.Contains(a.Approvers, caller) {
+			writeError(w, http.StatusForbidden, "You are not an approver of this approval.")
+
+			return
+		}
+
+		if a.Decision != "" && a.Decision != "pending" {
+			writeError(w, http.StatusBadRequest, "This approval has already been answered.")
+
+			return
+		}
+
+		a.Decision = map[string]string{"approve": "approved", "decline": "declined"}[req.Decision]
+		s.answers = append(s.answers, is.Key+"/"+a.ID+" "+req.Decision+" by "+caller)
+		writeJSON(w, http.StatusOK, approvalJSON(a))
+
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "The approval does not exist.")
+}
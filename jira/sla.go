@@ -0,0 +1,98 @@
+package jira
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// SLA - the state of a JSM SLA metric ("Time to first response",
+// "Time to resolution"...). Remaining is negative once breached.
+type SLA struct {
+	Name       string
+	Breached   bool
+	Paused     bool
+	Completed  bool // no ongoing cycle; the last completed one is reported
+	Goal       time.Duration
+	Remaining  time.Duration
+	BreachTime time.Time
+}
+
+type slaDuration struct {
+	Millis int64 `json:"millis"`
+}
+
+type slaTime struct {
+	EpochMillis int64 `json:"epochMillis"`
+}
+
+type slaCycle struct {
+	Breached      bool         `json:"breached"`
+	Paused        bool         `json:"paused"`
+	GoalDuration  *slaDuration `json:"goalDuration"`
+	RemainingTime *slaDuration `json:"remainingTime"`
+	BreachTime    *slaTime     `json:"breachTime"`
+}
+
+type slaField struct {
+	Name            string     `json:"name"`
+	OngoingCycle    *slaCycle  `json:"ongoingCycle"`
+	CompletedCycles []slaCycle `json:"completedCycles"`
+}
+
+func (c *slaCycle) sla(name string) SLA {
+	s := SLA{Name: name, Breached: c.Breached, Paused: c.Paused}
+
+	if c.GoalDuration != nil {
+		s.Goal = time.Duration(c.GoalDuration.Millis) * time.Millisecond
+	}
+
+	if c.RemainingTime != nil {
+		s.Remaining = time.Duration(c.RemainingTime.Millis) * time.Millisecond
+	}
+
+	if c.BreachTime != nil && c.BreachTime.EpochMillis != 0 {
+		s.BreachTime = time.UnixMilli(c.BreachTime.EpochMillis)
+	}
+
+	return s
+}
+
+// ParseSLA - decodes a JSM SLA field value. It returns false
+// if the value isn't an SLA.
+func ParseSLA(raw json.RawMessage) (SLA, bool) {
+	var f slaField
+	if json.Unmarshal(raw, &f) != nil || f.Name == "" || (f.OngoingCycle == nil && f.CompletedCycles == nil) {
+		return SLA{}, false
+	}
+
+	if f.OngoingCycle != nil {
+		return f.OngoingCycle.sla(f.Name), true
+	}
+
+	if len(f.CompletedCycles) == 0 {
+		return SLA{}, false
+	}
+
+	s := f.CompletedCycles[len(f.CompletedCycles)-1].sla(f.Name)
+	s.Completed = true
+
+	return s, true
+}
+
+// SLAs - returns the SLA metrics found in the custom fields, by name.
+func (f *Fields) SLAs() []SLA {
+	var out []SLA
+
+	for _, raw := range f.Custom {
+		if s, ok := ParseSLA(raw); ok {
+			out = append(out, s)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
@@ -0,0 +1,378 @@
+package jira
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook event names.
+const (
+	EventIssueCreated   = "jira:issue_created"
+	EventIssueUpdated   = "jira:issue_updated"
+	EventIssueDeleted   = "jira:issue_deleted"
+	EventCommentCreated = "comment_created"
+	EventCommentUpdated = "comment_updated"
+	EventCommentDeleted = "comment_deleted"
+
+	// Jira Service Management request events. They carry the same
+	// payload as their Jira counterparts.
+	EventRequestCreated        = "jira:request_created"
+	EventRequestUpdated        = "jira:request_updated"
+	EventRequestCommentCreated = "jira:request_comment_created"
+	EventRequestCommentUpdated = "jira:request_comment_updated"
+)
+
+// Time - a timestamp in the formats used by Jira ("2006-01-02T15:04:05.000-0700").
+type Time struct {
+	time.Time
+}
+
+var timeLayouts = []string{
+	"2006-01-02T15:04:05.000-0700",
+	"2006-01-02T15:04:05.000Z0700",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+func (t *Time) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil || s == "" {
+		// null, numbers and empty strings leave the zero time
+		return nil
+	}
+
+	for _, layout := range timeLayouts {
+		if v, err := time.Parse(layout, s); err == nil {
+			t.Time = v
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(t.Format("2006-01-02T15:04:05.000-0700"))
+}
+
+// User - a Jira user. Cloud identifies users by AccountID,
+// Server/DC by Name (username) and Key.
+type User struct {
+	AccountID    string `json:"accountId,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Key          string `json:"key,omitempty"`
+	DisplayName  string `json:"displayName,omitempty"`
+	EmailAddress string `json:"emailAddress,omitempty"`
+}
+
+// ID - returns the most specific identifier of the user.
+func (u *User) ID() string {
+	if u == nil {
+		return ""
+	}
+
+	switch {
+	case u.AccountID != "":
+		return u.AccountID
+	case u.Name != "":
+		return u.Name
+	default:
+		return u.Key
+	}
+}
+
+// Named - a Jira entity identified by name (issue type, priority, status...).
+type Named struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// Project - a Jira project.
+type Project struct {
+	ID   string `json:"id,omitempty"`
+	Key  string `json:"key"`
+	Name string `json:"name,omitempty"`
+}
+
+// Status - an issue status.
+type Status struct {
+	ID             string `json:"id,omitempty"`
+	Name           string `json:"name"`
+	StatusCategory struct {
+		Key string `json:"key"` // "new", "indeterminate" or "done"
+	} `json:"statusCategory"`
+}
+
+// Fields - the issue fields used by jsm2tg. Custom fields are kept raw.
+type Fields struct {
+	Summary     string   `json:"summary"`
+	Description string   `json:"description"`
+	IssueType   *Named   `json:"issuetype,omitempty"`
+	Project     *Project `json:"project,omitempty"`
+	Priority    *Named   `json:"priority,omitempty"`
+	Status      *Status  `json:"status,omitempty"`
+	Resolution  *Named   `json:"resolution,omitempty"`
+	Reporter    *User    `json:"reporter,omitempty"`
+	Assignee    *User    `json:"assignee,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	Components  []Named  `json:"components,omitempty"`
+	Created     Time     `json:"created"`
+	Updated     Time     `json:"updated"`
+
+	Attachments []Attachment `json:"attachment,omitempty"`
+
+	Custom map[string]json.RawMessage `json:"-"`
+}
+
+type fieldsAlias Fields
+
+func (f *Fields) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, (*fieldsAlias)(f)); err != nil {
+		return err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+
+	for k, v := range all {
+		if !strings.HasPrefix(k, "customfield_") || string(v) == "null" {
+			continue
+		}
+
+		if f.Custom == nil {
+			f.Custom = make(map[string]json.RawMessage)
+		}
+
+		f.Custom[k] = v
+	}
+
+	return nil
+}
+
+func (f Fields) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(fieldsAlias(f))
+	if err != nil || len(f.Custom) == 0 {
+		return b, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+
+	for k, v := range f.Custom {
+		all[k] = v
+	}
+
+	return json.Marshal(all)
+}
+
+// CustomString - returns a custom field value as text: strings as is,
+// options by their "value" or "name", arrays joined with ", ".
+func (f *Fields) CustomString(id string) string {
+	return strings.Join(f.CustomValues(id), ", ")
+}
+
+// CustomValues - returns a custom field value as a list of strings:
+// one element for scalars and options, one per item for arrays.
+func (f *Fields) CustomValues(id string) []string {
+	raw, ok := f.Custom[id]
+	if !ok {
+		return nil
+	}
+
+	return rawStrings(raw)
+}
+
+func rawStrings(raw json.RawMessage) []string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return []string{s}
+	}
+
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		return []string{n.String()}
+	}
+
+	var obj struct {
+		Value string `json:"value"`
+		Name  string `json:"name"`
+	}
+	if json.Unmarshal(raw, &obj) == nil && (obj.Value != "" || obj.Name != "") {
+		if obj.Value != "" {
+			return []string{obj.Value}
+		}
+
+		return []string{obj.Name}
+	}
+
+	var arr []json.RawMessage
+	if json.Unmarshal(raw, &arr) == nil {
+		var out []string
+		for _, v := range arr {
+			out = append(out, rawStrings(v)...)
+		}
+
+		return out
+	}
+
+	return nil
+}
+
+// RequestType - returns the JSM request type name, found in the
+// "Customer Request Type" custom field, or "" for plain Jira issues.
+func (f *Fields) RequestType() string {
+	for _, raw := range f.Custom {
+		var v struct {
+			RequestType *struct {
+				Name string `json:"name"`
+			} `json:"requestType"`
+		}
+
+		if json.Unmarshal(raw, &v) == nil && v.RequestType != nil {
+			return v.RequestType.Name
+		}
+	}
+
+	return ""
+}
+
+// Issue - a Jira issue. RenderedFields is only set when requested.
+type Issue struct {
+	ID             string          `json:"id"`
+	Key            string          `json:"key"`
+	Self           string          `json:"self,omitempty"`
+	Fields         Fields          `json:"fields"`
+	RenderedFields *RenderedFields `json:"renderedFields,omitempty"`
+}
+
+// RenderedFields - issue fields rendered to HTML by Jira.
+type RenderedFields struct {
+	Description string `json:"description"`
+}
+
+// Attachment - a file attached to an issue. Content is the download URL.
+type Attachment struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Author   *User  `json:"author,omitempty"`
+	Created  Time   `json:"created"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Content  string `json:"content"`
+}
+
+// Comment - an issue comment. JSDPublic is set by Jira Service Management:
+// false marks internal comments that customers don't see.
+type Comment struct {
+	ID        string `json:"id"`
+	Body      string `json:"body"`
+	Author    *User  `json:"author,omitempty"`
+	Created   Time   `json:"created"`
+	Updated   Time   `json:"updated"`
+	JSDPublic *bool  `json:"jsdPublic,omitempty"`
+}
+
+// Internal - reports whether the comment is a JSM internal note.
+func (c *Comment) Internal() bool {
+	return c.JSDPublic != nil && !*c.JSDPublic
+}
+
+// ChangelogItem - a single field change.
+type ChangelogItem struct {
+	Field      string `json:"field"`
+	FieldID    string `json:"fieldId,omitempty"`
+	FieldType  string `json:"fieldtype,omitempty"`
+	From       string `json:"from"`
+	FromString string `json:"fromString"`
+	To         string `json:"to"`
+	ToString   string `json:"toString"`
+}
+
+// Changelog - the field changes of an issue_updated event.
+type Changelog struct {
+	ID    string          `json:"id"`
+	Items []ChangelogItem `json:"items"`
+}
+
+// Event - a Jira/JSM webhook payload.
+type Event struct {
+	Timestamp          int64      `json:"timestamp"`
+	WebhookEvent       string     `json:"webhookEvent"`
+	IssueEventTypeName string     `json:"issue_event_type_name,omitempty"`
+	User               *User      `json:"user,omitempty"`
+	Issue              *Issue     `json:"issue,omitempty"`
+	Comment            *Comment   `json:"comment,omitempty"`
+	Changelog          *Changelog `json:"changelog,omitempty"`
+}
+
+// Kind - returns the event name with JSM request events mapped
+// onto the Jira events they mirror.
+func (e *Event) Kind() string {
+	switch e.WebhookEvent {
+	case EventRequestCreated:
+		return EventIssueCreated
+	case EventRequestUpdated:
+		return EventIssueUpdated
+	case EventRequestCommentCreated:
+		return EventCommentCreated
+	case EventRequestCommentUpdated:
+		return EventCommentUpdated
+	}
+
+	return e.WebhookEvent
+}
+
+// ID - identifies the change the event reports, so that duplicates can
+// be dropped: Jira retries webhooks and may fire several for one edit,
+// also as both a Jira and a JSM request event. Updates are identified
+// by their changelog, comment events by the comment and its update
+// time, other events by their timestamp. It returns "" if the event
+// carries none of them.
+func (e *Event) ID() string {
+	id := e.Kind() + "/"
+	if e.Issue != nil {
+		id += e.Issue.Key
+	}
+
+	switch {
+	case e.Changelog != nil && e.Changelog.ID != "":
+		return id + "/changelog/" + e.Changelog.ID
+	case e.Comment != nil && e.Comment.ID != "" && (e.Kind() == EventCommentCreated || e.Kind() == EventCommentUpdated):
+		return id + "/comment/" + e.Comment.ID + "/" + strconv.FormatInt(e.Comment.Updated.UnixMilli(), 10)
+	case e.Timestamp != 0:
+		return id + "/" + strconv.FormatInt(e.Timestamp, 10)
+	}
+
+	return ""
+}
+
+// Time - returns the event timestamp.
+func (e *Event) Time() time.Time {
+	return time.UnixMilli(e.Timestamp)
+}
+
+// ParseEvent - decodes a webhook payload.
+func ParseEvent(b []byte) (*Event, error) {
+	var e Event
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// BrowseURL - returns the human link to an issue on the given Jira base URL.
+func BrowseURL(baseURL, key string) string {
+	return strings.TrimRight(baseURL, "/") + "/browse/" + key
+}
@@ -0,0 +1,147 @@
+package jira
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func readEvent(t *testing.T, name string) *Event {
+	t.Helper()
+
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ParseEvent(b)
+	if err != nil {
+		t.Fatalf("ParseEvent(%s): %v", name, err)
+	}
+
+	return e
+}
+
+func TestParseIssueCreated(t *testing.T) {
+	e := readEvent(t, "issue_created.json")
+
+	if e.Kind() != EventIssueCreated || e.Issue == nil || e.Issue.Key != "SD-42" {
+		t.Fatalf("event = %+v", e)
+	}
+
+	f := e.Issue.Fields
+
+	if f.Project.Key != "SD" || f.Priority.Name != "High" || f.Status.StatusCategory.Key != "new" {
+		t.Errorf("fields = %+v", f)
+	}
+
+	if f.Assignee != nil {
+		t.Errorf("assignee = %+v, want nil", f.Assignee)
+	}
+
+	if want := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC); !f.Created.Equal(want) {
+		t.Errorf("created = %v, want %v", f.Created, want)
+	}
+
+	if got := f.RequestType(); got != "Report an incident" {
+		t.Errorf("RequestType() = %q", got)
+	}
+
+	if got := f.CustomString("customfield_10020"); got != "Moscow" {
+		t.Errorf("CustomString() = %q", got)
+	}
+
+	if _, ok := f.Custom["customfield_10030"]; ok {
+		t.Error("null custom field is kept")
+	}
+}
+
+func TestParseCommentCreated(t *testing.T) {
+	e := readEvent(t, "comment_created.json")
+
+	if e.Comment == nil || !e.Comment.Internal() || e.Comment.Author.ID() != "5b10a2844c20165700ede21g" {
+		t.Fatalf("comment = %+v", e.Comment)
+	}
+}
+
+func TestEventKind(t *testing.T) {
+	tests := map[string]string{
+		EventRequestCreated:        EventIssueCreated,
+		EventRequestCommentUpdated: EventCommentUpdated,
+		EventIssueUpdated:          EventIssueUpdated,
+	}
+
+	for in, want := range tests {
+		e := Event{WebhookEvent: in}
+		if got := e.Kind(); got != want {
+			t.Errorf("Kind(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEventID(t *testing.T) {
+	is := &Issue{Key: "SD-1"}
+	updated := Time{time.UnixMilli(1700000000000)}
+
+	tests := []struct {
+		name string
+		e    Event
+		want string
+	}{
+		{"update", Event{WebhookEvent: EventIssueUpdated, Timestamp: 1, Issue: is, Changelog: &Changelog{ID: "100"}}, "jira:issue_updated/SD-1/changelog/100"},
+		{"request update", Event{WebhookEvent: EventRequestUpdated, Timestamp: 2, Issue: is, Changelog: &Changelog{ID: "100"}}, "jira:issue_updated/SD-1/changelog/100"},
+		{"comment", Event{WebhookEvent: EventCommentUpdated, Timestamp: 3, Issue: is, Comment: &Comment{ID: "7", Updated: updated}}, "comment_updated/SD-1/comment/7/1700000000000"},
+		{"created", Event{WebhookEvent: EventIssueCreated, Timestamp: 4, Issue: is}, "jira:issue_created/SD-1/4"},
+		{"anonymous", Event{WebhookEvent: EventIssueCreated, Issue: is}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.e.ID(); got != tt.want {
+			t.Errorf("%s: ID() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSLAs(t *testing.T) {
+	e := readEvent(t, "issue_created.json")
+
+	got := e.Issue.Fields.SLAs()
+	if len(got) != 2 {
+		t.Fatalf("SLAs() = %+v", got)
+	}
+
+	first, res := got[0], got[1]
+
+	if first.Name != "Time to first response" || !first.Breached || !first.Completed || first.Remaining != -10*time.Minute {
+		t.Errorf("first response = %+v", first)
+	}
+
+	if res.Name != "Time to resolution" || res.Breached || res.Remaining != 30*time.Minute || res.Goal != 4*time.Hour ||
+		!res.BreachTime.Equal(time.UnixMilli(1735741800000)) {
+		t.Errorf("resolution = %+v", res)
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	e := readEvent(t, "issue_created.json")
+
+	got := e.Issue.Fields.Approvals()
+	if len(got) != 1 || got[0].ID != "3" || got[0].Name != "Change approval" || !got[0].Pending() {
+		t.Fatalf("Approvals() = %+v", got)
+	}
+
+	if ap, ok := got[0].Approver("5b10a2844c20165700ede21g", ""); !ok || ap.User.DisplayName != "Carol" || ap.Decision != ApprovalPending {
+		t.Errorf("Approver() = %+v, %v", ap, ok)
+	}
+
+	if _, ok := got[0].Approver("", "carol"); ok {
+		t.Error("Approver() found an unknown user")
+	}
+
+	for _, raw := range []string{`[]`, `[{"id": "1"}]`, `{"id": "1", "finalDecision": "pending"}`, `["x"]`} {
+		if aa, ok := ParseApprovals(json.RawMessage(raw)); ok {
+			t.Errorf("%s: ParseApprovals() = %+v", raw, aa)
+		}
+	}
+}
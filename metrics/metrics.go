@@ -0,0 +1,372 @@
+// Package metrics keeps counters, gauges and histograms and serves them
+// in the Prometheus text exposition format.
+//
+// A nil *Registry hands out nil metrics and nil metrics ignore updates,
+// so code can be instrumented unconditionally.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets - histogram buckets in seconds for request-like latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets - returns n buckets starting at start, each factor
+// times the previous one.
+func ExponentialBuckets(start, factor float64, n int) []float64 {
+	out := make([]float64, n)
+
+	for i := range out {
+		out[i] = start
+		start *= factor
+	}
+
+	return out
+}
+
+// metric - a metric family written by a Registry.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry - a set of metrics by name. Registry is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry - creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// register - returns the metric with the name, made by fn if there
+// isn't one. Asking for one name with different types is a bug.
+func register[M metric](r *Registry, name string, fn func() M) M {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		typed, ok := m.(M)
+		if !ok {
+			panic(fmt.Sprintf("metrics: %s registered as %T", name, m))
+		}
+
+		return typed
+	}
+
+	m := fn()
+	r.metrics[name] = m
+
+	return m
+}
+
+// Counter - returns the counter with the name and label names,
+// registering it on the first call; nil if r is nil.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	if r == nil {
+		return nil
+	}
+
+	return register(r, name, func() *Counter {
+		c := &Counter{family: family{name: name, help: help, labels: labels}, series: make(map[string]*counterSeries)}
+		if len(labels) == 0 {
+			// a counter without labels is exposed from the start
+			c.series[""] = &counterSeries{}
+		}
+
+		return c
+	})
+}
+
+// Histogram - returns the histogram with the name, upper bounds of the
+// buckets in ascending order and label names, registering it on the
+// first call; nil if r is nil.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if r == nil {
+		return nil
+	}
+
+	return register(r, name, func() *Histogram {
+		h := &Histogram{family: family{name: name, help: help, labels: labels}, buckets: buckets, series: make(map[string]*histogramSeries)}
+		if len(labels) == 0 {
+			h.series[""] = &histogramSeries{counts: make([]uint64, len(buckets))}
+		}
+
+		return h
+	})
+}
+
+// GaugeFunc - registers a gauge whose value is read from fn on every
+// scrape, replacing the one registered before with the name.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics[name] = &gaugeFunc{family: family{name: name, help: help}, fn: fn}
+}
+
+// Write - writes the metrics in the text exposition format, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP - serves the metrics to a Prometheus scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// family - the name, help and label names of a metric.
+type family struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (f *family) header(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, typ)
+}
+
+// key - returns the key of the series with the label values.
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs - formats the labels of a series and extra pairs as {a="x"}.
+func (f *family) labelPairs(values []string, extra ...string) string {
+	if len(f.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(values)+len(extra)/2)
+
+	for i, l := range f.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter - a monotonically increasing value per combination of labels.
+type Counter struct {
+	family
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	v      float64
+}
+
+// Inc - adds 1 to the series with the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add - adds v, which must not be negative, to the series with the
+// label values.
+func (c *Counter) Add(v float64, values ...string) {
+	if c == nil {
+		return
+	}
+
+	key := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: slices.Clone(values)}
+		c.series[key] = s
+	}
+
+	s.v += v
+}
+
+// Value - returns the value of the series with the label values.
+func (c *Counter) Value(values ...string) float64 {
+	if c == nil {
+		return 0
+	}
+
+	key := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.series[key]; ok {
+		return s.v
+	}
+
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(s.values), formatFloat(s.v))
+	}
+}
+
+// Histogram - counts of observations in buckets per combination of labels.
+type Histogram struct {
+	family
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Observe - adds an observation to the series with the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	if h == nil {
+		return
+	}
+
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: slices.Clone(values), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var n uint64
+
+		for i, le := range h.buckets {
+			n += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, "le", formatFloat(le)), n)
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.values), s.count)
+	}
+}
+
+// gaugeFunc - a gauge read on every scrape.
+type gaugeFunc struct {
+	family
+	fn func() float64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
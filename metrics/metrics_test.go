@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+
+	sent := r.Counter("messages_sent_total", "Messages sent.", "chat")
+	sent.Inc("-100")
+	sent.Add(2, "-200")
+	sent.Inc("-100")
+
+	r.Counter("errors_total", "Errors.")
+	r.Counter("odd_total", "Odd \\ help\nline.", "v").Inc("a\"b\\c\nd")
+
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	depth := 3.0
+	r.GaugeFunc("queue_depth", "Queued jobs.", func() float64 { return depth })
+
+	want := `# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total 0
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 3.65
+latency_seconds_count 4
+# HELP messages_sent_total Messages sent.
+# TYPE messages_sent_total counter
+messages_sent_total{chat="-100"} 2
+messages_sent_total{chat="-200"} 2
+# HELP odd_total Odd \\ help\nline.
+# TYPE odd_total counter
+odd_total{v="a\"b\\c\nd"} 1
+# HELP queue_depth Queued jobs.
+# TYPE queue_depth gauge
+queue_depth 3
+`
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+
+	a := r.Counter("x_total", "X.", "l")
+	if b := r.Counter("x_total", "X.", "l"); a != b {
+		t.Errorf("Counter() registered x_total twice")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Histogram() of a counter name didn't panic")
+		}
+	}()
+
+	r.Histogram("x_total", "X.", DefaultBuckets)
+}
+
+func TestNil(t *testing.T) {
+	var r *Registry
+
+	c := r.Counter("x_total", "X.", "l")
+	c.Inc("a")
+
+	r.Histogram("y", "Y.", DefaultBuckets).Observe(1)
+	r.GaugeFunc("z", "Z.", func() float64 { return 0 })
+
+	if v := c.Value("a"); v != 0 {
+		t.Errorf("nil counter value = %v", v)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("hits_total", "Hits.").Inc()
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	if !strings.Contains(string(b), "\nhits_total 1\n") {
+		t.Errorf("scrape = %q, want hits_total 1", b)
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	got := ExponentialBuckets(0.001, 10, 4)
+	want := []float64{0.001, 0.01, 0.1, 1}
+
+	for i := range want {
+		if len(got) != len(want) || got[i] < want[i]*0.999 || got[i] > want[i]*1.001 {
+			t.Errorf("ExponentialBuckets() = %v, want %v", got, want)
+
+			break
+		}
+	}
+}
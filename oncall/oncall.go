@@ -0,0 +1,193 @@
+// Package oncall reads on-call rotations and finds who is on call.
+package oncall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TimeLayout - the layout of the times in schedule files, read in the
+// time zone of their rotation.
+const TimeLayout = "2006-01-02 15:04"
+
+// Member - a person on call: the name shown and their Telegram user id.
+type Member struct {
+	Name     string `yaml:"name"`
+	Telegram int64  `yaml:"telegram"`
+}
+
+// Override - another member on call from From until To, e.g. for a
+// swapped shift or a holiday.
+type Override struct {
+	From   string `yaml:"from"`
+	To     string `yaml:"to"`
+	Member Member `yaml:"member"`
+
+	from, to time.Time
+}
+
+// Rotation - members taking Shift long turns in order, starting from
+// the first one at Start. A rotation is on call for the routing rules
+// named in Routes, or for all of them and the default chats if empty.
+type Rotation struct {
+	Name      string        `yaml:"name"`
+	Routes    []string      `yaml:"routes"`
+	Timezone  string        `yaml:"timezone"`
+	Start     string        `yaml:"start"`
+	Shift     time.Duration `yaml:"shift"`
+	Members   []Member      `yaml:"members"`
+	Overrides []Override    `yaml:"overrides"`
+
+	start time.Time
+}
+
+// Schedule - on-call rotations; the first rotation of a route wins.
+type Schedule struct {
+	Rotations []Rotation `yaml:"rotations"`
+}
+
+// Load - reads and validates a schedule file.
+func Load(path string) (*Schedule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Parse - decodes and validates a schedule.
+func Parse(b []byte) (*Schedule, error) {
+	var s Schedule
+
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+
+	for i := range s.Rotations {
+		errs = append(errs, s.Rotations[i].parse(i)...)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (r *Rotation) parse(i int) []error {
+	var errs []error
+
+	loc := time.UTC
+
+	if r.Timezone != "" {
+		l, err := time.LoadLocation(r.Timezone)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rotations[%d].timezone: %w", i, err))
+		} else {
+			loc = l
+		}
+	}
+
+	var err error
+	if r.start, err = time.ParseInLocation(TimeLayout, r.Start, loc); err != nil {
+		errs = append(errs, fmt.Errorf("rotations[%d].start: want %q, got %q", i, TimeLayout, r.Start))
+	}
+
+	if r.Shift <= 0 {
+		errs = append(errs, fmt.Errorf("rotations[%d].shift: must be positive", i))
+	}
+
+	if len(r.Members) == 0 {
+		errs = append(errs, fmt.Errorf("rotations[%d].members: at least one member is required", i))
+	}
+
+	for j, m := range r.Members {
+		if m.Name == "" {
+			errs = append(errs, fmt.Errorf("rotations[%d].members[%d].name: required", i, j))
+		}
+	}
+
+	for j := range r.Overrides {
+		o := &r.Overrides[j]
+
+		from, err1 := time.ParseInLocation(TimeLayout, o.From, loc)
+		to, err2 := time.ParseInLocation(TimeLayout, o.To, loc)
+
+		switch {
+		case err1 != nil || err2 != nil:
+			errs = append(errs, fmt.Errorf("rotations[%d].overrides[%d]: from and to must be %q", i, j, TimeLayout))
+		case !to.After(from):
+			errs = append(errs, fmt.Errorf("rotations[%d].overrides[%d]: to must be after from", i, j))
+		case o.Member.Name == "":
+			errs = append(errs, fmt.Errorf("rotations[%d].overrides[%d].member.name: required", i, j))
+		}
+
+		o.from, o.to = from, to
+	}
+
+	return errs
+}
+
+// covers - reports whether the rotation is on call for the routing rule;
+// route is empty for the default chats.
+func (r *Rotation) covers(route string) bool {
+	if len(r.Routes) == 0 {
+		return true
+	}
+
+	for _, name := range r.Routes {
+		if route != "" && strings.EqualFold(name, route) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OnCall - returns the member of the rotation on call at t, false
+// before the rotation starts.
+func (r *Rotation) OnCall(t time.Time) (Member, bool) {
+	for _, o := range r.Overrides {
+		if !t.Before(o.from) && t.Before(o.to) {
+			return o.Member, true
+		}
+	}
+
+	if t.Before(r.start) {
+		return Member{}, false
+	}
+
+	turn := int64(t.Sub(r.start) / r.Shift)
+
+	return r.Members[turn%int64(len(r.Members))], true
+}
+
+// OnCall - returns who is on call for the routing rule at t; route is
+// empty for the default chats. It returns false if nobody is.
+func (s *Schedule) OnCall(route string, t time.Time) (Member, bool) {
+	for i := range s.Rotations {
+		r := &s.Rotations[i]
+		if !r.covers(route) {
+			continue
+		}
+
+		if m, ok := r.OnCall(t); ok {
+			return m, true
+		}
+	}
+
+	return Member{}, false
+}
@@ -0,0 +1,87 @@
+package oncall
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const schedule = `
+rotations:
+  - name: service desk
+    routes: [service desk, Incidents]
+    timezone: Europe/Moscow
+    start: "2024-05-06 09:00"
+    shift: 24h
+    members:
+      - {name: Alice, telegram: 501}
+      - {name: Bob, telegram: 502}
+      - {name: Carol, telegram: 503}
+    overrides:
+      - from: "2024-05-08 09:00"
+        to: "2024-05-08 18:00"
+        member: {name: Dave, telegram: 504}
+  - name: everything else
+    start: "2024-01-01 00:00"
+    shift: 168h
+    members:
+      - {name: Eve}
+`
+
+func TestOnCall(t *testing.T) {
+	s, err := Parse([]byte(schedule))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	// 09:00 MSK
+	start := time.Date(2024, 5, 6, 6, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		route string
+		at    time.Time
+		want  string
+	}{
+		{"service desk", start, "Alice"},
+		{"Service Desk", start.Add(23 * time.Hour), "Alice"},
+		{"incidents", start.Add(24 * time.Hour), "Bob"},
+		{"service desk", start.Add(48 * time.Hour), "Dave"},
+		{"service desk", start.Add(57 * time.Hour), "Carol"},
+		{"service desk", start.Add(72 * time.Hour), "Alice"},
+		// before the rotation starts
+		{"service desk", start.Add(-time.Minute), "Eve"},
+		{"other", start, "Eve"},
+		{"", start, "Eve"},
+	}
+
+	for _, tt := range tests {
+		got, ok := s.OnCall(tt.route, tt.at)
+		if !ok || got.Name != tt.want {
+			t.Errorf("%s at %v: OnCall() = %+v, %v; want %s", tt.route, tt.at, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := (&Schedule{}).OnCall("service desk", start); ok {
+		t.Error("empty schedule: somebody is on call")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"bad time zone", `{rotations: [{timezone: Mars/Olympus, start: "2024-01-01 00:00", shift: 24h, members: [{name: A}]}]}`, "timezone"},
+		{"bad start", `{rotations: [{start: "2024-01-01", shift: 24h, members: [{name: A}]}]}`, "start"},
+		{"no shift", `{rotations: [{start: "2024-01-01 00:00", members: [{name: A}]}]}`, "shift"},
+		{"no members", `{rotations: [{start: "2024-01-01 00:00", shift: 24h}]}`, "members"},
+		{"backwards override", `{rotations: [{start: "2024-01-01 00:00", shift: 24h, members: [{name: A}], overrides: [{from: "2024-01-02 00:00", to: "2024-01-01 00:00", member: {name: B}}]}]}`, "to must be after from"},
+	}
+
+	for _, tt := range tests {
+		if _, err := Parse([]byte(tt.yaml)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Parse() = %v, want a %s error", tt.name, err, tt.want)
+		}
+	}
+}
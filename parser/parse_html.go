@@ -0,0 +1,249 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/schors/jsm2tg/text"
+	"github.com/schors/jsm2tg/tg"
+)
+
+type htmlStack []string // closing tags
+
+func (s *htmlStack) closeUntil(result *strings.Builder, tag string) {
+	for len(*s) > 0 {
+		t := (*s)[len(*s)-1]
+		*s = (*s)[:len(*s)-1]
+
+		result.WriteString(t)
+
+		if t == tag {
+			return
+		}
+	}
+}
+
+func (s *htmlStack) has(tag string) bool {
+	for _, t := range *s {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConvertJiraToTgHTML - Convert Jira markup to Telegram HTML markup.
+// It supports the same subset of Jira markup as ConvertJiraToTgMarkup
+// and is used as a fallback when Telegram rejects the MarkdownV2 output.
+func ConvertJiraToTgHTML(input string) string {
+	var (
+		result strings.Builder
+		stack  htmlStack
+	)
+
+	pr := rune(0)
+	pre := "" // closing tag of the open preformatted block
+
+	i := 0
+	for i < len(input) {
+		r, sz := utf8.DecodeRuneInString(input[i:])
+		lineStart := i == 0 || pr == '\n'
+
+		// inside {noformat} or {code} everything is literal
+		if pre != "" {
+			switch {
+			case r == '\\' && i+sz < len(input):
+				nr, nsz := utf8.DecodeRuneInString(input[i+sz:])
+				if unicode.IsSpace(nr) {
+					result.WriteString(tg.EscapeTelegramHTML(string(r)))
+					i += sz
+
+					break
+				}
+
+				result.WriteString(tg.EscapeTelegramHTML(string(nr)))
+				i += sz + nsz
+			case strings.HasPrefix(input[i:], "{noformat}"):
+				stack.closeUntil(&result, pre)
+				pre = ""
+				i += len("{noformat}")
+			case strings.HasPrefix(input[i:], "{code}"):
+				stack.closeUntil(&result, pre)
+				pre = ""
+				i += len("{code}")
+			default:
+				result.WriteString(tg.EscapeTelegramHTML(string(r)))
+				i += sz
+			}
+
+			pr = r
+
+			continue
+		}
+
+		if r == '\\' && i+sz < len(input) {
+			nr, nsz := utf8.DecodeRuneInString(input[i+sz:])
+			if unicode.IsSpace(nr) {
+				result.WriteString(tg.EscapeTelegramHTML(string(r)))
+				i += sz
+			} else {
+				result.WriteString(tg.EscapeTelegramHTML(string(nr)))
+				i += sz + nsz
+			}
+
+			pr = r
+
+			continue
+		}
+
+		if lineStart && r == 'h' && len(input[i:]) > 3 && input[i+1] >= '1' && input[i+1] <= '6' &&
+			strings.HasPrefix(input[i+2:], ". ") {
+			j := text.FindEndOfString(input[i:])
+			if j == 0 {
+				j = len(input) - i
+			}
+
+			result.WriteString("<b>" + tg.EscapeTelegramHTML(input[i:i+j]) + "</b>")
+
+			i += j
+			pr = r
+
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(input[i:], "{noformat}"):
+			pre = "</pre>"
+
+			result.WriteString("<pre>")
+			stack = append(stack, pre)
+
+			i += len("{noformat}")
+		case r == '{' && IsLeftBlock(input[i:], "code"):
+			lang, j := DetectJiraCodeType(input[i:], "code")
+
+			pre = "</code></pre>"
+
+			result.WriteString("<pre><code class=\"language-" + tg.EscapeTelegramHTML(lang) + "\">")
+			stack = append(stack, pre)
+
+			i += j
+		case strings.HasPrefix(input[i:], "{{"):
+			end := strings.Index(input[i+2:], "}}")
+			if end < 0 {
+				result.WriteString(tg.EscapeTelegramHTML("{{"))
+				i += 2
+
+				break
+			}
+
+			result.WriteString("<code>" + tg.EscapeTelegramHTML(input[i+2:i+2+end]) + "</code>")
+
+			i += 2 + end + 2
+		case strings.HasPrefix(input[i:], "{quote}"):
+			if stack.has("</blockquote>") {
+				stack.closeUntil(&result, "</blockquote>")
+			} else {
+				result.WriteString("<blockquote>")
+				stack = append(stack, "</blockquote>")
+			}
+
+			i += len("{quote}")
+		case r == '{':
+			j := 0
+
+			for _, b := range []string{"color", "panel", "anchor"} {
+				if ok, n := DetectLeftBlock(input[i:], b); ok {
+					j = n
+
+					break
+				}
+
+				if ok, n := DetectRightBlock(input[i:], b); ok {
+					j = n
+
+					break
+				}
+			}
+
+			if j == 0 {
+				result.WriteString(tg.EscapeTelegramHTML(string(r)))
+				j = sz
+			}
+
+			i += j
+		case strings.HasPrefix(input[i:], "??"):
+			if stack.has("</i>") {
+				stack.closeUntil(&result, "</i>")
+			} else {
+				result.WriteString("<i>")
+				stack = append(stack, "</i>")
+			}
+
+			i += 2
+		case r == '!':
+			nr, nsz := utf8.DecodeRuneInString(input[i+sz:])
+			ok, end := text.DetectRune(input[i+sz:], '!')
+
+			if nsz == 0 || unicode.IsSpace(nr) || !ok {
+				result.WriteString(tg.EscapeTelegramHTML(string(r)))
+				i += sz
+
+				break
+			}
+
+			// images are dropped
+			i += sz + end + 1
+		case r == '[':
+			ok, end := text.DetectRune(input[i+sz:], ']')
+			if !ok {
+				result.WriteString(tg.EscapeTelegramHTML(string(r)))
+				i += sz
+
+				break
+			}
+
+			result.WriteString(convertJiraLinkToHTML(input[i+sz : i+sz+end]))
+
+			i += sz + end + 1
+		default:
+			result.WriteString(tg.EscapeTelegramHTML(string(r)))
+			i += sz
+		}
+
+		pr = r
+	}
+
+	stack.closeUntil(&result, "")
+
+	return result.String()
+}
+
+// convertJiraLinkToHTML - converts the inside of a Jira link ("text|url",
+// "url" or an unsupported "~user", "^attachment", "#anchor") to HTML.
+func convertJiraLinkToHTML(s string) string {
+	if s == "" || s[0] == '^' || s[0] == '#' || s[0] == '~' || strings.HasPrefix(s, "file://") {
+		return ""
+	}
+
+	label, url := s, s
+
+	if ok, j := text.DetectRune(s, '|'); ok {
+		label, url = s[:j], s[j+1:]
+		if ok, k := text.DetectRune(url, '|'); ok {
+			url = url[:k]
+		}
+	}
+
+	if okScheme, _ := text.DetectSchemeFast(url); !okScheme {
+		if label == s {
+			return ""
+		}
+
+		return tg.EscapeTelegramHTML(label)
+	}
+
+	return "<a href=\"" + tg.EscapeTelegramHTML(url) + "\">" + tg.EscapeTelegramHTML(label) + "</a>"
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestConvertJiraToTgHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "plain text",
+			input: "1 < 2 & 3 > 2",
+			want:  "1 &lt; 2 &amp; 3 &gt; 2",
+		},
+		{
+			name:  "heading",
+			input: "h1. Title\ntext",
+			want:  "<b>h1. Title</b>\ntext",
+		},
+		{
+			name:  "monospace",
+			input: "run {{make <all>}} now",
+			want:  "run <code>make &lt;all&gt;</code> now",
+		},
+		{
+			name:  "unclosed monospace",
+			input: "run {{make",
+			want:  "run {{make",
+		},
+		{
+			name:  "link with text",
+			input: "see [docs|https://example.com/?a=1&b=2]",
+			want:  "see <a href=\"https://example.com/?a=1&amp;b=2\">docs</a>",
+		},
+		{
+			name:  "bare link",
+			input: "[https://example.com]",
+			want:  "<a href=\"https://example.com\">https://example.com</a>",
+		},
+		{
+			name:  "unsupported link",
+			input: "ping [~admin] now",
+			want:  "ping  now",
+		},
+		{
+			name:  "citation in quote",
+			input: "{quote}??cite{quote} after",
+			want:  "<blockquote><i>cite</i></blockquote> after",
+		},
+		{
+			name:  "color and panel",
+			input: "{panel:title=x}{color:red}red{color}{panel}",
+			want:  "red",
+		},
+		{
+			name:  "code block",
+			input: "{code:go}if a < b {}{code}",
+			want:  "<pre><code class=\"language-go\">if a &lt; b {}</code></pre>",
+		},
+		{
+			name:  "unclosed noformat",
+			input: "{noformat}<x>",
+			want:  "<pre>&lt;x&gt;</pre>",
+		},
+		{
+			name:  "image",
+			input: "look !image.png! here",
+			want:  "look  here",
+		},
+		{
+			name:  "escaped",
+			input: `\[not a link]`,
+			want:  "[not a link]",
+		},
+	}
+
+	for _, tt := range tests {
+		got := ConvertJiraToTgHTML(tt.input)
+		if got != tt.want {
+			t.Errorf("%s: ConvertJiraToTgHTML(%q) = %q, want %q", tt.name, tt.input, got, tt.want)
+		}
+	}
+}
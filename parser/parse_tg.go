@@ -0,0 +1,236 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/schors/jsm2tg/tg"
+)
+
+// jiraMarks - Jira markup wrapped around the text of an entity type.
+var jiraMarks = map[string][2]string{
+	"bold":                  {"*", "*"},
+	"italic":                {"_", "_"},
+	"underline":             {"+", "+"},
+	"strikethrough":         {"-", "-"},
+	"code":                  {"{{", "}}"},
+	"blockquote":            {"{quote}", "{quote}"},
+	"expandable_blockquote": {"{quote}", "{quote}"},
+}
+
+// inlineEntities - entities whose markup breaks on surrounding spaces.
+var inlineEntities = map[string]bool{
+	"bold":          true,
+	"italic":        true,
+	"underline":     true,
+	"strikethrough": true,
+	"code":          true,
+	"text_link":     true,
+}
+
+// literalEntities - entities whose text is copied without escaping.
+var literalEntities = map[string]bool{
+	"code":  true,
+	"pre":   true,
+	"url":   true,
+	"email": true,
+}
+
+// ConvertTgToJira - Convert a Telegram message text with its entities
+// to Jira markup. Offsets and lengths of entities are in UTF-16 code units.
+func ConvertTgToJira(input string, entities []tg.MessageEntity) string {
+	units := utf16.Encode([]rune(input))
+
+	type span struct {
+		start, end int
+		e          tg.MessageEntity
+	}
+
+	var spans []span
+
+	for _, e := range entities {
+		start, end := e.Offset, e.Offset+e.Length
+		if start < 0 || end > len(units) || start >= end {
+			continue
+		}
+
+		// Jira needs the markup next to the text
+		if inlineEntities[e.Type] {
+			for start < end && isSpaceUnit(units[start]) {
+				start++
+			}
+
+			for end > start && isSpaceUnit(units[end-1]) {
+				end--
+			}
+
+			if start == end {
+				continue
+			}
+		}
+
+		spans = append(spans, span{start, end, e})
+	}
+
+	// outer entities first
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+
+		return spans[i].end > spans[j].end
+	})
+
+	var (
+		result  strings.Builder
+		open    []span
+		literal int // open entities copied literally
+	)
+
+	closeSpan := func(s span) {
+		switch s.e.Type {
+		case "pre":
+			if s.e.Language != "" {
+				result.WriteString("\n{code}")
+			} else {
+				result.WriteString("\n{noformat}")
+			}
+		case "text_link":
+			result.WriteString("|" + s.e.URL + "]")
+		default:
+			result.WriteString(jiraMarks[s.e.Type][1])
+		}
+
+		if literalEntities[s.e.Type] {
+			literal--
+		}
+	}
+
+	openSpan := func(s span) {
+		switch s.e.Type {
+		case "pre":
+			if s.e.Language != "" {
+				result.WriteString("{code:" + s.e.Language + "}\n")
+			} else {
+				result.WriteString("{noformat}\n")
+			}
+		case "text_link":
+			result.WriteString("[")
+		default:
+			result.WriteString(jiraMarks[s.e.Type][0])
+		}
+
+		if literalEntities[s.e.Type] {
+			literal++
+		}
+	}
+
+	next := 0
+	pos := 0
+
+	for pos <= len(units) {
+		// close entities ending here, innermost first; an entity
+		// crossing the border of an inner one is closed early
+		for len(open) > 0 {
+			top := open[len(open)-1]
+			if top.end > pos {
+				break
+			}
+
+			open = open[:len(open)-1]
+			closeSpan(top)
+		}
+
+		for next < len(spans) && spans[next].start == pos {
+			s := spans[next]
+			next++
+
+			if len(open) > 0 && s.end > open[len(open)-1].end {
+				s.end = open[len(open)-1].end
+			}
+
+			open = append(open, s)
+			openSpan(s)
+		}
+
+		if pos == len(units) {
+			break
+		}
+
+		// the text up to the next entity border
+		end := len(units)
+		if next < len(spans) && spans[next].start < end {
+			end = spans[next].start
+		}
+
+		if len(open) > 0 && open[len(open)-1].end < end {
+			end = open[len(open)-1].end
+		}
+
+		s := string(utf16.Decode(units[pos:end]))
+		if literal > 0 {
+			result.WriteString(s)
+		} else {
+			result.WriteString(EscapeJira(s))
+		}
+
+		pos = end
+	}
+
+	return result.String()
+}
+
+func isSpaceUnit(u uint16) bool {
+	return u < 0x80 && unicode.IsSpace(rune(u))
+}
+
+// isBorder - reports whether r can delimit Jira inline markup.
+func isBorder(r rune) bool {
+	return r == 0 || unicode.IsSpace(r) || unicode.IsPunct(r)
+}
+
+// EscapeJira - escapes plain text so that Jira renders it as is.
+// Inline markup characters are only escaped where they could open
+// or close markup, so "e-mail" and "snake_case" stay readable.
+func EscapeJira(s string) string {
+	rr := []rune(s)
+
+	var result strings.Builder
+
+	for i, r := range rr {
+		prev, next := rune(0), rune(0)
+		if i > 0 {
+			prev = rr[i-1]
+		}
+
+		if i+1 < len(rr) {
+			next = rr[i+1]
+		}
+
+		escape := false
+
+		switch r {
+		case '\\', '{', '}', '[', ']', '|':
+			escape = true
+		case '*', '_', '+', '-', '^', '~':
+			escape = (isBorder(prev) && next != 0 && !unicode.IsSpace(next)) ||
+				(prev != 0 && !unicode.IsSpace(prev) && isBorder(next))
+		case '!':
+			escape = isBorder(prev) && next != 0 && !unicode.IsSpace(next)
+		case '?':
+			escape = next == '?'
+		case '#':
+			escape = prev == 0 || prev == '\n'
+		}
+
+		if escape {
+			result.WriteRune('\\')
+		}
+
+		result.WriteRune(r)
+	}
+
+	return result.String()
+}
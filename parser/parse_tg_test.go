@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/schors/jsm2tg/tg"
+)
+
+func TestConvertTgToJira(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		entities []tg.MessageEntity
+		want     string
+	}{
+		{
+			name:  "plain text",
+			input: "an e-mail about snake_case, 2*3 and [brackets]",
+			want:  "an e-mail about snake_case, 2*3 and \\[brackets\\]",
+		},
+		{
+			name:  "markup characters",
+			input: "*not bold* and -not struck- ok??",
+			want:  "\\*not bold\\* and \\-not struck\\- ok\\??",
+		},
+		{
+			name:     "bold and italic",
+			input:    "bold italic",
+			entities: []tg.MessageEntity{{Type: "bold", Offset: 0, Length: 11}, {Type: "italic", Offset: 5, Length: 6}},
+			want:     "*bold _italic_*",
+		},
+		{
+			name:     "trailing space moved out",
+			input:    "bold text",
+			entities: []tg.MessageEntity{{Type: "bold", Offset: 0, Length: 5}},
+			want:     "*bold* text",
+		},
+		{
+			name:     "utf-16 offsets",
+			input:    "🔥 fire",
+			entities: []tg.MessageEntity{{Type: "underline", Offset: 3, Length: 4}},
+			want:     "🔥 +fire+",
+		},
+		{
+			name:     "code is literal",
+			input:    "run make [all]",
+			entities: []tg.MessageEntity{{Type: "code", Offset: 4, Length: 10}},
+			want:     "run {{make [all]}}",
+		},
+		{
+			name:     "pre with language",
+			input:    "x := 1",
+			entities: []tg.MessageEntity{{Type: "pre", Offset: 0, Length: 6, Language: "go"}},
+			want:     "{code:go}\nx := 1\n{code}",
+		},
+		{
+			name:     "pre without language",
+			input:    "log",
+			entities: []tg.MessageEntity{{Type: "pre", Offset: 0, Length: 3}},
+			want:     "{noformat}\nlog\n{noformat}",
+		},
+		{
+			name:     "text link",
+			input:    "see docs",
+			entities: []tg.MessageEntity{{Type: "text_link", Offset: 4, Length: 4, URL: "https://example.com/a_b"}},
+			want:     "see [docs|https://example.com/a_b]",
+		},
+		{
+			name:     "url is literal",
+			input:    "https://example.com/a_b-",
+			entities: []tg.MessageEntity{{Type: "url", Offset: 0, Length: 24}},
+			want:     "https://example.com/a_b-",
+		},
+		{
+			name:     "quote",
+			input:    "quoted",
+			entities: []tg.MessageEntity{{Type: "blockquote", Offset: 0, Length: 6}},
+			want:     "{quote}quoted{quote}",
+		},
+		{
+			name:     "unknown entity and bad offsets",
+			input:    "@alice hi",
+			entities: []tg.MessageEntity{{Type: "mention", Offset: 0, Length: 6}, {Type: "bold", Offset: 5, Length: 40}},
+			want:     "@alice hi",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := ConvertTgToJira(tt.input, tt.entities); got != tt.want {
+			t.Errorf("%s: ConvertTgToJira() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package render
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/schors/jsm2tg/jira"
+	"github.com/schors/jsm2tg/parser"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// MaxChangeRunes - longer values are cut when a change is formatted.
+const MaxChangeRunes = 100
+
+// MaxDiffRunes - longer word diffs of text fields are cut.
+const MaxDiffRunes = 1000
+
+// Change - a field change of issue updates. From and To are the values
+// as shown, empty if unset; FromID and ToID are the raw values, e.g. the
+// account ids of users. FromMention and ToMention mention the users
+// linked to Telegram; the daemon sets them.
+type Change struct {
+	Field                  string // as shown, e.g. "Status"
+	FieldID                string // e.g. "status" or "customfield_10020"; empty in Jira Server
+	From, To               string
+	FromID, ToID           string
+	FromMention, ToMention Markdown
+}
+
+// NewChanges - extracts the field changes of a changelog, which may be nil.
+func NewChanges(cl *jira.Changelog) []Change {
+	if cl == nil {
+		return nil
+	}
+
+	var cc []Change
+
+	for _, it := range cl.Items {
+		cc = append(cc, Change{
+			Field:   fieldName(it.Field),
+			FieldID: it.FieldID,
+			From:    it.FromString,
+			To:      it.ToString,
+			FromID:  it.From,
+			ToID:    it.To,
+		})
+	}
+
+	return cc
+}
+
+// fieldName - capitalizes the changelog names of system fields ("status").
+func fieldName(f string) string {
+	r, n := utf8.DecodeRuneInString(f)
+	if n == 0 {
+		return f
+	}
+
+	return string(unicode.ToUpper(r)) + f[n:]
+}
+
+func (c Change) key() string {
+	return strings.ToLower(cmp.Or(c.FieldID, c.Field))
+}
+
+// IsUser - reports whether the change is of a user field (assignee, reporter).
+func (c Change) IsUser() bool {
+	return c.key() == "assignee" || c.key() == "reporter"
+}
+
+// IsText - reports whether the change is of a multi-line text field,
+// shown as a word diff.
+func (c Change) IsText() bool {
+	return c.key() == "description" || c.key() == "environment"
+}
+
+// Labels - returns the labels added and removed.
+func (c Change) Labels() (added, removed []string) {
+	from, to := strings.Fields(c.From), strings.Fields(c.To)
+
+	for _, l := range to {
+		if !slices.Contains(from, l) {
+			added = append(added, l)
+		}
+	}
+
+	for _, l := range from {
+		if !slices.Contains(to, l) {
+			removed = append(removed, l)
+		}
+	}
+
+	return added, removed
+}
+
+// Markdown - renders the change in MarkdownV2: "Field: from → to" with
+// priority emoji and user mentions, labels as "+added -removed", and
+// text fields as a word diff on the next lines.
+func (c Change) Markdown() Markdown {
+	field := tg.EscapeTelegram(c.Field) + ":"
+
+	switch {
+	case c.key() == "labels":
+		return Markdown(field + " " + tg.EscapeTelegram(c.labels()))
+	case c.IsText():
+		return Markdown(field + "\n" + parser.ConvertJiraToTgMarkup(Truncate(WordDiff(c.From, c.To), MaxDiffRunes)))
+	}
+
+	from, to := c.values()
+
+	return Markdown(field + " " + string(cmp.Or(c.FromMention, escape(from))) + " → " + string(cmp.Or(c.ToMention, escape(to))))
+}
+
+// String - renders the change as plain text, like Markdown.
+func (c Change) String() string {
+	switch {
+	case c.key() == "labels":
+		return c.Field + ": " + c.labels()
+	case c.IsText():
+		return c.Field + ":\n" + Truncate(WordDiff(c.From, c.To), MaxDiffRunes)
+	}
+
+	from, to := c.values()
+
+	return c.Field + ": " + from + " → " + to
+}
+
+func (c Change) labels() string {
+	added, removed := c.Labels()
+
+	var out []string
+
+	for _, l := range added {
+		out = append(out, "+"+l)
+	}
+
+	for _, l := range removed {
+		out = append(out, "-"+l)
+	}
+
+	return strings.Join(out, " ")
+}
+
+func (c Change) values() (from, to string) {
+	value := func(v string) string {
+		switch {
+		case v == "":
+			return "—"
+		case c.key() == "priority":
+			return EmojiForPriority(v) + " " + v
+		default:
+			return Truncate(v, MaxChangeRunes)
+		}
+	}
+
+	return value(c.From), value(c.To)
+}
+
+// Changes - renders changes in MarkdownV2: the one-line changes joined
+// with commas, then the word diffs of text fields.
+func Changes(cc []Change) Markdown {
+	return Markdown(joinChanges(cc, func(c Change) string { return string(c.Markdown()) }))
+}
+
+// PlainChanges - renders changes as plain text, like Changes.
+func PlainChanges(cc []Change) string {
+	return joinChanges(cc, Change.String)
+}
+
+func joinChanges(cc []Change, format func(Change) string) string {
+	var inline, blocks []string
+
+	for _, c := range cc {
+		if c.IsText() {
+			blocks = append(blocks, format(c))
+		} else {
+			inline = append(inline, format(c))
+		}
+	}
+
+	if len(inline) > 0 {
+		blocks = slices.Insert(blocks, 0, strings.Join(inline, ", "))
+	}
+
+	return strings.Join(blocks, "\n")
+}
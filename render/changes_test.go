@@ -0,0 +1,135 @@
+package render
+
+import (
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func TestWordDiff(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"the quick brown fox", "the slow brown fox", "the -quick- +slow+ brown fox"},
+		{"", "new text", "+new text+"},
+		{"old\nsecond line", "old", "old\n-second line-"},
+		{"one two three four five six seven eight nine ten eleven", "one two three four five six seven eight nine ten twelve",
+			"… six seven eight nine ten -eleven- +twelve+"},
+		{"a b c d e f g h i j k l m n", "x b c d e f g h i j k l m y", "-a- +x+ b c d e f … i j k l m -n- +y+"},
+	}
+
+	for _, tt := range tests {
+		if got := WordDiff(tt.from, tt.to); got != tt.want {
+			t.Errorf("WordDiff(%q, %q) = %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+
+	words := func(n int) []string {
+		w := make([]string, n)
+		for i := range w {
+			w[i] = string(rune('a' + rnd.IntN(4)))
+		}
+
+		return w
+	}
+
+	// the longest common subsequence, by the full table
+	lcs := func(a, b []string) int {
+		t := make([][]int, len(a)+1)
+		for i := range t {
+			t[i] = make([]int, len(b)+1)
+		}
+
+		for i := range a {
+			for j := range b {
+				if a[i] == b[j] {
+					t[i+1][j+1] = t[i][j] + 1
+				} else {
+					t[i+1][j+1] = max(t[i][j+1], t[i+1][j])
+				}
+			}
+		}
+
+		return t[len(a)][len(b)]
+	}
+
+	for _, n := range [][2]int{{0, 3}, {1, 1}, {7, 5}, {40, 60}, {300, 250}} {
+		a, b := words(n[0]), words(n[1])
+
+		var from, to []string
+
+		common := 0
+
+		for _, op := range diffWords(a, b) {
+			switch op.kind {
+			case '=':
+				from, to = append(from, op.tokens...), append(to, op.tokens...)
+				common += len(op.tokens)
+			case '-':
+				from = append(from, op.tokens...)
+			case '+':
+				to = append(to, op.tokens...)
+			}
+		}
+
+		if strings.Join(from, "") != strings.Join(a, "") || strings.Join(to, "") != strings.Join(b, "") {
+			t.Errorf("%v: the diff doesn't rebuild the texts", n)
+		}
+
+		if want := lcs(a, b); common != want {
+			t.Errorf("%v: %d tokens kept, want %d", n, common, want)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		changes  []Change
+		markdown Markdown
+		plain    string
+	}{
+		{
+			"status and assignee",
+			[]Change{
+				{Field: "Status", From: "Open", To: "In Progress"},
+				{Field: "Assignee", FieldID: "assignee", From: "Bob", To: "Alice", ToMention: "[Alice](tg://user?id=501)"},
+			},
+			"Status: Open → In Progress, Assignee: Bob → [Alice](tg://user?id=501)",
+			"Status: Open → In Progress, Assignee: Bob → Alice",
+		},
+		{
+			"priority and labels",
+			[]Change{
+				{Field: "Priority", From: "High", To: "Low"},
+				{Field: "Labels", From: "vpn office", To: "vpn remote-access"},
+			},
+			"Priority: 🟠 High → 🟢 Low, Labels: \\+remote\\-access \\-office",
+			"Priority: 🟠 High → 🟢 Low, Labels: +remote-access -office",
+		},
+		{
+			"description",
+			[]Change{
+				{Field: "Description", From: "VPN is down.", To: "VPN is up."},
+				{Field: "Fix Version", To: "2.1"},
+			},
+			"Fix Version: — → 2\\.1\nDescription:\nVPN is \\-down\\.\\- \\+up\\.\\+",
+			"Fix Version: — → 2.1\nDescription:\nVPN is -down.- +up.+",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := Changes(tt.changes); got != tt.markdown {
+			t.Errorf("%s: Changes = %q, want %q", tt.name, got, tt.markdown)
+		}
+
+		if got := PlainChanges(tt.changes); got != tt.plain {
+			t.Errorf("%s: PlainChanges = %q, want %q", tt.name, got, tt.plain)
+		}
+	}
+}
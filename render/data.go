@@ -0,0 +1,178 @@
+package render
+
+import (
+	"time"
+
+	"github.com/schors/jsm2tg/jira"
+)
+
+// Issue - the issue fields available to templates. Description is the
+// raw Jira markup; use jiraToTg to convert it.
+type Issue struct {
+	Key         string
+	Summary     string
+	Project     string
+	IssueType   string
+	RequestType string
+	Priority    string
+	Status      string
+	Resolution  string
+	Reporter    string
+	Assignee    string
+	Labels      []string
+	Components  []string
+	Description string
+	URL         string
+	Created     time.Time
+	Updated     time.Time
+	SLA         []jira.SLA
+}
+
+// Comment - the comment of comment events.
+type Comment struct {
+	Author   string
+	Body     string // raw Jira markup
+	Internal bool
+}
+
+// Alert - the SLA of SLA alerts, with SLA.Remaining as of the alert.
+// Overdue is the time since the breach; Mention mentions the assignee
+// in Telegram if they are linked.
+type Alert struct {
+	SLA       jira.SLA
+	Threshold time.Duration
+	Breached  bool
+	Overdue   time.Duration
+	Mention   Markdown
+}
+
+// Approval - the approval of approval requests. Decision is the final
+// decision: "pending", "approved" or "declined".
+type Approval struct {
+	Name      string
+	Decision  string
+	DecidedBy string
+}
+
+// Digest - the summary of digests. Created and Resolved count the
+// issues since the last digest; the lists have at most Top issues each.
+type Digest struct {
+	Rule     string    // the routing rule
+	Time     time.Time // when the digest is sent, in its time zone
+	Created  int
+	Resolved int
+	Open     int
+	Breached []DigestIssue // the issues with SLAs breached since the last digest
+	Oldest   []DigestIssue // the open issues, oldest first
+	Top      []DigestIssue // the issues with the most events
+}
+
+// DigestIssue - an issue listed in digests. Events counts its events
+// since the last digest, Breached names the SLAs breached since then.
+type DigestIssue struct {
+	Key      string
+	Summary  string
+	Priority string
+	Status   string
+	URL      string
+	Created  time.Time
+	Events   int
+	Breached []string
+}
+
+// Data - the value templates are executed with.
+type Data struct {
+	Event    string // the event kind, see jira.Event.Kind
+	Actor    string // who triggered the event
+	Issue    Issue
+	Changes  []Change // the changelog of issue updates
+	Comment  *Comment
+	Alert    *Alert
+	Approval *Approval
+	Digest   *Digest
+	OnCall   Markdown // mentions who is on call for the destination, if anyone
+}
+
+// DisplayName - returns the user's display name, or "" for nil.
+func DisplayName(u *jira.User) string {
+	switch {
+	case u == nil:
+		return ""
+	case u.DisplayName != "":
+		return u.DisplayName
+	default:
+		return u.ID()
+	}
+}
+
+func name(n *jira.Named) string {
+	if n == nil {
+		return ""
+	}
+
+	return n.Name
+}
+
+// NewIssue - extracts template data from an issue.
+func NewIssue(is *jira.Issue, jiraURL string) Issue {
+	f := &is.Fields
+
+	i := Issue{
+		Key:         is.Key,
+		Summary:     f.Summary,
+		IssueType:   name(f.IssueType),
+		RequestType: f.RequestType(),
+		Priority:    name(f.Priority),
+		Resolution:  name(f.Resolution),
+		Reporter:    DisplayName(f.Reporter),
+		Assignee:    DisplayName(f.Assignee),
+		Labels:      f.Labels,
+		Description: f.Description,
+		Created:     f.Created.Time,
+		Updated:     f.Updated.Time,
+		SLA:         f.SLAs(),
+	}
+
+	if f.Project != nil {
+		i.Project = f.Project.Key
+	}
+
+	if f.Status != nil {
+		i.Status = f.Status.Name
+	}
+
+	for _, c := range f.Components {
+		i.Components = append(i.Components, c.Name)
+	}
+
+	if jiraURL != "" {
+		i.URL = jira.BrowseURL(jiraURL, is.Key)
+	}
+
+	return i
+}
+
+// NewData - extracts template data from a webhook event.
+func NewData(e *jira.Event, jiraURL string) Data {
+	d := Data{Event: e.Kind(), Actor: DisplayName(e.User)}
+
+	if e.Issue != nil {
+		d.Issue = NewIssue(e.Issue, jiraURL)
+	}
+
+	d.Changes = NewChanges(e.Changelog)
+
+	if e.Comment != nil {
+		d.Comment = &Comment{
+			Author:   DisplayName(e.Comment.Author),
+			Body:     e.Comment.Body,
+			Internal: e.Comment.Internal(),
+		}
+
+		if d.Actor == "" {
+			d.Actor = d.Comment.Author
+		}
+	}
+
+	return d
+}
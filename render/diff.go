@@ -0,0 +1,233 @@
+package render
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// diffContext - the unchanged words kept around the changes of a diff.
+const diffContext = 5
+
+// maxDiffTokens - texts with more words and spaces than this are
+// diffed as a whole, not word by word.
+const maxDiffTokens = 2000
+
+var diffTokens = regexp.MustCompile(`\s+|\S+`)
+
+type diffOp struct {
+	kind   byte // '=', '-' or '+'
+	tokens []string
+}
+
+// WordDiff - compares two Jira markup texts word by word and returns the
+// changes in Jira markup: removed words -struck-, added words +underlined+,
+// the unchanged words around them cut to a few with "…".
+func WordDiff(from, to string) string {
+	a, b := diffTokens.FindAllString(from, -1), diffTokens.FindAllString(to, -1)
+
+	var ops []diffOp
+	if len(a) > maxDiffTokens || len(b) > maxDiffTokens {
+		ops = []diffOp{{'-', a}, {'+', b}}
+	} else {
+		ops = diffWords(a, b)
+	}
+
+	var sb strings.Builder
+
+	for i, op := range ops {
+		switch op.kind {
+		case '-', '+':
+			// markers must not touch
+			if i > 0 && ops[i-1].kind == '-' && op.kind == '+' {
+				sb.WriteString(" ")
+			}
+
+			sb.WriteString(mark(strings.Join(op.tokens, ""), string(op.kind)))
+		default:
+			sb.WriteString(unchanged(op.tokens, i == 0, i == len(ops)-1))
+		}
+	}
+
+	return strings.TrimSpace(sb.String())
+}
+
+// diffWords - the shortest edit of a into b, by the longest common
+// subsequence of their tokens. The common prefix and suffix are matched
+// first, the rest with Hirschberg's algorithm in linear memory.
+func diffWords(a, b []string) []diffOp {
+	var ops []diffOp
+
+	add := func(kind byte, tokens ...string) {
+		if len(tokens) == 0 {
+			return
+		}
+
+		if n := len(ops); n > 0 && ops[n-1].kind == kind {
+			ops[n-1].tokens = append(ops[n-1].tokens, tokens...)
+
+			return
+		}
+
+		ops = append(ops, diffOp{kind, append([]string(nil), tokens...)})
+	}
+
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+
+	s := 0
+	for s < len(a)-p && s < len(b)-p && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+
+	add('=', a[:p]...)
+	hirschberg(a[p:len(a)-s], b[p:len(b)-s], add)
+	add('=', a[len(a)-s:]...)
+
+	return ops
+}
+
+// hirschberg - adds the edit of a into b: a is split in halves and b
+// where the common subsequences of the halves add up to the longest,
+// then both parts are diffed alike. Removals go before additions.
+func hirschberg(a, b []string, add func(kind byte, tokens ...string)) {
+	switch {
+	case len(a) == 0:
+		add('+', b...)
+	case len(b) == 0:
+		add('-', a...)
+	case len(a) == 1:
+		k := slices.Index(b, a[0])
+		if k < 0 {
+			add('-', a[0])
+			add('+', b...)
+
+			return
+		}
+
+		add('+', b[:k]...)
+		add('=', a[0])
+		add('+', b[k+1:]...)
+	default:
+		mid := len(a) / 2
+		head, tail := lcsLengths(a[:mid], b, false), lcsLengths(a[mid:], b, true)
+
+		k, best := 0, -1
+		for j := range len(b) + 1 {
+			if n := head[j] + tail[len(b)-j]; n > best {
+				k, best = j, n
+			}
+		}
+
+		hirschberg(a[:mid], b[:k], add)
+		hirschberg(a[mid:], b[k:], add)
+	}
+}
+
+// lcsLengths - the lengths of the longest common subsequences of a and
+// every prefix of b, by their length; of the suffixes with reverse.
+// Only two rows of the table are kept.
+func lcsLengths(a, b []string, reverse bool) []int {
+	at := func(s []string, i int) string {
+		if reverse {
+			return s[len(s)-1-i]
+		}
+
+		return s[i]
+	}
+
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+
+	for i := range a {
+		for j := range b {
+			if at(a, i) == at(b, j) {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev
+}
+
+// mark - wraps the words of every line of s in the Jira markup marker m,
+// leaving the surrounding spaces outside.
+func mark(s, m string) string {
+	lines := strings.Split(s, "\n")
+
+	for i, l := range lines {
+		words := strings.TrimSpace(l)
+		if words == "" {
+			continue
+		}
+
+		start := strings.Index(l, words)
+		lines[i] = l[:start] + m + words + m + l[start+len(words):]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// unchanged - cuts unchanged tokens to the words next to the changes:
+// the last ones at the start of the text, the first ones at its end,
+// both in between.
+func unchanged(tokens []string, first, last bool) string {
+	words := 0
+	for _, t := range tokens {
+		if strings.TrimSpace(t) != "" {
+			words++
+		}
+	}
+
+	keep := 2 * diffContext
+	if first || last {
+		keep = diffContext
+	}
+
+	if first && last || words <= keep {
+		return strings.Join(tokens, "")
+	}
+
+	// head - the tokens of the first n words, tail - of the last n
+	head := func(n int) string {
+		for i, t := range tokens {
+			if strings.TrimSpace(t) != "" {
+				if n == 0 {
+					return strings.Join(tokens[:i], "")
+				}
+
+				n--
+			}
+		}
+
+		return strings.Join(tokens, "")
+	}
+
+	tail := func(n int) string {
+		for i := len(tokens) - 1; i >= 0; i-- {
+			if strings.TrimSpace(tokens[i]) != "" {
+				if n == 0 {
+					return strings.Join(tokens[i+1:], "")
+				}
+
+				n--
+			}
+		}
+
+		return strings.Join(tokens, "")
+	}
+
+	switch {
+	case first:
+		return "…" + tail(diffContext)
+	case last:
+		return head(diffContext) + "…"
+	default:
+		return head(diffContext) + "…" + tail(diffContext)
+	}
+}
@@ -0,0 +1,215 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/jira"
+)
+
+// DefaultKey - the template key used for events without their own template.
+const DefaultKey = "default"
+
+// SLAKey - the template key and routing event of SLA alerts.
+const SLAKey = "sla_alert"
+
+// ApprovalKey - the template key of approval requests sent to approvers.
+const ApprovalKey = "approval"
+
+// TicketKey - the template key of the full issue view shown by /ticket.
+const TicketKey = "ticket"
+
+// DigestKey - the template key of scheduled digests.
+const DigestKey = "digest"
+
+// defaultTemplates - the built-in templates, by event kind.
+var defaultTemplates = map[string]string{
+	jira.EventIssueCreated: `🆕 *{{.Issue.Key}}: {{.Issue.Summary}}*
+{{emojiForPriority .Issue.Priority}} {{.Issue.Priority}} · {{.Issue.IssueType}}{{with .Issue.RequestType}} · {{.}}{{end}} · {{.Issue.Status}}
+Reporter: {{.Issue.Reporter}}
+{{- range .Issue.SLA}}
+⏱ {{.Name}}: {{if .Breached}}breached{{else}}{{duration .Remaining}} left{{end}}
+{{- end}}
+{{- with .OnCall}}
+On call: {{.}}
+{{- end}}
+{{- with .Issue.Description}}
+
+{{truncate 1500 . | jiraToTg}}
+{{- end}}
+{{- with .Issue.URL}}
+
+{{link . "Open in Jira"}}
+{{- end}}`,
+
+	jira.EventIssueUpdated: `✏️ *{{.Issue.Key}}: {{.Issue.Summary}}*
+{{- with .Changes}}
+{{changes .}}
+{{- end}}
+{{emojiForPriority .Issue.Priority}} {{.Issue.Priority}} · {{.Issue.Status}}
+Assignee: {{or .Issue.Assignee "Unassigned"}}
+{{- with .Actor}}
+Updated by {{.}}
+{{- end}}
+{{- with .Issue.URL}}
+
+{{link . "Open in Jira"}}
+{{- end}}`,
+
+	jira.EventCommentCreated: `💬 *{{.Issue.Key}}: {{.Issue.Summary}}*
+{{.Comment.Author}} {{if eq .Event "comment_updated"}}edited a comment{{else}}commented{{end}}{{if .Comment.Internal}} \(internal\){{end}}:
+
+{{truncate 1500 .Comment.Body | jiraToTg}}
+{{- with .Issue.URL}}
+
+{{link . "Open in Jira"}}
+{{- end}}`,
+
+	TicketKey: `📋 *{{.Issue.Key}}: {{.Issue.Summary}}*
+{{emojiForPriority .Issue.Priority}} {{.Issue.Priority}} · {{.Issue.IssueType}}{{with .Issue.RequestType}} · {{.}}{{end}} · {{.Issue.Status}}
+Reporter: {{.Issue.Reporter}}
+Assignee: {{or .Issue.Assignee "Unassigned"}}
+{{- range .Issue.SLA}}
+⏱ {{.Name}}: {{if .Breached}}breached{{else}}{{duration .Remaining}} left{{end}}
+{{- end}}
+{{- with .Issue.Description}}
+
+{{jiraToTg .}}
+{{- end}}
+{{- with .Issue.URL}}
+
+{{link . "Open in Jira"}}
+{{- end}}`,
+
+	SLAKey: `{{if .Alert.Breached}}🔥{{else}}⏰{{end}} *{{.Issue.Key}}: {{.Issue.Summary}}*
+{{.Alert.SLA.Name}}: {{if .Alert.Breached}}breached{{with .Alert.Overdue}} {{duration .}} ago{{end}}{{else}}{{duration .Alert.SLA.Remaining}} left{{end}}
+{{emojiForPriority .Issue.Priority}} {{.Issue.Priority}} · {{.Issue.Status}}
+Assignee: {{with .Alert.Mention}}{{.}}{{else}}{{or .Issue.Assignee "Unassigned"}}{{end}}
+{{- with .OnCall}}
+On call: {{.}}
+{{- end}}
+{{- with .Issue.URL}}
+
+{{link . "Open in Jira"}}
+{{- end}}`,
+
+	ApprovalKey: `{{if eq .Approval.Decision "approved"}}✅{{else if eq .Approval.Decision "declined"}}❌{{else}}🗳{{end}} *{{.Issue.Key}}: {{.Issue.Summary}}*
+Approval: {{.Approval.Name}}
+{{emojiForPriority .Issue.Priority}} {{.Issue.Priority}}{{with .Issue.RequestType}} · {{.}}{{end}}
+Reporter: {{.Issue.Reporter}}
+{{- with .Issue.Description}}
+
+{{truncate 1500 . | jiraToTg}}
+{{- end}}
+{{- if ne .Approval.Decision "pending"}}
+
+*{{if eq .Approval.Decision "approved"}}Approved{{else}}Declined{{end}}{{with .Approval.DecidedBy}} by {{.}}{{end}}*
+{{- end}}
+{{- with .Issue.URL}}
+
+{{link . "Open in Jira"}}
+{{- end}}`,
+
+	DigestKey: `📊 *Digest{{with .Digest.Rule}}: {{.}}{{end}}*
+{{.Digest.Time.Format "Mon, 02 Jan 2006 15:04 MST"}}
+🆕 Created: {{.Digest.Created}} · ✅ Resolved: {{.Digest.Resolved}} · 📂 Open: {{.Digest.Open}}
+{{- with .Digest.Breached}}
+
+*🔥 SLA breaches*
+{{- range .}}
+{{emojiForPriority .Priority}} {{if .URL}}{{link .URL .Key}}{{else}}{{.Key}}{{end}}: {{truncate 100 .Summary}} · {{join .Breached ", "}}
+{{- end}}
+{{- end}}
+{{- with .Digest.Oldest}}
+
+*⏳ Oldest open*
+{{- range .}}
+{{emojiForPriority .Priority}} {{if .URL}}{{link .URL .Key}}{{else}}{{.Key}}{{end}}: {{truncate 100 .Summary}} · {{.Status}} · {{timeAgo .Created}}
+{{- end}}
+{{- end}}
+{{- with .Digest.Top}}
+
+*📈 Most active*
+{{- range .}}
+{{emojiForPriority .Priority}} {{if .URL}}{{link .URL .Key}}{{else}}{{.Key}}{{end}}: {{truncate 100 .Summary}} · {{.Events}} {{if eq .Events 1}}event{{else}}events{{end}}
+{{- end}}
+{{- end}}`,
+
+	jira.EventIssueDeleted: `🗑 *{{.Issue.Key}}: {{.Issue.Summary}}*
+Issue deleted`,
+}
+
+func init() {
+	defaultTemplates[jira.EventCommentUpdated] = defaultTemplates[jira.EventCommentCreated]
+}
+
+// Set - templates by event kind with an optional parent set
+// consulted for events the set has no template for.
+type Set struct {
+	byEvent map[string]*Template
+	parent  *Set
+}
+
+// NewSet - compiles templates keyed by event kind or DefaultKey.
+func NewSet(texts map[string]string, parent *Set, clk clock.Clock) (*Set, error) {
+	s := &Set{byEvent: make(map[string]*Template, len(texts)), parent: parent}
+
+	keys := make([]string, 0, len(texts))
+	for k := range texts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var errs []error
+
+	for _, k := range keys {
+		t, err := Parse(k, texts[k], clk)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", k, err))
+
+			continue
+		}
+
+		s.byEvent[k] = t
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Defaults - returns the built-in templates.
+func Defaults(clk clock.Clock) *Set {
+	s, err := NewSet(defaultTemplates, nil, clk)
+	if err != nil {
+		panic("render: built-in templates: " + err.Error())
+	}
+
+	return s
+}
+
+// ownData - the template keys whose data isn't an issue event: a
+// default template can't render them, so it never stands in for them.
+var ownData = map[string]bool{SLAKey: true, ApprovalKey: true, TicketKey: true, DigestKey: true}
+
+// Lookup - returns the template for the event kind: the set's own,
+// its default, or the parent's; nil if there is none. Default
+// templates are skipped for the keys of ownData.
+func (s *Set) Lookup(event string) *Template {
+	for ; s != nil; s = s.parent {
+		if t, ok := s.byEvent[event]; ok {
+			return t
+		}
+
+		if t, ok := s.byEvent[DefaultKey]; ok && !ownData[event] {
+			return t
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/parser"
+	"github.com/schors/jsm2tg/tg"
+)
+
+// Markdown - text that is already valid Telegram MarkdownV2.
+// Template actions producing Markdown are not escaped again.
+type Markdown string
+
+// safeFuncs - template functions returning Markdown. An action ending
+// with one of them is left as is, any other action gets "| escape" appended.
+var safeFuncs = map[string]bool{
+	"changes":  true,
+	"escape":   true,
+	"jiraToTg": true,
+	"link":     true,
+	"markdown": true,
+}
+
+var priorityEmoji = map[string]string{
+	"blocker":  "⛔",
+	"highest":  "🔴",
+	"critical": "🔴",
+	"high":     "🟠",
+	"major":    "🟠",
+	"medium":   "🟡",
+	"low":      "🟢",
+	"minor":    "🟢",
+	"lowest":   "🔵",
+	"trivial":  "🔵",
+}
+
+// EmojiForPriority - returns an emoji for a Jira priority name.
+func EmojiForPriority(priority string) string {
+	if e, ok := priorityEmoji[strings.ToLower(priority)]; ok {
+		return e
+	}
+
+	return "⚪"
+}
+
+func escape(v any) Markdown {
+	switch v := v.(type) {
+	case Markdown:
+		return v
+	case nil:
+		return ""
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+
+		return Markdown(tg.EscapeTelegram(v.Format("2006-01-02 15:04")))
+	default:
+		return Markdown(tg.EscapeTelegram(fmt.Sprint(v)))
+	}
+}
+
+// Truncate - cuts plain or Jira markup text to n runes, adding an ellipsis.
+// Unclosed Jira markup is closed by the converters, so the result
+// is always safe to convert.
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	i := 0
+	for j := range s {
+		if i == n {
+			s = s[:j]
+
+			break
+		}
+
+		i++
+	}
+
+	// don't leave a dangling Jira escape
+	if strings.HasSuffix(s, "\\") && !strings.HasSuffix(s, "\\\\") {
+		s = s[:len(s)-1]
+	}
+
+	return s + "…"
+}
+
+func truncate(n int, v any) (string, error) {
+	switch v := v.(type) {
+	case Markdown:
+		return "", errors.New("truncate: converted markup can't be truncated; truncate before jiraToTg")
+	case string:
+		return Truncate(v, n), nil
+	default:
+		return Truncate(fmt.Sprint(v), n), nil
+	}
+}
+
+// Ago - formats the time elapsed between t and now ("5m ago", "3h ago", "2d ago").
+func Ago(t, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	d := now.Sub(t)
+
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dd ago", int(d/(24*time.Hour)))
+	}
+}
+
+// Duration - formats a duration in days, hours and minutes ("1d 2h", "45m").
+func Duration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	mins := int(d % time.Hour / time.Minute)
+
+	switch {
+	case days > 0 && hours > 0:
+		return fmt.Sprintf("%s%dd %dh", sign, days, hours)
+	case days > 0:
+		return fmt.Sprintf("%s%dd", sign, days)
+	case hours > 0 && mins > 0:
+		return fmt.Sprintf("%s%dh %dm", sign, hours, mins)
+	case hours > 0:
+		return fmt.Sprintf("%s%dh", sign, hours)
+	default:
+		return fmt.Sprintf("%s%dm", sign, mins)
+	}
+}
+
+func funcs(clk clock.Clock) template.FuncMap {
+	return template.FuncMap{
+		"escape": escape,
+		"jiraToTg": func(s string) Markdown {
+			return Markdown(parser.ConvertJiraToTgMarkup(s))
+		},
+		"link": func(url string, text any) Markdown {
+			return Markdown("[" + string(escape(text)) + "](" + tg.EscapeTelegramLink(url) + ")")
+		},
+		"markdown": func(s string) Markdown {
+			return Markdown(s)
+		},
+		"truncate":         truncate,
+		"emojiForPriority": EmojiForPriority,
+		"timeAgo": func(t time.Time) string {
+			return Ago(t, clk.Now())
+		},
+		"duration": Duration,
+		"join":     strings.Join,
+		"changes":  Changes,
+	}
+}
+
+// Template - a message template producing Telegram MarkdownV2.
+// Literal template text must be valid MarkdownV2 itself.
+type Template struct {
+	t *template.Template
+}
+
+// Parse - compiles a message template. A nil clock selects the wall clock.
+func Parse(name, text string, clk clock.Clock) (*Template, error) {
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	t, err := template.New(name).Funcs(funcs(clk)).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tt := range t.Templates() {
+		if tt.Tree != nil {
+			autoEscape(tt.Tree, tt.Tree.Root)
+		}
+	}
+
+	return &Template{t: t}, nil
+}
+
+// Execute - renders the template.
+func (t *Template) Execute(data any) (string, error) {
+	var b strings.Builder
+
+	if err := t.t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// autoEscape - appends "| escape" to every output action whose
+// pipeline doesn't already end with a function returning Markdown.
+func autoEscape(tree *parse.Tree, n parse.Node) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+
+		for _, c := range n.Nodes {
+			autoEscape(tree, c)
+		}
+	case *parse.ActionNode:
+		p := n.Pipe
+		if len(p.Decl) > 0 || len(p.Cmds) == 0 {
+			return
+		}
+
+		last := p.Cmds[len(p.Cmds)-1]
+		if id, ok := last.Args[0].(*parse.IdentifierNode); ok && safeFuncs[id.Ident] {
+			return
+		}
+
+		p.Cmds = append(p.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      last.Pos,
+			Args:     []parse.Node{parse.NewIdentifier("escape").SetTree(tree).SetPos(last.Pos)},
+		})
+	case *parse.IfNode:
+		autoEscape(tree, n.List)
+		autoEscape(tree, n.ElseList)
+	case *parse.RangeNode:
+		autoEscape(tree, n.List)
+		autoEscape(tree, n.ElseList)
+	case *parse.WithNode:
+		autoEscape(tree, n.List)
+		autoEscape(tree, n.ElseList)
+	}
+}
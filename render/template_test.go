@@ -0,0 +1,286 @@
+package render
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/clock"
+	"github.com/schors/jsm2tg/jira"
+)
+
+var now = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTemplateAutoEscape(t *testing.T) {
+	clk := clock.NewFake(now)
+
+	data := Data{
+		Event: jira.EventIssueCreated,
+		Issue: Issue{
+			Key:         "SD-1",
+			Summary:     "Fix *all* the [things] (now).",
+			Priority:    "High",
+			Description: "h1. Title\n{{code}} and more",
+			URL:         "https://jira.example.com/browse/SD-1",
+			Created:     now.Add(-3 * time.Hour),
+		},
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain field", `{{.Issue.Summary}}`, `Fix \*all\* the \[things\] \(now\)\.`},
+		{"explicit escape", `{{.Issue.Summary | escape}}`, `Fix \*all\* the \[things\] \(now\)\.`},
+		{"literal markup is kept", `*{{.Issue.Key}}*`, `*SD\-1*`},
+		{"converted description", `{{jiraToTg .Issue.Description}}`, "*h1\\. Title*\n`code` and more"},
+		{"truncate then convert", `{{truncate 4 .Issue.Summary | jiraToTg}}`, `Fix …`},
+		{"truncate is escaped", `{{truncate 5 .Issue.Summary}}`, `Fix \*…`},
+		{"emoji", `{{emojiForPriority .Issue.Priority}}`, "🟠"},
+		{"time ago", `{{timeAgo .Issue.Created}}`, "3h ago"},
+		{"link", `{{link .Issue.URL .Issue.Key}}`, `[SD\-1](https://jira.example.com/browse/SD-1)`},
+		{"printf", `{{printf "%s!" .Issue.Key}}`, `SD\-1\!`},
+		{"variables", `{{$k := .Issue.Key}}{{$k}}`, `SD\-1`},
+		{"inside range", `{{range $i, $v := .Issue.Labels}}{{$v}}{{else}}no-labels{{end}}`, `no-labels`},
+		{"defined template", `{{define "k"}}{{.Issue.Key}}{{end}}[{{template "k" .}}]`, `[SD\-1]`},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := Parse(tt.name, tt.text, clk)
+		if err != nil {
+			t.Errorf("%s: Parse: %v", tt.name, err)
+
+			continue
+		}
+
+		got, err := tmpl.Execute(data)
+		if err != nil {
+			t.Errorf("%s: Execute: %v", tt.name, err)
+
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateConvertedFails(t *testing.T) {
+	tmpl, err := Parse("x", `{{jiraToTg .Issue.Summary | truncate 3}}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tmpl.Execute(Data{Issue: Issue{Summary: "long text"}}); err == nil {
+		t.Error("Execute: expected error")
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	b, err := os.ReadFile("../jira/testdata/issue_created.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := jira.ParseEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := Defaults(clock.NewFake(now))
+
+	got, err := set.Lookup(e.Kind()).Execute(NewData(e, "https://jira.example.com"))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	for _, want := range []string{
+		"🆕 *SD\\-42: VPN is down*",
+		"🟠 High · Incident · Report an incident · Open",
+		"⏱ Time to resolution: 30m left",
+		"⏱ Time to first response: breached",
+		"*h2\\. Symptoms*",
+		"[Open in Jira](https://jira.example.com/browse/SD-42)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestChangesTemplate(t *testing.T) {
+	e := &jira.Event{WebhookEvent: jira.EventIssueUpdated, Issue: &jira.Issue{Key: "SD-42", Fields: jira.Fields{Summary: "VPN is down"}},
+		Changelog: &jira.Changelog{ID: "1", Items: []jira.ChangelogItem{
+			{Field: "status", FromString: "Open", ToString: "In Progress"},
+			{Field: "assignee", ToString: "Alice"},
+		}}}
+
+	got, err := Defaults(clock.NewFake(now)).Lookup(e.Kind()).Execute(NewData(e, ""))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	if want := "✏️ *SD\\-42: VPN is down*\nStatus: Open → In Progress, Assignee: — → Alice\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("output = %q, want prefix %q", got, want)
+	}
+}
+
+func TestApprovalTemplate(t *testing.T) {
+	b, err := os.ReadFile("../jira/testdata/issue_created.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := jira.ParseEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := Defaults(clock.NewFake(now)).Lookup(ApprovalKey)
+
+	for _, tt := range []struct {
+		approval Approval
+		want     []string
+		not      string
+	}{
+		{Approval{Name: "Change approval", Decision: jira.ApprovalPending}, []string{"🗳 *SD\\-42: VPN is down*", "Approval: Change approval", "*h2\\. Symptoms*"}, "Approved"},
+		{Approval{Name: "Change approval", Decision: jira.ApprovalDeclined, DecidedBy: "Bob"}, []string{"❌ *SD\\-42", "*Declined by Bob*"}, "🗳"},
+	} {
+		data := NewData(e, "https://jira.example.com")
+		data.Approval = &tt.approval
+
+		got, err := tmpl.Execute(data)
+		if err != nil {
+			t.Fatalf("%s: Execute: %v", tt.approval.Decision, err)
+		}
+
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: output does not contain %q:\n%s", tt.approval.Decision, want, got)
+			}
+		}
+
+		if strings.Contains(got, tt.not) {
+			t.Errorf("%s: output contains %q:\n%s", tt.approval.Decision, tt.not, got)
+		}
+	}
+}
+
+func TestDigestTemplate(t *testing.T) {
+	tmpl := Defaults(clock.NewFake(now)).Lookup(DigestKey)
+
+	vpn := DigestIssue{Key: "SD-42", Summary: "VPN is down", Priority: "High", Status: "Open", URL: "https://jira.example.com/browse/SD-42", Created: now.Add(-72 * time.Hour), Events: 3, Breached: []string{"Time to first response"}}
+	printer := DigestIssue{Key: "SD-7", Summary: "Printer (2nd floor)", Priority: "Low", Status: "Waiting", Created: now.Add(-time.Hour), Events: 1}
+
+	got, err := tmpl.Execute(Data{Event: DigestKey, Digest: &Digest{
+		Rule:     "support",
+		Time:     now,
+		Created:  2,
+		Resolved: 1,
+		Open:     2,
+		Breached: []DigestIssue{vpn},
+		Oldest:   []DigestIssue{vpn, printer},
+		Top:      []DigestIssue{vpn, printer},
+	}})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	for _, want := range []string{
+		"📊 *Digest: support*\n",
+		"🆕 Created: 2 · ✅ Resolved: 1 · 📂 Open: 2",
+		"*🔥 SLA breaches*\n🟠 [SD\\-42](https://jira.example.com/browse/SD-42): VPN is down · Time to first response\n",
+		"*⏳ Oldest open*\n🟠 [SD\\-42](https://jira.example.com/browse/SD-42): VPN is down · Open · 3d ago\n🟢 SD\\-7: Printer \\(2nd floor\\) · Waiting · 1h ago",
+		"· 3 events\n🟢 SD\\-7: Printer \\(2nd floor\\) · 1 event",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestSetLookup(t *testing.T) {
+	parent := Defaults(nil)
+
+	child, err := NewSet(map[string]string{jira.EventCommentCreated: "c"}, parent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := child.Lookup(jira.EventCommentCreated).Execute(Data{}); got != "c" {
+		t.Errorf("own template = %q", got)
+	}
+
+	if child.Lookup(jira.EventIssueCreated) != parent.Lookup(jira.EventIssueCreated) {
+		t.Error("parent template is not used")
+	}
+
+	if child.Lookup("unknown") != nil {
+		t.Error("unknown event has a template")
+	}
+
+	// a default stands in for issue events only
+	global, err := NewSet(map[string]string{DefaultKey: "d"}, parent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := NewSet(map[string]string{DefaultKey: "r"}, global, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := rule.Lookup(jira.EventIssueCreated).Execute(Data{}); got != "r" {
+		t.Errorf("rule default = %q", got)
+	}
+
+	for _, key := range []string{SLAKey, ApprovalKey, TicketKey, DigestKey} {
+		for name, set := range map[string]*Set{"global": global, "rule": rule} {
+			if set.Lookup(key) != parent.Lookup(key) {
+				t.Errorf("%s: %s default replaces the built-in template", key, name)
+			}
+		}
+	}
+
+	if _, err := NewSet(map[string]string{"x": "{{.Broken"}, nil, nil); err == nil {
+		t.Error("NewSet: expected error")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Minute:              "30m",
+		90 * time.Minute:              "1h 30m",
+		2 * time.Hour:                 "2h",
+		26 * time.Hour:                "1d 2h",
+		-10 * time.Minute:             "-10m",
+		48*time.Hour + 10*time.Minute: "2d",
+	}
+
+	for d, want := range tests {
+		if got := Duration(d); got != want {
+			t.Errorf("Duration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"абвгд", 3, "абв…"},
+		{`ab\c`, 3, "ab…"},
+	}
+
+	for _, tt := range tests {
+		if got := Truncate(tt.in, tt.n); got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
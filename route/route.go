@@ -0,0 +1,278 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schors/jsm2tg/cron"
+	"github.com/schors/jsm2tg/jira"
+)
+
+// Routing modes.
+const (
+	ModeFirst  = "first"  // the first matching rule wins
+	ModeFanOut = "fanout" // every matching rule is applied
+)
+
+// Destination - a Telegram chat, optionally a forum topic in it.
+// With Topics set a forum topic is created for every issue.
+type Destination struct {
+	Chat   int64 `yaml:"chat"`
+	Thread int   `yaml:"thread"`
+	Topics bool  `yaml:"topics"`
+	// Public - only public comments are delivered, e.g. to customer chats.
+	Public bool `yaml:"public"`
+}
+
+// Match - rule conditions. Every non-empty condition must hold;
+// a list condition holds if any of its values matches. Names are
+// compared case-insensitively.
+type Match struct {
+	Events       []string            `yaml:"events"`
+	Projects     []string            `yaml:"projects"`
+	IssueTypes   []string            `yaml:"issue_types"`
+	RequestTypes []string            `yaml:"request_types"`
+	Priorities   []string            `yaml:"priorities"`
+	Labels       []string            `yaml:"labels"`
+	Components   []string            `yaml:"components"`
+	Fields       map[string][]string `yaml:"fields"` // custom field id -> values
+}
+
+// Rule - a named routing rule. Templates override the message
+// templates for the rule's destinations, by event kind. Issue updates
+// arriving within Coalesce of the first one are merged into one message.
+// With Digest set the destinations get a summary on schedule instead
+// of the events themselves.
+type Rule struct {
+	Name      string            `yaml:"name"`
+	Match     Match             `yaml:"match"`
+	To        []Destination     `yaml:"to"`
+	Templates map[string]string `yaml:"templates"`
+	Coalesce  time.Duration     `yaml:"coalesce"`
+	Digest    *Digest           `yaml:"digest"`
+}
+
+// DefaultDigestTop - the issues listed per digest section by default.
+const DefaultDigestTop = 5
+
+// Digest - the schedule of a digest: a cron spec (minute hour
+// day-of-month month day-of-week) in an IANA time zone, UTC by default.
+// Top limits the issues listed per section.
+type Digest struct {
+	Schedule string `yaml:"schedule"`
+	Timezone string `yaml:"timezone"`
+	Top      int    `yaml:"top"`
+}
+
+// Location - returns the time zone of the schedule.
+func (d *Digest) Location() (*time.Location, error) {
+	if d.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(d.Timezone)
+}
+
+// Config - the routing section of the configuration.
+type Config struct {
+	Mode  string `yaml:"mode"` // ModeFirst (default) or ModeFanOut
+	Rules []Rule `yaml:"rules"`
+}
+
+// Validate - checks the routing configuration.
+func (c *Config) Validate() error {
+	var errs []error
+
+	switch c.Mode {
+	case "", ModeFirst, ModeFanOut:
+	default:
+		errs = append(errs, fmt.Errorf("routing.mode: must be %q or %q, got %q", ModeFirst, ModeFanOut, c.Mode))
+	}
+
+	for i, r := range c.Rules {
+		if r.Coalesce < 0 {
+			errs = append(errs, fmt.Errorf("routing.rules[%d].coalesce: must not be negative", i))
+		}
+
+		if len(r.To) == 0 {
+			errs = append(errs, fmt.Errorf("routing.rules[%d].to: at least one destination is required", i))
+		}
+
+		if r.Digest != nil {
+			errs = append(errs, r.Digest.validate(i)...)
+		}
+
+		for j, d := range r.To {
+			if d.Chat == 0 {
+				errs = append(errs, fmt.Errorf("routing.rules[%d].to[%d].chat: required", i, j))
+			}
+
+			if d.Topics && r.Digest != nil {
+				errs = append(errs, fmt.Errorf("routing.rules[%d].to[%d]: topics and digests are mutually exclusive", i, j))
+			}
+
+			if d.Topics && d.Thread != 0 {
+				errs = append(errs, fmt.Errorf("routing.rules[%d].to[%d]: thread and topics are mutually exclusive", i, j))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d *Digest) validate(rule int) []error {
+	var errs []error
+
+	if _, err := cron.Parse(d.Schedule); err != nil {
+		errs = append(errs, fmt.Errorf("routing.rules[%d].digest.schedule: %w", rule, err))
+	}
+
+	if _, err := d.Location(); err != nil {
+		errs = append(errs, fmt.Errorf("routing.rules[%d].digest.timezone: %w", rule, err))
+	}
+
+	if d.Top < 0 {
+		errs = append(errs, fmt.Errorf("routing.rules[%d].digest.top: must not be negative", rule))
+	}
+
+	return errs
+}
+
+// Target - a destination selected by a rule.
+type Target struct {
+	Rule *Rule
+	Destination
+}
+
+// Router - selects destinations for webhook events.
+type Router struct {
+	fanOut bool
+	rules  []Rule
+}
+
+// New - creates a router. The configuration must be validated.
+func New(c Config) *Router {
+	return &Router{fanOut: c.Mode == ModeFanOut, rules: c.Rules}
+}
+
+// Rules - returns the rules; Target.Rule points into this slice.
+func (r *Router) Rules() []Rule {
+	return r.rules
+}
+
+// Route - returns the targets of the event. In fan-out mode
+// a destination selected by several rules is returned once,
+// for the first of them.
+func (r *Router) Route(e *jira.Event) []Target {
+	var (
+		targets []Target
+		seen    = make(map[Destination]bool)
+	)
+
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !rule.Match.Matches(e) {
+			continue
+		}
+
+		for _, d := range rule.To {
+			if seen[d] {
+				continue
+			}
+
+			seen[d] = true
+			targets = append(targets, Target{Rule: rule, Destination: d})
+		}
+
+		if !r.fanOut {
+			break
+		}
+	}
+
+	return targets
+}
+
+// Matches - reports whether the event satisfies the conditions.
+func (m *Match) Matches(e *jira.Event) bool {
+	if len(m.Events) > 0 && !containsFold(m.Events, e.WebhookEvent) && !containsFold(m.Events, e.Kind()) {
+		return false
+	}
+
+	var f *jira.Fields
+	if e.Issue != nil {
+		f = &e.Issue.Fields
+	} else {
+		f = &jira.Fields{}
+	}
+
+	project := ""
+	if f.Project != nil {
+		project = f.Project.Key
+	}
+
+	switch {
+	case !matchOne(m.Projects, project),
+		!matchOne(m.IssueTypes, named(f.IssueType)),
+		!matchOne(m.RequestTypes, f.RequestType()),
+		!matchOne(m.Priorities, named(f.Priority)),
+		!matchAny(m.Labels, f.Labels),
+		!matchAny(m.Components, componentNames(f.Components)):
+		return false
+	}
+
+	for id, values := range m.Fields {
+		if !matchAny(values, f.CustomValues(id)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func named(n *jira.Named) string {
+	if n == nil {
+		return ""
+	}
+
+	return n.Name
+}
+
+func componentNames(cc []jira.Named) []string {
+	names := make([]string, len(cc))
+	for i, c := range cc {
+		names[i] = c.Name
+	}
+
+	return names
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(v string) bool {
+		return strings.EqualFold(v, s)
+	})
+}
+
+// matchOne - an empty condition matches anything,
+// otherwise the value must be listed.
+func matchOne(cond []string, value string) bool {
+	return len(cond) == 0 || containsFold(cond, value)
+}
+
+// matchAny - an empty condition matches anything,
+// otherwise any of the values must be listed.
+func matchAny(cond, values []string) bool {
+	if len(cond) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if containsFold(cond, v) {
+			return true
+		}
+	}
+
+	return false
+}
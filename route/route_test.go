@@ -0,0 +1,165 @@
+package route
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schors/jsm2tg/jira"
+	"gopkg.in/yaml.v3"
+)
+
+func event(t *testing.T, kind string, fields string) *jira.Event {
+	t.Helper()
+
+	var f jira.Fields
+	if err := json.Unmarshal([]byte(fields), &f); err != nil {
+		t.Fatal(err)
+	}
+
+	return &jira.Event{WebhookEvent: kind, Issue: &jira.Issue{Key: "X-1", Fields: f}}
+}
+
+const incident = `{
+	"project": {"key": "SD"},
+	"issuetype": {"name": "Incident"},
+	"priority": {"name": "High"},
+	"labels": ["vpn", "network"],
+	"components": [{"name": "Infrastructure"}],
+	"customfield_10010": {"requestType": {"name": "Report an incident"}},
+	"customfield_10020": [{"value": "Moscow"}, {"value": "Berlin"}]
+}`
+
+func TestMatch(t *testing.T) {
+	e := event(t, jira.EventIssueCreated, incident)
+
+	tests := []struct {
+		name  string
+		match Match
+		want  bool
+	}{
+		{"empty matches everything", Match{}, true},
+		{"project", Match{Projects: []string{"OPS", "sd"}}, true},
+		{"other project", Match{Projects: []string{"OPS"}}, false},
+		{"issue type", Match{IssueTypes: []string{"incident"}}, true},
+		{"request type", Match{RequestTypes: []string{"Report an incident"}}, true},
+		{"priority", Match{Priorities: []string{"Highest"}}, false},
+		{"any label", Match{Labels: []string{"db", "VPN"}}, true},
+		{"no label", Match{Labels: []string{"db"}}, false},
+		{"component", Match{Components: []string{"infrastructure"}}, true},
+		{"custom field array", Match{Fields: map[string][]string{"customfield_10020": {"Berlin"}}}, true},
+		{"custom field mismatch", Match{Fields: map[string][]string{"customfield_10020": {"Paris"}}}, false},
+		{"missing custom field", Match{Fields: map[string][]string{"customfield_99999": {"x"}}}, false},
+		{"event", Match{Events: []string{jira.EventIssueCreated}}, true},
+		{"other event", Match{Events: []string{jira.EventCommentCreated}}, false},
+		{"all conditions", Match{Projects: []string{"SD"}, Priorities: []string{"High"}, Labels: []string{"vpn"}}, true},
+		{"one condition fails", Match{Projects: []string{"SD"}, Priorities: []string{"Low"}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.match.Matches(e); got != tt.want {
+			t.Errorf("%s: Matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchJSMEvent(t *testing.T) {
+	e := event(t, jira.EventRequestCreated, incident)
+	m := Match{Events: []string{jira.EventIssueCreated}}
+
+	if !m.Matches(e) {
+		t.Error("request event does not match its Jira counterpart")
+	}
+}
+
+const rules = `
+rules:
+  - name: vpn
+    match:
+      labels: [vpn]
+    to:
+      - chat: -1
+        thread: 10
+  - name: sd
+    match:
+      projects: [SD]
+    to:
+      - chat: -1
+        thread: 10
+      - chat: -2
+  - name: ops
+    match:
+      projects: [OPS]
+    to:
+      - chat: -3
+`
+
+func TestRouter(t *testing.T) {
+	var c Config
+	if err := yaml.Unmarshal([]byte(rules), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	e := event(t, jira.EventIssueCreated, incident)
+
+	got := New(c).Route(e)
+	if len(got) != 1 || got[0].Chat != -1 || got[0].Thread != 10 || got[0].Rule.Name != "vpn" {
+		t.Errorf("first match = %+v", got)
+	}
+
+	c.Mode = ModeFanOut
+
+	got = New(c).Route(e)
+	if len(got) != 2 || got[0].Rule.Name != "vpn" || got[1].Chat != -2 || got[1].Rule.Name != "sd" {
+		t.Errorf("fan-out = %+v", got)
+	}
+
+	if got := New(c).Route(event(t, jira.EventIssueCreated, `{"project": {"key": "DEV"}}`)); len(got) != 0 {
+		t.Errorf("no match = %+v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Config{Mode: "random", Rules: []Rule{{Name: "x"}}}
+	if err := c.Validate(); err == nil {
+		t.Error("Validate: expected error")
+	}
+
+	c = Config{Rules: []Rule{{Name: "x", To: []Destination{{Chat: -1}}, Coalesce: -time.Second}}}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "coalesce") {
+		t.Errorf("Validate = %v, want a coalesce error", err)
+	}
+}
+
+func TestValidateDigest(t *testing.T) {
+	tests := []struct {
+		name   string
+		digest Digest
+		topics bool
+		want   string
+	}{
+		{"valid", Digest{Schedule: "0 9 * * 1-5", Timezone: "UTC", Top: 10}, false, ""},
+		{"bad schedule", Digest{Schedule: "0 25 * * *"}, false, "digest.schedule"},
+		{"bad time zone", Digest{Schedule: "@daily", Timezone: "Mars/Olympus"}, false, "digest.timezone"},
+		{"negative top", Digest{Schedule: "@daily", Top: -1}, false, "digest.top"},
+		{"topics", Digest{Schedule: "@daily"}, true, "topics and digests"},
+	}
+
+	for _, tt := range tests {
+		c := Config{Rules: []Rule{{Name: "x", To: []Destination{{Chat: -1, Topics: tt.topics}}, Digest: &tt.digest}}}
+
+		err := c.Validate()
+
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: Validate = %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: Validate = %v, want a %s error", tt.name, err, tt.want)
+		}
+	}
+}
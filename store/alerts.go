@@ -0,0 +1,30 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const bucketAlerts = "alerts"
+
+// alertKey - identifies an SLA threshold of one SLA cycle; a new cycle
+// has another breach time, so it is alerted again.
+func alertKey(issue, sla string, breach time.Time, threshold time.Duration) string {
+	return issue + "/" + sla + "/" + strconv.FormatInt(breach.UnixMilli(), 10) + "/" + strconv.FormatInt(int64(threshold/time.Second), 10)
+}
+
+// SLAAlerted - reports whether the SLA threshold was alerted.
+func (m *Messages) SLAAlerted(issue, sla string, breach time.Time, threshold time.Duration) (bool, error) {
+	_, err := m.s.Get(bucketAlerts, alertKey(issue, sla, breach, threshold))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// SetSLAAlerted - records an alerted SLA threshold.
+func (m *Messages) SetSLAAlerted(issue, sla string, breach time.Time, threshold time.Duration) error {
+	return m.s.Put(bucketAlerts, alertKey(issue, sla, breach, threshold), []byte{1})
+}
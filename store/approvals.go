@@ -0,0 +1,52 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+const bucketApprovals = "approvals"
+
+// ApprovalRequest - the messages asking the approvers of a JSM approval
+// for their decision. Decision is set once the approval is decided and
+// the messages show it; DecidedBy names who decided it from Telegram.
+type ApprovalRequest struct {
+	Messages  []MessageRef `json:"messages"`
+	Decision  string       `json:"decision,omitempty"`
+	DecidedBy string       `json:"decided_by,omitempty"`
+}
+
+func approvalKey(issue, approvalID string) string {
+	return issue + "/" + approvalID
+}
+
+// ApprovalRequest - returns the approval request of an issue approval.
+// It returns false if there is none.
+func (m *Messages) ApprovalRequest(issue, approvalID string) (ApprovalRequest, bool, error) {
+	var r ApprovalRequest
+
+	b, err := m.s.Get(bucketApprovals, approvalKey(issue, approvalID))
+	if errors.Is(err, ErrNotFound) {
+		return r, false, nil
+	}
+
+	if err != nil {
+		return r, false, err
+	}
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, false, err
+	}
+
+	return r, true, nil
+}
+
+// SetApprovalRequest - saves the approval request of an issue approval.
+func (m *Messages) SetApprovalRequest(issue, approvalID string, r ApprovalRequest) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return m.s.Put(bucketApprovals, approvalKey(issue, approvalID), b)
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// Bolt - a Store backed by an embedded bbolt database file.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// lockTimeout - how long OpenBolt waits for the file lock.
+var lockTimeout = 5 * time.Second
+
+// OpenBolt - opens or creates the database file. ErrLocked is returned
+// if another process keeps it open.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: lockTimeout})
+	if errors.Is(err, berrors.ErrTimeout) {
+		return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+func (b *Bolt) Get(bucket, key string) ([]byte, error) {
+	var v []byte
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(bucket))
+		if bk == nil {
+			return ErrNotFound
+		}
+
+		raw := bk.Get([]byte(key))
+		if raw == nil {
+			return ErrNotFound
+		}
+
+		v = append([]byte(nil), raw...)
+
+		return nil
+	})
+
+	return v, err
+}
+
+func (b *Bolt) Put(bucket, key string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		return bk.Put([]byte(key), value)
+	})
+}
+
+func (b *Bolt) Delete(bucket, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(bucket))
+		if bk == nil {
+			return nil
+		}
+
+		return bk.Delete([]byte(key))
+	})
+}
+
+func (b *Bolt) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(bucket))
+		if bk == nil {
+			return nil
+		}
+
+		return bk.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const bucketDigests = "digests"
+
+// DigestIssue - an issue of a digest: its last known state and what
+// happened to it since the last digest was sent. Open issues are kept
+// for the next digests, resolved ones are dropped once sent.
+type DigestIssue struct {
+	Key      string    `json:"key"`
+	Summary  string    `json:"summary"`
+	Priority string    `json:"priority,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Created  time.Time `json:"created"`
+	Open     bool      `json:"open"`
+
+	Events   int      `json:"events,omitempty"`
+	New      bool     `json:"new,omitempty"`
+	Resolved bool     `json:"resolved,omitempty"`
+	Breached []string `json:"breached,omitempty"` // SLA names
+
+	// the breached SLAs counted in a digest once
+	Reported []string `json:"reported,omitempty"`
+}
+
+func digestPrefix(chat int64, thread int) string {
+	return strconv.FormatInt(chat, 10) + "/" + strconv.Itoa(thread) + "/"
+}
+
+// DigestIssue - returns an issue of the digest of a chat thread.
+// It returns false if there is none.
+func (m *Messages) DigestIssue(chat int64, thread int, issue string) (DigestIssue, bool, error) {
+	var is DigestIssue
+
+	b, err := m.s.Get(bucketDigests, digestPrefix(chat, thread)+issue)
+	if errors.Is(err, ErrNotFound) {
+		return is, false, nil
+	}
+
+	if err != nil {
+		return is, false, err
+	}
+
+	if err := json.Unmarshal(b, &is); err != nil {
+		return is, false, err
+	}
+
+	return is, true, nil
+}
+
+// SetDigestIssue - saves an issue of the digest of a chat thread.
+func (m *Messages) SetDigestIssue(chat int64, thread int, is DigestIssue) error {
+	b, err := json.Marshal(is)
+	if err != nil {
+		return err
+	}
+
+	return m.s.Put(bucketDigests, digestPrefix(chat, thread)+is.Key, b)
+}
+
+// DeleteDigestIssue - drops an issue from the digest of a chat thread.
+func (m *Messages) DeleteDigestIssue(chat int64, thread int, issue string) error {
+	return m.s.Delete(bucketDigests, digestPrefix(chat, thread)+issue)
+}
+
+// DigestIssues - returns the issues of the digest of a chat thread,
+// ordered by key.
+func (m *Messages) DigestIssues(chat int64, thread int) ([]DigestIssue, error) {
+	prefix := digestPrefix(chat, thread)
+
+	var issues []DigestIssue
+
+	err := m.s.ForEach(bucketDigests, func(k string, v []byte) error {
+		if !strings.HasPrefix(k, prefix) {
+			return nil
+		}
+
+		var is DigestIssue
+		if err := json.Unmarshal(v, &is); err != nil {
+			return err
+		}
+
+		issues = append(issues, is)
+
+		return nil
+	})
+
+	return issues, err
+}
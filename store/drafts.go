@@ -0,0 +1,136 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+const (
+	bucketDrafts = "drafts"
+	bucketLinks  = "links"
+)
+
+// Draft steps.
+const (
+	DraftSummary     = "summary"
+	DraftDescription = "description"
+)
+
+// DraftFile - a Telegram file to attach to a request.
+type DraftFile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Draft - a JSM request being collected in a chat dialog with a user.
+// Description is Jira markup; Prompt is the bot message offering
+// the request types.
+type Draft struct {
+	Chat        int64       `json:"chat"`
+	Thread      int         `json:"thread,omitempty"`
+	User        int64       `json:"user"`
+	Step        string      `json:"step"`
+	Summary     string      `json:"summary,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Files       []DraftFile `json:"files,omitempty"`
+	Prompt      int         `json:"prompt,omitempty"`
+}
+
+// DraftKey - identifies the draft of a user in a chat.
+func DraftKey(chat, user int64) string {
+	return strconv.FormatInt(chat, 10) + "/" + strconv.FormatInt(user, 10)
+}
+
+// Draft - returns the draft of a user in a chat.
+// It returns false if there is none.
+func (m *Messages) Draft(chat, user int64) (Draft, bool, error) {
+	var d Draft
+
+	b, err := m.s.Get(bucketDrafts, DraftKey(chat, user))
+	if errors.Is(err, ErrNotFound) {
+		return d, false, nil
+	}
+
+	if err != nil {
+		return d, false, err
+	}
+
+	if err := json.Unmarshal(b, &d); err != nil {
+		return d, false, err
+	}
+
+	return d, true, nil
+}
+
+// SetDraft - saves a draft.
+func (m *Messages) SetDraft(d Draft) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return m.s.Put(bucketDrafts, DraftKey(d.Chat, d.User), b)
+}
+
+// DeleteDraft - discards the draft of a user in a chat.
+func (m *Messages) DeleteDraft(chat, user int64) error {
+	return m.s.Delete(bucketDrafts, DraftKey(chat, user))
+}
+
+// Link - makes the chat receive the updates of the issue, like
+// a routing destination.
+func (m *Messages) Link(issue string, ref MessageRef) error {
+	refs, err := m.Links(issue)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		if r.Chat == ref.Chat {
+			return nil
+		}
+	}
+
+	b, err := json.Marshal(append(refs, MessageRef{Chat: ref.Chat, Thread: ref.Thread}))
+	if err != nil {
+		return err
+	}
+
+	return m.s.Put(bucketLinks, issue, b)
+}
+
+// Links - returns the chats linked to the issue.
+func (m *Messages) Links(issue string) ([]MessageRef, error) {
+	b, err := m.s.Get(bucketLinks, issue)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []MessageRef
+	if err := json.Unmarshal(b, &refs); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// Linked - reports whether the chat is linked to the issue.
+func (m *Messages) Linked(issue string, chat int64) (bool, error) {
+	refs, err := m.Links(issue)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range refs {
+		if r.Chat == chat {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
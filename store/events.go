@@ -0,0 +1,50 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const bucketEvents = "events"
+
+// EventSeen - reports whether the webhook event (see jira.Event.ID)
+// was handled before.
+func (m *Messages) EventSeen(id string) (bool, error) {
+	_, err := m.s.Get(bucketEvents, id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// SetEventSeen - records a handled webhook event.
+func (m *Messages) SetEventSeen(id string, at time.Time) error {
+	return m.s.Put(bucketEvents, id, []byte(strconv.FormatInt(at.UnixMilli(), 10)))
+}
+
+// ForgetEvents - drops the events handled before t and returns
+// how many were dropped.
+func (m *Messages) ForgetEvents(t time.Time) (int, error) {
+	var old []string
+
+	err := m.s.ForEach(bucketEvents, func(k string, v []byte) error {
+		if ms, err := strconv.ParseInt(string(v), 10, 64); err != nil || ms < t.UnixMilli() {
+			old = append(old, k)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, k := range old {
+		if err := m.s.Delete(bucketEvents, k); err != nil {
+			return i, err
+		}
+	}
+
+	return len(old), nil
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	bucketHeld     = "held"
+	bucketHeldMeta = "held_meta"
+	keyHeldSeq     = "seq"
+)
+
+// Reasons for holding an event, see HeldEvent.Kind.
+const (
+	HeldCoalesce = "coalesce" // merged with the updates of a coalescing window
+	HeldQuiet    = "quiet"    // delivered when quiet hours end
+)
+
+// HeldEvent - a webhook event held back for a chat thread until a
+// coalescing window or quiet hours end, kept until it is delivered so
+// that it survives a restart.
+type HeldEvent struct {
+	ID     string          `json:"-"`
+	Kind   string          `json:"kind"`
+	Chat   int64           `json:"chat"`
+	Thread int             `json:"thread,omitempty"`
+	Until  time.Time       `json:"until"`
+	Event  json.RawMessage `json:"event"` // jira.Event
+}
+
+// HoldEvent - saves a held event and returns its id; ids sort in the
+// order the events were held. Calls must not run concurrently.
+func (m *Messages) HoldEvent(h HeldEvent) (string, error) {
+	var seq uint64
+
+	b, err := m.s.Get(bucketHeldMeta, keyHeldSeq)
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		return "", err
+	default:
+		if seq, err = strconv.ParseUint(string(b), 10, 64); err != nil {
+			return "", fmt.Errorf("held events sequence: %w", err)
+		}
+	}
+
+	seq++
+
+	if err := m.s.Put(bucketHeldMeta, keyHeldSeq, []byte(strconv.FormatUint(seq, 10))); err != nil {
+		return "", err
+	}
+
+	v, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+
+	id := fmt.Sprintf("%020d", seq)
+
+	return id, m.s.Put(bucketHeld, id, v)
+}
+
+// HeldEvents - returns the held events in the order they were held.
+func (m *Messages) HeldEvents() ([]HeldEvent, error) {
+	var held []HeldEvent
+
+	err := m.s.ForEach(bucketHeld, func(k string, v []byte) error {
+		var h HeldEvent
+		if err := json.Unmarshal(v, &h); err != nil {
+			return fmt.Errorf("held event %s: %w", k, err)
+		}
+
+		h.ID = k
+		held = append(held, h)
+
+		return nil
+	})
+
+	return held, err
+}
+
+// DeleteHeldEvent - drops a held event once it is delivered.
+func (m *Messages) DeleteHeldEvent(id string) error {
+	return m.s.Delete(bucketHeld, id)
+}
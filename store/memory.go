@@ -0,0 +1,86 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory - an in-memory Store for tests and ephemeral setups.
+type Memory struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemory - creates an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]map[string][]byte)}
+}
+
+func (m *Memory) Get(bucket, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), v...), nil
+}
+
+func (m *Memory) Put(bucket, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		b = make(map[string][]byte)
+		m.buckets[bucket] = b
+	}
+
+	b[key] = append([]byte(nil), value...)
+
+	return nil
+}
+
+func (m *Memory) Delete(bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.buckets[bucket], key)
+
+	return nil
+}
+
+func (m *Memory) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	m.mu.RLock()
+
+	b := m.buckets[bucket]
+	keys := make([]string, 0, len(b))
+
+	for k := range b {
+		keys = append(keys, k)
+	}
+
+	values := make([][]byte, len(keys))
+
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		values[i] = b[k]
+	}
+
+	m.mu.RUnlock()
+
+	for i, k := range keys {
+		if err := fn(k, values[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+const (
+	bucketCards    = "cards"
+	bucketMessages = "messages"
+	bucketTopics   = "topics"
+	bucketThreads  = "threads"
+	bucketComments = "comments"
+)
+
+// MessageRef - a Telegram message posted for an issue.
+type MessageRef struct {
+	Chat      int64 `json:"chat"`
+	Thread    int   `json:"thread,omitempty"`
+	MessageID int   `json:"message_id"`
+}
+
+// Messages - the mapping between issues and the Telegram messages
+// posted for them: the issue card and forum topic per chat, and every
+// message and topic back to its issue.
+type Messages struct {
+	s Store
+}
+
+// NewMessages - creates the mapping on top of a store.
+func NewMessages(s Store) *Messages {
+	return &Messages{s: s}
+}
+
+func cardKey(issue string, chat int64) string {
+	return issue + "/" + strconv.FormatInt(chat, 10)
+}
+
+func messageKey(chat int64, messageID int) string {
+	return strconv.FormatInt(chat, 10) + "/" + strconv.Itoa(messageID)
+}
+
+// Card - returns the issue card posted to the chat.
+// It returns false if there is none.
+func (m *Messages) Card(issue string, chat int64) (MessageRef, bool, error) {
+	var ref MessageRef
+
+	b, err := m.s.Get(bucketCards, cardKey(issue, chat))
+	if errors.Is(err, ErrNotFound) {
+		return ref, false, nil
+	}
+
+	if err != nil {
+		return ref, false, err
+	}
+
+	if err := json.Unmarshal(b, &ref); err != nil {
+		return ref, false, err
+	}
+
+	return ref, true, nil
+}
+
+// SetCard - records the issue card posted to ref.Chat.
+func (m *Messages) SetCard(issue string, ref MessageRef) error {
+	b, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+
+	if err := m.s.Put(bucketCards, cardKey(issue, ref.Chat), b); err != nil {
+		return err
+	}
+
+	return m.AddMessage(issue, ref)
+}
+
+// DeleteCard - forgets the issue card in the chat.
+func (m *Messages) DeleteCard(issue string, chat int64) error {
+	return m.s.Delete(bucketCards, cardKey(issue, chat))
+}
+
+// AddMessage - records a message posted for the issue.
+func (m *Messages) AddMessage(issue string, ref MessageRef) error {
+	return m.s.Put(bucketMessages, messageKey(ref.Chat, ref.MessageID), []byte(issue))
+}
+
+// Issue - returns the issue a message was posted for.
+// It returns false if the message is unknown.
+func (m *Messages) Issue(chat int64, messageID int) (string, bool, error) {
+	b, err := m.s.Get(bucketMessages, messageKey(chat, messageID))
+	if errors.Is(err, ErrNotFound) {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(b), true, nil
+}
+
+// Topic - returns the forum topic created for the issue in the chat.
+// It returns false if there is none.
+func (m *Messages) Topic(issue string, chat int64) (int, bool, error) {
+	b, err := m.s.Get(bucketTopics, cardKey(issue, chat))
+	if errors.Is(err, ErrNotFound) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	thread, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, false, err
+	}
+
+	return thread, true, nil
+}
+
+// SetTopic - records the forum topic created for the issue in the chat.
+func (m *Messages) SetTopic(issue string, chat int64, thread int) error {
+	if err := m.s.Put(bucketTopics, cardKey(issue, chat), []byte(strconv.Itoa(thread))); err != nil {
+		return err
+	}
+
+	return m.s.Put(bucketThreads, messageKey(chat, thread), []byte(issue))
+}
+
+// DeleteTopic - forgets the issue's forum topic in the chat.
+func (m *Messages) DeleteTopic(issue string, chat int64) error {
+	thread, ok, err := m.Topic(issue, chat)
+	if err != nil || !ok {
+		return err
+	}
+
+	if err := m.s.Delete(bucketThreads, messageKey(chat, thread)); err != nil {
+		return err
+	}
+
+	return m.s.Delete(bucketTopics, cardKey(issue, chat))
+}
+
+// TopicIssue - returns the issue a forum topic was created for.
+// It returns false if the topic is unknown.
+func (m *Messages) TopicIssue(chat int64, thread int) (string, bool, error) {
+	b, err := m.s.Get(bucketThreads, messageKey(chat, thread))
+	if errors.Is(err, ErrNotFound) {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(b), true, nil
+}
+
+// SetOwnComment - records a Jira comment posted by jsm2tg itself,
+// so that its webhook echo isn't announced again.
+func (m *Messages) SetOwnComment(issue, id string) error {
+	return m.s.Put(bucketComments, issue+"/"+id, []byte{1})
+}
+
+// OwnComment - reports whether the comment was posted by jsm2tg.
+func (m *Messages) OwnComment(issue, id string) (bool, error) {
+	_, err := m.s.Get(bucketComments, issue+"/"+id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+const bucketQueries = "queries"
+
+// Query - a saved issue search, paged through by the inline buttons of
+// a result list.
+type Query struct {
+	Title string `json:"title"`
+	JQL   string `json:"jql"`
+}
+
+// SetQuery - saves the query and returns its short id. The id is derived
+// from the query, so saving the same query again returns the same id.
+func (m *Messages) SetQuery(q Query) (string, error) {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	id := base64.RawURLEncoding.EncodeToString(sum[:6])
+
+	return id, m.s.Put(bucketQueries, id, b)
+}
+
+// Query - returns a saved query. It returns false if the id is unknown.
+func (m *Messages) Query(id string) (Query, bool, error) {
+	var q Query
+
+	b, err := m.s.Get(bucketQueries, id)
+	if errors.Is(err, ErrNotFound) {
+		return q, false, nil
+	}
+
+	if err != nil {
+		return q, false, err
+	}
+
+	if err := json.Unmarshal(b, &q); err != nil {
+		return q, false, err
+	}
+
+	return q, true, nil
+}
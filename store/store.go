@@ -0,0 +1,30 @@
+package store
+
+import "errors"
+
+// ErrNotFound - the key is not in the bucket.
+var ErrNotFound = errors.New("store: not found")
+
+// ErrLocked - the database file is open in another process.
+var ErrLocked = errors.New("store: database locked by another process")
+
+// Store - a persistent key/value store with named buckets.
+// Implementations are safe for concurrent use.
+type Store interface {
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	// ForEach - calls fn for every key of the bucket in key order.
+	// The value must not be retained after fn returns.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+// Open - opens the bbolt database at path, or an in-memory store if path is empty.
+func Open(path string) (Store, error) {
+	if path == "" {
+		return NewMemory(), nil
+	}
+
+	return OpenBolt(path)
+}
@@ -0,0 +1,417 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func backends(t *testing.T) map[string]Store {
+	t.Helper()
+
+	b, err := OpenBolt(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { b.Close() })
+
+	return map[string]Store{"memory": NewMemory(), "bolt": b}
+}
+
+func TestStore(t *testing.T) {
+	for name, s := range backends(t) {
+		if _, err := s.Get("b", "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: Get(missing) error = %v, want %v", name, err, ErrNotFound)
+		}
+
+		for _, k := range []string{"c", "a", "b"} {
+			if err := s.Put("b", k, []byte("v"+k)); err != nil {
+				t.Fatalf("%s: Put: %v", name, err)
+			}
+		}
+
+		if v, err := s.Get("b", "a"); err != nil || string(v) != "va" {
+			t.Errorf("%s: Get(a) = %q, %v", name, v, err)
+		}
+
+		if err := s.Delete("b", "c"); err != nil {
+			t.Fatalf("%s: Delete: %v", name, err)
+		}
+
+		var keys string
+
+		err := s.ForEach("b", func(k string, v []byte) error {
+			keys += k
+
+			return nil
+		})
+		if err != nil || keys != "ab" {
+			t.Errorf("%s: ForEach keys = %q, %v; want \"ab\"", name, keys, err)
+		}
+
+		if err := s.Delete("nobucket", "x"); err != nil {
+			t.Errorf("%s: Delete in a missing bucket: %v", name, err)
+		}
+	}
+}
+
+func TestBoltPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	b, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMessages(b)
+	if err := m.SetCard("SD-1", MessageRef{Chat: -100, MessageID: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Close()
+
+	b, err = OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	ref, ok, err := NewMessages(b).Card("SD-1", -100)
+	if err != nil || !ok || ref.MessageID != 5 {
+		t.Errorf("Card after reopen = %+v, %v, %v", ref, ok, err)
+	}
+
+	// the file stays locked while it is open
+	defer func(d time.Duration) { lockTimeout = d }(lockTimeout)
+	lockTimeout = 10 * time.Millisecond
+
+	if _, err := OpenBolt(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("second OpenBolt error = %v, want %v", err, ErrLocked)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		if _, ok, err := m.Card("SD-1", -100); ok || err != nil {
+			t.Errorf("%s: Card of unknown issue = %v, %v", name, ok, err)
+		}
+
+		if err := m.SetCard("SD-1", MessageRef{Chat: -100, Thread: 3, MessageID: 10}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.AddMessage("SD-1", MessageRef{Chat: -100, MessageID: 11}); err != nil {
+			t.Fatal(err)
+		}
+
+		ref, ok, err := m.Card("SD-1", -100)
+		if err != nil || !ok || ref.MessageID != 10 || ref.Thread != 3 {
+			t.Errorf("%s: Card = %+v, %v, %v", name, ref, ok, err)
+		}
+
+		for _, id := range []int{10, 11} {
+			if issue, ok, _ := m.Issue(-100, id); !ok || issue != "SD-1" {
+				t.Errorf("%s: Issue(%d) = %q, %v", name, id, issue, ok)
+			}
+		}
+
+		if _, ok, _ := m.Card("SD-1", -200); ok {
+			t.Errorf("%s: card found in another chat", name)
+		}
+
+		if err := m.DeleteCard("SD-1", -100); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok, _ := m.Card("SD-1", -100); ok {
+			t.Errorf("%s: card found after DeleteCard", name)
+		}
+	}
+}
+
+func TestTopics(t *testing.T) {
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		if _, ok, err := m.Topic("SD-1", -100); ok || err != nil {
+			t.Errorf("%s: Topic of unknown issue = %v, %v", name, ok, err)
+		}
+
+		if err := m.SetTopic("SD-1", -100, 17); err != nil {
+			t.Fatal(err)
+		}
+
+		if thread, ok, err := m.Topic("SD-1", -100); err != nil || !ok || thread != 17 {
+			t.Errorf("%s: Topic = %d, %v, %v", name, thread, ok, err)
+		}
+
+		if issue, ok, _ := m.TopicIssue(-100, 17); !ok || issue != "SD-1" {
+			t.Errorf("%s: TopicIssue = %q, %v", name, issue, ok)
+		}
+
+		if err := m.DeleteTopic("SD-1", -100); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok, _ := m.Topic("SD-1", -100); ok {
+			t.Errorf("%s: topic found after DeleteTopic", name)
+		}
+
+		if _, ok, _ := m.TopicIssue(-100, 17); ok {
+			t.Errorf("%s: topic issue found after DeleteTopic", name)
+		}
+	}
+}
+
+func TestOwnComments(t *testing.T) {
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		if err := m.SetOwnComment("SD-1", "10001"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			issue, id string
+			want      bool
+		}{
+			{"SD-1", "10001", true},
+			{"SD-1", "10002", false},
+			{"SD-2", "10001", false},
+		} {
+			if got, err := m.OwnComment(tt.issue, tt.id); err != nil || got != tt.want {
+				t.Errorf("%s: OwnComment(%s, %s) = %v, %v; want %v", name, tt.issue, tt.id, got, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestQueries(t *testing.T) {
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+		q := Query{Title: "My issues", JQL: "assignee = currentUser()"}
+
+		id, err := m.SetQuery(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if again, _ := m.SetQuery(q); again != id {
+			t.Errorf("%s: SetQuery id = %q, then %q", name, id, again)
+		}
+
+		if got, ok, err := m.Query(id); err != nil || !ok || got != q {
+			t.Errorf("%s: Query(%s) = %+v, %v, %v", name, id, got, ok, err)
+		}
+
+		if _, ok, _ := m.Query("missing"); ok {
+			t.Errorf("%s: unknown query found", name)
+		}
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+		d := Draft{Chat: -100, User: 501, Step: DraftDescription, Summary: "VPN is down", Files: []DraftFile{{ID: "f1", Name: "a.png"}}}
+
+		if err := m.SetDraft(d); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, ok, err := m.Draft(-100, 501); err != nil || !ok || got.Summary != d.Summary || len(got.Files) != 1 {
+			t.Errorf("%s: Draft = %+v, %v, %v", name, got, ok, err)
+		}
+
+		if _, ok, _ := m.Draft(-100, 502); ok {
+			t.Errorf("%s: another user's draft found", name)
+		}
+
+		if err := m.DeleteDraft(-100, 501); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok, _ := m.Draft(-100, 501); ok {
+			t.Errorf("%s: draft found after DeleteDraft", name)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		for _, ref := range []MessageRef{{Chat: -100}, {Chat: 501, MessageID: 3}, {Chat: -100}} {
+			if err := m.Link("SD-1", ref); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		refs, err := m.Links("SD-1")
+		if err != nil || len(refs) != 2 || refs[1] != (MessageRef{Chat: 501}) {
+			t.Errorf("%s: Links = %+v, %v", name, refs, err)
+		}
+
+		if ok, _ := m.Linked("SD-1", 501); !ok {
+			t.Errorf("%s: chat 501 not linked", name)
+		}
+
+		if ok, _ := m.Linked("SD-2", 501); ok {
+			t.Errorf("%s: SD-2 linked", name)
+		}
+	}
+}
+
+func TestSLAAlerts(t *testing.T) {
+	breach := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		if err := m.SetSLAAlerted("SD-1", "Time to resolution", breach, 30*time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			breach    time.Time
+			threshold time.Duration
+			want      bool
+		}{
+			{breach, 30 * time.Minute, true},
+			{breach, 0, false},
+			{breach.Add(time.Hour), 30 * time.Minute, false},
+		} {
+			if got, err := m.SLAAlerted("SD-1", "Time to resolution", tt.breach, tt.threshold); err != nil || got != tt.want {
+				t.Errorf("%s: SLAAlerted(%v, %v) = %v, %v; want %v", name, tt.breach, tt.threshold, got, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		for id, at := range map[string]time.Time{"old": now.Add(-25 * time.Hour), "new": now} {
+			if err := m.SetEventSeen(id, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if n, err := m.ForgetEvents(now.Add(-24 * time.Hour)); n != 1 || err != nil {
+			t.Errorf("%s: ForgetEvents = %d, %v; want 1", name, n, err)
+		}
+
+		for id, want := range map[string]bool{"old": false, "new": true, "other": false} {
+			if got, err := m.EventSeen(id); err != nil || got != want {
+				t.Errorf("%s: EventSeen(%q) = %v, %v; want %v", name, id, got, err, want)
+			}
+		}
+	}
+}
+
+func TestApprovalRequests(t *testing.T) {
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		if _, ok, err := m.ApprovalRequest("SD-1", "7"); ok || err != nil {
+			t.Errorf("%s: ApprovalRequest() of an unknown approval = %v, %v", name, ok, err)
+		}
+
+		want := ApprovalRequest{Messages: []MessageRef{{Chat: 501, MessageID: 3}, {Chat: 502, MessageID: 9}}, Decision: "approved", DecidedBy: "Alice"}
+		if err := m.SetApprovalRequest("SD-1", "7", want); err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok, err := m.ApprovalRequest("SD-1", "7")
+		if err != nil || !ok || len(got.Messages) != 2 || got.Messages[1] != want.Messages[1] || got.Decision != want.Decision || got.DecidedBy != want.DecidedBy {
+			t.Errorf("%s: ApprovalRequest() = %+v, %v, %v", name, got, ok, err)
+		}
+	}
+}
+
+func TestDigests(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		for _, r := range []struct {
+			chat   int64
+			thread int
+			is     DigestIssue
+		}{
+			{-100, 1, DigestIssue{Key: "SD-2", Open: true, Created: created, Events: 2, Breached: []string{"Time to resolution"}}},
+			{-100, 1, DigestIssue{Key: "SD-1", Resolved: true}},
+			{-100, 12, DigestIssue{Key: "SD-3"}},
+			{-200, 1, DigestIssue{Key: "SD-4"}},
+		} {
+			if err := m.SetDigestIssue(r.chat, r.thread, r.is); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := m.DigestIssues(-100, 1)
+		if err != nil || len(got) != 2 || got[0].Key != "SD-1" || got[1].Key != "SD-2" {
+			t.Fatalf("%s: DigestIssues() = %+v, %v", name, got, err)
+		}
+
+		if is := got[1]; !is.Open || !is.Created.Equal(created) || is.Events != 2 || len(is.Breached) != 1 {
+			t.Errorf("%s: DigestIssues()[1] = %+v", name, is)
+		}
+
+		if err := m.DeleteDigestIssue(-100, 1, "SD-1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok, err := m.DigestIssue(-100, 1, "SD-1"); ok || err != nil {
+			t.Errorf("%s: DigestIssue() of a deleted issue = %v, %v", name, ok, err)
+		}
+
+		if is, ok, err := m.DigestIssue(-100, 12, "SD-3"); !ok || err != nil || is.Key != "SD-3" {
+			t.Errorf("%s: DigestIssue() = %+v, %v, %v", name, is, ok, err)
+		}
+	}
+}
+
+func TestHeldEvents(t *testing.T) {
+	until := time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+
+	for name, s := range backends(t) {
+		m := NewMessages(s)
+
+		var ids []string
+
+		for _, h := range []HeldEvent{
+			{Kind: HeldQuiet, Chat: -100, Until: until, Event: []byte(`{"webhookEvent":"jira:issue_created"}`)},
+			{Kind: HeldCoalesce, Chat: -100, Thread: 7, Until: until, Event: []byte(`{"webhookEvent":"jira:issue_updated"}`)},
+			{Kind: HeldQuiet, Chat: -200, Until: until, Event: []byte(`{"webhookEvent":"comment_created"}`)},
+		} {
+			id, err := m.HoldEvent(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids = append(ids, id)
+		}
+
+		if err := m.DeleteHeldEvent(ids[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := m.HeldEvents()
+		if err != nil || len(got) != 2 || got[0].ID != ids[1] || got[1].ID != ids[2] {
+			t.Fatalf("%s: HeldEvents() = %+v, %v", name, got, err)
+		}
+
+		if h := got[0]; h.Kind != HeldCoalesce || h.Chat != -100 || h.Thread != 7 || !h.Until.Equal(until) || string(h.Event) != `{"webhookEvent":"jira:issue_updated"}` {
+			t.Errorf("%s: HeldEvents()[0] = %+v", name, h)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package tg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL - the public Bot API endpoint.
+const DefaultBaseURL = "https://api.telegram.org"
+
+// MaxMessageLength - the Bot API limit for text messages, in UTF-16 code units.
+const MaxMessageLength = 4096
+
+// MaxCaptionLength - the Bot API limit for media captions, in UTF-16 code units.
+const MaxCaptionLength = 1024
+
+// MaxDownloadSize - the largest file bots can download, in bytes.
+const MaxDownloadSize = 20 << 20
+
+// MaxTopicNameLength - the Bot API limit for forum topic names, in characters.
+const MaxTopicNameLength = 128
+
+// TopicColors - the forum topic icon colors accepted by createForumTopic.
+var TopicColors = []int{0x6FB9F0, 0xFFD67E, 0xCB86DB, 0x8EEE98, 0xFF93B2, 0xFB6F5F}
+
+// Client - a minimal typed Telegram Bot API client.
+// Client is safe for concurrent use.
+type Client struct {
+	token   string
+	baseURL string
+	http    *http.Client
+}
+
+// Option - configures a Client.
+type Option func(*Client)
+
+// WithBaseURL - overrides the Bot API endpoint (e.g. for a local Bot API
+// server or an httptest stand-in).
+func WithBaseURL(u string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(u, "/")
+	}
+}
+
+// WithHTTPClient - overrides the HTTP client used for requests.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// NewClient - creates a Bot API client for the given bot token.
+func NewClient(token string, opts ...Option) *Client {
+	c := &Client{
+		token:   token,
+		baseURL: DefaultBaseURL,
+		http:    &http.Client{Timeout: 70 * time.Second},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// SendMessageParams - parameters of sendMessage.
+type SendMessageParams struct {
+	ChatID              int64                 `json:"chat_id"`
+	MessageThreadID     int                   `json:"message_thread_id,omitempty"`
+	Text                string                `json:"text"`
+	ParseMode           string                `json:"parse_mode,omitempty"`
+	LinkPreviewOptions  *LinkPreviewOptions   `json:"link_preview_options,omitempty"`
+	DisableNotification bool                  `json:"disable_notification,omitempty"`
+	ReplyParameters     *ReplyParameters      `json:"reply_parameters,omitempty"`
+	ReplyMarkup         *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// EditMessageTextParams - parameters of editMessageText.
+type EditMessageTextParams struct {
+	ChatID             int64                 `json:"chat_id"`
+	MessageID          int                   `json:"message_id"`
+	Text               string                `json:"text"`
+	ParseMode          string                `json:"parse_mode,omitempty"`
+	LinkPreviewOptions *LinkPreviewOptions   `json:"link_preview_options,omitempty"`
+	ReplyMarkup        *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// DeleteMessageParams - parameters of deleteMessage.
+type DeleteMessageParams struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int   `json:"message_id"`
+}
+
+// InputFile - a file to upload. If FileID is set the file is not
+// uploaded again and Name/Reader are ignored.
+type InputFile struct {
+	FileID string
+	Name   string
+	Reader io.Reader
+}
+
+// SendDocumentParams - parameters of sendDocument.
+type SendDocumentParams struct {
+	ChatID              int64
+	MessageThreadID     int
+	Document            InputFile
+	Caption             string
+	ParseMode           string
+	DisableNotification bool
+	ReplyParameters     *ReplyParameters
+	ReplyMarkup         *InlineKeyboardMarkup
+}
+
+// SendPhotoParams - parameters of sendPhoto.
+type SendPhotoParams struct {
+	ChatID              int64
+	MessageThreadID     int
+	Photo               InputFile
+	Caption             string
+	ParseMode           string
+	DisableNotification bool
+	ReplyParameters     *ReplyParameters
+	ReplyMarkup         *InlineKeyboardMarkup
+}
+
+// AnswerCallbackQueryParams - parameters of answerCallbackQuery.
+type AnswerCallbackQueryParams struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+	ShowAlert       bool   `json:"show_alert,omitempty"`
+	CacheTime       int    `json:"cache_time,omitempty"`
+}
+
+// CreateForumTopicParams - parameters of createForumTopic.
+type CreateForumTopicParams struct {
+	ChatID            int64  `json:"chat_id"`
+	Name              string `json:"name"`
+	IconColor         int    `json:"icon_color,omitempty"`
+	IconCustomEmojiID string `json:"icon_custom_emoji_id,omitempty"`
+}
+
+// EditForumTopicParams - parameters of editForumTopic. An empty name
+// and a nil icon are left unchanged; an empty icon removes it.
+type EditForumTopicParams struct {
+	ChatID            int64   `json:"chat_id"`
+	MessageThreadID   int     `json:"message_thread_id"`
+	Name              string  `json:"name,omitempty"`
+	IconCustomEmojiID *string `json:"icon_custom_emoji_id,omitempty"`
+}
+
+// ForumTopicParams - parameters of closeForumTopic and reopenForumTopic.
+type ForumTopicParams struct {
+	ChatID          int64 `json:"chat_id"`
+	MessageThreadID int   `json:"message_thread_id"`
+}
+
+// SetWebhookParams - parameters of setWebhook.
+type SetWebhookParams struct {
+	URL                string   `json:"url"`
+	SecretToken        string   `json:"secret_token,omitempty"`
+	AllowedUpdates     []string `json:"allowed_updates,omitempty"`
+	DropPendingUpdates bool     `json:"drop_pending_updates,omitempty"`
+}
+
+// GetUpdatesParams - parameters of getUpdates.
+type GetUpdatesParams struct {
+	Offset         int      `json:"offset,omitempty"`
+	Limit          int      `json:"limit,omitempty"`
+	Timeout        int      `json:"timeout,omitempty"`
+	AllowedUpdates []string `json:"allowed_updates,omitempty"`
+}
+
+// SendMessage - sends a text message.
+func (c *Client) SendMessage(ctx context.Context, p SendMessageParams) (*Message, error) {
+	var m Message
+	if err := c.call(ctx, "sendMessage", p, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// EditMessageText - edits the text of a message sent by the bot.
+func (c *Client) EditMessageText(ctx context.Context, p EditMessageTextParams) (*Message, error) {
+	var m Message
+	if err := c.call(ctx, "editMessageText", p, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// DeleteMessage - deletes a message.
+func (c *Client) DeleteMessage(ctx context.Context, p DeleteMessageParams) error {
+	return c.call(ctx, "deleteMessage", p, nil)
+}
+
+// SendDocument - sends a general file.
+func (c *Client) SendDocument(ctx context.Context, p SendDocumentParams) (*Message, error) {
+	var m Message
+	err := c.upload(ctx, "sendDocument", "document", p.Document, mediaFields{
+		chatID:              p.ChatID,
+		threadID:            p.MessageThreadID,
+		caption:             p.Caption,
+		parseMode:           p.ParseMode,
+		disableNotification: p.DisableNotification,
+		reply:               p.ReplyParameters,
+		markup:              p.ReplyMarkup,
+	}, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// SendPhoto - sends a photo.
+func (c *Client) SendPhoto(ctx context.Context, p SendPhotoParams) (*Message, error) {
+	var m Message
+	err := c.upload(ctx, "sendPhoto", "photo", p.Photo, mediaFields{
+		chatID:              p.ChatID,
+		threadID:            p.MessageThreadID,
+		caption:             p.Caption,
+		parseMode:           p.ParseMode,
+		disableNotification: p.DisableNotification,
+		reply:               p.ReplyParameters,
+		markup:              p.ReplyMarkup,
+	}, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// AnswerCallbackQuery - acknowledges a callback query.
+func (c *Client) AnswerCallbackQuery(ctx context.Context, p AnswerCallbackQueryParams) error {
+	return c.call(ctx, "answerCallbackQuery", p, nil)
+}
+
+// CreateForumTopic - creates a topic in a forum supergroup.
+func (c *Client) CreateForumTopic(ctx context.Context, p CreateForumTopicParams) (*ForumTopic, error) {
+	var t ForumTopic
+	if err := c.call(ctx, "createForumTopic", p, &t); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// EditForumTopic - changes the name or icon of a forum topic.
+func (c *Client) EditForumTopic(ctx context.Context, p EditForumTopicParams) error {
+	return c.call(ctx, "editForumTopic", p, nil)
+}
+
+// CloseForumTopic - closes a forum topic.
+func (c *Client) CloseForumTopic(ctx context.Context, p ForumTopicParams) error {
+	return c.call(ctx, "closeForumTopic", p, nil)
+}
+
+// ReopenForumTopic - reopens a closed forum topic.
+func (c *Client) ReopenForumTopic(ctx context.Context, p ForumTopicParams) error {
+	return c.call(ctx, "reopenForumTopic", p, nil)
+}
+
+// GetFile - prepares a file for downloading. Bots can download files
+// of up to MaxDownloadSize bytes.
+func (c *Client) GetFile(ctx context.Context, fileID string) (*File, error) {
+	var f File
+	if err := c.call(ctx, "getFile", map[string]string{"file_id": fileID}, &f); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// DownloadFile - downloads a file by the FilePath returned by GetFile.
+// The caller must close the reader.
+func (c *Client) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/file/bot"+c.token+"/"+filePath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("tg: download: %w", c.redact(err))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tg: download: %w", c.redact(err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, &Error{Method: "download", Code: resp.StatusCode, Description: resp.Status}
+	}
+
+	return resp.Body, nil
+}
+
+// GetMe - returns the bot user.
+func (c *Client) GetMe(ctx context.Context) (*User, error) {
+	var u User
+	if err := c.call(ctx, "getMe", struct{}{}, &u); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// SetWebhook - makes Telegram post updates to a URL.
+func (c *Client) SetWebhook(ctx context.Context, p SetWebhookParams) error {
+	return c.call(ctx, "setWebhook", p, nil)
+}
+
+// DeleteWebhook - removes the webhook, so that getUpdates can be used.
+func (c *Client) DeleteWebhook(ctx context.Context) error {
+	return c.call(ctx, "deleteWebhook", struct{}{}, nil)
+}
+
+// GetUpdates - receives incoming updates using long polling.
+func (c *Client) GetUpdates(ctx context.Context, p GetUpdatesParams) ([]Update, error) {
+	var u []Update
+	if err := c.call(ctx, "getUpdates", p, &u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+type apiResponse struct {
+	OK          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result"`
+	ErrorCode   int                 `json:"error_code"`
+	Description string              `json:"description"`
+	Parameters  *ResponseParameters `json:"parameters"`
+}
+
+type mediaFields struct {
+	chatID              int64
+	threadID            int
+	caption             string
+	parseMode           string
+	disableNotification bool
+	reply               *ReplyParameters
+	markup              *InlineKeyboardMarkup
+}
+
+func (c *Client) methodURL(method string) string {
+	return c.baseURL + "/bot" + c.token + "/" + method
+}
+
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("tg: %s: %w", method, err)
+	}
+
+	return c.do(ctx, method, "application/json", bytes.NewReader(body), result)
+}
+
+func (c *Client) upload(ctx context.Context, method, field string, f InputFile, m mediaFields, result any) error {
+	var body bytes.Buffer
+
+	w := multipart.NewWriter(&body)
+
+	fields := [][2]string{{"chat_id", strconv.FormatInt(m.chatID, 10)}}
+	if m.threadID != 0 {
+		fields = append(fields, [2]string{"message_thread_id", strconv.Itoa(m.threadID)})
+	}
+
+	if m.caption != "" {
+		fields = append(fields, [2]string{"caption", m.caption})
+	}
+
+	if m.parseMode != "" {
+		fields = append(fields, [2]string{"parse_mode", m.parseMode})
+	}
+
+	if m.disableNotification {
+		fields = append(fields, [2]string{"disable_notification", "true"})
+	}
+
+	for _, v := range []struct {
+		name  string
+		value any
+		set   bool
+	}{
+		{"reply_parameters", m.reply, m.reply != nil},
+		{"reply_markup", m.markup, m.markup != nil},
+	} {
+		if !v.set {
+			continue
+		}
+
+		b, err := json.Marshal(v.value)
+		if err != nil {
+			return fmt.Errorf("tg: %s: %w", method, err)
+		}
+
+		fields = append(fields, [2]string{v.name, string(b)})
+	}
+
+	for _, kv := range fields {
+		if err := w.WriteField(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("tg: %s: %w", method, err)
+		}
+	}
+
+	if f.FileID != "" {
+		if err := w.WriteField(field, f.FileID); err != nil {
+			return fmt.Errorf("tg: %s: %w", method, err)
+		}
+	} else {
+		if f.Reader == nil {
+			return fmt.Errorf("tg: %s: %s: no file to upload", method, field)
+		}
+
+		name := f.Name
+		if name == "" {
+			name = field
+		}
+
+		part, err := w.CreateFormFile(field, name)
+		if err != nil {
+			return fmt.Errorf("tg: %s: %w", method, err)
+		}
+
+		if _, err := io.Copy(part, f.Reader); err != nil {
+			return fmt.Errorf("tg: %s: %w", method, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("tg: %s: %w", method, err)
+	}
+
+	return c.do(ctx, method, w.FormDataContentType(), &body, result)
+}
+
+func (c *Client) do(ctx context.Context, method, contentType string, body io.Reader, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.methodURL(method), body)
+	if err != nil {
+		return fmt.Errorf("tg: %s: %w", method, c.redact(err))
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("tg: %s: %w", method, ctx.Err())
+		}
+
+		return fmt.Errorf("tg: %s: %w", method, c.redact(err))
+	}
+	defer resp.Body.Close()
+
+	var r apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &Error{Method: method, Code: resp.StatusCode, Description: resp.Status}
+		}
+
+		return fmt.Errorf("tg: %s: decode response: %w", method, err)
+	}
+
+	if !r.OK {
+		code := r.ErrorCode
+		if code == 0 {
+			code = resp.StatusCode
+		}
+
+		return &Error{Method: method, Code: code, Description: r.Description, Parameters: r.Parameters}
+	}
+
+	if result == nil || len(r.Result) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		return fmt.Errorf("tg: %s: decode result: %w", method, err)
+	}
+
+	return nil
+}
+
+// redact - strips the bot token from transport errors, which
+// otherwise carry the full request URL. The URL of a *url.Error is
+// redacted in place, keeping the cause for errors.As.
+func (c *Client) redact(err error) error {
+	if c.token == "" || !strings.Contains(err.Error(), c.token) {
+		return err
+	}
+
+	if ue, ok := err.(*url.Error); ok && !strings.Contains(ue.Err.Error(), c.token) {
+		return &url.Error{Op: ue.Op, URL: strings.ReplaceAll(ue.URL, c.token, "<token>"), Err: ue.Err}
+	}
+
+	return errors.New(strings.ReplaceAll(err.Error(), c.token, "<token>"))
+}
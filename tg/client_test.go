@@ -0,0 +1,385 @@
+package tg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientSendMessage(t *testing.T) {
+	var got SendMessageParams
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/botTOKEN/sendMessage" {
+			t.Errorf("path = %q, want %q", r.URL.Path, "/botTOKEN/sendMessage")
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+
+		io.WriteString(w, `{"ok":true,"result":{"message_id":42,"chat":{"id":-100,"type":"supergroup"},"date":1,"text":"hi"}}`)
+	}))
+	defer srv.Close()
+
+	c := NewClient("TOKEN", WithBaseURL(srv.URL))
+
+	m, err := c.SendMessage(context.Background(), SendMessageParams{
+		ChatID:          -100,
+		MessageThreadID: 7,
+		Text:            "hi",
+		ParseMode:       ParseModeMarkdownV2,
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if m.MessageID != 42 || m.Chat.ID != -100 {
+		t.Errorf("SendMessage = %+v, want message 42 in chat -100", m)
+	}
+
+	if got.ChatID != -100 || got.MessageThreadID != 7 || got.ParseMode != ParseModeMarkdownV2 {
+		t.Errorf("request = %+v", got)
+	}
+}
+
+func TestClientCreateForumTopic(t *testing.T) {
+	var got CreateForumTopicParams
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/botTOKEN/createForumTopic" {
+			t.Errorf("path = %q, want %q", r.URL.Path, "/botTOKEN/createForumTopic")
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+
+		io.WriteString(w, `{"ok":true,"result":{"message_thread_id":17,"name":"SD-1: x","icon_color":7322096}}`)
+	}))
+	defer srv.Close()
+
+	c := NewClient("TOKEN", WithBaseURL(srv.URL))
+
+	topic, err := c.CreateForumTopic(context.Background(), CreateForumTopicParams{ChatID: -100, Name: "SD-1: x", IconCustomEmojiID: "5312"})
+	if err != nil {
+		t.Fatalf("CreateForumTopic: %v", err)
+	}
+
+	if topic.MessageThreadID != 17 || topic.IconColor != 0x6FB9F0 {
+		t.Errorf("CreateForumTopic = %+v", topic)
+	}
+
+	if got.ChatID != -100 || got.Name != "SD-1: x" || got.IconCustomEmojiID != "5312" {
+		t.Errorf("request = %+v", got)
+	}
+}
+
+func TestClientSetWebhook(t *testing.T) {
+	var got SetWebhookParams
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/botTOKEN/setWebhook":
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				t.Errorf("decode request: %v", err)
+			}
+
+			io.WriteString(w, `{"ok":true,"result":true}`)
+		case "/botTOKEN/getMe":
+			io.WriteString(w, `{"ok":true,"result":{"id":42,"is_bot":true,"first_name":"JSM","username":"jsm2tg_bot"}}`)
+		default:
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient("TOKEN", WithBaseURL(srv.URL))
+
+	err := c.SetWebhook(context.Background(), SetWebhookParams{URL: "https://bot.example.com/telegram", SecretToken: "s", AllowedUpdates: []string{"message"}})
+	if err != nil {
+		t.Fatalf("SetWebhook: %v", err)
+	}
+
+	if got.URL != "https://bot.example.com/telegram" || got.SecretToken != "s" || len(got.AllowedUpdates) != 1 {
+		t.Errorf("request = %+v", got)
+	}
+
+	me, err := c.GetMe(context.Background())
+	if err != nil || me.Username != "jsm2tg_bot" || !me.IsBot {
+		t.Errorf("GetMe = %+v, %v", me, err)
+	}
+}
+
+func TestClientDownloadFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/botTOKEN/getFile":
+			io.WriteString(w, `{"ok":true,"result":{"file_id":"F1","file_unique_id":"u1","file_size":4,"file_path":"photos/file_1.jpg"}}`)
+		case "/file/botTOKEN/photos/file_1.jpg":
+			io.WriteString(w, "JPEG")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient("TOKEN", WithBaseURL(srv.URL))
+	ctx := context.Background()
+
+	f, err := c.GetFile(ctx, "F1")
+	if err != nil || f.FilePath != "photos/file_1.jpg" {
+		t.Fatalf("GetFile = %+v, %v", f, err)
+	}
+
+	r, err := c.DownloadFile(ctx, f.FilePath)
+	if err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	defer r.Close()
+
+	if b, _ := io.ReadAll(r); string(b) != "JPEG" {
+		t.Errorf("content = %q", b)
+	}
+
+	if _, err := c.DownloadFile(ctx, "photos/missing.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		want       []error
+		retryAfter time.Duration
+		offset     int
+	}{
+		{
+			name:       "too many requests",
+			status:     http.StatusTooManyRequests,
+			body:       `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 5","parameters":{"retry_after":5}}`,
+			want:       []error{ErrTooManyRequests},
+			retryAfter: 5 * time.Second,
+		},
+		{
+			name:   "can't parse entities",
+			status: http.StatusBadRequest,
+			body:   `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities: Can't find end of the entity starting at byte offset 12"}`,
+			want:   []error{ErrBadRequest, ErrCantParseEntities},
+			offset: 12,
+		},
+		{
+			name:   "message not modified",
+			status: http.StatusBadRequest,
+			body:   `{"ok":false,"error_code":400,"description":"Bad Request: message is not modified: specified new message content and reply markup are exactly the same"}`,
+			want:   []error{ErrBadRequest, ErrMessageNotModified},
+		},
+		{
+			name:   "chat not found",
+			status: http.StatusBadRequest,
+			body:   `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`,
+			want:   []error{ErrBadRequest, ErrChatNotFound},
+		},
+		{
+			name:   "topic not modified",
+			status: http.StatusBadRequest,
+			body:   `{"ok":false,"error_code":400,"description":"Bad Request: TOPIC_NOT_MODIFIED"}`,
+			want:   []error{ErrBadRequest, ErrTopicNotModified},
+		},
+		{
+			name:   "topic deleted",
+			status: http.StatusBadRequest,
+			body:   `{"ok":false,"error_code":400,"description":"Bad Request: message thread not found"}`,
+			want:   []error{ErrBadRequest, ErrTopicNotFound},
+		},
+		{
+			name:   "bad gateway without body",
+			status: http.StatusBadGateway,
+			body:   `<html>502</html>`,
+			want:   []error{ErrServer},
+		},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		}))
+
+		c := NewClient("TOKEN", WithBaseURL(srv.URL))
+		_, err := c.SendMessage(context.Background(), SendMessageParams{ChatID: 1, Text: "x"})
+
+		srv.Close()
+
+		for _, want := range tt.want {
+			if !errors.Is(err, want) {
+				t.Errorf("%s: error %v is not %v", tt.name, err, want)
+			}
+		}
+
+		e, ok := AsError(err)
+		if !ok {
+			t.Errorf("%s: error %v is not *Error", tt.name, err)
+
+			continue
+		}
+
+		if e.RetryAfter() != tt.retryAfter {
+			t.Errorf("%s: RetryAfter() = %v, want %v", tt.name, e.RetryAfter(), tt.retryAfter)
+		}
+
+		if off, _ := e.EntityOffset(); off != tt.offset {
+			t.Errorf("%s: EntityOffset() = %d, want %d", tt.name, off, tt.offset)
+		}
+	}
+}
+
+func TestClientSendDocument(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm: %v", err)
+
+			return
+		}
+
+		if r.FormValue("chat_id") != "5" || r.FormValue("caption") != "report" {
+			t.Errorf("form = %v", r.MultipartForm.Value)
+		}
+
+		f, h, err := r.FormFile("document")
+		if err != nil {
+			t.Errorf("FormFile: %v", err)
+
+			return
+		}
+
+		b, _ := io.ReadAll(f)
+		if h.Filename != "log.txt" || string(b) != "content" {
+			t.Errorf("file = %q %q", h.Filename, b)
+		}
+
+		io.WriteString(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":5,"type":"private"},"date":1}}`)
+	}))
+	defer srv.Close()
+
+	c := NewClient("TOKEN", WithBaseURL(srv.URL))
+
+	_, err := c.SendDocument(context.Background(), SendDocumentParams{
+		ChatID:   5,
+		Caption:  "report",
+		Document: InputFile{Name: "log.txt", Reader: strings.NewReader("content")},
+	})
+	if err != nil {
+		t.Fatalf("SendDocument: %v", err)
+	}
+}
+
+func TestClientRedactsToken(t *testing.T) {
+	c := NewClient("SECRET", WithBaseURL("http://127.0.0.1:1"))
+
+	_, err := c.GetUpdates(context.Background(), GetUpdatesParams{})
+	if err == nil {
+		t.Fatal("GetUpdates: expected error")
+	}
+
+	if strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("error leaks token: %v", err)
+	}
+
+	// the cause is kept for retrying network errors
+	var ne net.Error
+	if !errors.As(err, &ne) {
+		t.Errorf("error %v is not a net.Error", err)
+	}
+}
+
+func TestClientPoll(t *testing.T) {
+	var offsets []int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p GetUpdatesParams
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+
+		offsets = append(offsets, p.Offset)
+
+		if p.Offset == 0 {
+			io.WriteString(w, `{"ok":true,"result":[{"update_id":10,"message":{"message_id":1,"chat":{"id":5},"text":"a"}},{"update_id":11,"message":{"message_id":2,"chat":{"id":5},"text":"b"}}]}`)
+
+			return
+		}
+
+		io.WriteString(w, `{"ok":true,"result":[]}`)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var texts []string
+
+	c := NewClient("TOKEN", WithBaseURL(srv.URL))
+	err := c.Poll(ctx, UpdateHandlerFunc(func(_ context.Context, u Update) {
+		texts = append(texts, u.Message.Text)
+		if len(texts) == 2 {
+			cancel()
+		}
+	}), nil, nil)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Poll = %v, want context.Canceled", err)
+	}
+
+	if strings.Join(texts, ",") != "a,b" {
+		t.Errorf("updates = %q", texts)
+	}
+
+	if len(offsets) != 1 {
+		t.Errorf("offsets = %v, want a single request", offsets)
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	var got []int
+
+	h := WebhookHandler("s3cret", UpdateHandlerFunc(func(_ context.Context, u Update) {
+		got = append(got, u.UpdateID)
+	}))
+
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		status int
+	}{
+		{"valid", "s3cret", `{"update_id":7,"message":{"message_id":1,"chat":{"id":5},"text":"/my"}}`, http.StatusOK},
+		{"wrong secret", "nope", `{"update_id":8}`, http.StatusForbidden},
+		{"bad body", "s3cret", `{`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(tt.body))
+		r.Header.Set(SecretTokenHeader, tt.secret)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+
+	if len(got) != 1 || got[0] != 7 {
+		t.Errorf("updates = %v, want [7]", got)
+	}
+}
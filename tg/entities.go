@@ -0,0 +1,470 @@
+package tg
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// reserved - the characters that must be escaped in MarkdownV2 text.
+const reserved = "_*[]()~`>#+-=|{}.!"
+
+// EntityError - MarkdownV2 that Telegram would reject, at a byte offset
+// of the text. It matches ErrCantParseEntities.
+type EntityError struct {
+	Offset int
+	Reason string
+}
+
+func (e *EntityError) Error() string {
+	return fmt.Sprintf("tg: can't parse entities: %s at byte offset %d", e.Reason, e.Offset)
+}
+
+// Is - matches ErrCantParseEntities.
+func (e *EntityError) Is(target error) bool {
+	return target == ErrCantParseEntities
+}
+
+// openEntity - an entity whose end isn't reached yet.
+type openEntity struct {
+	marker string
+	typ    string
+	offset int // in UTF-16 code units of the text
+	at     int // the byte offset of the marker
+	lang   string
+}
+
+// markers - the toggling MarkdownV2 markers and their entity types,
+// the two-character ones first.
+var markers = []struct{ marker, typ string }{
+	{"__", "underline"},
+	{"||", "spoiler"},
+	{"*", "bold"},
+	{"_", "italic"},
+	{"~", "strikethrough"},
+}
+
+// ParseMarkdownV2 - parses MarkdownV2 like Telegram does and returns the
+// text without markup and its entities, ordered by offset. It reports
+// the markup Telegram would reject, e.g. unescaped reserved characters
+// and unclosed or crossing entities, as an *EntityError.
+func ParseMarkdownV2(text string) (string, []MessageEntity, error) {
+	var (
+		out      strings.Builder
+		n        int // the UTF-16 length of out
+		stack    []openEntity
+		entities []MessageEntity
+		quote    = -1 // the offset of the open blockquote
+	)
+
+	write := func(s string) {
+		out.WriteString(s)
+		n += UTF16Len(s)
+	}
+
+	add := func(o openEntity, e MessageEntity) {
+		// Telegram drops empty entities
+		if n > o.offset {
+			e.Type, e.Offset, e.Length = o.typ, o.offset, n-o.offset
+			entities = append(entities, e)
+		}
+	}
+
+	fail := func(at int, format string, args ...any) (string, []MessageEntity, error) {
+		return "", nil, &EntityError{Offset: at, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	lineStart := true
+
+	for i := 0; i < len(text); {
+		r, sz := utf8.DecodeRuneInString(text[i:])
+
+		top := ""
+		if len(stack) > 0 {
+			top = stack[len(stack)-1].marker
+		}
+
+		inPre := top == "```"
+		start := lineStart
+		lineStart = false
+
+		switch {
+		case r == '\\':
+			if i+sz == len(text) {
+				return fail(i, "the text ends with an escape")
+			}
+
+			e, esz := utf8.DecodeRuneInString(text[i+sz:])
+			if e < 1 || e > 126 {
+				return fail(i, "character %q can't be escaped", e)
+			}
+
+			write(string(e))
+			i += sz + esz
+		case inPre && strings.HasPrefix(text[i:], "```"):
+			o := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			add(o, MessageEntity{Language: o.lang})
+			i += 3
+		case top == "`" && r == '`':
+			o := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			add(o, MessageEntity{})
+			i++
+		case inPre || top == "`":
+			if r == '`' {
+				return fail(i, "character '`' is reserved and must be escaped with the preceding '\\'")
+			}
+
+			write(string(r))
+			i += sz
+
+			lineStart = r == '\n'
+		case start && r == '>':
+			if quote < 0 {
+				quote = n
+			}
+
+			i++
+		case strings.HasPrefix(text[i:], "```"):
+			j := strings.IndexByte(text[i:], '\n')
+			if j < 0 {
+				return fail(i, "can't find end of pre entity")
+			}
+
+			stack = append(stack, openEntity{marker: "```", typ: "pre", offset: n, at: i, lang: text[i+3 : i+j]})
+			i += j + 1
+		case r == '`':
+			stack = append(stack, openEntity{marker: "`", typ: "code", offset: n, at: i})
+			i++
+		case strings.HasPrefix(text[i:], "!["):
+			stack = append(stack, openEntity{marker: "![", typ: "custom_emoji", offset: n, at: i})
+			i += 2
+		case r == '[':
+			stack = append(stack, openEntity{marker: "[", typ: "text_link", offset: n, at: i})
+			i++
+		case r == ']' && (top == "[" || top == "!["):
+			end, u := linkURL(text, i+1)
+			if end < 0 {
+				return fail(i, "can't find end of the URL")
+			}
+
+			o := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			e := MessageEntity{URL: u}
+			if o.typ == "custom_emoji" {
+				id, err := customEmojiID(u)
+				if err != nil {
+					return fail(i, "%s", err)
+				}
+
+				e = MessageEntity{CustomEmojiID: id}
+			}
+
+			add(o, e)
+			i = end
+		default:
+			m, typ := marker(text[i:])
+			if m == "" {
+				if strings.ContainsRune(reserved, r) {
+					return fail(i, "character '%c' is reserved and must be escaped with the preceding '\\'", r)
+				}
+
+				// a blockquote ends with the last line starting with '>'
+				if r == '\n' && quote >= 0 && !strings.HasPrefix(text[i+1:], ">") {
+					add(openEntity{typ: "blockquote", offset: quote}, MessageEntity{})
+					quote = -1
+				}
+
+				write(string(r))
+				i += sz
+				lineStart = r == '\n'
+
+				break
+			}
+
+			j := slices.IndexFunc(stack, func(o openEntity) bool { return o.marker == m })
+
+			switch {
+			case j < 0:
+				stack = append(stack, openEntity{marker: m, typ: typ, offset: n, at: i})
+			case j == len(stack)-1:
+				o := stack[j]
+				stack = stack[:j]
+				add(o, MessageEntity{})
+			default:
+				return fail(i, "%s entity must be closed before %s entity", stack[len(stack)-1].typ, typ)
+			}
+
+			i += len(m)
+		}
+	}
+
+	if len(stack) > 0 {
+		o := stack[len(stack)-1]
+
+		return fail(o.at, "can't find end of %s entity", o.typ)
+	}
+
+	if quote >= 0 {
+		add(openEntity{typ: "blockquote", offset: quote}, MessageEntity{})
+	}
+
+	slices.SortStableFunc(entities, func(a, b MessageEntity) int {
+		return cmp.Or(cmp.Compare(a.Offset, b.Offset), cmp.Compare(b.Length, a.Length))
+	})
+
+	return out.String(), entities, nil
+}
+
+// marker - returns the toggling marker at the start of s and its type.
+func marker(s string) (string, string) {
+	for _, m := range markers {
+		if strings.HasPrefix(s, m.marker) {
+			return m.marker, m.typ
+		}
+	}
+
+	return "", ""
+}
+
+// linkURL - parses "(url)" at i, where only ')' and '\' are escaped, and
+// returns the end of it and the URL; -1 if there is none.
+func linkURL(text string, i int) (int, string) {
+	if i >= len(text) || text[i] != '(' {
+		return -1, ""
+	}
+
+	var u strings.Builder
+
+	for j := i + 1; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			if j+1 < len(text) {
+				j++
+				u.WriteByte(text[j])
+			}
+		case ')':
+			return j + 1, u.String()
+		default:
+			u.WriteByte(text[j])
+		}
+	}
+
+	return -1, ""
+}
+
+// customEmojiID - returns the id of a "tg://emoji?id=..." URL.
+func customEmojiID(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "tg" || u.Host != "emoji" || u.Query().Get("id") == "" {
+		return "", fmt.Errorf("custom emoji URL %q is invalid", s)
+	}
+
+	return u.Query().Get("id"), nil
+}
+
+// htmlTags - the HTML tags Telegram supports and their entity types.
+var htmlTags = map[string]string{
+	"b":          "bold",
+	"strong":     "bold",
+	"i":          "italic",
+	"em":         "italic",
+	"u":          "underline",
+	"ins":        "underline",
+	"s":          "strikethrough",
+	"strike":     "strikethrough",
+	"del":        "strikethrough",
+	"tg-spoiler": "spoiler",
+	"span":       "spoiler",
+	"code":       "code",
+	"pre":        "pre",
+	"a":          "text_link",
+	"blockquote": "blockquote",
+	"tg-emoji":   "custom_emoji",
+}
+
+// htmlEntities - the named character references Telegram supports.
+var htmlEntities = map[string]string{"lt": "<", "gt": ">", "amp": "&", "quot": "\""}
+
+// ParseHTML - parses Telegram HTML like Telegram does and returns the
+// text without tags and its entities, ordered by offset. Unsupported or
+// unbalanced tags are reported as an *EntityError.
+func ParseHTML(text string) (string, []MessageEntity, error) {
+	var (
+		out      strings.Builder
+		n        int // the UTF-16 length of out
+		stack    []openEntity
+		entities []MessageEntity
+	)
+
+	fail := func(at int, format string, args ...any) (string, []MessageEntity, error) {
+		return "", nil, &EntityError{Offset: at, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '&':
+			j := strings.IndexByte(text[i:], ';')
+			if s, ok := htmlEntity(text[i+1 : i+max(j, 1)]); j > 0 && ok {
+				out.WriteString(s)
+				n += UTF16Len(s)
+				i += j + 1
+
+				continue
+			}
+
+			// unknown references are kept as they are
+			out.WriteByte('&')
+			n++
+			i++
+		case '<':
+			j := strings.IndexByte(text[i:], '>')
+			if j < 0 {
+				return fail(i, "unclosed start tag")
+			}
+
+			tag := text[i+1 : i+j]
+
+			if name, ok := strings.CutPrefix(tag, "/"); ok {
+				name = strings.ToLower(strings.TrimSpace(name))
+
+				if len(stack) == 0 || stack[len(stack)-1].marker != name {
+					return fail(i, "unmatched end tag %q", name)
+				}
+
+				o := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+
+				// Telegram drops empty entities; a code of a pre is its language
+				if n > o.offset && o.typ != "" {
+					e := MessageEntity{Type: o.typ, Offset: o.offset, Length: n - o.offset, Language: o.lang}
+
+					switch o.typ {
+					case "text_link":
+						e.URL, e.Language = o.lang, ""
+					case "custom_emoji":
+						e.CustomEmojiID, e.Language = o.lang, ""
+					}
+
+					entities = append(entities, e)
+				}
+
+				i += j + 1
+
+				continue
+			}
+
+			name, attrs := htmlTag(tag)
+
+			typ, ok := htmlTags[name]
+			if !ok {
+				return fail(i, "unsupported start tag %q", name)
+			}
+
+			o := openEntity{marker: name, typ: typ, offset: n, at: i}
+
+			switch name {
+			case "span":
+				if attrs["class"] != "tg-spoiler" {
+					return fail(i, "tag \"span\" must have class \"tg-spoiler\"")
+				}
+			case "a":
+				o.lang = attrs["href"]
+			case "tg-emoji":
+				if o.lang = attrs["emoji-id"]; o.lang == "" {
+					return fail(i, "tag \"tg-emoji\" must have attribute \"emoji-id\"")
+				}
+			case "code":
+				if lang, ok := strings.CutPrefix(attrs["class"], "language-"); ok && len(stack) > 0 && stack[len(stack)-1].marker == "pre" {
+					stack[len(stack)-1].lang = lang
+					o.typ = ""
+				}
+			}
+
+			stack = append(stack, o)
+			i += j + 1
+		default:
+			r, sz := utf8.DecodeRuneInString(text[i:])
+			out.WriteRune(r)
+			n += UTF16Len(string(r))
+			i += sz
+		}
+	}
+
+	if len(stack) > 0 {
+		o := stack[len(stack)-1]
+
+		return fail(o.at, "can't find end tag corresponding to start tag %q", o.marker)
+	}
+
+	slices.SortStableFunc(entities, func(a, b MessageEntity) int {
+		return cmp.Or(cmp.Compare(a.Offset, b.Offset), cmp.Compare(b.Length, a.Length))
+	})
+
+	return out.String(), entities, nil
+}
+
+// htmlEntity - decodes a character reference without '&' and ';'.
+func htmlEntity(s string) (string, bool) {
+	if v, ok := htmlEntities[s]; ok {
+		return v, true
+	}
+
+	num, ok := strings.CutPrefix(s, "#")
+	if !ok || num == "" {
+		return "", false
+	}
+
+	base := 10
+	if h, ok := strings.CutPrefix(strings.ToLower(num), "x"); ok {
+		num, base = h, 16
+	}
+
+	c, err := strconv.ParseUint(num, base, 32)
+	if err != nil || !utf8.ValidRune(rune(c)) {
+		return "", false
+	}
+
+	return string(rune(c)), true
+}
+
+// htmlTag - splits the inside of a start tag into its lowercased name and
+// attributes.
+func htmlTag(tag string) (string, map[string]string) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(tag), " ")
+	attrs := make(map[string]string)
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		k, v, ok := strings.Cut(rest, "=")
+		if !ok {
+			attrs[strings.ToLower(rest)] = ""
+
+			break
+		}
+
+		v = strings.TrimSpace(v)
+
+		if v != "" && (v[0] == '"' || v[0] == '\'') {
+			end := strings.IndexByte(v[1:], v[0])
+			if end < 0 {
+				end = len(v) - 1
+			}
+
+			rest = v[min(end+2, len(v)):]
+			v = v[1 : end+1]
+		} else {
+			v, rest, _ = strings.Cut(v, " ")
+		}
+
+		attrs[strings.ToLower(strings.TrimSpace(k))] = html.UnescapeString(v)
+	}
+
+	return strings.ToLower(name), attrs
+}
@@ -0,0 +1,208 @@
+package tg
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMarkdownV2(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		text     string
+		entities []MessageEntity
+	}{
+		{
+			name:  "plain",
+			input: "hello world",
+			text:  "hello world",
+		},
+		{
+			name:  "escapes",
+			input: "a\\.b \\*c\\* \\\\",
+			text:  "a.b *c* \\",
+		},
+		{
+			name:  "nested",
+			input: "*bold _italic ~strike~_* __under__ ||spoiler||",
+			text:  "bold italic strike under spoiler",
+			entities: []MessageEntity{
+				{Type: "bold", Offset: 0, Length: 18},
+				{Type: "italic", Offset: 5, Length: 13},
+				{Type: "strikethrough", Offset: 12, Length: 6},
+				{Type: "underline", Offset: 19, Length: 5},
+				{Type: "spoiler", Offset: 25, Length: 7},
+			},
+		},
+		{
+			name:  "underline closes before italic",
+			input: "_it __under___",
+			text:  "it under",
+			entities: []MessageEntity{
+				{Type: "italic", Offset: 0, Length: 8},
+				{Type: "underline", Offset: 3, Length: 5},
+			},
+		},
+		{
+			name:  "code keeps markup",
+			input: "`a*b_c` and ```go\nx := *p\n```",
+			text:  "a*b_c and x := *p\n",
+			entities: []MessageEntity{
+				{Type: "code", Offset: 0, Length: 5},
+				{Type: "pre", Offset: 10, Length: 8, Language: "go"},
+			},
+		},
+		{
+			name:  "links",
+			input: "see [the *docs*](https://example.com/a\\)b) ![👍](tg://emoji?id=42)",
+			text:  "see the docs 👍",
+			entities: []MessageEntity{
+				{Type: "text_link", Offset: 4, Length: 8, URL: "https://example.com/a)b"},
+				{Type: "bold", Offset: 8, Length: 4},
+				{Type: "custom_emoji", Offset: 13, Length: 2, CustomEmojiID: "42"},
+			},
+		},
+		{
+			name:  "utf-16 offsets",
+			input: "🔥 *hot*",
+			text:  "🔥 hot",
+			entities: []MessageEntity{
+				{Type: "bold", Offset: 3, Length: 3},
+			},
+		},
+		{
+			name:  "blockquote",
+			input: ">first\n>second\nafter",
+			text:  "first\nsecond\nafter",
+			entities: []MessageEntity{
+				{Type: "blockquote", Offset: 0, Length: 12},
+			},
+		},
+		{
+			name:  "empty entities are dropped",
+			input: "a**b",
+			text:  "ab",
+		},
+	}
+
+	for _, tt := range tests {
+		text, entities, err := ParseMarkdownV2(tt.input)
+		if err != nil {
+			t.Errorf("%s: ParseMarkdownV2() error = %v", tt.name, err)
+
+			continue
+		}
+
+		if text != tt.text {
+			t.Errorf("%s: text = %q, want %q", tt.name, text, tt.text)
+		}
+
+		if !reflect.DeepEqual(entities, tt.entities) {
+			t.Errorf("%s: entities = %+v, want %+v", tt.name, entities, tt.entities)
+		}
+	}
+}
+
+func TestParseMarkdownV2Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		offset int
+		want   string
+	}{
+		{"reserved", "version 1.2", 9, "character '.' is reserved"},
+		{"unclosed", "a *bold", 2, "can't find end of bold entity"},
+		{"crossing", "*a _b* c_", 5, "italic entity must be closed before bold entity"},
+		{"link without url", "[text] x", 5, "can't find end of the URL"},
+		{"bad emoji", "![x](https://example.com)", 3, "custom emoji URL"},
+		{"backtick in pre", "```\na`b\n```", 5, "character '`' is reserved"},
+		{"trailing escape", "a\\", 1, "ends with an escape"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := ParseMarkdownV2(tt.input)
+
+		var e *EntityError
+		if !errors.As(err, &e) || !errors.Is(err, ErrCantParseEntities) {
+			t.Errorf("%s: ParseMarkdownV2() error = %v, want an *EntityError", tt.name, err)
+
+			continue
+		}
+
+		if e.Offset != tt.offset || !strings.Contains(e.Reason, tt.want) {
+			t.Errorf("%s: error = %v, want %q at byte offset %d", tt.name, err, tt.want, tt.offset)
+		}
+	}
+}
+
+func TestParseMarkdownV2Escaped(t *testing.T) {
+	for _, s := range []string{"1.2 - [x] (y) {z} #a +b =c |d| !e >f", "snake_case *ptr `tick` ~tilde \\ back"} {
+		text, entities, err := ParseMarkdownV2(EscapeTelegram(s))
+		if err != nil || text != s || len(entities) != 0 {
+			t.Errorf("%q: ParseMarkdownV2(EscapeTelegram()) = %q, %v, %v", s, text, entities, err)
+		}
+	}
+}
+
+func TestParseHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		text     string
+		entities []MessageEntity
+		err      string
+	}{
+		{
+			name:  "entities",
+			input: `<b>bold <i>it</i></b> <tg-spoiler>s</tg-spoiler> <span class="tg-spoiler">t</span> &lt;&amp;&#33;&#x3f; &nbsp;`,
+			text:  "bold it s t <&!? &nbsp;",
+			entities: []MessageEntity{
+				{Type: "bold", Offset: 0, Length: 7},
+				{Type: "italic", Offset: 5, Length: 2},
+				{Type: "spoiler", Offset: 8, Length: 1},
+				{Type: "spoiler", Offset: 10, Length: 1},
+			},
+		},
+		{
+			name:  "links and code",
+			input: `<a href="https://example.com/?a=1&amp;b=2">x</a> <pre><code class="language-go">y</code></pre> <code>z</code>`,
+			text:  "x y z",
+			entities: []MessageEntity{
+				{Type: "text_link", Offset: 0, Length: 1, URL: "https://example.com/?a=1&b=2"},
+				{Type: "pre", Offset: 2, Length: 1, Language: "go"},
+				{Type: "code", Offset: 4, Length: 1},
+			},
+		},
+		{name: "unsupported", input: "<p>x</p>", err: `unsupported start tag "p" at byte offset 0`},
+		{name: "crossing", input: "<b><i>x</b></i>", err: `unmatched end tag "b" at byte offset 7`},
+		{name: "unclosed", input: "a <b>x", err: `can't find end tag corresponding to start tag "b" at byte offset 2`},
+	}
+
+	for _, tt := range tests {
+		text, entities, err := ParseHTML(tt.input)
+
+		if tt.err != "" {
+			if !errors.Is(err, ErrCantParseEntities) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: ParseHTML() error = %v, want %q", tt.name, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: ParseHTML() error = %v", tt.name, err)
+
+			continue
+		}
+
+		if text != tt.text {
+			t.Errorf("%s: text = %q, want %q", tt.name, text, tt.text)
+		}
+
+		if !reflect.DeepEqual(entities, tt.entities) {
+			t.Errorf("%s: entities = %+v, want %+v", tt.name, entities, tt.entities)
+		}
+	}
+}
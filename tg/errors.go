@@ -0,0 +1,119 @@
+package tg
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors matched by *Error via errors.Is.
+var (
+	ErrBadRequest         = errors.New("tg: bad request")
+	ErrUnauthorized       = errors.New("tg: unauthorized")
+	ErrForbidden          = errors.New("tg: forbidden")
+	ErrNotFound           = errors.New("tg: not found")
+	ErrTooManyRequests    = errors.New("tg: too many requests")
+	ErrServer             = errors.New("tg: server error")
+	ErrCantParseEntities  = errors.New("tg: can't parse entities")
+	ErrMessageNotModified = errors.New("tg: message is not modified")
+	ErrMessageNotFound    = errors.New("tg: message not found")
+	ErrChatNotFound       = errors.New("tg: chat not found")
+	ErrTopicNotModified   = errors.New("tg: topic is not modified")
+	ErrTopicNotFound      = errors.New("tg: topic not found")
+)
+
+// Error - an unsuccessful Bot API response.
+type Error struct {
+	Method      string
+	Code        int
+	Description string
+	Parameters  *ResponseParameters
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tg: %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// Is - matches the error against the package sentinels
+// by HTTP status code and by well-known description fragments.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.Code == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.Code == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Code == http.StatusForbidden
+	case ErrNotFound:
+		return e.Code == http.StatusNotFound
+	case ErrTooManyRequests:
+		return e.Code == http.StatusTooManyRequests
+	case ErrServer:
+		return e.Code >= http.StatusInternalServerError
+	case ErrCantParseEntities:
+		return e.hasDescription("can't parse entities")
+	case ErrMessageNotModified:
+		return e.hasDescription("message is not modified")
+	case ErrMessageNotFound:
+		return e.hasDescription("message to edit not found") ||
+			e.hasDescription("message to delete not found") ||
+			e.hasDescription("message to be replied not found")
+	case ErrChatNotFound:
+		return e.hasDescription("chat not found")
+	case ErrTopicNotModified:
+		return e.hasDescription("topic_not_modified")
+	case ErrTopicNotFound:
+		return e.hasDescription("message thread not found") ||
+			e.hasDescription("topic_id_invalid") ||
+			e.hasDescription("topic_deleted")
+	}
+
+	return false
+}
+
+func (e *Error) hasDescription(s string) bool {
+	return strings.Contains(strings.ToLower(e.Description), s)
+}
+
+// RetryAfter - returns the delay requested by the server
+// for 429 responses, zero otherwise.
+func (e *Error) RetryAfter() time.Duration {
+	if e.Parameters == nil || e.Parameters.RetryAfter <= 0 {
+		return 0
+	}
+
+	return time.Duration(e.Parameters.RetryAfter) * time.Second
+}
+
+var byteOffsetRe = regexp.MustCompile(`byte offset (\d+)`)
+
+// EntityOffset - returns the byte offset reported in
+// "can't parse entities" errors and true if it is present.
+func (e *Error) EntityOffset() (int, bool) {
+	m := byteOffsetRe.FindStringSubmatch(e.Description)
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// AsError - unwraps err into *Error and returns it
+// along with true if err is a Bot API error.
+func AsError(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+
+	return nil, false
+}
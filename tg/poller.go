@@ -0,0 +1,67 @@
+package tg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// PollTimeout - the getUpdates long polling timeout, in seconds.
+// It must stay below the HTTP client timeout.
+const PollTimeout = 50
+
+// UpdateHandler - processes incoming updates.
+type UpdateHandler interface {
+	HandleUpdate(ctx context.Context, u Update)
+}
+
+// UpdateHandlerFunc - an UpdateHandler function.
+type UpdateHandlerFunc func(ctx context.Context, u Update)
+
+// HandleUpdate - calls f(ctx, u).
+func (f UpdateHandlerFunc) HandleUpdate(ctx context.Context, u Update) {
+	f(ctx, u)
+}
+
+// Poll - receives updates with getUpdates long polling and passes them
+// to h one by one until ctx is cancelled. Failed requests are retried
+// after a pause, honouring retry_after.
+func (c *Client) Poll(ctx context.Context, h UpdateHandler, allowed []string, log *slog.Logger) error {
+	if log == nil {
+		log = slog.Default()
+	}
+
+	p := GetUpdatesParams{Timeout: PollTimeout, AllowedUpdates: allowed}
+
+	for {
+		updates, err := c.GetUpdates(ctx, p)
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(err, ErrUnauthorized):
+			return err
+		case err != nil:
+			pause := 5 * time.Second
+			if e, ok := AsError(err); ok && e.RetryAfter() > 0 {
+				pause = e.RetryAfter()
+			}
+
+			log.Warn("getUpdates failed", "error", err, "retry_in", pause)
+
+			select {
+			case <-time.After(pause):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			continue
+		}
+
+		for _, u := range updates {
+			h.HandleUpdate(ctx, u)
+			p.Offset = u.UpdateID + 1
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package tg
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// atom - an unsplittable piece of MarkdownV2 text: a character,
+// an escape sequence, an entity marker or a whole link.
+type atom struct {
+	s     string
+	open  string // the marker the atom opens
+	close bool   // the atom closes the innermost marker
+	brk   int    // a chunk may end after the atom: 1 - a space, 2 - a newline
+}
+
+// UTF16Len - returns the length of s in UTF-16 code units,
+// the unit of the Bot API length limits.
+func UTF16Len(s string) int {
+	n := 0
+
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+
+	return n
+}
+
+// linkEnd - returns the end of the "[text](url)" link at i, or -1.
+func linkEnd(text string, i int) int {
+	j := i + 1
+	if strings.HasPrefix(text[i:], "![") {
+		j++
+	}
+
+	for closing := byte(']'); j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case closing:
+			if closing == ')' {
+				return j + 1
+			}
+
+			if j+1 >= len(text) || text[j+1] != '(' {
+				return -1
+			}
+
+			closing = ')'
+			j++
+		}
+	}
+
+	return -1
+}
+
+func atomize(text string) []atom {
+	var (
+		atoms []atom
+		stack []string
+	)
+
+	toggle := func(m string) {
+		if len(stack) > 0 && stack[len(stack)-1] == m {
+			stack = stack[:len(stack)-1]
+			atoms = append(atoms, atom{s: m, close: true})
+
+			return
+		}
+
+		stack = append(stack, m)
+		atoms = append(atoms, atom{s: m, open: m})
+	}
+
+	for i := 0; i < len(text); {
+		top := ""
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		inPre := strings.HasPrefix(top, "```")
+		inCode := inPre || top == "`"
+
+		r, sz := utf8.DecodeRuneInString(text[i:])
+
+		switch {
+		case r == '\\' && i+sz < len(text):
+			_, nsz := utf8.DecodeRuneInString(text[i+sz:])
+			atoms = append(atoms, atom{s: text[i : i+sz+nsz]})
+			i += sz + nsz
+		case strings.HasPrefix(text[i:], "```") && (inPre || !inCode):
+			if inPre {
+				stack = stack[:len(stack)-1]
+				atoms = append(atoms, atom{s: "```", close: true})
+				i += 3
+
+				break
+			}
+
+			// the opener includes the language and the newline
+			j := strings.IndexByte(text[i:], '\n')
+			if j < 0 {
+				j = len(text) - i - 1
+			}
+
+			m := text[i : i+j+1]
+			stack = append(stack, m)
+			atoms = append(atoms, atom{s: m, open: m})
+			i += len(m)
+		case r == '`' && !inPre:
+			toggle("`")
+			i++
+		case !inCode && (strings.HasPrefix(text[i:], "__") || strings.HasPrefix(text[i:], "||")):
+			toggle(text[i : i+2])
+			i += 2
+		case !inCode && (r == '*' || r == '_' || r == '~'):
+			toggle(string(r))
+			i++
+		case !inCode && (r == '[' || strings.HasPrefix(text[i:], "![")):
+			if end := linkEnd(text, i); end > 0 {
+				atoms = append(atoms, atom{s: text[i:end]})
+				i = end
+
+				break
+			}
+
+			fallthrough
+		default:
+			a := atom{s: text[i : i+sz]}
+
+			switch r {
+			case '\n':
+				a.brk = 2
+			case ' ':
+				a.brk = 1
+			}
+
+			atoms = append(atoms, a)
+			i += sz
+		}
+	}
+
+	return atoms
+}
+
+// closers - returns the markup closing the markers open at the end of chunk.
+func closers(stack []string, chunk string) string {
+	var b strings.Builder
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		if strings.HasPrefix(stack[i], "```") {
+			if !strings.HasSuffix(chunk, "\n") {
+				b.WriteString("\n")
+			}
+
+			b.WriteString("```")
+
+			continue
+		}
+
+		b.WriteString(stack[i])
+	}
+
+	return b.String()
+}
+
+// step - returns a copy of the marker stack updated by the atom.
+func step(stack []string, a atom) []string {
+	out := make([]string, len(stack), len(stack)+1)
+	copy(out, stack)
+
+	switch {
+	case a.open != "":
+		out = append(out, a.open)
+	case a.close && len(out) > 0:
+		out = out[:len(out)-1]
+	}
+
+	return out
+}
+
+// SplitMarkdownV2 - splits MarkdownV2 text into messages of at most
+// limit UTF-16 code units (MaxMessageLength if limit is 0). Messages
+// end at a line break or a space where possible; entities open at a
+// split are closed and reopened in the next message, escape sequences
+// and links are never cut.
+func SplitMarkdownV2(text string, limit int) []string {
+	if limit <= 0 {
+		limit = MaxMessageLength
+	}
+
+	if UTF16Len(text) <= limit {
+		return []string{text}
+	}
+
+	var (
+		atoms  = atomize(text)
+		chunks []string
+		stack  []string
+	)
+
+	for start := 0; start < len(atoms); {
+		prefix := strings.Join(stack, "")
+
+		var (
+			chunk    strings.Builder
+			st       = stack
+			n        = UTF16Len(prefix)
+			spaceCut = -1 // the last atom of the chunk when cut after a space
+			lineCut  = -1 // ... after a newline
+			lineLen  int  // the chunk length at lineCut
+			spaceSt  []string
+			lineSt   []string
+			i        = start
+		)
+
+		for ; i < len(atoms); i++ {
+			a := atoms[i]
+			next := step(st, a)
+			size := UTF16Len(a.s)
+
+			// room for the closing markup
+			if i > start && n+size+len(closers(next, "")) > limit {
+				break
+			}
+
+			st = next
+			n += size
+
+			switch a.brk {
+			case 2:
+				lineCut, lineLen, lineSt = i, n, st
+				fallthrough
+			case 1:
+				spaceCut, spaceSt = i, st
+			}
+		}
+
+		// a hard cut before atom i unless there is a better place
+		end, endSt := i-1, st
+
+		switch {
+		case i == len(atoms):
+			endSt = nil
+		case lineCut >= 0 && lineLen >= limit/2:
+			end, endSt = lineCut, lineSt
+		case spaceCut >= 0:
+			end, endSt = spaceCut, spaceSt
+		}
+
+		chunk.WriteString(prefix)
+
+		for _, a := range atoms[start : end+1] {
+			chunk.WriteString(a.s)
+		}
+
+		chunk.WriteString(closers(endSt, chunk.String()))
+		chunks = append(chunks, chunk.String())
+
+		start, stack = end+1, endSt
+	}
+
+	return chunks
+}
@@ -0,0 +1,90 @@
+package tg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitMarkdownV2(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "short text",
+			input: "*hello*",
+			limit: 10,
+			want:  []string{"*hello*"},
+		},
+		{
+			name:  "words",
+			input: "one two three four",
+			limit: 9,
+			want:  []string{"one two ", "three ", "four"},
+		},
+		{
+			name:  "prefers line breaks",
+			input: "first line\nsecond line",
+			limit: 15,
+			want:  []string{"first line\n", "second line"},
+		},
+		{
+			name:  "bold reopened",
+			input: "*bold text here*",
+			limit: 12,
+			want:  []string{"*bold text *", "*here*"},
+		},
+		{
+			name:  "nested entities",
+			input: "_it *bold words* end_",
+			limit: 16,
+			want:  []string{"_it *bold *_", "_*words* end_"},
+		},
+		{
+			name:  "code block",
+			input: "```go\nline one\nline two\n```",
+			limit: 22,
+			want:  []string{"```go\nline one\n```", "```go\nline two\n```"},
+		},
+		{
+			name:  "escapes are kept",
+			input: "a\\.b\\.c\\.d",
+			limit: 5,
+			want:  []string{"a\\.b", "\\.c\\.", "d"},
+		},
+		{
+			name:  "links are kept",
+			input: "see [the docs](https://example.com/x) now",
+			limit: 20,
+			want:  []string{"see ", "[the docs](https://example.com/x)", " now"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := SplitMarkdownV2(tt.input, tt.limit)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: SplitMarkdownV2() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitMarkdownV2Limit(t *testing.T) {
+	input := strings.Repeat("*bold* _italic_ `code` 🔥\n", 400)
+
+	chunks := SplitMarkdownV2(input, MaxMessageLength)
+	if len(chunks) < 2 {
+		t.Fatalf("%d chunks, want several", len(chunks))
+	}
+
+	for i, c := range chunks {
+		if n := UTF16Len(c); n > MaxMessageLength {
+			t.Errorf("chunk %d: %d code units", i, n)
+		}
+
+		if strings.Count(c, "*")%2 != 0 || strings.Count(c, "`")%2 != 0 {
+			t.Errorf("chunk %d has unbalanced markers", i)
+		}
+	}
+}
@@ -45,3 +45,24 @@ func EscapeTelegramLink(text string) string {
 
 	return escaped.String()
 }
+
+func EscapeTelegramHTML(text string) string {
+	var escaped strings.Builder
+
+	for _, ch := range text {
+		switch ch {
+		case '<':
+			escaped.WriteString("&lt;")
+		case '>':
+			escaped.WriteString("&gt;")
+		case '&':
+			escaped.WriteString("&amp;")
+		case '"':
+			escaped.WriteString("&quot;")
+		default:
+			escaped.WriteRune(ch)
+		}
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,33 @@
+package tg
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+)
+
+// SecretTokenHeader - the header carrying the secret_token of setWebhook.
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// WebhookHandler - returns an HTTP handler receiving updates posted by
+// Telegram and passing them to h. Requests without the secret token are
+// rejected; an empty secret accepts all requests.
+func WebhookHandler(secret string, h UpdateHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretTokenHeader)), []byte(secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+
+			return
+		}
+
+		var u Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&u); err != nil {
+			http.Error(w, "bad update", http.StatusBadRequest)
+
+			return
+		}
+
+		h.HandleUpdate(r.Context(), u)
+		w.WriteHeader(http.StatusOK)
+	})
+}